@@ -1,28 +1,94 @@
+-- System accounts such as escrow are found by system_account and never log
+-- in, which no_login enforces for logins and password resets.
 CREATE TABLE users(
   id serial PRIMARY KEY,
   username varchar(64) NOT NULL UNIQUE,
   password_hash char(60),
+  token_version integer NOT NULL DEFAULT 0,
+  no_login boolean NOT NULL DEFAULT false,
+  system_account varchar(32) UNIQUE,
   created_at timestamp NOT NULL DEFAULT NOW()
 );
 
+-- Every user has a primary wallet, named sub-accounts are listed in wallets.
+CREATE TABLE wallets(
+  user_id integer NOT NULL REFERENCES users(id),
+  name varchar(32) NOT NULL,
+  created_at timestamptz NOT NULL DEFAULT now(),
+  PRIMARY KEY (user_id, name)
+);
+
+-- named_wallet is NULL for the primary wallet, so only balances of named
+-- wallets have to match a row in wallets.
 CREATE TABLE balances(
   user_id integer NOT NULL REFERENCES users(id),
+  wallet varchar(32) NOT NULL DEFAULT 'primary',
   currency varchar(64) NOT NULL,
   amount bigint NOT NULL,
-  CONSTRAINT unique_user_currency UNIQUE (user_id, currency)
+  named_wallet varchar(32) GENERATED ALWAYS AS (nullif(wallet, 'primary')) STORED,
+  CONSTRAINT unique_user_wallet_currency UNIQUE (user_id, wallet, currency),
+  FOREIGN KEY (user_id, named_wallet) REFERENCES wallets(user_id, name)
 );
 
 CREATE TABLE permissions(
   id serial PRIMARY KEY,
-  name varchar(32) NOT NULL UNIQUE
+  name varchar(32) NOT NULL UNIQUE,
+  description text NOT NULL DEFAULT '',
+  admin_only boolean NOT NULL DEFAULT false,
+  custom boolean NOT NULL DEFAULT false,
+  created_at timestamp NOT NULL DEFAULT NOW()
 );
 
 CREATE TABLE user_permission(
   user_id integer NOT NULL REFERENCES users(id),
   permission_id integer NOT NULL REFERENCES permissions(id),
+  granted_by integer REFERENCES users(id),
+  granted_at timestamptz NOT NULL DEFAULT now(),
+  expires_at timestamptz,
   CONSTRAINT unique_permissions UNIQUE (user_id, permission_id)
 );
 
+-- Every grant, revocation and expiry of a direct permission. Names are kept
+-- so entries of deleted custom permissions stay readable.
+CREATE TABLE user_permission_history(
+  id serial PRIMARY KEY,
+  user_id integer NOT NULL REFERENCES users(id),
+  permission_id integer NOT NULL,
+  permission_name varchar(32) NOT NULL,
+  action varchar(16) NOT NULL,
+  initiator_id integer REFERENCES users(id),
+  expires_at timestamptz,
+  created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE roles(
+  id serial PRIMARY KEY,
+  name varchar(32) NOT NULL UNIQUE,
+  created_at timestamp NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE role_permission(
+  role_id integer NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+  permission_id integer NOT NULL REFERENCES permissions(id),
+  CONSTRAINT unique_role_permissions UNIQUE (role_id, permission_id)
+);
+
+CREATE TABLE user_role(
+  user_id integer NOT NULL REFERENCES users(id),
+  role_id integer NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+  CONSTRAINT unique_user_roles UNIQUE (user_id, role_id)
+);
+
+-- Permissions a user holds either directly or through one of their roles
+CREATE VIEW effective_user_permission AS
+SELECT user_id, permission_id
+FROM user_permission
+WHERE expires_at IS NULL OR expires_at > now()
+UNION
+SELECT user_role.user_id, role_permission.permission_id
+FROM user_role
+JOIN role_permission ON role_permission.role_id = user_role.role_id;
+
 CREATE TABLE recovery_code(
   user_id integer NOT NULL REFERENCES users(id),
   code varchar(12) NOT NULL,
@@ -46,7 +112,11 @@ CREATE TABLE transaction_logs(
   currency varchar(64) NOT NULL,
   amount bigint NOT NULL,
   fee bigint NOT NULL,
-  created_at timestamp NOT NULL DEFAULT NOW()
+  sender_wallet varchar(32) NOT NULL DEFAULT 'primary',
+  receiver_wallet varchar(32) NOT NULL DEFAULT 'primary',
+  created_at timestamp NOT NULL DEFAULT NOW(),
+  prev_hash bytea NOT NULL,
+  hash bytea NOT NULL
 );
 
 CREATE TABLE print_money_logs(
@@ -57,16 +127,289 @@ CREATE TABLE print_money_logs(
   receiver_balance_after bigint DEFAULT 0,
   currency varchar(64) NOT NULL,
   amount bigint NOT NULL,
-  created_at timestamp NOT NULL DEFAULT NOW()
+  created_at timestamp NOT NULL DEFAULT NOW(),
+  prev_hash bytea NOT NULL,
+  hash bytea NOT NULL
+);
+
+-- Signed heads of the ledger hash chains. A signature over the hash of the
+-- last row pins every row before it, so rewriting the whole chain after an
+-- edit is detected too.
+CREATE TABLE ledger_checkpoints(
+  id serial PRIMARY KEY,
+  chain varchar(32) NOT NULL,
+  last_id integer NOT NULL,
+  hash bytea NOT NULL,
+  key_id varchar(16) NOT NULL,
+  signature bytea NOT NULL,
+  created_at timestamptz NOT NULL DEFAULT now()
 );
 
 CREATE EXTENSION IF NOT EXISTS pgcrypto;
 
+CREATE TABLE oauth_clients (
+    client_id VARCHAR(64) PRIMARY KEY,
+    client_secret_hash CHAR(64),
+    owner_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(64) NOT NULL,
+    redirect_uris TEXT[] NOT NULL,
+    scopes VARCHAR(32)[] NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE TABLE oauth_authorization_codes (
+    code_hash CHAR(64) PRIMARY KEY,
+    client_id VARCHAR(64) NOT NULL REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    redirect_uri TEXT NOT NULL,
+    scopes VARCHAR(32)[] NOT NULL,
+    code_challenge VARCHAR(128) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used BOOLEAN NOT NULL DEFAULT false
+);
+
 CREATE TABLE refresh_tokens (
     user_id INTEGER NOT NULL,
     token UUID NOT NULL DEFAULT gen_random_uuid(),
+    family_id UUID NOT NULL DEFAULT gen_random_uuid(),
+    replaced_by UUID,
+    user_agent VARCHAR(256),
+    ip VARCHAR(64),
+    client_id VARCHAR(64) REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+    scopes VARCHAR(32)[],
     expires_at TIMESTAMPTZ NOT NULL,
     created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
     revoked BOOLEAN NOT NULL DEFAULT false,
     CONSTRAINT fk_user FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
-);
\ No newline at end of file
+);
+
+CREATE TABLE revoked_access_tokens (
+    jti VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CONSTRAINT fk_user FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE scoped_token_spending (
+    jti VARCHAR(64) NOT NULL,
+    currency VARCHAR(64) NOT NULL,
+    spent BIGINT NOT NULL DEFAULT 0,
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (jti, currency)
+);
+
+CREATE TABLE signing_keys (
+    kid VARCHAR(64) PRIMARY KEY,
+    algorithm VARCHAR(16) NOT NULL,
+    private_key TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE api_keys (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(64) NOT NULL,
+    key_hash CHAR(64) NOT NULL UNIQUE,
+    prefix VARCHAR(16) NOT NULL,
+    restricted BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE TABLE api_key_permissions (
+    api_key_id INTEGER NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    permission_id INTEGER NOT NULL REFERENCES permissions(id) ON DELETE CASCADE,
+    PRIMARY KEY (api_key_id, permission_id)
+);
+
+CREATE TABLE user_totp (
+    user_id INTEGER PRIMARY KEY,
+    secret VARCHAR(64) NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT false,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    confirmed_at TIMESTAMPTZ,
+    CONSTRAINT fk_user FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE totp_backup_codes (
+    user_id INTEGER NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    used_at TIMESTAMPTZ,
+    CONSTRAINT unique_backup_code UNIQUE (user_id, code_hash),
+    CONSTRAINT fk_user FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE login_challenges (
+    token UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id INTEGER NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    used BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CONSTRAINT fk_user FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE login_lockouts (
+    lockout_key VARCHAR(128) PRIMARY KEY,
+    failures INTEGER NOT NULL DEFAULT 0,
+    window_started_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    blocked_until TIMESTAMPTZ
+);
+
+CREATE TABLE rate_limit_buckets (
+    bucket_key VARCHAR(256) PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Append-only record of privileged calls. Rows can't be updated or deleted,
+-- see prevent_audit_event_changes.
+CREATE TABLE audit_events(
+  id bigserial PRIMARY KEY,
+  actor_id integer,
+  target_id integer,
+  action varchar(64) NOT NULL,
+  parameters jsonb NOT NULL DEFAULT '{}',
+  succeeded boolean NOT NULL,
+  error text,
+  ip varchar(64),
+  request_id varchar(64),
+  created_at timestamptz NOT NULL DEFAULT now()
+);
+
+-- Transactional outbox: events are written in the same transaction as the
+-- change they describe. user_ids are the users an event concerns.
+CREATE TABLE events(
+  id bigserial PRIMARY KEY,
+  event_type varchar(32) NOT NULL,
+  user_ids integer[] NOT NULL DEFAULT '{}',
+  payload jsonb NOT NULL,
+  created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE webhook_subscriptions(
+  id serial PRIMARY KEY,
+  user_id integer NOT NULL REFERENCES users(id),
+  url text NOT NULL,
+  event_types varchar(32)[] NOT NULL,
+  all_events boolean NOT NULL DEFAULT false,
+  secret text NOT NULL,
+  created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE webhook_deliveries(
+  id bigserial PRIMARY KEY,
+  subscription_id integer NOT NULL REFERENCES webhook_subscriptions(id) ON DELETE CASCADE,
+  event_id bigint NOT NULL REFERENCES events(id),
+  status varchar(16) NOT NULL DEFAULT 'pending',
+  attempts integer NOT NULL DEFAULT 0,
+  next_attempt_at timestamptz NOT NULL DEFAULT now(),
+  last_status_code integer,
+  last_error text,
+  delivered_at timestamptz,
+  created_at timestamptz NOT NULL DEFAULT now()
+);
+
+-- Payment requests ask payer_id to pay requester_id. A pending request past
+-- expires_at counts as expired, see payment_request_status.
+CREATE TABLE payment_requests(
+  id serial PRIMARY KEY,
+  requester_id integer NOT NULL REFERENCES users(id),
+  payer_id integer NOT NULL REFERENCES users(id),
+  currency varchar(64) NOT NULL,
+  amount bigint NOT NULL CHECK (amount > 0),
+  memo varchar(256) NOT NULL DEFAULT '',
+  status varchar(16) NOT NULL DEFAULT 'pending',
+  expires_at timestamptz,
+  transaction_id integer REFERENCES transaction_logs(id),
+  created_at timestamptz NOT NULL DEFAULT now(),
+  resolved_at timestamptz
+);
+
+-- Escrow contracts hold amount on the escrow system account until they are
+-- released to the payee or refunded to the payer. Both parties confirming
+-- the same outcome, the arbiter or the deadline settle a contract. Failed
+-- deadline settlements are retried from next_settlement_at with a backoff.
+CREATE TABLE escrows(
+  id serial PRIMARY KEY,
+  payer_id integer NOT NULL REFERENCES users(id),
+  payee_id integer NOT NULL REFERENCES users(id),
+  arbiter_id integer REFERENCES users(id),
+  currency varchar(64) NOT NULL,
+  amount bigint NOT NULL CHECK (amount > 0),
+  memo varchar(256) NOT NULL DEFAULT '',
+  status varchar(16) NOT NULL DEFAULT 'funded',
+  deadline timestamptz NOT NULL,
+  deadline_action varchar(16) NOT NULL,
+  payer_vote varchar(16),
+  payee_vote varchar(16),
+  funding_transaction_id integer REFERENCES transaction_logs(id),
+  settlement_transaction_id integer REFERENCES transaction_logs(id),
+  created_at timestamptz NOT NULL DEFAULT now(),
+  resolved_at timestamptz,
+  settlement_attempts integer NOT NULL DEFAULT 0,
+  next_settlement_at timestamptz,
+  last_settlement_error text
+);
+
+CREATE TABLE escrow_logs(
+  id serial PRIMARY KEY,
+  escrow_id integer NOT NULL REFERENCES escrows(id),
+  action varchar(32) NOT NULL,
+  actor_id integer REFERENCES users(id),
+  transaction_id integer REFERENCES transaction_logs(id),
+  created_at timestamptz NOT NULL DEFAULT now()
+);
+
+-- Organizations are accounts without a password, operated by their members.
+CREATE TABLE organizations(
+  user_id integer PRIMARY KEY REFERENCES users(id),
+  created_by integer NOT NULL REFERENCES users(id),
+  created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE organization_members(
+  organization_id integer NOT NULL REFERENCES organizations(user_id),
+  user_id integer NOT NULL REFERENCES users(id),
+  role varchar(16) NOT NULL,
+  added_by integer REFERENCES users(id),
+  created_at timestamptz NOT NULL DEFAULT now(),
+  PRIMARY KEY (organization_id, user_id)
+);
+
+-- Daily spending limits of spenders. A spender can't spend a currency
+-- without a limit for it.
+CREATE TABLE organization_spend_limits(
+  organization_id integer NOT NULL,
+  user_id integer NOT NULL,
+  currency varchar(64) NOT NULL,
+  daily_limit bigint NOT NULL CHECK (daily_limit >= 0),
+  PRIMARY KEY (organization_id, user_id, currency),
+  FOREIGN KEY (organization_id, user_id) REFERENCES organization_members(organization_id, user_id) ON DELETE CASCADE
+);
+
+-- Credit lines let the primary wallet of a user go below zero in a currency,
+-- down to minus credit_limit.
+CREATE TABLE credit_limits(
+  user_id integer NOT NULL REFERENCES users(id),
+  currency varchar(64) NOT NULL,
+  credit_limit bigint NOT NULL CHECK (credit_limit > 0),
+  updated_by integer REFERENCES users(id),
+  updated_at timestamptz NOT NULL DEFAULT now(),
+  PRIMARY KEY (user_id, currency)
+);
+
+CREATE TABLE credit_limit_history(
+  id serial PRIMARY KEY,
+  user_id integer NOT NULL REFERENCES users(id),
+  currency varchar(64) NOT NULL,
+  old_limit bigint NOT NULL,
+  new_limit bigint NOT NULL,
+  initiator_id integer REFERENCES users(id),
+  created_at timestamptz NOT NULL DEFAULT now()
+);
@@ -19,18 +19,91 @@ VALUES (100, 'Transaction: Successful'),
        (401, 'Register: User already exists'),
        (501, 'Get history: Insufficient permissions'),
        (601, 'Change permission: Insufficient permissions'),
+       (602, 'Change permission: Permission does not exist'),
+       (603, 'Change permission: Expiry must be in the future'),
        (701, 'Change password: Insufficient permissions'),
-       (702, 'Change password: User does not exists');
+       (702, 'Change password: User does not exists'),
+       (703, 'Change password: Account can not log in'),
+       (801, 'Two-factor: Already enabled'),
+       (802, 'Two-factor: Enrollment was not started'),
+       (803, 'Two-factor: Not enabled'),
+       (901, 'Sessions: Insufficient permissions'),
+       (902, 'Sessions: Session does not exist'),
+       (1001, 'API keys: Permission does not exist'),
+       (1002, 'API keys: User does not have the requested permission'),
+       (1003, 'API keys: Insufficient permissions'),
+       (1004, 'API keys: Key does not exist'),
+       (1005, 'API keys: Expiry must be in the future'),
+       (1101, 'OAuth: Unknown scope'),
+       (1102, 'OAuth: Insufficient permissions'),
+       (1103, 'OAuth: Client does not exist'),
+       (1201, 'Lockouts: Insufficient permissions'),
+       (1301, 'Roles: Insufficient permissions'),
+       (1302, 'Roles: Role does not exist'),
+       (1303, 'Roles: Role already exists'),
+       (1304, 'Roles: Permission does not exist'),
+       (1305, 'Roles: Administrator can not be granted through a role'),
+       (1401, 'Permissions: Insufficient permissions'),
+       (1402, 'Permissions: Permission already exists'),
+       (1403, 'Permissions: Permission does not exist'),
+       (1404, 'Permissions: Built-in permissions can not be deleted'),
+       (1501, 'Audit: Insufficient permissions'),
+       (1502, 'Audit: Events can not be changed'),
+       (1601, 'Ledger: Insufficient permissions'),
+       (1602, 'Ledger: Unknown chain'),
+       (1701, 'Webhooks: Insufficient permissions'),
+       (1702, 'Webhooks: Subscription does not exist'),
+       (1703, 'Webhooks: Delivery does not exist'),
+       (1801, 'Events: Insufficient permissions'),
+       (1901, 'Payment requests: Payer does not exist'),
+       (1902, 'Payment requests: Can not request money from yourself'),
+       (1903, 'Payment requests: Amount less than or equal to zero'),
+       (1904, 'Payment requests: Expiry must be in the future'),
+       (1905, 'Payment requests: Request does not exist'),
+       (1906, 'Payment requests: Request is not pending'),
+       (1907, 'Payment requests: Request has expired'),
+       (2001, 'Escrow: Payee does not exist'),
+       (2002, 'Escrow: Arbiter does not exist'),
+       (2003, 'Escrow: Payer, payee and arbiter must be different users'),
+       (2004, 'Escrow: Amount less than or equal to zero'),
+       (2005, 'Escrow: Deadline must be in the future'),
+       (2006, 'Escrow: Contract does not exist'),
+       (2007, 'Escrow: Contract is already settled'),
+       (2008, 'Escrow: Payee can not receive funds'),
+       (2009, 'Escrow: Unknown outcome'),
+       (2010, 'Escrow: Escrow account is missing'),
+       (2011, 'Escrow: Deadline has passed'),
+       (2101, 'Wallets: Insufficient permissions'),
+       (2102, 'Wallets: Wallet does not exist'),
+       (2103, 'Wallets: Wallet already exists'),
+       (2104, 'Wallets: Wallet is not empty'),
+       (2105, 'Wallets: Sender and receiver wallet are the same'),
+       (2106, 'Wallets: Primary wallet can not be deleted'),
+       (2201, 'Organizations: Insufficient permissions'),
+       (2202, 'Organizations: Organization does not exist'),
+       (2203, 'Organizations: User does not exist'),
+       (2204, 'Organizations: Unknown role'),
+       (2205, 'Organizations: User is not a member'),
+       (2206, 'Organizations: Organization must keep an admin'),
+       (2207, 'Organizations: Spending limit can not be negative'),
+       (2208, 'Organizations: Daily spending limit exceeded'),
+       (2209, 'Organizations: Only spenders have spending limits'),
+       (2210, 'Organizations: Name is already taken'),
+       (2211, 'Organizations: Organizations can not be members'),
+       (2301, 'Credit: Insufficient permissions'),
+       (2302, 'Credit: User does not exist'),
+       (2303, 'Credit: Limit can not be negative');
 
-INSERT INTO permissions(name)
-VALUES ('administrator'),
-       ('manage_user_permissions'),
-       ('manage_user_funds'),
-       ('control_user_accounts'),
-       ('print_money'),
-       ('audit_funds'),
-       ('receive_funds'),
-       ('send_funds');
+INSERT INTO permissions(name, admin_only, description)
+VALUES ('administrator', true, 'Holds every permission'),
+       ('manage_user_permissions', true, 'Grant and revoke permissions and roles of other users'),
+       ('manage_user_funds', false, 'Send funds on behalf of other users'),
+       ('control_user_accounts', false, 'Register users, reset passwords and manage sessions and lockouts'),
+       ('print_money', true, 'Issue new funds'),
+       ('audit_funds', false, 'Read balances and histories of other users'),
+       ('receive_funds', false, 'Receive transfers'),
+       ('send_funds', false, 'Send transfers'),
+       ('create_organizations', false, 'Open organization accounts shared by several users');
 
 INSERT INTO users(username)
 VALUES ('adm'), --1
@@ -38,7 +111,19 @@ VALUES ('adm'), --1
        ('registration'), --3
        ('money_printer'); --4
 
+-- System accounts are found by system_account rather than by id and can't log
+-- in. The escrow account holds escrowed funds, its username contains a space,
+-- which registration never accepts, so it can't collide with a user.
+INSERT INTO users(username, no_login, system_account)
+VALUES ('system escrow', true, 'escrow');
+
 INSERT INTO user_permission(user_id, permission_id)
 VALUES (1, 1),
        (3, 4),
        (4, 5);
+
+INSERT INTO user_permission(user_id, permission_id)
+SELECT users.id, permissions.id
+FROM users, permissions
+WHERE users.system_account = 'escrow'
+  AND permissions.name = 'receive_funds';
@@ -1,3 +1,93 @@
+-- enqueue_event writes an event to the outbox and queues a delivery for every
+-- webhook subscribed to it, all in the caller's transaction. Listeners on
+-- gbs_events are notified once the transaction commits.
+CREATE OR REPLACE FUNCTION enqueue_event(
+  event_type_param VARCHAR(32),
+  user_ids_param INTEGER[],
+  payload_param JSONB
+) RETURNS BIGINT AS $$
+DECLARE
+  new_event_id BIGINT;
+  new_created_at TIMESTAMPTZ;
+BEGIN
+INSERT INTO events(event_type, user_ids, payload)
+VALUES (event_type_param, user_ids_param, payload_param)
+    RETURNING id, created_at INTO new_event_id, new_created_at;
+
+PERFORM pg_notify('gbs_events', jsonb_build_object(
+    'id', new_event_id,
+    'type', event_type_param,
+    'user_ids', to_jsonb(user_ids_param),
+    'data', payload_param,
+    'created_at', new_created_at
+)::text);
+
+INSERT INTO webhook_deliveries(subscription_id, event_id)
+SELECT webhook_subscriptions.id, new_event_id
+FROM webhook_subscriptions
+WHERE event_type_param = ANY(webhook_subscriptions.event_types)
+  AND (webhook_subscriptions.all_events OR webhook_subscriptions.user_id = ANY(user_ids_param));
+
+RETURN new_event_id;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Ledger rows are chained: each hash covers the row and the hash of the row
+-- before it, starting from 32 zero bytes. Timestamps are formatted explicitly
+-- so the hash doesn't depend on DateStyle.
+CREATE OR REPLACE FUNCTION ledger_genesis_hash()
+RETURNS BYTEA AS $$
+SELECT decode(repeat('00', 32), 'hex');
+$$ LANGUAGE sql IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION transaction_log_hash(
+  id_param integer,
+  sender_id_param integer,
+  receiver_id_param integer,
+  initiator_id_param integer,
+  transaction_status_param integer,
+  sender_balance_after_param bigint,
+  receiver_balance_after_param bigint,
+  currency_param varchar(64),
+  amount_param bigint,
+  fee_param bigint,
+  sender_wallet_param varchar(32),
+  receiver_wallet_param varchar(32),
+  created_at_param timestamp,
+  prev_hash_param bytea
+) RETURNS BYTEA AS $$
+SELECT sha256(prev_hash_param || convert_to(concat_ws('|',
+    'transaction', id_param, sender_id_param, receiver_id_param, initiator_id_param,
+    COALESCE(transaction_status_param::text, ''),
+    COALESCE(sender_balance_after_param::text, ''),
+    COALESCE(receiver_balance_after_param::text, ''),
+    currency_param, amount_param, fee_param, sender_wallet_param, receiver_wallet_param,
+    to_char(created_at_param, 'YYYY-MM-DD"T"HH24:MI:SS.US')
+), 'UTF8'));
+$$ LANGUAGE sql IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION print_money_log_hash(
+  id_param integer,
+  receiver_id_param integer,
+  initiator_id_param integer,
+  print_status_param integer,
+  receiver_balance_after_param bigint,
+  currency_param varchar(64),
+  amount_param bigint,
+  created_at_param timestamp,
+  prev_hash_param bytea
+) RETURNS BYTEA AS $$
+SELECT sha256(prev_hash_param || convert_to(concat_ws('|',
+    'print_money', id_param, receiver_id_param, initiator_id_param,
+    COALESCE(print_status_param::text, ''),
+    COALESCE(receiver_balance_after_param::text, ''),
+    currency_param, amount_param,
+    to_char(created_at_param, 'YYYY-MM-DD"T"HH24:MI:SS.US')
+), 'UTF8'));
+$$ LANGUAGE sql IMMUTABLE;
+
+-- log_transaction appends to the chain under an advisory lock, so rows are
+-- linked in id order even with concurrent transfers. Returns the row id.
 CREATE OR REPLACE FUNCTION log_transaction(
   sender_id_param integer,
   receiver_id_param integer,
@@ -7,20 +97,59 @@ CREATE OR REPLACE FUNCTION log_transaction(
   receiver_balance_after_param bigint,
   currency_param varchar(64),
   amount_param bigint,
-  fee_param bigint
+  fee_param bigint,
+  sender_wallet_param varchar(32),
+  receiver_wallet_param varchar(32)
 )
-  RETURNS void
+  RETURNS integer
   AS $$
+DECLARE
+  log_id integer;
+  log_created_at timestamp := now();
+  log_prev_hash bytea;
 BEGIN
+PERFORM pg_advisory_xact_lock(hashtext('transaction_logs'));
+
+SELECT hash INTO log_prev_hash
+FROM transaction_logs
+ORDER BY id DESC
+LIMIT 1;
+
+log_prev_hash := COALESCE(log_prev_hash, ledger_genesis_hash());
+log_id := nextval(pg_get_serial_sequence('transaction_logs', 'id'));
+
 INSERT INTO transaction_logs(
-    sender_id, receiver_id, initiator_id,
+    id, sender_id, receiver_id, initiator_id,
     transaction_status, sender_balance_after, receiver_balance_after, currency,
-    amount, fee
+    amount, fee, sender_wallet, receiver_wallet, created_at, prev_hash, hash
 )
 VALUES(
-          sender_id_param, receiver_id_param, initiator_id_param, transaction_status_param,
-          sender_balance_after_param, receiver_balance_after_param, currency_param, amount_param, fee_param
+          log_id, sender_id_param, receiver_id_param, initiator_id_param, transaction_status_param,
+          sender_balance_after_param, receiver_balance_after_param, currency_param, amount_param, fee_param,
+          sender_wallet_param, receiver_wallet_param, log_created_at, log_prev_hash,
+          transaction_log_hash(
+              log_id, sender_id_param, receiver_id_param, initiator_id_param, transaction_status_param,
+              sender_balance_after_param, receiver_balance_after_param, currency_param, amount_param, fee_param,
+              sender_wallet_param, receiver_wallet_param, log_created_at, log_prev_hash
+          )
       );
+
+  IF transaction_status_param = 100 THEN
+    PERFORM enqueue_event('transfer', ARRAY[sender_id_param, receiver_id_param, initiator_id_param], jsonb_build_object(
+        'transaction_id', log_id,
+        'sender_id', sender_id_param,
+        'receiver_id', receiver_id_param,
+        'initiator_id', initiator_id_param,
+        'sender_wallet', sender_wallet_param,
+        'receiver_wallet', receiver_wallet_param,
+        'currency', currency_param,
+        'amount', amount_param,
+        'fee', fee_param,
+        'created_at', log_created_at
+    ));
+END IF;
+
+RETURN log_id;
 END;
 $$
 LANGUAGE plpgsql;
@@ -33,17 +162,50 @@ CREATE OR REPLACE FUNCTION log_print_money(
   currency_param varchar(64),
   amount_param bigint
 )
-  RETURNS void
+  RETURNS integer
   AS $$
+DECLARE
+  log_id integer;
+  log_created_at timestamp := now();
+  log_prev_hash bytea;
 BEGIN
+PERFORM pg_advisory_xact_lock(hashtext('print_money_logs'));
+
+SELECT hash INTO log_prev_hash
+FROM print_money_logs
+ORDER BY id DESC
+LIMIT 1;
+
+log_prev_hash := COALESCE(log_prev_hash, ledger_genesis_hash());
+log_id := nextval(pg_get_serial_sequence('print_money_logs', 'id'));
+
 INSERT INTO print_money_logs(
-    receiver_id, initiator_id, print_status,
-    receiver_balance_after, currency, amount
+    id, receiver_id, initiator_id, print_status,
+    receiver_balance_after, currency, amount, created_at, prev_hash, hash
 )
 VALUES(
-          receiver_id_param, initiator_id_param, print_status_param,
-          receiver_balance_after_param, currency_param, amount_param
+          log_id, receiver_id_param, initiator_id_param, print_status_param,
+          receiver_balance_after_param, currency_param, amount_param,
+          log_created_at, log_prev_hash,
+          print_money_log_hash(
+              log_id, receiver_id_param, initiator_id_param, print_status_param,
+              receiver_balance_after_param, currency_param, amount_param,
+              log_created_at, log_prev_hash
+          )
       );
+
+  IF print_status_param = 200 THEN
+    PERFORM enqueue_event('print_money', ARRAY[receiver_id_param, initiator_id_param], jsonb_build_object(
+        'print_id', log_id,
+        'receiver_id', receiver_id_param,
+        'initiator_id', initiator_id_param,
+        'currency', currency_param,
+        'amount', amount_param,
+        'created_at', log_created_at
+    ));
+END IF;
+
+RETURN log_id;
 END;
 $$
 LANGUAGE plpgsql;
@@ -66,6 +228,22 @@ END IF;
 END;
 $$ LANGUAGE plpgsql;
 
+-- has_permission reports whether a user holds any of the given permissions,
+-- directly or through a role
+CREATE OR REPLACE FUNCTION has_permission(
+  user_id_param INTEGER,
+  VARIADIC permission_names_param VARCHAR(32)[]
+) RETURNS BOOLEAN AS $$
+BEGIN
+RETURN EXISTS (
+    SELECT 1 FROM effective_user_permission
+    JOIN permissions ON permissions.id = effective_user_permission.permission_id
+    WHERE effective_user_permission.user_id = user_id_param
+      AND permissions.name = ANY(permission_names_param)
+);
+END;
+$$ LANGUAGE plpgsql STABLE;
+
 CREATE OR REPLACE FUNCTION check_transaction_permissions(
   initiator_id_param integer,
   sender_id_param integer,
@@ -73,52 +251,144 @@ CREATE OR REPLACE FUNCTION check_transaction_permissions(
 )
   RETURNS void AS $$
 BEGIN
-  IF EXISTS(
-      SELECT 1 FROM user_permission
-        JOIN permissions ON permissions.id = user_permission.permission_id
-       WHERE user_id = initiator_id_param
-         AND permissions.name IN ('manage_user_funds', 'administrator')
-  ) THEN
+  IF has_permission(initiator_id_param, 'manage_user_funds', 'administrator') THEN
     RETURN;
 END IF;
 
-  IF initiator_id_param != sender_id_param THEN
+  IF initiator_id_param != sender_id_param
+     AND coalesce(organization_role(sender_id_param, initiator_id_param), '') NOT IN ('spender', 'admin') THEN
     PERFORM raise_error(104);
 END IF;
 
-  IF NOT EXISTS(
-      SELECT 1 FROM user_permission
-        JOIN permissions ON permissions.id = user_permission.permission_id
-       WHERE user_id = initiator_id_param
-         AND permissions.name = 'send_funds'
-  ) THEN
+  -- Members spending from an organization need send_funds, and so does the
+  -- organization itself.
+  IF NOT has_permission(initiator_id_param, 'send_funds')
+     OR NOT has_permission(sender_id_param, 'send_funds') THEN
     PERFORM raise_error(105);
 END IF;
 
-  IF NOT EXISTS(
-      SELECT 1 FROM user_permission
-        JOIN permissions ON permissions.id = user_permission.permission_id
-       WHERE user_id = receiver_id_param
-         AND permissions.name = 'receive_funds'
-  ) THEN
+  IF NOT has_permission(receiver_id_param, 'receive_funds') THEN
     PERFORM raise_error(106);
 END IF;
 END;
 $$ LANGUAGE plpgsql;
 
-CREATE OR REPLACE FUNCTION proceed_transaction(
+-- wallet_exists reports whether a user has a wallet. Every user has the
+-- primary wallet, other wallets are created explicitly.
+CREATE OR REPLACE FUNCTION wallet_exists(
+  user_id_param integer,
+  wallet_param varchar(32)
+)
+  RETURNS boolean AS $$
+SELECT wallet_param = 'primary'
+    OR EXISTS (SELECT 1 FROM wallets WHERE user_id = user_id_param AND name = wallet_param);
+$$ LANGUAGE sql STABLE;
+
+-- organization_role returns the role of a member in an organization, NULL
+-- when the account isn't an organization the user belongs to.
+CREATE OR REPLACE FUNCTION organization_role(
+  organization_id_param integer,
+  user_id_param integer
+)
+  RETURNS varchar(16) AS $$
+SELECT role
+FROM organization_members
+WHERE organization_id = organization_id_param AND user_id = user_id_param;
+$$ LANGUAGE sql STABLE;
+
+-- check_organization_spending keeps spenders within their daily limit when
+-- they spend from an organization. Admins aren't limited.
+CREATE OR REPLACE FUNCTION check_organization_spending(
+  organization_id_param integer,
+  member_id_param integer,
+  currency_param varchar(64),
+  amount_param bigint
+)
+  RETURNS void AS $$
+DECLARE
+limit_amount bigint;
+  spent_amount bigint;
+BEGIN
+  IF organization_role(organization_id_param, member_id_param) IS DISTINCT FROM 'spender'
+     OR has_permission(member_id_param, 'manage_user_funds', 'administrator') THEN
+    RETURN;
+END IF;
+
+SELECT daily_limit
+INTO limit_amount
+FROM organization_spend_limits
+WHERE organization_id = organization_id_param
+  AND user_id = member_id_param
+  AND currency = currency_param
+    FOR UPDATE;
+
+SELECT coalesce(sum(amount), 0)
+INTO spent_amount
+FROM transaction_logs
+WHERE sender_id = organization_id_param
+  AND initiator_id = member_id_param
+  AND currency = currency_param
+  AND transaction_status = 100
+  AND created_at > NOW() - interval '1 day';
+
+  IF limit_amount IS NULL OR spent_amount + amount_param > limit_amount THEN
+    PERFORM raise_error(2208);
+END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+-- credit_limit_of returns how far a wallet can go below zero in a currency.
+-- Credit lines only cover primary wallets. The limit is locked so concurrent
+-- transfers on credit don't overdraw it.
+CREATE OR REPLACE FUNCTION credit_limit_of(
+  user_id_param integer,
+  wallet_param varchar(32),
+  currency_param varchar(64)
+)
+  RETURNS bigint AS $$
+DECLARE
+credit bigint;
+BEGIN
+  IF wallet_param != 'primary' THEN
+    RETURN 0;
+END IF;
+
+SELECT credit_limit
+INTO credit
+FROM credit_limits
+WHERE user_id = user_id_param AND currency = currency_param
+    FOR UPDATE;
+
+RETURN coalesce(credit, 0);
+END;
+$$ LANGUAGE plpgsql;
+
+-- transfer_funds moves funds between wallets, the primary ones unless given.
+-- Fees always go to the primary wallet of the fees user. Senders with a
+-- credit line can go below zero down to minus their limit. It doesn't check
+-- permissions: transfers go through proceed_transaction, only system payouts
+-- such as escrow settlements call it directly.
+CREATE OR REPLACE FUNCTION transfer_funds(
   sender_id_param integer,
   receiver_id_param integer,
   initiator_id_param integer,
   currency_param varchar(64),
   amount_param bigint,
-  fee_param integer
+  fee_param integer,
+  sender_wallet_param varchar(32) DEFAULT 'primary',
+  receiver_wallet_param varchar(32) DEFAULT 'primary'
 )
-  RETURNS void AS $$
+  RETURNS TABLE(
+    receipt_transaction_id integer,
+    receipt_fee bigint,
+    receipt_created_at timestamp
+  ) AS $$
 DECLARE
 sender_balance bigint;
+  sender_credit bigint;
   receiver_balance bigint;
   commission_amount bigint;
+  log_id integer;
 BEGIN
   IF NOT EXISTS (SELECT 1 FROM users WHERE id = sender_id_param) THEN
     PERFORM raise_error(101);
@@ -132,21 +402,39 @@ END IF;
     PERFORM raise_error(103);
 END IF;
 
+  -- Named wallets are share locked so delete_wallet can't remove them between
+  -- the check and the balance updates.
+PERFORM 1
+FROM wallets
+WHERE (user_id = sender_id_param AND name = sender_wallet_param)
+   OR (user_id = receiver_id_param AND name = receiver_wallet_param)
+ORDER BY user_id, name
+    FOR SHARE;
+
+  IF NOT wallet_exists(sender_id_param, sender_wallet_param)
+     OR NOT wallet_exists(receiver_id_param, receiver_wallet_param) THEN
+    PERFORM raise_error(2102);
+END IF;
+
+  IF sender_id_param = receiver_id_param AND sender_wallet_param = receiver_wallet_param THEN
+    PERFORM raise_error(2105);
+END IF;
+
 SELECT amount
 INTO sender_balance
 FROM balances
-WHERE user_id = sender_id_param AND currency = currency_param
+WHERE user_id = sender_id_param AND wallet = sender_wallet_param AND currency = currency_param
     FOR UPDATE;
 
 SELECT amount
 INTO receiver_balance
 FROM balances
-WHERE user_id = receiver_id_param AND currency = currency_param
+WHERE user_id = receiver_id_param AND wallet = receiver_wallet_param AND currency = currency_param
     FOR UPDATE;
 
-PERFORM check_transaction_permissions(initiator_id_param, sender_id_param, receiver_id_param);
+sender_credit := credit_limit_of(sender_id_param, sender_wallet_param, currency_param);
 
-  IF sender_balance < amount_param OR sender_balance IS NULL THEN
+  IF coalesce(sender_balance, 0) + sender_credit < amount_param THEN
     PERFORM raise_error(107);
 END IF;
 
@@ -156,39 +444,93 @@ END IF;
 
   commission_amount := (amount_param * fee_param + 9999) / 10000;
 
-INSERT INTO balances(user_id, currency, amount)
+INSERT INTO balances(user_id, wallet, currency, amount)
 VALUES (
-           receiver_id_param, currency_param, amount_param - commission_amount
+           receiver_id_param, receiver_wallet_param, currency_param, amount_param - commission_amount
        )
-    ON CONFLICT (user_id, currency)
+    ON CONFLICT (user_id, wallet, currency)
     DO UPDATE SET amount = balances.amount + EXCLUDED.amount;
 
-INSERT INTO balances(user_id, currency, amount)
+INSERT INTO balances(user_id, wallet, currency, amount)
 VALUES (
-           2, currency_param, commission_amount
+           2, 'primary', currency_param, commission_amount
        )
-    ON CONFLICT (user_id, currency)
+    ON CONFLICT (user_id, wallet, currency)
     DO UPDATE SET amount = balances.amount + EXCLUDED.amount;
 
-UPDATE balances
-SET amount = sender_balance - amount_param
-WHERE user_id = sender_id_param AND currency = currency_param;
+INSERT INTO balances(user_id, wallet, currency, amount)
+VALUES (
+           sender_id_param, sender_wallet_param, currency_param, -amount_param
+       )
+    ON CONFLICT (user_id, wallet, currency)
+    DO UPDATE SET amount = balances.amount + EXCLUDED.amount;
 
 SELECT amount
 INTO receiver_balance
 FROM balances
-WHERE user_id = receiver_id_param AND currency = currency_param;
+WHERE user_id = receiver_id_param AND wallet = receiver_wallet_param AND currency = currency_param;
 
 SELECT amount
 INTO sender_balance
 FROM balances
-WHERE user_id = sender_id_param AND currency = currency_param;
+WHERE user_id = sender_id_param AND wallet = sender_wallet_param AND currency = currency_param;
 
-PERFORM log_transaction(
+log_id := log_transaction(
       sender_id_param, receiver_id_param, initiator_id_param, 100,
       sender_balance, receiver_balance,
-      currency_param, amount_param, commission_amount
+      currency_param, amount_param, commission_amount,
+      sender_wallet_param, receiver_wallet_param
   );
+
+RETURN QUERY
+SELECT transaction_logs.id, transaction_logs.fee, transaction_logs.created_at
+FROM transaction_logs
+WHERE transaction_logs.id = log_id;
+END;
+$$ LANGUAGE plpgsql;
+
+-- proceed_transaction is a transfer initiated by a user, checked against the
+-- permissions of the initiator and the parties before the funds move.
+CREATE OR REPLACE FUNCTION proceed_transaction(
+  sender_id_param integer,
+  receiver_id_param integer,
+  initiator_id_param integer,
+  currency_param varchar(64),
+  amount_param bigint,
+  fee_param integer,
+  sender_wallet_param varchar(32) DEFAULT 'primary',
+  receiver_wallet_param varchar(32) DEFAULT 'primary'
+)
+  RETURNS TABLE(
+    receipt_transaction_id integer,
+    receipt_fee bigint,
+    receipt_created_at timestamp
+  ) AS $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM users WHERE id = sender_id_param) THEN
+    PERFORM raise_error(101);
+END IF;
+
+  IF NOT EXISTS (SELECT 1 FROM users WHERE id = receiver_id_param) THEN
+    PERFORM raise_error(102);
+END IF;
+
+  IF NOT EXISTS (SELECT 1 FROM users WHERE id = initiator_id_param) THEN
+    PERFORM raise_error(103);
+END IF;
+
+PERFORM check_transaction_permissions(initiator_id_param, sender_id_param, receiver_id_param);
+
+  IF initiator_id_param != sender_id_param THEN
+    PERFORM check_organization_spending(sender_id_param, initiator_id_param, currency_param, amount_param);
+END IF;
+
+RETURN QUERY
+SELECT *
+FROM transfer_funds(
+    sender_id_param, receiver_id_param, initiator_id_param, currency_param, amount_param, fee_param,
+    sender_wallet_param, receiver_wallet_param
+);
 END;
 $$ LANGUAGE plpgsql;
 
@@ -210,12 +552,7 @@ END IF;
     PERFORM raise_error(202);
 END IF;
 
-  IF NOT EXISTS (
-      SELECT 1 FROM user_permission
-      JOIN permissions ON permissions.id = user_permission.permission_id
-     WHERE user_id = initiator_id_param
-       AND (permissions.name = 'print_money' OR permissions.name = 'administrator')
-  ) THEN
+  IF NOT has_permission(initiator_id_param, 'print_money', 'administrator') THEN
     PERFORM raise_error(203);
 END IF;
 
@@ -225,13 +562,13 @@ END IF;
 
 INSERT INTO balances(user_id, currency, amount)
 VALUES (receiver_id_param, currency_param, amount_param)
-    ON CONFLICT (user_id, currency)
+    ON CONFLICT (user_id, wallet, currency)
     DO UPDATE SET amount = balances.amount + EXCLUDED.amount;
 
 SELECT amount
 INTO receiver_balance
 FROM balances
-WHERE user_id = receiver_id_param;
+WHERE user_id = receiver_id_param AND wallet = 'primary' AND currency = currency_param;
 
 PERFORM log_print_money(
       receiver_id_param, initiator_id_param, 200, receiver_balance,
@@ -240,24 +577,44 @@ PERFORM log_print_money(
 END;
 $$ LANGUAGE plpgsql;
 
+-- get_balances returns the balances of a wallet with the credit line of each
+-- currency and what is available to spend. Currencies with a credit line show
+-- up even before the user held them.
 CREATE FUNCTION get_balances(
     initiator_id_param integer,
-    user_id_param integer
+    user_id_param integer,
+    wallet_param varchar(32) DEFAULT 'primary'
 )
-    RETURNS TABLE(currency varchar(64), amount bigint) AS $$
+    RETURNS TABLE(currency varchar(64), amount bigint, credit_limit bigint, available bigint) AS $$
 BEGIN
-    IF NOT EXISTS (
-        SELECT 1 FROM user_permission
-        WHERE initiator_id_param = user_id AND
-              (permission_id = 1 OR permission_id = 6)
-    ) AND user_id_param != initiator_id_param THEN
+    IF NOT has_permission(initiator_id_param, 'administrator', 'audit_funds')
+       AND user_id_param != initiator_id_param
+       AND organization_role(user_id_param, initiator_id_param) IS NULL THEN
       PERFORM raise_error(301);
 END IF;
 
+    IF NOT wallet_exists(user_id_param, wallet_param) THEN
+      PERFORM raise_error(2102);
+END IF;
+
 RETURN QUERY
-SELECT balances.currency, balances.amount
-FROM balances
-WHERE user_id = user_id_param;
+SELECT
+    coalesce(wallet_balances.currency, credit_lines.currency),
+    coalesce(wallet_balances.amount, 0),
+    coalesce(credit_lines.credit_limit, 0),
+    coalesce(wallet_balances.amount, 0) + coalesce(credit_lines.credit_limit, 0)
+FROM (
+    SELECT balances.currency, balances.amount
+    FROM balances
+    WHERE balances.user_id = user_id_param
+      AND balances.wallet = wallet_param
+) AS wallet_balances
+FULL JOIN (
+    SELECT credit_limits.currency, credit_limits.credit_limit
+    FROM credit_limits
+    WHERE credit_limits.user_id = user_id_param
+      AND wallet_param = 'primary'
+) AS credit_lines ON credit_lines.currency = wallet_balances.currency;
 END;
 $$ LANGUAGE plpgsql;
 
@@ -279,31 +636,42 @@ INSERT INTO users (username, password_hash)
 VALUES (username_param, password_hash_param)
     RETURNING id INTO new_user_id;
 
+PERFORM enqueue_event('registration', ARRAY[new_user_id], jsonb_build_object(
+    'user_id', new_user_id,
+    'username', username_param
+));
+
 RETURN new_user_id;
 END;
 $$ LANGUAGE plpgsql;
 
+-- History functions cover one wallet, the primary one unless given. Printed
+-- money always lands in the primary wallet.
 CREATE OR REPLACE FUNCTION get_amount_of_user_transactions(
   initiator_id_param integer,
-  user_id_param integer
+  user_id_param integer,
+  wallet_param varchar(32) DEFAULT 'primary'
 )
 RETURNS integer AS $$
 DECLARE
 transaction_count INTEGER;
 BEGIN
-  IF NOT EXISTS (
-      SELECT 1 FROM user_permission
-      WHERE initiator_id_param = user_id AND
-            (permission_id = 1 OR permission_id = 6)
-  ) AND user_id_param != initiator_id_param THEN
+  IF NOT has_permission(initiator_id_param, 'administrator', 'audit_funds')
+     AND user_id_param != initiator_id_param
+     AND organization_role(user_id_param, initiator_id_param) IS NULL THEN
     PERFORM raise_error(301);
 END IF;
 
+  IF NOT wallet_exists(user_id_param, wallet_param) THEN
+    PERFORM raise_error(2102);
+END IF;
+
 SELECT
     (
         SELECT COUNT(*)
         FROM transaction_logs
-        WHERE (sender_id = user_id_param OR receiver_id = user_id_param)
+        WHERE ((sender_id = user_id_param AND sender_wallet = wallet_param)
+            OR (receiver_id = user_id_param AND receiver_wallet = wallet_param))
           AND transaction_status = 100
     )
         +
@@ -312,6 +680,7 @@ SELECT
         FROM print_money_logs
         WHERE (initiator_id = user_id_param OR receiver_id = user_id_param)
           AND print_status = 200
+          AND wallet_param = 'primary'
     )
 INTO transaction_count;
 
@@ -323,7 +692,8 @@ CREATE OR REPLACE FUNCTION get_transaction_history(
   initiator_id_param INTEGER,
   user_id_param INTEGER,
   limit_param INTEGER,
-  offset_param INTEGER
+  offset_param INTEGER,
+  wallet_param VARCHAR(32) DEFAULT 'primary'
 ) RETURNS TABLE(
   sender_id INTEGER,
   receiver_id INTEGER,
@@ -331,18 +701,21 @@ CREATE OR REPLACE FUNCTION get_transaction_history(
   currency VARCHAR(64),
   amount BIGINT,
   fee BIGINT,
-  created_at TIMESTAMP
+  created_at TIMESTAMP,
+  sender_wallet VARCHAR(32),
+  receiver_wallet VARCHAR(32)
 ) AS $$
 BEGIN
   IF user_id_param != initiator_id_param
-     AND NOT EXISTS (
-       SELECT 1 FROM user_permission
-       WHERE user_id = initiator_id_param
-         AND permission_id IN (1, 6)
-     ) THEN
+     AND organization_role(user_id_param, initiator_id_param) IS NULL
+     AND NOT has_permission(initiator_id_param, 'administrator', 'audit_funds') THEN
     PERFORM raise_error(301);
 END IF;
 
+  IF NOT wallet_exists(user_id_param, wallet_param) THEN
+    PERFORM raise_error(2102);
+END IF;
+
 RETURN QUERY
 SELECT
     transaction_logs.sender_id,
@@ -351,10 +724,12 @@ SELECT
     transaction_logs.currency,
     transaction_logs.amount,
     transaction_logs.fee,
-    transaction_logs.created_at
+    transaction_logs.created_at,
+    transaction_logs.sender_wallet,
+    transaction_logs.receiver_wallet
 FROM transaction_logs
-WHERE (transaction_logs.sender_id = user_id_param
-    OR transaction_logs.receiver_id = user_id_param)
+WHERE ((transaction_logs.sender_id = user_id_param AND transaction_logs.sender_wallet = wallet_param)
+    OR (transaction_logs.receiver_id = user_id_param AND transaction_logs.receiver_wallet = wallet_param))
   AND transaction_logs.transaction_status = 100
 
 UNION ALL
@@ -366,90 +741,177 @@ SELECT
     print_money_logs.currency,
     print_money_logs.amount,
     0 AS fee,
-    print_money_logs.created_at
+    print_money_logs.created_at,
+    NULL::VARCHAR(32) AS sender_wallet,
+    'primary'::VARCHAR(32) AS receiver_wallet
 FROM print_money_logs
 WHERE print_money_logs.receiver_id = user_id_param
   AND print_money_logs.print_status = 200
+  AND wallet_param = 'primary'
 
 ORDER BY created_at DESC
 OFFSET offset_param LIMIT limit_param;
 END;
 $$ LANGUAGE plpgsql;
 
-CREATE OR REPLACE FUNCTION set_permission(
+CREATE OR REPLACE FUNCTION check_permission_change(
   initiator_id_param INTEGER,
-  user_id_param INTEGER,
   permission_id_param INTEGER
 ) RETURNS VOID AS $$
+DECLARE
+  permission_name VARCHAR(32);
+  permission_admin_only BOOLEAN;
 BEGIN
-  IF NOT EXISTS (
-      SELECT 1 FROM permissions WHERE id = permission_id_param
-  ) THEN
-    PERFORM raise_error(401);
+SELECT name, admin_only INTO permission_name, permission_admin_only
+FROM permissions
+WHERE id = permission_id_param;
+
+  IF permission_name IS NULL THEN
+    PERFORM raise_error(602);
 END IF;
-  IF permission_id_param = 1 THEN
-    PERFORM raise_error(401);
+
+  IF permission_name = 'administrator' THEN
+    PERFORM raise_error(601);
 END IF;
 
-  IF permission_id_param IN (2, 5)
-     AND NOT EXISTS (
-       SELECT 1 FROM user_permission
-       WHERE user_id = initiator_id_param
-         AND permission_id = 1
-     ) THEN
-    PERFORM raise_error(401);
+  IF permission_admin_only
+     AND NOT has_permission(initiator_id_param, 'administrator') THEN
+    PERFORM raise_error(601);
 END IF;
 
-  IF NOT EXISTS (
-      SELECT 1 FROM user_permission
-      WHERE user_id = initiator_id_param
-        AND permission_id IN (1, 2)
-  ) THEN
-    PERFORM raise_error(401);
+  IF NOT has_permission(initiator_id_param, 'administrator', 'manage_user_permissions') THEN
+    PERFORM raise_error(601);
 END IF;
+END;
+$$ LANGUAGE plpgsql;
 
-INSERT INTO user_permission (user_id, permission_id)
-VALUES (user_id_param, permission_id_param)
-    ON CONFLICT DO NOTHING;
+CREATE OR REPLACE FUNCTION log_permission_change(
+  user_id_param INTEGER,
+  permission_id_param INTEGER,
+  action_param VARCHAR(16),
+  initiator_id_param INTEGER,
+  expires_at_param TIMESTAMPTZ
+) RETURNS VOID AS $$
+BEGIN
+INSERT INTO user_permission_history(user_id, permission_id, permission_name, action, initiator_id, expires_at)
+SELECT user_id_param, permissions.id, permissions.name, action_param, initiator_id_param, expires_at_param
+FROM permissions
+WHERE permissions.id = permission_id_param;
+
+PERFORM enqueue_event('permission_change', ARRAY[user_id_param], jsonb_build_object(
+    'user_id', user_id_param,
+    'permission_id', permission_id_param,
+    'permission', (SELECT name FROM permissions WHERE id = permission_id_param),
+    'action', action_param,
+    'initiator_id', initiator_id_param,
+    'expires_at', expires_at_param
+));
 END;
 $$ LANGUAGE plpgsql;
 
-CREATE OR REPLACE FUNCTION unset_permission(
+-- set_permission grants a permission, until expires_at_param if given.
+-- Granting it again replaces the expiry of the existing grant.
+CREATE OR REPLACE FUNCTION set_permission(
   initiator_id_param INTEGER,
   user_id_param INTEGER,
-  permission_id_param INTEGER
+  permission_id_param INTEGER,
+  expires_at_param TIMESTAMPTZ DEFAULT NULL
 ) RETURNS VOID AS $$
 BEGIN
-  IF NOT EXISTS (
-      SELECT 1 FROM permissions WHERE id = permission_id_param
-  ) THEN
-    PERFORM raise_error(401);
-END IF;
+PERFORM check_permission_change(initiator_id_param, permission_id_param);
 
-  IF permission_id_param = 1 THEN
-    PERFORM raise_error(401);
+  IF expires_at_param IS NOT NULL AND expires_at_param <= now() THEN
+    PERFORM raise_error(603);
 END IF;
 
-  IF permission_id_param IN (2, 5)
-     AND NOT EXISTS (
-       SELECT 1 FROM user_permission
-       WHERE user_id = initiator_id_param
-         AND permission_id = 1
-     ) THEN
-    PERFORM raise_error(401);
-END IF;
+INSERT INTO user_permission (user_id, permission_id, granted_by, granted_at, expires_at)
+VALUES (user_id_param, permission_id_param, initiator_id_param, now(), expires_at_param)
+    ON CONFLICT (user_id, permission_id) DO UPDATE
+    SET granted_by = EXCLUDED.granted_by,
+        granted_at = EXCLUDED.granted_at,
+        expires_at = EXCLUDED.expires_at;
 
-  IF NOT EXISTS (
-      SELECT 1 FROM user_permission
-      WHERE user_id = initiator_id_param
-        AND permission_id IN (1, 2)
-  ) THEN
-    PERFORM raise_error(401);
-END IF;
+PERFORM log_permission_change(user_id_param, permission_id_param, 'granted', initiator_id_param, expires_at_param);
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION unset_permission(
+  initiator_id_param INTEGER,
+  user_id_param INTEGER,
+  permission_id_param INTEGER
+) RETURNS VOID AS $$
+BEGIN
+PERFORM check_permission_change(initiator_id_param, permission_id_param);
 
 DELETE FROM user_permission
 WHERE user_id = user_id_param
   AND permission_id = permission_id_param;
+
+  -- Tokens are only invalidated when a grant was actually removed.
+  IF FOUND THEN
+    PERFORM log_permission_change(user_id_param, permission_id_param, 'revoked', initiator_id_param, NULL);
+
+UPDATE users
+SET token_version = token_version + 1
+WHERE id = user_id_param;
+END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+-- delete_expired_permissions drops grants that have run out and returns the
+-- users who lost one
+CREATE OR REPLACE FUNCTION delete_expired_permissions()
+RETURNS SETOF INTEGER AS $$
+DECLARE
+  expired RECORD;
+BEGIN
+FOR expired IN
+    DELETE FROM user_permission
+    WHERE expires_at <= now()
+    RETURNING user_id, permission_id, expires_at
+LOOP
+    PERFORM log_permission_change(expired.user_id, expired.permission_id, 'expired', NULL, expired.expires_at);
+
+    UPDATE users
+    SET token_version = token_version + 1
+    WHERE id = expired.user_id;
+
+    RETURN NEXT expired.user_id;
+END LOOP;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION get_permission_history(
+  initiator_id_param INTEGER,
+  user_id_param INTEGER,
+  limit_param INTEGER,
+  offset_param INTEGER
+) RETURNS TABLE(
+  permission_id INTEGER,
+  permission_name VARCHAR(32),
+  action VARCHAR(16),
+  initiator_id INTEGER,
+  expires_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ
+) AS $$
+BEGIN
+  IF user_id_param != initiator_id_param
+     AND NOT has_permission(initiator_id_param, 'administrator', 'manage_user_permissions', 'audit_funds') THEN
+    PERFORM raise_error(1401);
+END IF;
+
+RETURN QUERY
+SELECT
+    user_permission_history.permission_id,
+    user_permission_history.permission_name,
+    user_permission_history.action,
+    user_permission_history.initiator_id,
+    user_permission_history.expires_at,
+    user_permission_history.created_at
+FROM user_permission_history
+WHERE user_permission_history.user_id = user_id_param
+ORDER BY user_permission_history.created_at DESC, user_permission_history.id DESC
+OFFSET offset_param LIMIT limit_param;
 END;
 $$ LANGUAGE plpgsql;
 
@@ -465,43 +927,39 @@ BEGIN
     PERFORM raise_error(702);
 END IF;
 
-  IF NOT EXISTS (
-      SELECT 1 FROM user_permission
-      WHERE user_id = initiator_id_param
-        AND permission_id IN (1, 4)
-  ) THEN
+  IF NOT has_permission(initiator_id_param, 'administrator', 'control_user_accounts') THEN
     PERFORM raise_error(701);
 END IF;
 
-  IF EXISTS (
-      SELECT 1 FROM user_permission
-      WHERE user_id = target_user_id_param
-        AND permission_id = 1
-  ) THEN
-    IF NOT EXISTS (
-        SELECT 1 FROM user_permission
-        WHERE user_id = initiator_id_param
-          AND permission_id = 1
-    ) THEN
-      PERFORM raise_error(701);
+  IF has_permission(target_user_id_param, 'administrator')
+     AND NOT has_permission(initiator_id_param, 'administrator') THEN
+    PERFORM raise_error(701);
 END IF;
+
+  IF EXISTS (SELECT 1 FROM users WHERE id = target_user_id_param AND no_login) THEN
+    PERFORM raise_error(703);
 END IF;
 
 UPDATE users
-SET password_hash = new_password_hash_param
+SET password_hash = new_password_hash_param,
+    token_version = token_version + 1
 WHERE id = target_user_id_param;
 END;
 $$ LANGUAGE plpgsql;
 
 CREATE OR REPLACE FUNCTION create_refresh_token(
   user_id_param INTEGER,
-  expires_at_param TIMESTAMPTZ
+  expires_at_param TIMESTAMPTZ,
+  user_agent_param VARCHAR(256),
+  ip_param VARCHAR(64),
+  client_id_param VARCHAR(64) DEFAULT NULL,
+  scopes_param VARCHAR(32)[] DEFAULT NULL
 ) RETURNS UUID AS $$
 DECLARE
 new_token UUID;
 BEGIN
-INSERT INTO refresh_tokens(user_id, expires_at)
-VALUES (user_id_param, expires_at_param)
+INSERT INTO refresh_tokens(user_id, expires_at, user_agent, ip, client_id, scopes)
+VALUES (user_id_param, expires_at_param, user_agent_param, ip_param, client_id_param, scopes_param)
     RETURNING token INTO new_token;
 
 RETURN new_token;
@@ -520,24 +978,2784 @@ WHERE user_id = user_id_param
 END;
 $$ LANGUAGE plpgsql;
 
-CREATE OR REPLACE FUNCTION is_refresh_token_valid(
-  token_param UUID
-) RETURNS INTEGER AS $$
+CREATE OR REPLACE FUNCTION rotate_refresh_token(
+  token_param UUID,
+  expires_at_param TIMESTAMPTZ,
+  client_id_param VARCHAR(64),
+  OUT owner_id INTEGER,
+  OUT new_token UUID,
+  OUT scopes VARCHAR(32)[]
+) AS $$
 DECLARE
-valid_user INTEGER;
+current_token refresh_tokens%ROWTYPE;
 BEGIN
-SELECT user_id
-INTO valid_user
+SELECT *
+INTO current_token
 FROM refresh_tokens
 WHERE token = token_param
-  AND revoked = false
-  AND expires_at > now()
-    LIMIT 1;
+    FOR UPDATE;
 
-IF valid_user IS NULL THEN
-    RETURN -1;
-ELSE
-    RETURN valid_user;
+-- Tokens issued to an OAuth client can only be refreshed by that client
+IF NOT FOUND OR current_token.client_id IS DISTINCT FROM client_id_param THEN
+    owner_id := -1;
+    RETURN;
+END IF;
+
+-- A revoked token presented again means it leaked: revoke the whole family
+IF current_token.revoked THEN
+UPDATE refresh_tokens
+SET revoked = true
+WHERE family_id = current_token.family_id
+  AND revoked = false;
+    owner_id := -2;
+    RETURN;
 END IF;
+
+IF current_token.expires_at <= now() THEN
+    owner_id := -1;
+    RETURN;
+END IF;
+
+INSERT INTO refresh_tokens(user_id, family_id, user_agent, ip, client_id, scopes, expires_at)
+VALUES (
+           current_token.user_id, current_token.family_id,
+           current_token.user_agent, current_token.ip,
+           current_token.client_id, current_token.scopes, expires_at_param
+       )
+    RETURNING token INTO new_token;
+
+UPDATE refresh_tokens
+SET revoked = true,
+    replaced_by = new_token
+WHERE token = token_param;
+
+owner_id := current_token.user_id;
+scopes := current_token.scopes;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION revoke_access_token(
+  jti_param VARCHAR(64),
+  user_id_param INTEGER,
+  expires_at_param TIMESTAMPTZ
+) RETURNS VOID AS $$
+BEGIN
+DELETE FROM revoked_access_tokens
+WHERE expires_at < now();
+
+INSERT INTO revoked_access_tokens(jti, user_id, expires_at)
+VALUES (jti_param, user_id_param, expires_at_param)
+    ON CONFLICT DO NOTHING;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION reserve_token_spending(
+  jti_param VARCHAR(64),
+  currency_param VARCHAR(64),
+  amount_param BIGINT,
+  limit_param BIGINT,
+  expires_at_param TIMESTAMPTZ
+) RETURNS BOOLEAN AS $$
+BEGIN
+DELETE FROM scoped_token_spending
+WHERE expires_at <= now();
+
+INSERT INTO scoped_token_spending(jti, currency, expires_at)
+VALUES (jti_param, currency_param, expires_at_param)
+    ON CONFLICT DO NOTHING;
+
+UPDATE scoped_token_spending
+SET spent = spent + amount_param
+WHERE jti = jti_param
+  AND currency = currency_param
+  AND spent + amount_param <= limit_param;
+
+RETURN FOUND;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION release_token_spending(
+  jti_param VARCHAR(64),
+  currency_param VARCHAR(64),
+  amount_param BIGINT
+) RETURNS VOID AS $$
+BEGIN
+UPDATE scoped_token_spending
+SET spent = GREATEST(spent - amount_param, 0)
+WHERE jti = jti_param
+  AND currency = currency_param;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION create_signing_key(
+  kid_param VARCHAR(64),
+  algorithm_param VARCHAR(16),
+  private_key_param TEXT,
+  rotate_before_param TIMESTAMPTZ
+) RETURNS BOOLEAN AS $$
+BEGIN
+-- Serialize rotation so that several instances don't add a key each
+PERFORM pg_advisory_xact_lock(hashtext('signing_keys'));
+
+IF EXISTS (
+    SELECT 1 FROM signing_keys
+    WHERE algorithm = algorithm_param
+      AND created_at > rotate_before_param
+) THEN
+    RETURN false;
+END IF;
+
+INSERT INTO signing_keys(kid, algorithm, private_key)
+VALUES (kid_param, algorithm_param, private_key_param);
+
+RETURN true;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION check_session_permissions(
+  initiator_id_param INTEGER,
+  user_id_param INTEGER
+) RETURNS VOID AS $$
+BEGIN
+  IF user_id_param != initiator_id_param
+     AND NOT has_permission(initiator_id_param, 'administrator', 'control_user_accounts') THEN
+    PERFORM raise_error(901);
+END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION get_sessions(
+  initiator_id_param INTEGER,
+  user_id_param INTEGER
+) RETURNS TABLE(
+  session_id UUID,
+  created_at TIMESTAMPTZ,
+  last_used_at TIMESTAMPTZ,
+  expires_at TIMESTAMPTZ,
+  user_agent VARCHAR(256),
+  ip VARCHAR(64),
+  client_id VARCHAR(64)
+) AS $$
+BEGIN
+PERFORM check_session_permissions(initiator_id_param, user_id_param);
+
+RETURN QUERY
+SELECT
+    refresh_tokens.family_id,
+    (
+        SELECT MIN(family.created_at)
+        FROM refresh_tokens family
+        WHERE family.family_id = refresh_tokens.family_id
+    ),
+    refresh_tokens.created_at,
+    refresh_tokens.expires_at,
+    refresh_tokens.user_agent,
+    refresh_tokens.ip,
+    refresh_tokens.client_id
+FROM refresh_tokens
+WHERE refresh_tokens.user_id = user_id_param
+  AND refresh_tokens.revoked = false
+  AND refresh_tokens.expires_at > now()
+ORDER BY refresh_tokens.created_at DESC;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION revoke_session(
+  initiator_id_param INTEGER,
+  user_id_param INTEGER,
+  session_id_param UUID
+) RETURNS VOID AS $$
+BEGIN
+PERFORM check_session_permissions(initiator_id_param, user_id_param);
+
+UPDATE refresh_tokens
+SET revoked = true
+WHERE family_id = session_id_param
+  AND user_id = user_id_param
+  AND revoked = false;
+
+IF NOT FOUND THEN
+    PERFORM raise_error(902);
+END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION revoke_user_sessions(
+  initiator_id_param INTEGER,
+  user_id_param INTEGER
+) RETURNS VOID AS $$
+BEGIN
+PERFORM check_session_permissions(initiator_id_param, user_id_param);
+PERFORM invalidate_refresh_tokens(user_id_param);
+
+UPDATE users
+SET token_version = token_version + 1
+WHERE id = user_id_param;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION revoke_session_by_token(
+  user_id_param INTEGER,
+  token_param UUID
+) RETURNS BOOLEAN AS $$
+BEGIN
+UPDATE refresh_tokens
+SET revoked = true
+WHERE user_id = user_id_param
+  AND revoked = false
+  AND family_id = (
+      SELECT family_id FROM refresh_tokens
+      WHERE token = token_param
+        AND user_id = user_id_param
+  );
+
+RETURN FOUND;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION check_api_key_permissions(
+  initiator_id_param INTEGER,
+  user_id_param INTEGER
+) RETURNS VOID AS $$
+BEGIN
+  IF user_id_param != initiator_id_param
+     AND NOT has_permission(initiator_id_param, 'administrator', 'control_user_accounts') THEN
+    PERFORM raise_error(1003);
+END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION create_api_key(
+  user_id_param INTEGER,
+  name_param VARCHAR(64),
+  key_hash_param CHAR(64),
+  prefix_param VARCHAR(16),
+  permissions_param VARCHAR(32)[],
+  expires_at_param TIMESTAMPTZ
+) RETURNS INTEGER AS $$
+DECLARE
+  permission_name VARCHAR(32);
+  new_key_id INTEGER;
+BEGIN
+IF expires_at_param IS NOT NULL AND expires_at_param <= now() THEN
+    PERFORM raise_error(1005);
+END IF;
+
+-- A key can only narrow down what its owner is allowed to do
+IF permissions_param IS NOT NULL THEN
+    FOREACH permission_name IN ARRAY permissions_param LOOP
+        IF NOT EXISTS (SELECT 1 FROM permissions WHERE name = permission_name) THEN
+            PERFORM raise_error(1001);
+END IF;
+        IF NOT has_permission(user_id_param, permission_name, 'administrator') THEN
+            PERFORM raise_error(1002);
+END IF;
+    END LOOP;
+END IF;
+
+INSERT INTO api_keys(user_id, name, key_hash, prefix, restricted, expires_at)
+VALUES (user_id_param, name_param, key_hash_param, prefix_param, permissions_param IS NOT NULL, expires_at_param)
+RETURNING id INTO new_key_id;
+
+IF permissions_param IS NOT NULL THEN
+    INSERT INTO api_key_permissions(api_key_id, permission_id)
+    SELECT new_key_id, permissions.id
+    FROM permissions
+    WHERE permissions.name = ANY(permissions_param);
+END IF;
+
+RETURN new_key_id;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION get_api_key_identity(
+  key_hash_param CHAR(64)
+) RETURNS TABLE(
+  key_id INTEGER,
+  owner_id INTEGER,
+  restricted BOOLEAN,
+  permissions VARCHAR(32)[]
+) AS $$
+BEGIN
+-- Coarse last-use tracking keeps busy keys from writing on every request
+UPDATE api_keys
+SET last_used_at = now()
+WHERE api_keys.key_hash = key_hash_param
+  AND api_keys.revoked_at IS NULL
+  AND (api_keys.last_used_at IS NULL OR api_keys.last_used_at < now() - INTERVAL '1 minute');
+
+RETURN QUERY
+SELECT
+    api_keys.id,
+    api_keys.user_id,
+    api_keys.restricted,
+    ARRAY(
+        SELECT permissions.name
+        FROM api_key_permissions
+        JOIN permissions ON permissions.id = api_key_permissions.permission_id
+        WHERE api_key_permissions.api_key_id = api_keys.id
+    )::VARCHAR(32)[]
+FROM api_keys
+WHERE api_keys.key_hash = key_hash_param
+  AND api_keys.revoked_at IS NULL
+  AND (api_keys.expires_at IS NULL OR api_keys.expires_at > now());
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION get_api_keys(
+  initiator_id_param INTEGER,
+  user_id_param INTEGER
+) RETURNS TABLE(
+  key_id INTEGER,
+  name VARCHAR(64),
+  prefix VARCHAR(16),
+  restricted BOOLEAN,
+  permissions VARCHAR(32)[],
+  created_at TIMESTAMPTZ,
+  expires_at TIMESTAMPTZ,
+  last_used_at TIMESTAMPTZ
+) AS $$
+BEGIN
+PERFORM check_api_key_permissions(initiator_id_param, user_id_param);
+
+RETURN QUERY
+SELECT
+    api_keys.id,
+    api_keys.name,
+    api_keys.prefix,
+    api_keys.restricted,
+    ARRAY(
+        SELECT permissions.name
+        FROM api_key_permissions
+        JOIN permissions ON permissions.id = api_key_permissions.permission_id
+        WHERE api_key_permissions.api_key_id = api_keys.id
+    )::VARCHAR(32)[],
+    api_keys.created_at,
+    api_keys.expires_at,
+    api_keys.last_used_at
+FROM api_keys
+WHERE api_keys.user_id = user_id_param
+  AND api_keys.revoked_at IS NULL
+  AND (api_keys.expires_at IS NULL OR api_keys.expires_at > now())
+ORDER BY api_keys.created_at DESC;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION revoke_api_key(
+  initiator_id_param INTEGER,
+  user_id_param INTEGER,
+  key_id_param INTEGER
+) RETURNS VOID AS $$
+BEGIN
+PERFORM check_api_key_permissions(initiator_id_param, user_id_param);
+
+UPDATE api_keys
+SET revoked_at = now()
+WHERE id = key_id_param
+  AND user_id = user_id_param
+  AND revoked_at IS NULL;
+
+IF NOT FOUND THEN
+    PERFORM raise_error(1004);
+END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION check_oauth_client_permissions(
+  initiator_id_param INTEGER,
+  user_id_param INTEGER
+) RETURNS VOID AS $$
+BEGIN
+  IF user_id_param != initiator_id_param
+     AND NOT has_permission(initiator_id_param, 'administrator', 'control_user_accounts') THEN
+    PERFORM raise_error(1102);
+END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION create_oauth_client(
+  owner_id_param INTEGER,
+  client_id_param VARCHAR(64),
+  client_secret_hash_param CHAR(64),
+  name_param VARCHAR(64),
+  redirect_uris_param TEXT[],
+  scopes_param VARCHAR(32)[]
+) RETURNS VOID AS $$
+BEGIN
+IF EXISTS (
+    SELECT 1 FROM unnest(scopes_param) AS scope
+    WHERE NOT EXISTS (SELECT 1 FROM permissions WHERE permissions.name = scope)
+) THEN
+    PERFORM raise_error(1101);
+END IF;
+
+INSERT INTO oauth_clients(client_id, client_secret_hash, owner_id, name, redirect_uris, scopes)
+VALUES (client_id_param, client_secret_hash_param, owner_id_param, name_param, redirect_uris_param, scopes_param);
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION get_oauth_client(
+  client_id_param VARCHAR(64)
+) RETURNS TABLE(
+  client_secret_hash CHAR(64),
+  owner_id INTEGER,
+  name VARCHAR(64),
+  redirect_uris TEXT[],
+  scopes VARCHAR(32)[],
+  created_at TIMESTAMPTZ
+) AS $$
+BEGIN
+RETURN QUERY
+SELECT
+    oauth_clients.client_secret_hash,
+    oauth_clients.owner_id,
+    oauth_clients.name,
+    oauth_clients.redirect_uris,
+    oauth_clients.scopes,
+    oauth_clients.created_at
+FROM oauth_clients
+WHERE oauth_clients.client_id = client_id_param
+  AND oauth_clients.revoked_at IS NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION get_oauth_clients(
+  initiator_id_param INTEGER,
+  user_id_param INTEGER
+) RETURNS TABLE(
+  client_id VARCHAR(64),
+  confidential BOOLEAN,
+  name VARCHAR(64),
+  redirect_uris TEXT[],
+  scopes VARCHAR(32)[],
+  created_at TIMESTAMPTZ
+) AS $$
+BEGIN
+PERFORM check_oauth_client_permissions(initiator_id_param, user_id_param);
+
+RETURN QUERY
+SELECT
+    oauth_clients.client_id,
+    oauth_clients.client_secret_hash IS NOT NULL,
+    oauth_clients.name,
+    oauth_clients.redirect_uris,
+    oauth_clients.scopes,
+    oauth_clients.created_at
+FROM oauth_clients
+WHERE oauth_clients.owner_id = user_id_param
+  AND oauth_clients.revoked_at IS NULL
+ORDER BY oauth_clients.created_at DESC;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION revoke_oauth_client(
+  initiator_id_param INTEGER,
+  user_id_param INTEGER,
+  client_id_param VARCHAR(64)
+) RETURNS VOID AS $$
+BEGIN
+PERFORM check_oauth_client_permissions(initiator_id_param, user_id_param);
+
+UPDATE oauth_clients
+SET revoked_at = now()
+WHERE client_id = client_id_param
+  AND owner_id = user_id_param
+  AND revoked_at IS NULL;
+
+IF NOT FOUND THEN
+    PERFORM raise_error(1103);
+END IF;
+
+UPDATE refresh_tokens
+SET revoked = true
+WHERE client_id = client_id_param
+  AND revoked = false;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION create_authorization_code(
+  code_hash_param CHAR(64),
+  client_id_param VARCHAR(64),
+  user_id_param INTEGER,
+  redirect_uri_param TEXT,
+  scopes_param VARCHAR(32)[],
+  code_challenge_param VARCHAR(128),
+  expires_at_param TIMESTAMPTZ
+) RETURNS VOID AS $$
+BEGIN
+DELETE FROM oauth_authorization_codes
+WHERE expires_at <= now();
+
+INSERT INTO oauth_authorization_codes(code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, expires_at)
+VALUES (code_hash_param, client_id_param, user_id_param, redirect_uri_param, scopes_param, code_challenge_param, expires_at_param);
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION consume_authorization_code(
+  code_hash_param CHAR(64),
+  client_id_param VARCHAR(64),
+  redirect_uri_param TEXT
+) RETURNS TABLE(
+  user_id INTEGER,
+  scopes VARCHAR(32)[],
+  code_challenge VARCHAR(128)
+) AS $$
+BEGIN
+RETURN QUERY
+UPDATE oauth_authorization_codes
+SET used = true
+WHERE oauth_authorization_codes.code_hash = code_hash_param
+  AND oauth_authorization_codes.client_id = client_id_param
+  AND oauth_authorization_codes.redirect_uri = redirect_uri_param
+  AND oauth_authorization_codes.used = false
+  AND oauth_authorization_codes.expires_at > now()
+RETURNING
+    oauth_authorization_codes.user_id,
+    oauth_authorization_codes.scopes,
+    oauth_authorization_codes.code_challenge;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION begin_totp_enrollment(
+  user_id_param INTEGER,
+  secret_param VARCHAR(64)
+) RETURNS VOID AS $$
+BEGIN
+  IF EXISTS (
+      SELECT 1 FROM user_totp
+      WHERE user_id = user_id_param
+        AND enabled = true
+  ) THEN
+    PERFORM raise_error(801);
+END IF;
+
+INSERT INTO user_totp(user_id, secret)
+VALUES (user_id_param, secret_param)
+    ON CONFLICT (user_id)
+    DO UPDATE SET secret = EXCLUDED.secret, created_at = now();
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION confirm_totp(
+  user_id_param INTEGER,
+  step_param BIGINT,
+  backup_code_hashes_param TEXT[]
+) RETURNS VOID AS $$
+BEGIN
+  IF NOT EXISTS (
+      SELECT 1 FROM user_totp
+      WHERE user_id = user_id_param
+        AND enabled = false
+  ) THEN
+    PERFORM raise_error(802);
+END IF;
+
+UPDATE user_totp
+SET enabled = true,
+    confirmed_at = now(),
+    last_used_step = step_param
+WHERE user_id = user_id_param;
+
+DELETE FROM totp_backup_codes
+WHERE user_id = user_id_param;
+
+INSERT INTO totp_backup_codes(user_id, code_hash)
+SELECT user_id_param, unnest(backup_code_hashes_param);
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION disable_totp(
+  user_id_param INTEGER
+) RETURNS VOID AS $$
+BEGIN
+  IF NOT EXISTS (
+      SELECT 1 FROM user_totp
+      WHERE user_id = user_id_param
+        AND enabled = true
+  ) THEN
+    PERFORM raise_error(803);
+END IF;
+
+DELETE FROM totp_backup_codes
+WHERE user_id = user_id_param;
+
+DELETE FROM user_totp
+WHERE user_id = user_id_param;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION use_totp_step(
+  user_id_param INTEGER,
+  step_param BIGINT
+) RETURNS BOOLEAN AS $$
+BEGIN
+UPDATE user_totp
+SET last_used_step = step_param
+WHERE user_id = user_id_param
+  AND enabled = true
+  AND last_used_step < step_param;
+
+RETURN FOUND;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION use_totp_backup_code(
+  user_id_param INTEGER,
+  code_hash_param CHAR(64)
+) RETURNS BOOLEAN AS $$
+BEGIN
+UPDATE totp_backup_codes
+SET used_at = now()
+WHERE user_id = user_id_param
+  AND code_hash = code_hash_param
+  AND used_at IS NULL;
+
+RETURN FOUND;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION create_login_challenge(
+  user_id_param INTEGER,
+  expires_at_param TIMESTAMPTZ
+) RETURNS UUID AS $$
+DECLARE
+new_challenge UUID;
+BEGIN
+INSERT INTO login_challenges(user_id, expires_at)
+VALUES (user_id_param, expires_at_param)
+    RETURNING token INTO new_challenge;
+
+RETURN new_challenge;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION get_login_challenge_user(
+  token_param UUID,
+  max_attempts_param INTEGER,
+  window_start_param TIMESTAMPTZ
+) RETURNS INTEGER AS $$
+DECLARE
+challenge_user INTEGER;
+  failed_attempts INTEGER;
+BEGIN
+SELECT user_id
+INTO challenge_user
+FROM login_challenges
+WHERE token = token_param
+  AND used = false
+  AND expires_at > now();
+
+IF challenge_user IS NULL THEN
+    RETURN -1;
+END IF;
+
+SELECT COALESCE(SUM(attempts), 0)
+INTO failed_attempts
+FROM login_challenges
+WHERE user_id = challenge_user
+  AND created_at > window_start_param;
+
+IF failed_attempts >= max_attempts_param THEN
+    RETURN -1;
+END IF;
+
+RETURN challenge_user;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION register_login_challenge_failure(
+  token_param UUID
+) RETURNS VOID AS $$
+BEGIN
+UPDATE login_challenges
+SET attempts = attempts + 1
+WHERE token = token_param;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION consume_login_challenge(
+  token_param UUID
+) RETURNS BOOLEAN AS $$
+BEGIN
+UPDATE login_challenges
+SET used = true
+WHERE token = token_param
+  AND used = false
+  AND expires_at > now();
+
+RETURN FOUND;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION check_lockout_permissions(
+  initiator_id_param INTEGER
+) RETURNS VOID AS $$
+BEGIN
+  IF NOT has_permission(initiator_id_param, 'administrator', 'control_user_accounts') THEN
+    PERFORM raise_error(1201);
+END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION get_login_lockout(
+  lockout_key_param VARCHAR(128)
+) RETURNS TIMESTAMPTZ AS $$
+DECLARE
+  blocked TIMESTAMPTZ;
+BEGIN
+SELECT blocked_until INTO blocked
+FROM login_lockouts
+WHERE lockout_key = lockout_key_param
+  AND blocked_until > now();
+
+RETURN blocked;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION register_login_failure(
+  lockout_key_param VARCHAR(128),
+  max_attempts_param INTEGER,
+  lockout_seconds_param INTEGER
+) RETURNS TIMESTAMPTZ AS $$
+DECLARE
+  lockout INTERVAL := make_interval(secs => lockout_seconds_param);
+  failures_count INTEGER;
+  blocked TIMESTAMPTZ;
+BEGIN
+-- Failures are counted within a window as long as the lockout itself, a
+-- window or lockout that has run out starts the count over.
+INSERT INTO login_lockouts(lockout_key, failures, window_started_at)
+VALUES (lockout_key_param, 1, now())
+ON CONFLICT (lockout_key) DO UPDATE
+SET failures = CASE
+        WHEN login_lockouts.blocked_until <= now()
+          OR (login_lockouts.blocked_until IS NULL AND login_lockouts.window_started_at <= now() - lockout) THEN 1
+        ELSE login_lockouts.failures + 1
+    END,
+    window_started_at = CASE
+        WHEN login_lockouts.blocked_until <= now()
+          OR (login_lockouts.blocked_until IS NULL AND login_lockouts.window_started_at <= now() - lockout) THEN now()
+        ELSE login_lockouts.window_started_at
+    END,
+    blocked_until = CASE
+        WHEN login_lockouts.blocked_until <= now() THEN NULL
+        ELSE login_lockouts.blocked_until
+    END
+RETURNING failures, blocked_until INTO failures_count, blocked;
+
+IF blocked IS NULL AND failures_count >= max_attempts_param THEN
+UPDATE login_lockouts
+SET blocked_until = now() + lockout
+WHERE lockout_key = lockout_key_param
+RETURNING blocked_until INTO blocked;
+END IF;
+
+RETURN blocked;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION clear_login_lockout(
+  lockout_key_param VARCHAR(128)
+) RETURNS BOOLEAN AS $$
+BEGIN
+DELETE FROM login_lockouts
+WHERE lockout_key = lockout_key_param;
+
+RETURN FOUND;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION get_login_lockouts()
+RETURNS TABLE(
+  lockout_key VARCHAR(128),
+  failures INTEGER,
+  blocked_until TIMESTAMPTZ
+) AS $$
+BEGIN
+RETURN QUERY
+SELECT login_lockouts.lockout_key, login_lockouts.failures, login_lockouts.blocked_until
+FROM login_lockouts
+WHERE login_lockouts.blocked_until > now()
+ORDER BY login_lockouts.blocked_until DESC;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION delete_expired_login_lockouts(
+  lockout_seconds_param INTEGER
+) RETURNS VOID AS $$
+BEGIN
+DELETE FROM login_lockouts
+WHERE blocked_until <= now()
+   OR (blocked_until IS NULL AND window_started_at <= now() - make_interval(secs => lockout_seconds_param));
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION take_rate_limit_token(
+  bucket_key_param VARCHAR(256),
+  capacity_param INTEGER,
+  refill_per_second_param DOUBLE PRECISION,
+  OUT allowed BOOLEAN,
+  OUT tokens_left DOUBLE PRECISION
+) AS $$
+BEGIN
+-- The bucket is refilled for the time since it was last used, the row lock
+-- taken by the upsert keeps concurrent requests from spending the same token.
+INSERT INTO rate_limit_buckets(bucket_key, tokens, updated_at)
+VALUES (bucket_key_param, capacity_param, now())
+ON CONFLICT (bucket_key) DO UPDATE
+SET tokens = LEAST(
+        capacity_param,
+        rate_limit_buckets.tokens + GREATEST(EXTRACT(EPOCH FROM now() - rate_limit_buckets.updated_at), 0) * refill_per_second_param
+    ),
+    updated_at = now()
+RETURNING tokens INTO tokens_left;
+
+allowed := tokens_left >= 1;
+IF allowed THEN
+UPDATE rate_limit_buckets
+SET tokens = tokens - 1
+WHERE bucket_key = bucket_key_param
+RETURNING tokens INTO tokens_left;
+END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION delete_idle_rate_limit_buckets(
+  idle_seconds_param INTEGER
+) RETURNS VOID AS $$
+BEGIN
+DELETE FROM rate_limit_buckets
+WHERE updated_at <= now() - make_interval(secs => idle_seconds_param);
+END;
+$$ LANGUAGE plpgsql;
+
+-- Roles can't carry administrator, and only administrators may manage roles
+-- or hand out roles that carry the permissions only they can grant directly
+CREATE OR REPLACE FUNCTION check_role_permissions(
+  initiator_id_param INTEGER,
+  permissions_param VARCHAR(32)[]
+) RETURNS VOID AS $$
+BEGIN
+  IF EXISTS (
+      SELECT 1 FROM unnest(permissions_param) AS permission_name
+      WHERE NOT EXISTS (SELECT 1 FROM permissions WHERE permissions.name = permission_name)
+  ) THEN
+    PERFORM raise_error(1304);
+END IF;
+
+  IF 'administrator' = ANY(permissions_param) THEN
+    PERFORM raise_error(1305);
+END IF;
+
+  IF NOT has_permission(initiator_id_param, 'administrator') THEN
+    PERFORM raise_error(1301);
+END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION create_role(
+  initiator_id_param INTEGER,
+  name_param VARCHAR(32),
+  permissions_param VARCHAR(32)[]
+) RETURNS INTEGER AS $$
+DECLARE
+  new_role_id INTEGER;
+BEGIN
+PERFORM check_role_permissions(initiator_id_param, permissions_param);
+
+  IF EXISTS (SELECT 1 FROM roles WHERE name = name_param) THEN
+    PERFORM raise_error(1303);
+END IF;
+
+INSERT INTO roles(name)
+VALUES (name_param)
+RETURNING id INTO new_role_id;
+
+INSERT INTO role_permission(role_id, permission_id)
+SELECT new_role_id, permissions.id
+FROM permissions
+WHERE permissions.name = ANY(permissions_param);
+
+RETURN new_role_id;
+END;
+$$ LANGUAGE plpgsql;
+
+-- update_role_permissions replaces the permissions of a role and returns the
+-- users holding it, whose tokens are invalidated
+CREATE OR REPLACE FUNCTION update_role_permissions(
+  initiator_id_param INTEGER,
+  role_id_param INTEGER,
+  permissions_param VARCHAR(32)[]
+) RETURNS SETOF INTEGER AS $$
+BEGIN
+PERFORM check_role_permissions(initiator_id_param, permissions_param);
+
+  IF NOT EXISTS (SELECT 1 FROM roles WHERE id = role_id_param) THEN
+    PERFORM raise_error(1302);
+END IF;
+
+DELETE FROM role_permission
+WHERE role_id = role_id_param;
+
+INSERT INTO role_permission(role_id, permission_id)
+SELECT role_id_param, permissions.id
+FROM permissions
+WHERE permissions.name = ANY(permissions_param);
+
+UPDATE users
+SET token_version = token_version + 1
+WHERE id IN (SELECT user_id FROM user_role WHERE role_id = role_id_param);
+
+RETURN QUERY
+SELECT user_role.user_id
+FROM user_role
+WHERE user_role.role_id = role_id_param;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION delete_role(
+  initiator_id_param INTEGER,
+  role_id_param INTEGER
+) RETURNS SETOF INTEGER AS $$
+BEGIN
+PERFORM check_role_permissions(initiator_id_param, '{}');
+
+  IF NOT EXISTS (SELECT 1 FROM roles WHERE id = role_id_param) THEN
+    PERFORM raise_error(1302);
+END IF;
+
+UPDATE users
+SET token_version = token_version + 1
+WHERE id IN (SELECT user_id FROM user_role WHERE role_id = role_id_param);
+
+RETURN QUERY
+SELECT user_role.user_id
+FROM user_role
+WHERE user_role.role_id = role_id_param;
+
+DELETE FROM roles
+WHERE id = role_id_param;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION get_roles()
+RETURNS TABLE(
+  role_id INTEGER,
+  role_name VARCHAR(32),
+  role_permissions VARCHAR(32)[]
+) AS $$
+BEGIN
+RETURN QUERY
+SELECT
+    roles.id,
+    roles.name,
+    COALESCE(array_agg(permissions.name ORDER BY permissions.name) FILTER (WHERE permissions.name IS NOT NULL), '{}')::VARCHAR(32)[]
+FROM roles
+LEFT JOIN role_permission ON role_permission.role_id = roles.id
+LEFT JOIN permissions ON permissions.id = role_permission.permission_id
+GROUP BY roles.id, roles.name
+ORDER BY roles.name;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION get_user_roles(
+  user_id_param INTEGER
+) RETURNS TABLE(
+  role_id INTEGER,
+  role_name VARCHAR(32),
+  role_permissions VARCHAR(32)[]
+) AS $$
+BEGIN
+RETURN QUERY
+SELECT all_roles.role_id, all_roles.role_name, all_roles.role_permissions
+FROM get_roles() AS all_roles
+JOIN user_role ON user_role.role_id = all_roles.role_id
+WHERE user_role.user_id = user_id_param
+ORDER BY all_roles.role_name;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Assigning follows the same rules as set_permission: roles carrying an
+-- admin_only permission need an administrator
+CREATE OR REPLACE FUNCTION check_role_assignment_permissions(
+  initiator_id_param INTEGER,
+  role_id_param INTEGER
+) RETURNS VOID AS $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM roles WHERE id = role_id_param) THEN
+    PERFORM raise_error(1302);
+END IF;
+
+  IF NOT has_permission(initiator_id_param, 'administrator', 'manage_user_permissions') THEN
+    PERFORM raise_error(1301);
+END IF;
+
+  IF EXISTS (
+      SELECT 1 FROM role_permission
+      JOIN permissions ON permissions.id = role_permission.permission_id
+      WHERE role_permission.role_id = role_id_param
+        AND permissions.admin_only
+  ) AND NOT has_permission(initiator_id_param, 'administrator') THEN
+    PERFORM raise_error(1301);
+END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION assign_role(
+  initiator_id_param INTEGER,
+  user_id_param INTEGER,
+  role_id_param INTEGER
+) RETURNS VOID AS $$
+BEGIN
+PERFORM check_role_assignment_permissions(initiator_id_param, role_id_param);
+
+INSERT INTO user_role(user_id, role_id)
+VALUES (user_id_param, role_id_param)
+    ON CONFLICT DO NOTHING;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION unassign_role(
+  initiator_id_param INTEGER,
+  user_id_param INTEGER,
+  role_id_param INTEGER
+) RETURNS VOID AS $$
+BEGIN
+PERFORM check_role_assignment_permissions(initiator_id_param, role_id_param);
+
+DELETE FROM user_role
+WHERE user_id = user_id_param
+  AND role_id = role_id_param;
+
+UPDATE users
+SET token_version = token_version + 1
+WHERE id = user_id_param;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION create_permission(
+  initiator_id_param INTEGER,
+  name_param VARCHAR(32),
+  description_param TEXT,
+  admin_only_param BOOLEAN
+) RETURNS INTEGER AS $$
+DECLARE
+  new_permission_id INTEGER;
+BEGIN
+  IF NOT has_permission(initiator_id_param, 'administrator') THEN
+    PERFORM raise_error(1401);
+END IF;
+
+  IF EXISTS (SELECT 1 FROM permissions WHERE name = name_param) THEN
+    PERFORM raise_error(1402);
+END IF;
+
+INSERT INTO permissions(name, description, admin_only, custom)
+VALUES (name_param, description_param, admin_only_param, true)
+RETURNING id INTO new_permission_id;
+
+RETURN new_permission_id;
+END;
+$$ LANGUAGE plpgsql;
+
+-- delete_permission removes a custom permission from everything that refers
+-- to it and returns the users who held it, whose tokens are invalidated
+CREATE OR REPLACE FUNCTION delete_permission(
+  initiator_id_param INTEGER,
+  permission_id_param INTEGER
+) RETURNS SETOF INTEGER AS $$
+DECLARE
+  permission_name VARCHAR(32);
+  permission_custom BOOLEAN;
+BEGIN
+  IF NOT has_permission(initiator_id_param, 'administrator') THEN
+    PERFORM raise_error(1401);
+END IF;
+
+SELECT name, custom INTO permission_name, permission_custom
+FROM permissions
+WHERE id = permission_id_param;
+
+  IF permission_name IS NULL THEN
+    PERFORM raise_error(1403);
+END IF;
+
+  IF NOT permission_custom THEN
+    PERFORM raise_error(1404);
+END IF;
+
+RETURN QUERY
+SELECT DISTINCT effective_user_permission.user_id
+FROM effective_user_permission
+WHERE effective_user_permission.permission_id = permission_id_param;
+
+UPDATE users
+SET token_version = token_version + 1
+WHERE id IN (
+    SELECT effective_user_permission.user_id
+    FROM effective_user_permission
+    WHERE effective_user_permission.permission_id = permission_id_param
+);
+
+DELETE FROM user_permission WHERE permission_id = permission_id_param;
+DELETE FROM role_permission WHERE permission_id = permission_id_param;
+DELETE FROM api_key_permissions WHERE permission_id = permission_id_param;
+
+UPDATE oauth_clients
+SET scopes = array_remove(scopes, permission_name)
+WHERE permission_name = ANY(scopes);
+
+UPDATE refresh_tokens
+SET scopes = array_remove(scopes, permission_name)
+WHERE permission_name = ANY(scopes);
+
+DELETE FROM permissions WHERE id = permission_id_param;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION get_permissions()
+RETURNS TABLE(
+  permission_id INTEGER,
+  permission_name VARCHAR(32),
+  permission_description TEXT,
+  permission_admin_only BOOLEAN,
+  permission_custom BOOLEAN
+) AS $$
+BEGIN
+RETURN QUERY
+SELECT
+    permissions.id,
+    permissions.name,
+    permissions.description,
+    permissions.admin_only,
+    permissions.custom
+FROM permissions
+ORDER BY permissions.id;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION get_user_permission_names(
+  user_id_param INTEGER
+) RETURNS TABLE(
+  permission_id INTEGER,
+  permission_name VARCHAR(32)
+) AS $$
+BEGIN
+RETURN QUERY
+SELECT DISTINCT permissions.id, permissions.name
+FROM effective_user_permission
+JOIN permissions ON permissions.id = effective_user_permission.permission_id
+WHERE effective_user_permission.user_id = user_id_param
+ORDER BY permissions.id;
+END;
+$$ LANGUAGE plpgsql;
+
+-- get_permission_holders lists who holds a permission directly or through a
+-- role, one row per grant. Administrators aren't listed unless granted it.
+CREATE OR REPLACE FUNCTION get_permission_holders(
+  initiator_id_param INTEGER,
+  permission_name_param VARCHAR(32),
+  limit_param INTEGER,
+  offset_param INTEGER
+) RETURNS TABLE(
+  holder_id INTEGER,
+  holder_username VARCHAR(64),
+  granted_through VARCHAR(32),
+  grant_expires_at TIMESTAMPTZ
+) AS $$
+DECLARE
+  permission_id_var INTEGER;
+BEGIN
+  IF NOT has_permission(initiator_id_param, 'administrator', 'manage_user_permissions', 'audit_funds') THEN
+    PERFORM raise_error(1401);
+END IF;
+
+SELECT id INTO permission_id_var
+FROM permissions
+WHERE name = permission_name_param;
+
+  IF permission_id_var IS NULL THEN
+    PERFORM raise_error(1403);
+END IF;
+
+RETURN QUERY
+SELECT holders.user_id, users.username, holders.role_name, holders.expires_at
+FROM (
+    SELECT user_permission.user_id, NULL::VARCHAR(32) AS role_name, user_permission.expires_at
+    FROM user_permission
+    WHERE user_permission.permission_id = permission_id_var
+      AND (user_permission.expires_at IS NULL OR user_permission.expires_at > now())
+    UNION ALL
+    SELECT user_role.user_id, roles.name, NULL::TIMESTAMPTZ
+    FROM user_role
+    JOIN roles ON roles.id = user_role.role_id
+    JOIN role_permission ON role_permission.role_id = user_role.role_id
+    WHERE role_permission.permission_id = permission_id_var
+) AS holders
+JOIN users ON users.id = holders.user_id
+ORDER BY holders.user_id, holders.role_name NULLS FIRST
+OFFSET offset_param LIMIT limit_param;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION record_audit_event(
+  actor_id_param INTEGER,
+  target_id_param INTEGER,
+  action_param VARCHAR(64),
+  parameters_param JSONB,
+  succeeded_param BOOLEAN,
+  error_param TEXT,
+  ip_param VARCHAR(64),
+  request_id_param VARCHAR(64)
+) RETURNS VOID AS $$
+BEGIN
+INSERT INTO audit_events(actor_id, target_id, action, parameters, succeeded, error, ip, request_id)
+VALUES (actor_id_param, target_id_param, action_param, COALESCE(parameters_param, '{}'), succeeded_param, error_param, ip_param, request_id_param);
+END;
+$$ LANGUAGE plpgsql;
+
+-- get_audit_events returns audit events newest first. NULL filters match
+-- everything.
+CREATE OR REPLACE FUNCTION get_audit_events(
+  initiator_id_param INTEGER,
+  actor_id_param INTEGER,
+  target_id_param INTEGER,
+  action_param VARCHAR(64),
+  from_param TIMESTAMPTZ,
+  to_param TIMESTAMPTZ,
+  limit_param INTEGER,
+  offset_param INTEGER
+) RETURNS TABLE(
+  event_id BIGINT,
+  event_actor_id INTEGER,
+  event_target_id INTEGER,
+  event_action VARCHAR(64),
+  event_parameters JSONB,
+  event_succeeded BOOLEAN,
+  event_error TEXT,
+  event_ip VARCHAR(64),
+  event_request_id VARCHAR(64),
+  event_created_at TIMESTAMPTZ
+) AS $$
+BEGIN
+  IF NOT has_permission(initiator_id_param, 'administrator', 'audit_funds') THEN
+    PERFORM raise_error(1501);
+END IF;
+
+RETURN QUERY
+SELECT
+    audit_events.id,
+    audit_events.actor_id,
+    audit_events.target_id,
+    audit_events.action,
+    audit_events.parameters,
+    audit_events.succeeded,
+    audit_events.error,
+    audit_events.ip,
+    audit_events.request_id,
+    audit_events.created_at
+FROM audit_events
+WHERE (actor_id_param IS NULL OR audit_events.actor_id = actor_id_param)
+  AND (target_id_param IS NULL OR audit_events.target_id = target_id_param)
+  AND (action_param IS NULL OR audit_events.action = action_param)
+  AND (from_param IS NULL OR audit_events.created_at >= from_param)
+  AND (to_param IS NULL OR audit_events.created_at < to_param)
+ORDER BY audit_events.id DESC
+OFFSET offset_param LIMIT limit_param;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION prevent_audit_event_changes()
+RETURNS TRIGGER AS $$
+BEGIN
+PERFORM raise_error(1502);
+RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_immutable ON audit_events;
+CREATE TRIGGER audit_events_immutable
+BEFORE UPDATE OR DELETE ON audit_events
+FOR EACH ROW EXECUTE FUNCTION prevent_audit_event_changes();
+
+DROP TRIGGER IF EXISTS audit_events_no_truncate ON audit_events;
+CREATE TRIGGER audit_events_no_truncate
+BEFORE TRUNCATE ON audit_events
+FOR EACH STATEMENT EXECUTE FUNCTION prevent_audit_event_changes();
+
+CREATE OR REPLACE FUNCTION check_ledger_permissions(
+  initiator_id_param INTEGER
+) RETURNS VOID AS $$
+BEGIN
+  IF NOT has_permission(initiator_id_param, 'administrator', 'audit_funds') THEN
+    PERFORM raise_error(1601);
+END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+-- verify_ledger_chain walks a chain in id order and reports the first row
+-- whose link or hash doesn't match. broken_id is NULL if the chain is intact.
+CREATE OR REPLACE FUNCTION verify_ledger_chain(
+  initiator_id_param INTEGER,
+  chain_param VARCHAR(32)
+) RETURNS TABLE(
+  checked_rows BIGINT,
+  broken_id INTEGER,
+  problem TEXT
+) AS $$
+DECLARE
+  entry RECORD;
+  expected_prev BYTEA := ledger_genesis_hash();
+  checked BIGINT := 0;
+BEGIN
+PERFORM check_ledger_permissions(initiator_id_param);
+
+  IF chain_param = 'transactions' THEN
+    FOR entry IN
+        SELECT transaction_logs.id, transaction_logs.prev_hash, transaction_logs.hash,
+               transaction_log_hash(
+                   transaction_logs.id, transaction_logs.sender_id, transaction_logs.receiver_id,
+                   transaction_logs.initiator_id, transaction_logs.transaction_status,
+                   transaction_logs.sender_balance_after, transaction_logs.receiver_balance_after,
+                   transaction_logs.currency, transaction_logs.amount, transaction_logs.fee,
+                   transaction_logs.sender_wallet, transaction_logs.receiver_wallet,
+                   transaction_logs.created_at, transaction_logs.prev_hash
+               ) AS computed
+        FROM transaction_logs
+        ORDER BY transaction_logs.id
+    LOOP
+        IF entry.prev_hash != expected_prev THEN
+            RETURN QUERY SELECT checked, entry.id, 'previous hash does not match'::TEXT;
+            RETURN;
+        END IF;
+        IF entry.hash != entry.computed THEN
+            RETURN QUERY SELECT checked, entry.id, 'hash does not match contents'::TEXT;
+            RETURN;
+        END IF;
+        expected_prev := entry.hash;
+        checked := checked + 1;
+    END LOOP;
+  ELSIF chain_param = 'print_money' THEN
+    FOR entry IN
+        SELECT print_money_logs.id, print_money_logs.prev_hash, print_money_logs.hash,
+               print_money_log_hash(
+                   print_money_logs.id, print_money_logs.receiver_id, print_money_logs.initiator_id,
+                   print_money_logs.print_status, print_money_logs.receiver_balance_after,
+                   print_money_logs.currency, print_money_logs.amount,
+                   print_money_logs.created_at, print_money_logs.prev_hash
+               ) AS computed
+        FROM print_money_logs
+        ORDER BY print_money_logs.id
+    LOOP
+        IF entry.prev_hash != expected_prev THEN
+            RETURN QUERY SELECT checked, entry.id, 'previous hash does not match'::TEXT;
+            RETURN;
+        END IF;
+        IF entry.hash != entry.computed THEN
+            RETURN QUERY SELECT checked, entry.id, 'hash does not match contents'::TEXT;
+            RETURN;
+        END IF;
+        expected_prev := entry.hash;
+        checked := checked + 1;
+    END LOOP;
+  ELSE
+    PERFORM raise_error(1602);
+END IF;
+
+RETURN QUERY SELECT checked, NULL::INTEGER, NULL::TEXT;
+END;
+$$ LANGUAGE plpgsql;
+
+-- get_ledger_heads returns the last row of each chain; chains without rows
+-- are left out.
+CREATE OR REPLACE FUNCTION get_ledger_heads()
+RETURNS TABLE(
+  head_chain VARCHAR(32),
+  head_id INTEGER,
+  head_hash BYTEA
+) AS $$
+BEGIN
+RETURN QUERY
+(SELECT 'transactions'::VARCHAR(32), transaction_logs.id, transaction_logs.hash
+ FROM transaction_logs
+ ORDER BY transaction_logs.id DESC
+ LIMIT 1)
+UNION ALL
+(SELECT 'print_money'::VARCHAR(32), print_money_logs.id, print_money_logs.hash
+ FROM print_money_logs
+ ORDER BY print_money_logs.id DESC
+ LIMIT 1);
+END;
+$$ LANGUAGE plpgsql;
+
+-- get_ledger_entry_hash returns the stored hash of a row, NULL if it is gone.
+CREATE OR REPLACE FUNCTION get_ledger_entry_hash(
+  chain_param VARCHAR(32),
+  id_param INTEGER
+) RETURNS BYTEA AS $$
+DECLARE
+  entry_hash BYTEA;
+BEGIN
+  IF chain_param = 'transactions' THEN
+    SELECT hash INTO entry_hash FROM transaction_logs WHERE id = id_param;
+  ELSIF chain_param = 'print_money' THEN
+    SELECT hash INTO entry_hash FROM print_money_logs WHERE id = id_param;
+  ELSE
+    PERFORM raise_error(1602);
+END IF;
+RETURN entry_hash;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION create_ledger_checkpoint(
+  chain_param VARCHAR(32),
+  last_id_param INTEGER,
+  hash_param BYTEA,
+  key_id_param VARCHAR(16),
+  signature_param BYTEA
+) RETURNS VOID AS $$
+BEGIN
+INSERT INTO ledger_checkpoints(chain, last_id, hash, key_id, signature)
+VALUES (chain_param, last_id_param, hash_param, key_id_param, signature_param);
+END;
+$$ LANGUAGE plpgsql;
+
+-- get_latest_ledger_checkpoints returns the newest checkpoint of each chain.
+CREATE OR REPLACE FUNCTION get_latest_ledger_checkpoints()
+RETURNS TABLE(
+  checkpoint_id INTEGER,
+  checkpoint_chain VARCHAR(32),
+  checkpoint_last_id INTEGER,
+  checkpoint_hash BYTEA,
+  checkpoint_key_id VARCHAR(16),
+  checkpoint_signature BYTEA,
+  checkpoint_created_at TIMESTAMPTZ
+) AS $$
+BEGIN
+RETURN QUERY
+SELECT DISTINCT ON (ledger_checkpoints.chain)
+    ledger_checkpoints.id,
+    ledger_checkpoints.chain,
+    ledger_checkpoints.last_id,
+    ledger_checkpoints.hash,
+    ledger_checkpoints.key_id,
+    ledger_checkpoints.signature,
+    ledger_checkpoints.created_at
+FROM ledger_checkpoints
+ORDER BY ledger_checkpoints.chain, ledger_checkpoints.id DESC;
+END;
+$$ LANGUAGE plpgsql;
+
+-- get_ledger_checkpoints returns every checkpoint of a chain, oldest first.
+CREATE OR REPLACE FUNCTION get_ledger_checkpoints(
+  chain_param VARCHAR(32)
+) RETURNS TABLE(
+  checkpoint_id INTEGER,
+  checkpoint_chain VARCHAR(32),
+  checkpoint_last_id INTEGER,
+  checkpoint_hash BYTEA,
+  checkpoint_key_id VARCHAR(16),
+  checkpoint_signature BYTEA,
+  checkpoint_created_at TIMESTAMPTZ
+) AS $$
+BEGIN
+RETURN QUERY
+SELECT
+    ledger_checkpoints.id,
+    ledger_checkpoints.chain,
+    ledger_checkpoints.last_id,
+    ledger_checkpoints.hash,
+    ledger_checkpoints.key_id,
+    ledger_checkpoints.signature,
+    ledger_checkpoints.created_at
+FROM ledger_checkpoints
+WHERE ledger_checkpoints.chain = chain_param
+ORDER BY ledger_checkpoints.id;
+END;
+$$ LANGUAGE plpgsql;
+
+-- create_webhook_subscription registers a webhook for the initiator's own
+-- events. all_events subscriptions receive everyone's events and need
+-- audit_funds.
+CREATE OR REPLACE FUNCTION create_webhook_subscription(
+  initiator_id_param INTEGER,
+  url_param TEXT,
+  event_types_param VARCHAR(32)[],
+  all_events_param BOOLEAN,
+  secret_param TEXT
+) RETURNS INTEGER AS $$
+DECLARE
+  new_subscription_id INTEGER;
+BEGIN
+  IF all_events_param AND NOT has_permission(initiator_id_param, 'administrator', 'audit_funds') THEN
+    PERFORM raise_error(1701);
+END IF;
+
+INSERT INTO webhook_subscriptions(user_id, url, event_types, all_events, secret)
+VALUES (initiator_id_param, url_param, event_types_param, all_events_param, secret_param)
+    RETURNING id INTO new_subscription_id;
+
+RETURN new_subscription_id;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION get_webhook_subscriptions(
+  initiator_id_param INTEGER
+) RETURNS TABLE(
+  subscription_id INTEGER,
+  subscription_url TEXT,
+  subscription_event_types VARCHAR(32)[],
+  subscription_all_events BOOLEAN,
+  subscription_created_at TIMESTAMPTZ
+) AS $$
+BEGIN
+RETURN QUERY
+SELECT
+    webhook_subscriptions.id,
+    webhook_subscriptions.url,
+    webhook_subscriptions.event_types,
+    webhook_subscriptions.all_events,
+    webhook_subscriptions.created_at
+FROM webhook_subscriptions
+WHERE webhook_subscriptions.user_id = initiator_id_param
+ORDER BY webhook_subscriptions.id;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION delete_webhook_subscription(
+  initiator_id_param INTEGER,
+  subscription_id_param INTEGER
+) RETURNS VOID AS $$
+BEGIN
+DELETE FROM webhook_subscriptions
+WHERE id = subscription_id_param
+  AND (user_id = initiator_id_param OR has_permission(initiator_id_param, 'administrator'));
+
+  IF NOT FOUND THEN
+    PERFORM raise_error(1702);
+END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+-- claim_webhook_deliveries hands out due deliveries. Claimed rows are pushed
+-- back by lease_seconds_param, so a worker that dies mid-delivery only delays
+-- them; SKIP LOCKED keeps instances from claiming the same rows.
+CREATE OR REPLACE FUNCTION claim_webhook_deliveries(
+  limit_param INTEGER,
+  lease_seconds_param INTEGER
+) RETURNS TABLE(
+  delivery_id BIGINT,
+  delivery_attempts INTEGER,
+  delivery_url TEXT,
+  delivery_secret TEXT,
+  delivery_event_id BIGINT,
+  delivery_event_type VARCHAR(32),
+  delivery_payload JSONB,
+  delivery_event_created_at TIMESTAMPTZ
+) AS $$
+BEGIN
+RETURN QUERY
+WITH due AS (
+    SELECT webhook_deliveries.id
+    FROM webhook_deliveries
+    WHERE webhook_deliveries.status = 'pending'
+      AND webhook_deliveries.next_attempt_at <= now()
+    ORDER BY webhook_deliveries.next_attempt_at
+    LIMIT limit_param
+    FOR UPDATE SKIP LOCKED
+), claimed AS (
+    UPDATE webhook_deliveries
+    SET next_attempt_at = now() + make_interval(secs => lease_seconds_param)
+    FROM due
+    WHERE webhook_deliveries.id = due.id
+    RETURNING webhook_deliveries.id, webhook_deliveries.attempts,
+              webhook_deliveries.subscription_id, webhook_deliveries.event_id
+)
+SELECT
+    claimed.id,
+    claimed.attempts,
+    webhook_subscriptions.url,
+    webhook_subscriptions.secret,
+    events.id,
+    events.event_type,
+    events.payload,
+    events.created_at
+FROM claimed
+JOIN webhook_subscriptions ON webhook_subscriptions.id = claimed.subscription_id
+JOIN events ON events.id = claimed.event_id;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION complete_webhook_delivery(
+  delivery_id_param BIGINT,
+  status_code_param INTEGER
+) RETURNS VOID AS $$
+BEGIN
+UPDATE webhook_deliveries
+SET status = 'delivered',
+    attempts = attempts + 1,
+    last_status_code = status_code_param,
+    last_error = NULL,
+    delivered_at = now()
+WHERE id = delivery_id_param;
+END;
+$$ LANGUAGE plpgsql;
+
+-- fail_webhook_delivery records a failed attempt and either schedules the
+-- next one or moves the delivery to the dead letters.
+CREATE OR REPLACE FUNCTION fail_webhook_delivery(
+  delivery_id_param BIGINT,
+  status_code_param INTEGER,
+  error_param TEXT,
+  dead_param BOOLEAN,
+  next_attempt_at_param TIMESTAMPTZ
+) RETURNS VOID AS $$
+BEGIN
+UPDATE webhook_deliveries
+SET status = CASE WHEN dead_param THEN 'dead' ELSE 'pending' END,
+    attempts = attempts + 1,
+    last_status_code = status_code_param,
+    last_error = error_param,
+    next_attempt_at = COALESCE(next_attempt_at_param, now())
+WHERE id = delivery_id_param;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION get_webhook_deliveries(
+  initiator_id_param INTEGER,
+  status_param VARCHAR(16),
+  limit_param INTEGER,
+  offset_param INTEGER
+) RETURNS TABLE(
+  delivery_id BIGINT,
+  delivery_subscription_id INTEGER,
+  delivery_event_id BIGINT,
+  delivery_event_type VARCHAR(32),
+  delivery_status VARCHAR(16),
+  delivery_attempts INTEGER,
+  delivery_last_status_code INTEGER,
+  delivery_last_error TEXT,
+  delivery_next_attempt_at TIMESTAMPTZ,
+  delivery_created_at TIMESTAMPTZ
+) AS $$
+BEGIN
+RETURN QUERY
+SELECT
+    webhook_deliveries.id,
+    webhook_deliveries.subscription_id,
+    webhook_deliveries.event_id,
+    events.event_type,
+    webhook_deliveries.status,
+    webhook_deliveries.attempts,
+    webhook_deliveries.last_status_code,
+    webhook_deliveries.last_error,
+    webhook_deliveries.next_attempt_at,
+    webhook_deliveries.created_at
+FROM webhook_deliveries
+JOIN webhook_subscriptions ON webhook_subscriptions.id = webhook_deliveries.subscription_id
+JOIN events ON events.id = webhook_deliveries.event_id
+WHERE webhook_subscriptions.user_id = initiator_id_param
+  AND (status_param IS NULL OR webhook_deliveries.status = status_param)
+ORDER BY webhook_deliveries.id DESC
+OFFSET offset_param LIMIT limit_param;
+END;
+$$ LANGUAGE plpgsql;
+
+-- redeliver_webhook queues a delivery again, dead or already delivered, with
+-- a fresh set of attempts.
+CREATE OR REPLACE FUNCTION redeliver_webhook(
+  initiator_id_param INTEGER,
+  delivery_id_param BIGINT
+) RETURNS VOID AS $$
+BEGIN
+UPDATE webhook_deliveries
+SET status = 'pending',
+    attempts = 0,
+    next_attempt_at = now(),
+    delivered_at = NULL
+FROM webhook_subscriptions
+WHERE webhook_deliveries.id = delivery_id_param
+  AND webhook_subscriptions.id = webhook_deliveries.subscription_id
+  AND webhook_subscriptions.user_id = initiator_id_param;
+
+  IF NOT FOUND THEN
+    PERFORM raise_error(1703);
+END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+-- get_events returns events after after_id_param in id order, the ones that
+-- concern the initiator or, for auditors, all of them.
+CREATE OR REPLACE FUNCTION get_events(
+  initiator_id_param INTEGER,
+  after_id_param BIGINT,
+  all_events_param BOOLEAN,
+  limit_param INTEGER
+) RETURNS TABLE(
+  event_id BIGINT,
+  event_type VARCHAR(32),
+  event_payload JSONB,
+  event_created_at TIMESTAMPTZ
+) AS $$
+BEGIN
+  IF all_events_param AND NOT has_permission(initiator_id_param, 'administrator', 'audit_funds') THEN
+    PERFORM raise_error(1801);
+END IF;
+
+RETURN QUERY
+SELECT events.id, events.event_type, events.payload, events.created_at
+FROM events
+WHERE events.id > after_id_param
+  AND (all_events_param OR initiator_id_param = ANY(events.user_ids))
+ORDER BY events.id
+LIMIT limit_param;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION payment_request_status(
+  status_param VARCHAR(16),
+  expires_at_param TIMESTAMPTZ
+) RETURNS VARCHAR(16) AS $$
+SELECT CASE
+           WHEN status_param = 'pending' AND expires_at_param <= now() THEN 'expired'
+           ELSE status_param
+       END::VARCHAR(16);
+$$ LANGUAGE sql STABLE;
+
+CREATE OR REPLACE FUNCTION emit_payment_request_event(
+  request payment_requests
+) RETURNS VOID AS $$
+BEGIN
+  PERFORM enqueue_event('payment_request', ARRAY[request.requester_id, request.payer_id], jsonb_build_object(
+      'request_id', request.id,
+      'requester_id', request.requester_id,
+      'payer_id', request.payer_id,
+      'currency', request.currency,
+      'amount', request.amount,
+      'memo', request.memo,
+      'status', request.status,
+      'transaction_id', request.transaction_id
+  ));
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION create_payment_request(
+  initiator_id_param INTEGER,
+  payer_id_param INTEGER,
+  currency_param VARCHAR(64),
+  amount_param BIGINT,
+  memo_param VARCHAR(256),
+  expires_at_param TIMESTAMPTZ
+) RETURNS INTEGER AS $$
+DECLARE
+  request payment_requests;
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM users WHERE id = payer_id_param) THEN
+    PERFORM raise_error(1901);
+END IF;
+
+  IF payer_id_param = initiator_id_param THEN
+    PERFORM raise_error(1902);
+END IF;
+
+  IF amount_param <= 0 THEN
+    PERFORM raise_error(1903);
+END IF;
+
+  IF expires_at_param IS NOT NULL AND expires_at_param <= now() THEN
+    PERFORM raise_error(1904);
+END IF;
+
+INSERT INTO payment_requests(requester_id, payer_id, currency, amount, memo, expires_at)
+VALUES (initiator_id_param, payer_id_param, currency_param, amount_param, coalesce(memo_param, ''), expires_at_param)
+    RETURNING * INTO request;
+
+PERFORM emit_payment_request_event(request);
+RETURN request.id;
+END;
+$$ LANGUAGE plpgsql;
+
+-- lock_payment_request locks a pending request of the payer or, with
+-- payer_side_param false, of the requester. Requests of other users are
+-- reported as missing.
+CREATE OR REPLACE FUNCTION lock_payment_request(
+  user_id_param INTEGER,
+  request_id_param INTEGER,
+  payer_side_param BOOLEAN
+) RETURNS payment_requests AS $$
+DECLARE
+  request payment_requests;
+BEGIN
+SELECT * INTO request
+FROM payment_requests
+WHERE id = request_id_param
+  AND (CASE WHEN payer_side_param THEN payer_id ELSE requester_id END) = user_id_param
+    FOR UPDATE;
+
+  IF NOT FOUND THEN
+    PERFORM raise_error(1905);
+END IF;
+
+  IF request.status <> 'pending' THEN
+    PERFORM raise_error(1906);
+END IF;
+
+  IF payment_request_status(request.status, request.expires_at) = 'expired' THEN
+    PERFORM raise_error(1907);
+END IF;
+
+RETURN request;
+END;
+$$ LANGUAGE plpgsql;
+
+-- accept_payment_request pays a request through proceed_transaction, so the
+-- usual permission, balance and fee rules apply.
+CREATE OR REPLACE FUNCTION accept_payment_request(
+  initiator_id_param INTEGER,
+  request_id_param INTEGER,
+  fee_param INTEGER
+) RETURNS TABLE(
+  receipt_transaction_id INTEGER,
+  receipt_sender_id INTEGER,
+  receipt_receiver_id INTEGER,
+  receipt_currency VARCHAR(64),
+  receipt_amount BIGINT,
+  receipt_fee BIGINT,
+  receipt_created_at TIMESTAMP
+) AS $$
+DECLARE
+  request payment_requests;
+  transfer RECORD;
+BEGIN
+request := lock_payment_request(initiator_id_param, request_id_param, true);
+
+SELECT * INTO transfer
+FROM proceed_transaction(request.payer_id, request.requester_id, initiator_id_param, request.currency, request.amount, fee_param);
+
+UPDATE payment_requests
+SET status = 'paid',
+    transaction_id = transfer.receipt_transaction_id,
+    resolved_at = now()
+WHERE id = request.id
+    RETURNING * INTO request;
+
+PERFORM emit_payment_request_event(request);
+
+RETURN QUERY
+SELECT transfer.receipt_transaction_id, request.payer_id, request.requester_id,
+       request.currency, request.amount, transfer.receipt_fee, transfer.receipt_created_at;
+END;
+$$ LANGUAGE plpgsql;
+
+-- resolve_payment_request declines (payer) or cancels (requester) a pending
+-- request.
+CREATE OR REPLACE FUNCTION resolve_payment_request(
+  initiator_id_param INTEGER,
+  request_id_param INTEGER,
+  status_param VARCHAR(16)
+) RETURNS VOID AS $$
+DECLARE
+  request payment_requests;
+BEGIN
+request := lock_payment_request(initiator_id_param, request_id_param, status_param = 'declined');
+
+UPDATE payment_requests
+SET status = status_param,
+    resolved_at = now()
+WHERE id = request.id
+    RETURNING * INTO request;
+
+PERFORM emit_payment_request_event(request);
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION decline_payment_request(
+  initiator_id_param INTEGER,
+  request_id_param INTEGER
+) RETURNS VOID AS $$
+BEGIN
+  PERFORM resolve_payment_request(initiator_id_param, request_id_param, 'declined');
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION cancel_payment_request(
+  initiator_id_param INTEGER,
+  request_id_param INTEGER
+) RETURNS VOID AS $$
+BEGIN
+  PERFORM resolve_payment_request(initiator_id_param, request_id_param, 'cancelled');
+END;
+$$ LANGUAGE plpgsql;
+
+-- get_payment_requests lists requests the initiator has to pay (incoming) or
+-- has sent (outgoing), newest first. status_param filters by the effective
+-- status, expired included.
+CREATE OR REPLACE FUNCTION get_payment_requests(
+  initiator_id_param INTEGER,
+  incoming_param BOOLEAN,
+  status_param VARCHAR(16),
+  limit_param INTEGER,
+  offset_param INTEGER
+) RETURNS TABLE(
+  request_id INTEGER,
+  request_requester_id INTEGER,
+  request_payer_id INTEGER,
+  request_currency VARCHAR(64),
+  request_amount BIGINT,
+  request_memo VARCHAR(256),
+  request_status VARCHAR(16),
+  request_expires_at TIMESTAMPTZ,
+  request_transaction_id INTEGER,
+  request_created_at TIMESTAMPTZ,
+  request_resolved_at TIMESTAMPTZ
+) AS $$
+BEGIN
+RETURN QUERY
+SELECT
+    payment_requests.id,
+    payment_requests.requester_id,
+    payment_requests.payer_id,
+    payment_requests.currency,
+    payment_requests.amount,
+    payment_requests.memo,
+    payment_request_status(payment_requests.status, payment_requests.expires_at),
+    payment_requests.expires_at,
+    payment_requests.transaction_id,
+    payment_requests.created_at,
+    payment_requests.resolved_at
+FROM payment_requests
+WHERE (CASE WHEN incoming_param THEN payment_requests.payer_id ELSE payment_requests.requester_id END) = initiator_id_param
+  AND (status_param IS NULL OR payment_request_status(payment_requests.status, payment_requests.expires_at) = status_param)
+ORDER BY payment_requests.id DESC
+OFFSET offset_param LIMIT limit_param;
+END;
+$$ LANGUAGE plpgsql;
+
+-- get_payment_request returns one request to its requester or payer.
+CREATE OR REPLACE FUNCTION get_payment_request(
+  initiator_id_param INTEGER,
+  request_id_param INTEGER
+) RETURNS TABLE(
+  request_id INTEGER,
+  request_requester_id INTEGER,
+  request_payer_id INTEGER,
+  request_currency VARCHAR(64),
+  request_amount BIGINT,
+  request_memo VARCHAR(256),
+  request_status VARCHAR(16),
+  request_expires_at TIMESTAMPTZ,
+  request_transaction_id INTEGER,
+  request_created_at TIMESTAMPTZ,
+  request_resolved_at TIMESTAMPTZ
+) AS $$
+BEGIN
+RETURN QUERY
+SELECT
+    payment_requests.id,
+    payment_requests.requester_id,
+    payment_requests.payer_id,
+    payment_requests.currency,
+    payment_requests.amount,
+    payment_requests.memo,
+    payment_request_status(payment_requests.status, payment_requests.expires_at),
+    payment_requests.expires_at,
+    payment_requests.transaction_id,
+    payment_requests.created_at,
+    payment_requests.resolved_at
+FROM payment_requests
+WHERE payment_requests.id = request_id_param
+  AND initiator_id_param IN (payment_requests.requester_id, payment_requests.payer_id);
+
+  IF NOT FOUND THEN
+    PERFORM raise_error(1905);
+END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+-- escrow_account_id returns the system account escrowed funds are held on,
+-- seeded by 002. It can't log in and pays contracts out through
+-- transfer_funds, without any permission to move funds of others.
+CREATE OR REPLACE FUNCTION escrow_account_id()
+RETURNS INTEGER AS $$
+DECLARE
+  account_id INTEGER;
+BEGIN
+SELECT id INTO account_id
+FROM users
+WHERE system_account = 'escrow';
+
+  IF account_id IS NULL THEN
+    PERFORM raise_error(2010);
+END IF;
+
+RETURN account_id;
+END;
+$$ LANGUAGE plpgsql STABLE;
+
+-- log_escrow records a step of a contract and emits an escrow event to its
+-- parties.
+CREATE OR REPLACE FUNCTION log_escrow(
+  escrow escrows,
+  action_param VARCHAR(32),
+  actor_id_param INTEGER,
+  transaction_id_param INTEGER
+) RETURNS VOID AS $$
+BEGIN
+INSERT INTO escrow_logs(escrow_id, action, actor_id, transaction_id)
+VALUES (escrow.id, action_param, actor_id_param, transaction_id_param);
+
+PERFORM enqueue_event('escrow', array_remove(ARRAY[escrow.payer_id, escrow.payee_id, escrow.arbiter_id], NULL), jsonb_build_object(
+    'escrow_id', escrow.id,
+    'action', action_param,
+    'actor_id', actor_id_param,
+    'status', escrow.status,
+    'payer_id', escrow.payer_id,
+    'payee_id', escrow.payee_id,
+    'currency', escrow.currency,
+    'amount', escrow.amount,
+    'transaction_id', transaction_id_param
+));
+END;
+$$ LANGUAGE plpgsql;
+
+-- create_escrow moves amount from the initiator to the escrow account. The
+-- contract settles as deadline_action_param once the deadline has passed.
+CREATE OR REPLACE FUNCTION create_escrow(
+  initiator_id_param INTEGER,
+  payee_id_param INTEGER,
+  arbiter_id_param INTEGER,
+  currency_param VARCHAR(64),
+  amount_param BIGINT,
+  memo_param VARCHAR(256),
+  deadline_param TIMESTAMPTZ,
+  deadline_action_param VARCHAR(16)
+) RETURNS INTEGER AS $$
+DECLARE
+  escrow escrows;
+  funding RECORD;
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM users WHERE id = payee_id_param) THEN
+    PERFORM raise_error(2001);
+END IF;
+
+  IF arbiter_id_param IS NOT NULL AND NOT EXISTS (SELECT 1 FROM users WHERE id = arbiter_id_param) THEN
+    PERFORM raise_error(2002);
+END IF;
+
+  IF payee_id_param = initiator_id_param
+     OR arbiter_id_param IN (initiator_id_param, payee_id_param)
+     OR escrow_account_id() IN (initiator_id_param, payee_id_param, arbiter_id_param) THEN
+    PERFORM raise_error(2003);
+END IF;
+
+  IF amount_param <= 0 THEN
+    PERFORM raise_error(2004);
+END IF;
+
+  IF deadline_param <= now() THEN
+    PERFORM raise_error(2005);
+END IF;
+
+  IF deadline_action_param NOT IN ('release', 'refund') THEN
+    PERFORM raise_error(2009);
+END IF;
+
+SELECT * INTO funding
+FROM proceed_transaction(initiator_id_param, escrow_account_id(), initiator_id_param, currency_param, amount_param, 0);
+
+INSERT INTO escrows(payer_id, payee_id, arbiter_id, currency, amount, memo, deadline, deadline_action, funding_transaction_id)
+VALUES (
+           initiator_id_param, payee_id_param, arbiter_id_param, currency_param, amount_param,
+           coalesce(memo_param, ''), deadline_param, deadline_action_param, funding.receipt_transaction_id
+       )
+    RETURNING * INTO escrow;
+
+PERFORM log_escrow(escrow, 'funded', initiator_id_param, funding.receipt_transaction_id);
+RETURN escrow.id;
+END;
+$$ LANGUAGE plpgsql;
+
+-- settle_escrow pays a locked, funded contract out. Releases pay fee_param
+-- like any transfer to the payee, refunds are free.
+CREATE OR REPLACE FUNCTION settle_escrow(
+  escrow escrows,
+  outcome_param VARCHAR(16),
+  actor_id_param INTEGER,
+  fee_param INTEGER
+) RETURNS escrows AS $$
+DECLARE
+  settlement RECORD;
+BEGIN
+  IF outcome_param = 'release' THEN
+    IF NOT has_permission(escrow.payee_id, 'receive_funds') THEN
+      PERFORM raise_error(2008);
+END IF;
+SELECT * INTO settlement
+FROM transfer_funds(escrow_account_id(), escrow.payee_id, escrow_account_id(), escrow.currency, escrow.amount, fee_param);
+ELSE
+SELECT * INTO settlement
+FROM transfer_funds(escrow_account_id(), escrow.payer_id, escrow_account_id(), escrow.currency, escrow.amount, 0);
+END IF;
+
+UPDATE escrows
+SET status = CASE WHEN outcome_param = 'release' THEN 'released' ELSE 'refunded' END,
+    settlement_transaction_id = settlement.receipt_transaction_id,
+    resolved_at = now()
+WHERE id = escrow.id
+    RETURNING * INTO escrow;
+
+PERFORM log_escrow(escrow, escrow.status, actor_id_param, settlement.receipt_transaction_id);
+RETURN escrow;
+END;
+$$ LANGUAGE plpgsql;
+
+-- confirm_escrow records the outcome a party wants. The arbiter settles the
+-- contract alone, payer and payee once they agree. Returns the new status.
+CREATE OR REPLACE FUNCTION confirm_escrow(
+  initiator_id_param INTEGER,
+  escrow_id_param INTEGER,
+  outcome_param VARCHAR(16),
+  fee_param INTEGER
+) RETURNS VARCHAR(16) AS $$
+DECLARE
+  escrow escrows;
+BEGIN
+  IF outcome_param NOT IN ('release', 'refund') THEN
+    PERFORM raise_error(2009);
+END IF;
+
+SELECT * INTO escrow
+FROM escrows
+WHERE id = escrow_id_param
+  AND initiator_id_param IN (payer_id, payee_id, arbiter_id)
+    FOR UPDATE;
+
+  IF NOT FOUND THEN
+    PERFORM raise_error(2006);
+END IF;
+
+  IF escrow.status <> 'funded' THEN
+    PERFORM raise_error(2007);
+END IF;
+
+  -- Past the deadline the contract belongs to its deadline action, whether
+  -- or not settle_escrow_deadline got to it yet.
+  IF escrow.deadline <= now() THEN
+    PERFORM raise_error(2011);
+END IF;
+
+  IF initiator_id_param = escrow.arbiter_id THEN
+    escrow := settle_escrow(escrow, outcome_param, initiator_id_param, fee_param);
+    RETURN escrow.status;
+END IF;
+
+UPDATE escrows
+SET payer_vote = CASE WHEN initiator_id_param = payer_id THEN outcome_param ELSE payer_vote END,
+    payee_vote = CASE WHEN initiator_id_param = payee_id THEN outcome_param ELSE payee_vote END
+WHERE id = escrow.id
+    RETURNING * INTO escrow;
+
+PERFORM log_escrow(escrow, 'confirmed_' || outcome_param, initiator_id_param, NULL);
+
+  IF escrow.payer_vote = escrow.payee_vote THEN
+    escrow := settle_escrow(escrow, outcome_param, initiator_id_param, fee_param);
+END IF;
+
+RETURN escrow.status;
+END;
+$$ LANGUAGE plpgsql;
+
+-- get_due_escrows returns funded contracts past their deadline, skipping
+-- those whose last settlement failed until their retry is due.
+CREATE OR REPLACE FUNCTION get_due_escrows(
+  limit_param INTEGER
+) RETURNS SETOF INTEGER AS $$
+SELECT id
+FROM escrows
+WHERE status = 'funded'
+  AND deadline <= now()
+  AND coalesce(next_settlement_at, deadline) <= now()
+ORDER BY coalesce(next_settlement_at, deadline)
+LIMIT limit_param;
+$$ LANGUAGE sql STABLE;
+
+-- fail_escrow_settlement records a failed deadline settlement and postpones
+-- the next one, doubling the delay from a minute up to a day.
+CREATE OR REPLACE FUNCTION fail_escrow_settlement(
+  escrow_id_param INTEGER,
+  error_param TEXT
+) RETURNS VOID AS $$
+BEGIN
+UPDATE escrows
+SET settlement_attempts = settlement_attempts + 1,
+    last_settlement_error = error_param,
+    next_settlement_at = now() + least(
+        interval '1 minute' * power(2, least(settlement_attempts, 11)),
+        interval '1 day'
+    )
+WHERE id = escrow_id_param
+  AND status = 'funded';
+END;
+$$ LANGUAGE plpgsql;
+
+-- settle_escrow_deadline settles a contract whose deadline has passed with its
+-- deadline action. A release the payee can no longer receive is refunded.
+CREATE OR REPLACE FUNCTION settle_escrow_deadline(
+  escrow_id_param INTEGER,
+  fee_param INTEGER
+) RETURNS VOID AS $$
+DECLARE
+  escrow escrows;
+  outcome VARCHAR(16);
+BEGIN
+SELECT * INTO escrow
+FROM escrows
+WHERE id = escrow_id_param
+  AND status = 'funded'
+  AND deadline <= now()
+    FOR UPDATE SKIP LOCKED;
+
+  IF NOT FOUND THEN
+    RETURN;
+END IF;
+
+outcome := escrow.deadline_action;
+  IF outcome = 'release' AND NOT has_permission(escrow.payee_id, 'receive_funds') THEN
+    outcome := 'refund';
+END IF;
+
+PERFORM settle_escrow(escrow, outcome, NULL, fee_param);
+END;
+$$ LANGUAGE plpgsql;
+
+-- get_escrows lists contracts the initiator is payer, payee or arbiter of,
+-- newest first.
+CREATE OR REPLACE FUNCTION get_escrows(
+  initiator_id_param INTEGER,
+  status_param VARCHAR(16),
+  limit_param INTEGER,
+  offset_param INTEGER
+) RETURNS TABLE(
+  escrow_id INTEGER,
+  escrow_payer_id INTEGER,
+  escrow_payee_id INTEGER,
+  escrow_arbiter_id INTEGER,
+  escrow_currency VARCHAR(64),
+  escrow_amount BIGINT,
+  escrow_memo VARCHAR(256),
+  escrow_status VARCHAR(16),
+  escrow_deadline TIMESTAMPTZ,
+  escrow_deadline_action VARCHAR(16),
+  escrow_payer_vote VARCHAR(16),
+  escrow_payee_vote VARCHAR(16),
+  escrow_created_at TIMESTAMPTZ,
+  escrow_resolved_at TIMESTAMPTZ
+) AS $$
+BEGIN
+RETURN QUERY
+SELECT
+    escrows.id,
+    escrows.payer_id,
+    escrows.payee_id,
+    escrows.arbiter_id,
+    escrows.currency,
+    escrows.amount,
+    escrows.memo,
+    escrows.status,
+    escrows.deadline,
+    escrows.deadline_action,
+    escrows.payer_vote,
+    escrows.payee_vote,
+    escrows.created_at,
+    escrows.resolved_at
+FROM escrows
+WHERE initiator_id_param IN (escrows.payer_id, escrows.payee_id, escrows.arbiter_id)
+  AND (status_param IS NULL OR escrows.status = status_param)
+ORDER BY escrows.id DESC
+OFFSET offset_param LIMIT limit_param;
+END;
+$$ LANGUAGE plpgsql;
+
+-- get_escrow_logs returns the steps of a contract to its parties and auditors.
+CREATE OR REPLACE FUNCTION get_escrow_logs(
+  initiator_id_param INTEGER,
+  escrow_id_param INTEGER
+) RETURNS TABLE(
+  log_action VARCHAR(32),
+  log_actor_id INTEGER,
+  log_transaction_id INTEGER,
+  log_created_at TIMESTAMPTZ
+) AS $$
+BEGIN
+  IF NOT EXISTS (
+      SELECT 1
+      FROM escrows
+      WHERE escrows.id = escrow_id_param
+        AND initiator_id_param IN (escrows.payer_id, escrows.payee_id, escrows.arbiter_id)
+  ) AND NOT has_permission(initiator_id_param, 'administrator', 'audit_funds') THEN
+    PERFORM raise_error(2006);
+END IF;
+
+RETURN QUERY
+SELECT escrow_logs.action, escrow_logs.actor_id, escrow_logs.transaction_id, escrow_logs.created_at
+FROM escrow_logs
+WHERE escrow_logs.escrow_id = escrow_id_param
+ORDER BY escrow_logs.id;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION check_wallet_permissions(
+  initiator_id_param INTEGER,
+  user_id_param INTEGER
+) RETURNS VOID AS $$
+BEGIN
+  IF initiator_id_param != user_id_param
+     AND organization_role(user_id_param, initiator_id_param) IS DISTINCT FROM 'admin'
+     AND NOT has_permission(initiator_id_param, 'administrator', 'manage_user_funds') THEN
+    PERFORM raise_error(2101);
+END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION create_wallet(
+  initiator_id_param INTEGER,
+  user_id_param INTEGER,
+  name_param VARCHAR(32)
+) RETURNS VOID AS $$
+BEGIN
+PERFORM check_wallet_permissions(initiator_id_param, user_id_param);
+
+  IF wallet_exists(user_id_param, name_param) THEN
+    PERFORM raise_error(2103);
+END IF;
+
+INSERT INTO wallets(user_id, name)
+VALUES (user_id_param, name_param);
+END;
+$$ LANGUAGE plpgsql;
+
+-- delete_wallet removes an empty wallet. Its history stays in the logs.
+CREATE OR REPLACE FUNCTION delete_wallet(
+  initiator_id_param INTEGER,
+  user_id_param INTEGER,
+  name_param VARCHAR(32)
+) RETURNS VOID AS $$
+BEGIN
+PERFORM check_wallet_permissions(initiator_id_param, user_id_param);
+
+  IF name_param = 'primary' THEN
+    PERFORM raise_error(2106);
+END IF;
+
+  -- The lock waits for transfers holding the wallet and keeps new ones out,
+  -- so the balances checked below can't change before they are deleted.
+PERFORM 1
+FROM wallets
+WHERE user_id = user_id_param AND name = name_param
+    FOR UPDATE;
+
+  IF NOT FOUND THEN
+    PERFORM raise_error(2102);
+END IF;
+
+  IF EXISTS (
+      SELECT 1 FROM balances
+      WHERE user_id = user_id_param AND wallet = name_param AND amount <> 0
+  ) THEN
+    PERFORM raise_error(2104);
+END IF;
+
+DELETE FROM balances
+WHERE user_id = user_id_param AND wallet = name_param AND amount = 0;
+
+DELETE FROM wallets
+WHERE user_id = user_id_param AND name = name_param;
+END;
+$$ LANGUAGE plpgsql;
+
+-- get_wallets lists a user's wallets, primary first, with their balances.
+CREATE OR REPLACE FUNCTION get_wallets(
+  initiator_id_param INTEGER,
+  user_id_param INTEGER
+) RETURNS TABLE(
+  wallet_name VARCHAR(32),
+  wallet_currency VARCHAR(64),
+  wallet_amount BIGINT
+) AS $$
+BEGIN
+  IF NOT has_permission(initiator_id_param, 'administrator', 'audit_funds')
+     AND user_id_param != initiator_id_param
+     AND organization_role(user_id_param, initiator_id_param) IS NULL THEN
+    PERFORM raise_error(301);
+END IF;
+
+RETURN QUERY
+SELECT names.name, balances.currency, balances.amount
+FROM (
+    SELECT 'primary'::VARCHAR(32) AS name, timestamptz '-infinity' AS created_at
+    UNION ALL
+    SELECT wallets.name, wallets.created_at
+    FROM wallets
+    WHERE wallets.user_id = user_id_param
+) AS names
+LEFT JOIN balances ON balances.user_id = user_id_param AND balances.wallet = names.name
+ORDER BY names.created_at, names.name, balances.currency;
+END;
+$$ LANGUAGE plpgsql;
+
+-- create_organization opens a no-login organization account with its creator
+-- as the first admin. The organization gets send_funds and receive_funds only
+-- as far as the creator holds them, so it can't be used to get around a
+-- revoked permission.
+CREATE OR REPLACE FUNCTION create_organization(
+  initiator_id_param INTEGER,
+  name_param VARCHAR(64)
+) RETURNS INTEGER AS $$
+DECLARE
+  new_organization_id INTEGER;
+  inherited RECORD;
+BEGIN
+  IF NOT has_permission(initiator_id_param, 'administrator', 'create_organizations') THEN
+    PERFORM raise_error(2201);
+END IF;
+
+  IF EXISTS (SELECT 1 FROM users WHERE username = name_param) THEN
+    PERFORM raise_error(2210);
+END IF;
+
+INSERT INTO users(username, no_login)
+VALUES (name_param, true)
+    RETURNING id INTO new_organization_id;
+
+INSERT INTO organizations(user_id, created_by)
+VALUES (new_organization_id, initiator_id_param);
+
+INSERT INTO organization_members(organization_id, user_id, role, added_by)
+VALUES (new_organization_id, initiator_id_param, 'admin', initiator_id_param);
+
+FOR inherited IN
+    SELECT permissions.id
+    FROM permissions
+    WHERE permissions.name IN ('send_funds', 'receive_funds')
+      AND has_permission(initiator_id_param, permissions.name)
+LOOP
+    INSERT INTO user_permission(user_id, permission_id, granted_by)
+    VALUES (new_organization_id, inherited.id, initiator_id_param);
+
+    PERFORM log_permission_change(new_organization_id, inherited.id, 'granted', initiator_id_param, NULL);
+END LOOP;
+
+RETURN new_organization_id;
+END;
+$$ LANGUAGE plpgsql;
+
+-- check_organization_admin lets admins of the organization and system
+-- administrators manage it.
+CREATE OR REPLACE FUNCTION check_organization_admin(
+  initiator_id_param INTEGER,
+  organization_id_param INTEGER
+) RETURNS VOID AS $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM organizations WHERE user_id = organization_id_param) THEN
+    PERFORM raise_error(2202);
+END IF;
+
+  IF organization_role(organization_id_param, initiator_id_param) IS DISTINCT FROM 'admin'
+     AND NOT has_permission(initiator_id_param, 'administrator') THEN
+    PERFORM raise_error(2201);
+END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+-- check_organization_keeps_admin fails when an organization would be left
+-- without an admin once user_id_param stops being one.
+CREATE OR REPLACE FUNCTION check_organization_keeps_admin(
+  organization_id_param INTEGER,
+  user_id_param INTEGER
+) RETURNS VOID AS $$
+BEGIN
+  IF NOT EXISTS (
+      SELECT 1
+      FROM organization_members
+      WHERE organization_id = organization_id_param
+        AND user_id != user_id_param
+        AND role = 'admin'
+  ) THEN
+    PERFORM raise_error(2206);
+END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+-- set_organization_member adds a member or changes their role.
+CREATE OR REPLACE FUNCTION set_organization_member(
+  initiator_id_param INTEGER,
+  organization_id_param INTEGER,
+  user_id_param INTEGER,
+  role_param VARCHAR(16)
+) RETURNS VOID AS $$
+BEGIN
+PERFORM check_organization_admin(initiator_id_param, organization_id_param);
+
+  IF NOT EXISTS (SELECT 1 FROM users WHERE id = user_id_param) THEN
+    PERFORM raise_error(2203);
+END IF;
+
+  IF EXISTS (SELECT 1 FROM organizations WHERE user_id = user_id_param) THEN
+    PERFORM raise_error(2211);
+END IF;
+
+  IF role_param NOT IN ('viewer', 'spender', 'admin') THEN
+    PERFORM raise_error(2204);
+END IF;
+
+  IF role_param != 'admin'
+     AND organization_role(organization_id_param, user_id_param) = 'admin' THEN
+    PERFORM check_organization_keeps_admin(organization_id_param, user_id_param);
+END IF;
+
+INSERT INTO organization_members(organization_id, user_id, role, added_by)
+VALUES (organization_id_param, user_id_param, role_param, initiator_id_param)
+    ON CONFLICT (organization_id, user_id)
+    DO UPDATE SET role = EXCLUDED.role;
+END;
+$$ LANGUAGE plpgsql;
+
+-- remove_organization_member removes a member together with their spending
+-- limits. Members can always leave on their own.
+CREATE OR REPLACE FUNCTION remove_organization_member(
+  initiator_id_param INTEGER,
+  organization_id_param INTEGER,
+  user_id_param INTEGER
+) RETURNS VOID AS $$
+BEGIN
+  IF initiator_id_param != user_id_param THEN
+    PERFORM check_organization_admin(initiator_id_param, organization_id_param);
+END IF;
+
+  IF organization_role(organization_id_param, user_id_param) IS NULL THEN
+    PERFORM raise_error(2205);
+END IF;
+
+  IF organization_role(organization_id_param, user_id_param) = 'admin' THEN
+    PERFORM check_organization_keeps_admin(organization_id_param, user_id_param);
+END IF;
+
+DELETE FROM organization_members
+WHERE organization_id = organization_id_param AND user_id = user_id_param;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION set_organization_spend_limit(
+  initiator_id_param INTEGER,
+  organization_id_param INTEGER,
+  user_id_param INTEGER,
+  currency_param VARCHAR(64),
+  daily_limit_param BIGINT
+) RETURNS VOID AS $$
+BEGIN
+PERFORM check_organization_admin(initiator_id_param, organization_id_param);
+
+  IF organization_role(organization_id_param, user_id_param) IS NULL THEN
+    PERFORM raise_error(2205);
+END IF;
+
+  IF organization_role(organization_id_param, user_id_param) != 'spender' THEN
+    PERFORM raise_error(2209);
+END IF;
+
+  IF daily_limit_param < 0 THEN
+    PERFORM raise_error(2207);
+END IF;
+
+INSERT INTO organization_spend_limits(organization_id, user_id, currency, daily_limit)
+VALUES (organization_id_param, user_id_param, currency_param, daily_limit_param)
+    ON CONFLICT (organization_id, user_id, currency)
+    DO UPDATE SET daily_limit = EXCLUDED.daily_limit;
+END;
+$$ LANGUAGE plpgsql;
+
+-- get_organizations lists the organizations a user belongs to.
+CREATE OR REPLACE FUNCTION get_organizations(
+  initiator_id_param INTEGER
+) RETURNS TABLE(
+  organization_id INTEGER,
+  organization_name VARCHAR(64),
+  member_role VARCHAR(16),
+  member_since TIMESTAMPTZ
+) AS $$
+BEGIN
+RETURN QUERY
+SELECT organization_members.organization_id, users.username, organization_members.role, organization_members.created_at
+FROM organization_members
+JOIN users ON users.id = organization_members.organization_id
+WHERE organization_members.user_id = initiator_id_param
+ORDER BY organization_members.organization_id;
+END;
+$$ LANGUAGE plpgsql;
+
+-- get_organization_members lists members with their spending limits and
+-- what they spent of them during the last day, one row per limit.
+CREATE OR REPLACE FUNCTION get_organization_members(
+  initiator_id_param INTEGER,
+  organization_id_param INTEGER
+) RETURNS TABLE(
+  member_id INTEGER,
+  member_username VARCHAR(64),
+  member_role VARCHAR(16),
+  member_since TIMESTAMPTZ,
+  limit_currency VARCHAR(64),
+  limit_daily BIGINT,
+  limit_spent BIGINT
+) AS $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM organizations WHERE user_id = organization_id_param) THEN
+    PERFORM raise_error(2202);
+END IF;
+
+  IF organization_role(organization_id_param, initiator_id_param) IS NULL
+     AND NOT has_permission(initiator_id_param, 'administrator', 'audit_funds') THEN
+    PERFORM raise_error(2201);
+END IF;
+
+RETURN QUERY
+SELECT
+    organization_members.user_id,
+    users.username,
+    organization_members.role,
+    organization_members.created_at,
+    organization_spend_limits.currency,
+    organization_spend_limits.daily_limit,
+    (
+        SELECT coalesce(sum(transaction_logs.amount), 0)::BIGINT
+        FROM transaction_logs
+        WHERE transaction_logs.sender_id = organization_id_param
+          AND transaction_logs.initiator_id = organization_members.user_id
+          AND transaction_logs.currency = organization_spend_limits.currency
+          AND transaction_logs.transaction_status = 100
+          AND transaction_logs.created_at > NOW() - interval '1 day'
+    )
+FROM organization_members
+JOIN users ON users.id = organization_members.user_id
+LEFT JOIN organization_spend_limits
+    ON organization_spend_limits.organization_id = organization_members.organization_id
+   AND organization_spend_limits.user_id = organization_members.user_id
+WHERE organization_members.organization_id = organization_id_param
+ORDER BY organization_members.user_id, organization_spend_limits.currency;
+END;
+$$ LANGUAGE plpgsql;
+
+-- set_credit_limit grants, changes or, with a limit of 0, removes a credit
+-- line. Lowering a limit below the current overdraft only stops further
+-- spending, the debt stays.
+CREATE OR REPLACE FUNCTION set_credit_limit(
+  initiator_id_param INTEGER,
+  user_id_param INTEGER,
+  currency_param VARCHAR(64),
+  credit_limit_param BIGINT
+) RETURNS VOID AS $$
+DECLARE
+  old_limit BIGINT;
+BEGIN
+  IF NOT has_permission(initiator_id_param, 'administrator') THEN
+    PERFORM raise_error(2301);
+END IF;
+
+  IF NOT EXISTS (SELECT 1 FROM users WHERE id = user_id_param) THEN
+    PERFORM raise_error(2302);
+END IF;
+
+  IF credit_limit_param < 0 THEN
+    PERFORM raise_error(2303);
+END IF;
+
+SELECT credit_limits.credit_limit
+INTO old_limit
+FROM credit_limits
+WHERE credit_limits.user_id = user_id_param AND credit_limits.currency = currency_param
+    FOR UPDATE;
+
+  IF coalesce(old_limit, 0) = credit_limit_param THEN
+    RETURN;
+END IF;
+
+  IF credit_limit_param = 0 THEN
+DELETE FROM credit_limits
+WHERE user_id = user_id_param AND currency = currency_param;
+ELSE
+INSERT INTO credit_limits(user_id, currency, credit_limit, updated_by)
+VALUES (user_id_param, currency_param, credit_limit_param, initiator_id_param)
+    ON CONFLICT (user_id, currency)
+    DO UPDATE SET credit_limit = EXCLUDED.credit_limit, updated_by = EXCLUDED.updated_by, updated_at = now();
+END IF;
+
+INSERT INTO credit_limit_history(user_id, currency, old_limit, new_limit, initiator_id)
+VALUES (user_id_param, currency_param, coalesce(old_limit, 0), credit_limit_param, initiator_id_param);
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION get_credit_limit_history(
+  initiator_id_param INTEGER,
+  user_id_param INTEGER,
+  limit_param INTEGER,
+  offset_param INTEGER
+) RETURNS TABLE(
+  change_currency VARCHAR(64),
+  change_old_limit BIGINT,
+  change_new_limit BIGINT,
+  change_initiator_id INTEGER,
+  change_created_at TIMESTAMPTZ
+) AS $$
+BEGIN
+  IF user_id_param != initiator_id_param
+     AND NOT has_permission(initiator_id_param, 'administrator', 'audit_funds') THEN
+    PERFORM raise_error(2301);
+END IF;
+
+RETURN QUERY
+SELECT
+    credit_limit_history.currency,
+    credit_limit_history.old_limit,
+    credit_limit_history.new_limit,
+    credit_limit_history.initiator_id,
+    credit_limit_history.created_at
+FROM credit_limit_history
+WHERE credit_limit_history.user_id = user_id_param
+ORDER BY credit_limit_history.id DESC
+OFFSET offset_param LIMIT limit_param;
+END;
+$$ LANGUAGE plpgsql;
+
+-- get_overdrafts lists balances below zero, the deepest first.
+CREATE OR REPLACE FUNCTION get_overdrafts(
+  initiator_id_param INTEGER,
+  limit_param INTEGER,
+  offset_param INTEGER
+) RETURNS TABLE(
+  overdraft_user_id INTEGER,
+  overdraft_username VARCHAR(64),
+  overdraft_currency VARCHAR(64),
+  overdraft_amount BIGINT,
+  overdraft_credit_limit BIGINT
+) AS $$
+BEGIN
+  IF NOT has_permission(initiator_id_param, 'administrator', 'audit_funds') THEN
+    PERFORM raise_error(2301);
+END IF;
+
+RETURN QUERY
+SELECT
+    balances.user_id,
+    users.username,
+    balances.currency,
+    balances.amount,
+    coalesce(credit_limits.credit_limit, 0)
+FROM balances
+JOIN users ON users.id = balances.user_id
+LEFT JOIN credit_limits
+    ON credit_limits.user_id = balances.user_id
+   AND credit_limits.currency = balances.currency
+WHERE balances.amount < 0
+ORDER BY balances.amount, balances.user_id, balances.currency
+OFFSET offset_param LIMIT limit_param;
 END;
 $$ LANGUAGE plpgsql;
@@ -68,4 +68,107 @@ CREATE INDEX IF NOT EXISTS print_money_logs_print_status_idx
     ON print_money_logs(print_status);
 
 CREATE UNIQUE INDEX IF NOT EXISTS idx_refresh_tokens_token
-    ON refresh_tokens(token);
\ No newline at end of file
+    ON refresh_tokens(token);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id
+    ON refresh_tokens(family_id);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id
+    ON refresh_tokens(user_id);
+
+CREATE INDEX IF NOT EXISTS revoked_access_tokens_user_id_idx
+    ON revoked_access_tokens(user_id);
+
+CREATE INDEX IF NOT EXISTS signing_keys_algorithm_created_at_idx
+    ON signing_keys(algorithm, created_at);
+
+CREATE INDEX IF NOT EXISTS login_challenges_user_id_created_at_idx
+    ON login_challenges(user_id, created_at);
+
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx
+    ON api_keys(user_id);
+
+CREATE INDEX IF NOT EXISTS oauth_clients_owner_id_idx
+    ON oauth_clients(owner_id);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_client_id_idx
+    ON refresh_tokens(client_id);
+
+CREATE INDEX IF NOT EXISTS scoped_token_spending_expires_at_idx
+    ON scoped_token_spending(expires_at);
+
+CREATE INDEX IF NOT EXISTS login_lockouts_blocked_until_idx
+    ON login_lockouts(blocked_until);
+
+CREATE INDEX IF NOT EXISTS rate_limit_buckets_updated_at_idx
+    ON rate_limit_buckets(updated_at);
+
+CREATE INDEX IF NOT EXISTS user_role_role_id_idx
+    ON user_role(role_id);
+
+CREATE INDEX IF NOT EXISTS user_permission_expires_at_idx
+    ON user_permission(expires_at)
+    WHERE expires_at IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS user_permission_history_user_id_idx
+    ON user_permission_history(user_id, created_at);
+
+CREATE INDEX IF NOT EXISTS audit_events_actor_id_idx
+    ON audit_events(actor_id, id);
+
+CREATE INDEX IF NOT EXISTS audit_events_target_id_idx
+    ON audit_events(target_id, id);
+
+CREATE INDEX IF NOT EXISTS audit_events_action_idx
+    ON audit_events(action, id);
+
+CREATE INDEX IF NOT EXISTS ledger_checkpoints_chain_idx
+    ON ledger_checkpoints(chain, id);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx
+    ON webhook_deliveries(next_attempt_at)
+    WHERE status = 'pending';
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_subscription_id_idx
+    ON webhook_deliveries(subscription_id, id);
+
+CREATE INDEX IF NOT EXISTS webhook_subscriptions_user_id_idx
+    ON webhook_subscriptions(user_id);
+
+CREATE INDEX IF NOT EXISTS events_user_ids_idx
+    ON events USING GIN (user_ids);
+
+CREATE INDEX IF NOT EXISTS payment_requests_payer_id_idx
+    ON payment_requests(payer_id, id);
+
+CREATE INDEX IF NOT EXISTS payment_requests_requester_id_idx
+    ON payment_requests(requester_id, id);
+
+CREATE INDEX IF NOT EXISTS escrows_due_idx
+    ON escrows((coalesce(next_settlement_at, deadline)))
+    WHERE status = 'funded';
+
+CREATE INDEX IF NOT EXISTS escrows_payer_id_idx
+    ON escrows(payer_id, id);
+
+CREATE INDEX IF NOT EXISTS escrows_payee_id_idx
+    ON escrows(payee_id, id);
+
+CREATE INDEX IF NOT EXISTS escrows_arbiter_id_idx
+    ON escrows(arbiter_id, id);
+
+CREATE INDEX IF NOT EXISTS escrow_logs_escrow_id_idx
+    ON escrow_logs(escrow_id, id);
+
+CREATE INDEX IF NOT EXISTS organization_members_user_id_idx
+    ON organization_members(user_id);
+
+CREATE INDEX IF NOT EXISTS transaction_logs_sender_initiator_idx
+    ON transaction_logs(sender_id, initiator_id, created_at);
+
+CREATE INDEX IF NOT EXISTS credit_limit_history_user_id_idx
+    ON credit_limit_history(user_id, id);
+
+CREATE INDEX IF NOT EXISTS balances_overdraft_idx
+    ON balances(user_id, currency)
+    WHERE amount < 0;
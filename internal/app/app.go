@@ -47,6 +47,12 @@ func Run() {
 		logger.Info("#############################################")
 		logger.Info("Default users initialized (adm, fees, registration, money_printer). Change those passwords ASAP")
 	}
+	auth.StartKeyRotation()
+	auth.StartPermissionExpiry()
+	auth.StartLedgerCheckpoints()
+	auth.StartWebhookDelivery()
+	auth.StartEventStream()
+	auth.StartEscrowSettlement()
 	transport.Run()
 }
 
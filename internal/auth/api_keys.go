@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"fmt"
+	"gbs/internal/models"
+	"gbs/internal/repository"
+	"strings"
+	"time"
+)
+
+const (
+	apiKeyPrefix      = "gbs_"
+	apiKeySize        = 32
+	apiKeyVisibleSize = 8
+	maxAPIKeyName     = 64
+)
+
+// CreateAPIKey issues a long-lived key for userID. A nil permissions slice
+// leaves the key unrestricted, otherwise it may only be used for operations
+// covered by the listed permissions. Only the hash of the key is stored, so
+// the returned key can't be shown again.
+var CreateAPIKey = func(userID int, name string, permissions []string, expiresAt *time.Time) (int, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxAPIKeyName {
+		return 0, "", fmt.Errorf("invalid api key name")
+	}
+	key, err := generateAPIKey()
+	if err != nil {
+		return 0, "", err
+	}
+	visible := key[:len(apiKeyPrefix)+apiKeyVisibleSize]
+	keyID, err := repository.CreateAPIKey(userID, name, hashOpaqueToken(key), visible, permissions, expiresAt)
+	if err != nil {
+		return 0, "", err
+	}
+	return keyID, key, nil
+}
+
+// AuthenticateAPIKey resolves a presented key to its owner and restrictions.
+var AuthenticateAPIKey = func(key string) (*models.APIKeyIdentity, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, fmt.Errorf("invalid api key")
+	}
+	identity, err := repository.GetAPIKeyIdentity(hashOpaqueToken(key))
+	if err != nil {
+		return nil, err
+	}
+	if identity == nil {
+		return nil, fmt.Errorf("invalid api key")
+	}
+	return identity, nil
+}
+
+// APIKeyAllows reports whether a key may be used for an operation that needs
+// one of the given permissions. The owner's own permissions are still checked
+// by the database, so this only narrows them down.
+func APIKeyAllows(identity *models.APIKeyIdentity, permissions ...string) bool {
+	if identity == nil || !identity.Restricted {
+		return true
+	}
+	return scopeAllows(identity.Permissions, permissions...)
+}
+
+func generateAPIKey() (string, error) {
+	key, err := generateOpaqueToken(apiKeySize)
+	if err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + key, nil
+}
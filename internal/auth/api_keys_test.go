@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"gbs/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	first, err := generateAPIKey()
+	assert.NoError(t, err)
+	second, err := generateAPIKey()
+	assert.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(first, apiKeyPrefix))
+	assert.Len(t, first, len(apiKeyPrefix)+43, "32 random bytes should encode to 43 base64url characters")
+	assert.NotEqual(t, first, second)
+	assert.Len(t, hashOpaqueToken(first), 64)
+	assert.NotEqual(t, hashOpaqueToken(first), hashOpaqueToken(second))
+}
+
+func TestAPIKeyAllows(t *testing.T) {
+	assert.True(t, APIKeyAllows(nil, "print_money"), "requests without a key are not restricted")
+	assert.True(t, APIKeyAllows(&models.APIKeyIdentity{}, "print_money"), "unrestricted key")
+
+	restricted := &models.APIKeyIdentity{Restricted: true, Permissions: []string{"send_funds"}}
+	assert.True(t, APIKeyAllows(restricted, "send_funds"))
+	assert.True(t, APIKeyAllows(restricted, "manage_user_funds", "send_funds"))
+	assert.False(t, APIKeyAllows(restricted, "print_money"))
+
+	empty := &models.APIKeyIdentity{Restricted: true, Permissions: []string{}}
+	assert.False(t, APIKeyAllows(empty, "send_funds"), "key restricted to no permissions")
+
+	admin := &models.APIKeyIdentity{Restricted: true, Permissions: []string{"administrator"}}
+	assert.True(t, APIKeyAllows(admin, "print_money"))
+}
@@ -3,16 +3,19 @@ package auth
 import (
 	"fmt"
 	"gbs/internal/config"
+	"gbs/internal/models"
 	"gbs/internal/repository"
 	"gbs/pkg/logger"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var RegisterUser = func(login, password string) (string, string, error) {
+var RegisterUser = func(login, password string, client models.ClientInfo) (string, string, error) {
 	if !validateUsername(login) {
 		return "", "", fmt.Errorf("invalid username")
 	}
@@ -27,18 +30,10 @@ var RegisterUser = func(login, password string) (string, string, error) {
 	if err != nil {
 		return "", "", err
 	}
-	token, err := generateJWT(userID)
-	if err != nil {
-		return "", "", err
-	}
-	refreshToken, err := generateRefreshToken(userID)
-	if err != nil {
-		return "", "", err
-	}
-	return token, refreshToken, nil
+	return issueTokens(userID, client)
 }
 
-var Login = func(login, password string) (string, string, error) {
+var Login = func(login, password string, client models.ClientInfo) (string, string, error) {
 	id, hash, err := repository.GetUserIDHash(login)
 	if err != nil {
 		return "", "", err
@@ -49,15 +44,14 @@ var Login = func(login, password string) (string, string, error) {
 	if !compareHashes(hash, password) {
 		return "", "", fmt.Errorf("Invalid password for user " + login)
 	}
-	token, err := generateJWT(id)
+	_, twoFactorEnabled, err := repository.GetTOTP(id)
 	if err != nil {
 		return "", "", err
 	}
-	refreshToken, err := generateRefreshToken(id)
-	if err != nil {
-		return "", "", err
+	if twoFactorEnabled {
+		return "", "", createLoginChallenge(id)
 	}
-	return token, refreshToken, nil
+	return issueTokens(id, client)
 }
 
 var ChangePassword = func(initiatorID, userID int, password string) error {
@@ -72,6 +66,7 @@ var ChangePassword = func(initiatorID, userID int, password string) error {
 	if err != nil {
 		return err
 	}
+	InvalidateTokenState(userID)
 	err = repository.InvalidateRefreshTokens(userID)
 	if err != nil {
 		logger.Error("Couldnt invalidate refresh tokens")
@@ -80,72 +75,172 @@ var ChangePassword = func(initiatorID, userID int, password string) error {
 	return nil
 }
 
+var issueTokens = func(userID int, client models.ClientInfo) (string, string, error) {
+	token, err := generateJWT(userID)
+	if err != nil {
+		return "", "", err
+	}
+	refreshToken, err := generateRefreshToken(userID, client)
+	if err != nil {
+		return "", "", err
+	}
+	return token, refreshToken, nil
+}
+
+// AccessClaims are the claims carried by access tokens. Version is compared
+// with the user's current token version, so bumping it revokes every access
+// token issued before. Tokens issued to an OAuth client carry its ID and are
+// limited to the space separated permission names in Scope. Down-scoped tokens
+// minted by users are limited to Operations, see CreateScopedToken.
+type AccessClaims struct {
+	UserID         int                    `json:"user_id"`
+	Version        int                    `json:"ver"`
+	ClientID       string                 `json:"client_id,omitempty"`
+	Scope          string                 `json:"scope,omitempty"`
+	Operations     []string               `json:"ops,omitempty"`
+	TransferLimits []models.TransferLimit `json:"limits,omitempty"`
+	jwt.RegisteredClaims
+}
+
 var generateJWT = func(id int) (string, error) {
+	return generateScopedJWT(id, "", nil)
+}
+
+var generateScopedJWT = func(id int, clientID string, scopes []string) (string, error) {
 	tokenLifespan, err := time.ParseDuration(config.GetConfig().Security.TokenExpiry)
 	if err != nil {
 		logger.Fatal("Invalid token lifespan " + config.GetConfig().Security.TokenExpiry)
 	}
-	claims := jwt.MapClaims{
-		"user_id": id,
-		"exp":     time.Now().Add(tokenLifespan).Unix(),
-	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(config.GetConfig().Security.JwtSecret))
+	claims := &AccessClaims{UserID: id, ClientID: clientID, Scope: strings.Join(scopes, " ")}
+	return signAccessToken(claims, tokenLifespan)
 }
 
-var generateRefreshToken = func(userID int) (string, error) {
-	duration, err := time.ParseDuration(config.GetConfig().Security.RefreshTokenExpiry)
+// signAccessToken fills in the token version and the registered claims and
+// signs the token with the active key.
+var signAccessToken = func(claims *AccessClaims, lifespan time.Duration) (string, error) {
+	cfg := config.GetConfig()
+	state, err := getTokenState(claims.UserID)
 	if err != nil {
-		logger.Fatal("Invalid refresh token expiry " + config.GetConfig().Security.RefreshTokenExpiry)
+		return "", err
 	}
-	newRefreshToken, err := repository.CreateRefreshToken(userID, time.Now().Add(duration))
+	jti, err := generateTokenID()
 	if err != nil {
 		return "", err
 	}
-	return newRefreshToken, nil
+	now := time.Now()
+	claims.Version = state.version
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        jti,
+		Issuer:    cfg.Security.JwtIssuer,
+		Audience:  jwt.ClaimStrings{cfg.Security.JwtAudience},
+		Subject:   strconv.Itoa(claims.UserID),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(lifespan)),
+	}
+	key, err := currentSigningKey()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
 }
 
-var RefreshJWT = func(refreshToken string) (string, error) {
-	userID, err := repository.GetUserByRefreshToken(refreshToken)
+var generateRefreshToken = func(userID int, client models.ClientInfo) (string, error) {
+	newRefreshToken, err := repository.CreateRefreshToken(userID, refreshTokenExpiresAt(), client)
 	if err != nil {
 		return "", err
 	}
+	return newRefreshToken, nil
+}
+
+var refreshTokenExpiresAt = func() time.Time {
+	duration, err := time.ParseDuration(config.GetConfig().Security.RefreshTokenExpiry)
+	if err != nil {
+		logger.Fatal("Invalid refresh token expiry " + config.GetConfig().Security.RefreshTokenExpiry)
+	}
+	return time.Now().Add(duration)
+}
+
+// RefreshJWT exchanges a refresh token for a new JWT and a new refresh token.
+// The presented token is revoked, and presenting a revoked token again revokes
+// every token issued from the same login.
+var RefreshJWT = func(refreshToken string) (string, string, error) {
+	userID, newRefreshToken, _, err := repository.RotateRefreshToken(refreshToken, refreshTokenExpiresAt(), "")
+	if err != nil {
+		return "", "", err
+	}
+	if userID == -2 {
+		logger.Warn("Refresh token reuse detected, token family revoked")
+		return "", "", fmt.Errorf("refresh token reuse detected")
+	}
 	if userID == -1 {
-		return "", fmt.Errorf("invalid refresh token")
+		return "", "", fmt.Errorf("invalid refresh token")
 	}
 	token, err := generateJWT(userID)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
-	return token, nil
+	return token, newRefreshToken, nil
 }
 
 var GetUserIDFromJWT = func(tokenString string) (int, error) {
-	secret := []byte(config.GetConfig().Security.JwtSecret)
+	claims, err := ParseJWT(tokenString)
+	if err != nil {
+		return 0, err
+	}
+	return claims.UserID, nil
+}
 
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+// ParseJWT verifies the signature and registered claims of an access token and
+// rejects tokens that were revoked individually or by a token version bump.
+var ParseJWT = func(tokenString string) (*AccessClaims, error) {
+	cfg := config.GetConfig()
+
+	claims := &AccessClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := verificationKey(kid)
+		if err != nil {
+			logger.Debug("Unknown signing key")
+			return nil, err
+		}
+		if token.Method.Alg() != key.method.Alg() {
 			logger.Debug("Unexpected signing method")
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return secret, nil
-	})
+		return key.public, nil
+	},
+		jwt.WithIssuer(cfg.Security.JwtIssuer),
+		jwt.WithAudience(cfg.Security.JwtAudience),
+		jwt.WithIssuedAt(),
+		jwt.WithExpirationRequired(),
+	)
 
 	if err != nil {
 		logger.Debug("Couldn't parse token")
-		return 0, fmt.Errorf("invalid token: %v", err)
+		return nil, fmt.Errorf("invalid token: %v", err)
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		if userIDFloat, ok := claims["user_id"].(float64); ok {
-			return int(userIDFloat), nil
-		}
-		logger.Debug("Couldn't parse user id from token")
-		return 0, fmt.Errorf("user_id not found in token")
+	if !token.Valid || claims.ID == "" || claims.UserID == 0 {
+		logger.Debug("Invalid token claims")
+		return nil, fmt.Errorf("invalid token claims")
 	}
 
-	logger.Debug("Invalid token claims")
-	return 0, fmt.Errorf("invalid token claims")
+	state, err := getTokenState(claims.UserID)
+	if err != nil {
+		logger.Debug("Couldn't load token state")
+		return nil, fmt.Errorf("invalid token: %v", err)
+	}
+	if claims.Version < state.version {
+		logger.Debug("Token version is outdated")
+		return nil, fmt.Errorf("token has been revoked")
+	}
+	if _, revoked := state.revoked[claims.ID]; revoked {
+		logger.Debug("Token is on the denylist")
+		return nil, fmt.Errorf("token has been revoked")
+	}
+	return claims, nil
 }
 
 var validateUsername = func(username string) bool {
@@ -0,0 +1,19 @@
+package auth
+
+import (
+	"fmt"
+	"gbs/internal/models"
+	"gbs/internal/repository"
+)
+
+// SetCreditLimit lets the primary wallet of a user go below zero in a
+// currency, down to minus the limit. A limit of 0 removes the credit line.
+var SetCreditLimit = func(initiatorID int, req models.CreditLimitRequest) error {
+	if req.Currency == "" {
+		return fmt.Errorf("currency is required")
+	}
+	if req.CreditLimit < 0 {
+		return fmt.Errorf("credit limit can not be negative")
+	}
+	return repository.SetCreditLimit(initiatorID, req)
+}
@@ -0,0 +1,18 @@
+package auth
+
+import (
+	"testing"
+
+	"gbs/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetCreditLimitValidation(t *testing.T) {
+	req := models.CreditLimitRequest{UserID: 6, CreditLimit: 1000}
+	assert.EqualError(t, SetCreditLimit(1, req), "currency is required")
+
+	req.Currency = "USD"
+	req.CreditLimit = -1
+	assert.EqualError(t, SetCreditLimit(1, req), "credit limit can not be negative")
+}
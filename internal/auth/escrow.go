@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"fmt"
+	"gbs/internal/models"
+	"gbs/internal/repository"
+	"gbs/pkg/logger"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	escrowSettlementInterval = time.Minute
+	escrowSettlementBatch    = 100
+)
+
+// EscrowStatuses are the statuses contracts can be listed by. A contract is
+// funded when it is created and settles once, released or refunded.
+var EscrowStatuses = []string{"funded", "released", "refunded"}
+
+func validEscrowOutcome(outcome string) bool {
+	return outcome == "release" || outcome == "refund"
+}
+
+// CreateEscrow moves the amount from the initiator into escrow. Without a
+// deadline action the contract is refunded at the deadline.
+var CreateEscrow = func(initiatorID int, req models.CreateEscrowRequest) (int, error) {
+	if utf8.RuneCountInString(req.Memo) > maxMemoLength {
+		return 0, fmt.Errorf("memo is too long")
+	}
+	if !req.Deadline.After(time.Now()) {
+		return 0, fmt.Errorf("deadline must be in the future")
+	}
+	if req.DeadlineAction == "" {
+		req.DeadlineAction = "refund"
+	}
+	if !validEscrowOutcome(req.DeadlineAction) {
+		return 0, fmt.Errorf("deadline action must be release or refund")
+	}
+	return repository.CreateEscrow(initiatorID, req)
+}
+
+// ConfirmEscrow records that a party wants the contract released or refunded.
+var ConfirmEscrow = func(initiatorID, escrowID int, outcome string) (string, error) {
+	if !validEscrowOutcome(outcome) {
+		return "", fmt.Errorf("outcome must be release or refund")
+	}
+	return repository.ConfirmEscrow(initiatorID, escrowID, outcome)
+}
+
+// StartEscrowSettlement settles contracts whose deadline has passed.
+var StartEscrowSettlement = func() {
+	go func() {
+		ticker := time.NewTicker(escrowSettlementInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			settleDueEscrows()
+		}
+	}()
+}
+
+func settleDueEscrows() {
+	escrowIDs, err := repository.GetDueEscrows(escrowSettlementBatch)
+	if err != nil {
+		logger.Error("Couldn't get due escrows: " + err.Error())
+		return
+	}
+	// Each contract settles in its own transaction so one failing doesn't
+	// hold back the others. Failed ones are postponed, otherwise they would
+	// fill every batch.
+	for _, escrowID := range escrowIDs {
+		if err = repository.SettleEscrowDeadline(escrowID); err != nil {
+			logger.Error(fmt.Sprintf("Couldn't settle escrow %d: %s", escrowID, err.Error()))
+			if err = repository.FailEscrowSettlement(escrowID, err.Error()); err != nil {
+				logger.Error(fmt.Sprintf("Couldn't record escrow %d settlement failure: %s", escrowID, err.Error()))
+			}
+		}
+	}
+}
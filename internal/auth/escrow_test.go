@@ -0,0 +1,26 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"gbs/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateEscrowValidation(t *testing.T) {
+	req := models.CreateEscrowRequest{PayeeID: 2, Currency: "USD", Amount: 100, Deadline: time.Now().Add(-time.Minute)}
+	_, err := CreateEscrow(1, req)
+	assert.EqualError(t, err, "deadline must be in the future")
+
+	req.Deadline = time.Now().Add(time.Hour)
+	req.DeadlineAction = "split"
+	_, err = CreateEscrow(1, req)
+	assert.EqualError(t, err, "deadline action must be release or refund")
+}
+
+func TestConfirmEscrowRejectsUnknownOutcome(t *testing.T) {
+	_, err := ConfirmEscrow(1, 2, "split")
+	assert.EqualError(t, err, "outcome must be release or refund")
+}
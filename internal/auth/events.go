@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"gbs/internal/models"
+	"gbs/internal/repository"
+	"gbs/pkg/logger"
+	"github.com/lib/pq"
+	"sync"
+	"time"
+)
+
+const (
+	maxStreamsPerUser  = 5
+	streamBufferSize   = 64
+	eventListenerPing  = 90 * time.Second
+	eventListenerRetry = 10 * time.Second
+)
+
+// EventSubscription receives events published while it is open. Events is
+// closed when the subscriber falls behind or notifications may have been
+// lost, the client should then resume from the last event it has seen.
+type EventSubscription struct {
+	Events    <-chan models.EventNotification
+	events    chan models.EventNotification
+	userID    int
+	allEvents bool
+	hub       *eventHub
+}
+
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[*EventSubscription]struct{}
+	perUser     map[int]int
+}
+
+var events = newEventHub()
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		subscribers: map[*EventSubscription]struct{}{},
+		perUser:     map[int]int{},
+	}
+}
+
+// SubscribeEvents opens a subscription to the events that concern userID, or
+// to all events. Permissions for allEvents have to be checked by the caller.
+var SubscribeEvents = func(userID int, allEvents bool) (*EventSubscription, error) {
+	return events.subscribe(userID, allEvents)
+}
+
+func (h *eventHub) subscribe(userID int, allEvents bool) (*EventSubscription, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.perUser[userID] >= maxStreamsPerUser {
+		return nil, fmt.Errorf("too many open event streams")
+	}
+	ch := make(chan models.EventNotification, streamBufferSize)
+	sub := &EventSubscription{Events: ch, events: ch, userID: userID, allEvents: allEvents, hub: h}
+	h.subscribers[sub] = struct{}{}
+	h.perUser[userID]++
+	return sub, nil
+}
+
+// Close ends the subscription, it is safe to call more than once.
+func (s *EventSubscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s)
+}
+
+func (h *eventHub) remove(sub *EventSubscription) {
+	if _, ok := h.subscribers[sub]; !ok {
+		return
+	}
+	delete(h.subscribers, sub)
+	close(sub.events)
+	if h.perUser[sub.userID]--; h.perUser[sub.userID] <= 0 {
+		delete(h.perUser, sub.userID)
+	}
+}
+
+func (h *eventHub) publish(event models.EventNotification) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subscribers {
+		if !sub.allEvents && !EventConcerns(event, sub.userID) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			logger.Warn(fmt.Sprintf("Dropping event stream of userID=%d, it fell behind", sub.userID))
+			h.remove(sub)
+		}
+	}
+}
+
+// EventConcerns reports whether userID is one of the users an event is about.
+func EventConcerns(event models.EventNotification, userID int) bool {
+	for _, id := range event.UserIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// dropAll closes every subscription, used when notifications may have been
+// missed and subscribers have to resume from the events table.
+func (h *eventHub) dropAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subscribers {
+		h.remove(sub)
+	}
+}
+
+// StartEventStream listens for events committed by any GBS instance and fans
+// them out to open subscriptions.
+var StartEventStream = func() {
+	go func() {
+		for {
+			listener, err := repository.ListenEvents()
+			if err != nil {
+				time.Sleep(eventListenerRetry)
+				continue
+			}
+			listenEvents(listener.Notify, listener.Ping)
+			listener.Close()
+		}
+	}()
+}
+
+func listenEvents(notify <-chan *pq.Notification, ping func() error) {
+	for {
+		select {
+		case n, ok := <-notify:
+			if !ok {
+				events.dropAll()
+				return
+			}
+			if n == nil {
+				logger.Warn("Event listener reconnected, closing open event streams")
+				events.dropAll()
+				continue
+			}
+			var event models.EventNotification
+			if err := json.Unmarshal([]byte(n.Extra), &event); err != nil {
+				logger.Error("Couldn't decode event notification: " + err.Error())
+				continue
+			}
+			events.publish(event)
+		case <-time.After(eventListenerPing):
+			if err := ping(); err != nil {
+				logger.Warn("Event listener ping failed: " + err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"testing"
+
+	"gbs/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func notification(id int64, userIDs ...int) models.EventNotification {
+	return models.EventNotification{Event: models.Event{EventID: id, Type: "transfer"}, UserIDs: userIDs}
+}
+
+func TestEventHubRoutesEvents(t *testing.T) {
+	hub := newEventHub()
+	alice, err := hub.subscribe(1, false)
+	assert.NoError(t, err)
+	auditor, err := hub.subscribe(3, true)
+	assert.NoError(t, err)
+
+	hub.publish(notification(10, 1, 2))
+	hub.publish(notification(11, 2, 4))
+
+	assert.Equal(t, int64(10), (<-alice.Events).EventID)
+	assert.Len(t, alice.Events, 0, "events of other users must not be delivered")
+	assert.Equal(t, int64(10), (<-auditor.Events).EventID)
+	assert.Equal(t, int64(11), (<-auditor.Events).EventID)
+
+	alice.Close()
+	alice.Close()
+	_, open := <-alice.Events
+	assert.False(t, open)
+	hub.publish(notification(12, 1))
+	assert.Equal(t, int64(12), (<-auditor.Events).EventID)
+}
+
+func TestEventHubDropsSlowSubscribers(t *testing.T) {
+	hub := newEventHub()
+	sub, err := hub.subscribe(1, false)
+	assert.NoError(t, err)
+	for i := 0; i <= streamBufferSize; i++ {
+		hub.publish(notification(int64(i+1), 1))
+	}
+	received := 0
+	for range sub.Events {
+		received++
+	}
+	assert.Equal(t, streamBufferSize, received, "the channel is closed once the buffer overflows")
+	assert.Empty(t, hub.subscribers)
+	assert.Empty(t, hub.perUser)
+}
+
+func TestEventHubLimitsStreamsPerUser(t *testing.T) {
+	hub := newEventHub()
+	var subs []*EventSubscription
+	for i := 0; i < maxStreamsPerUser; i++ {
+		sub, err := hub.subscribe(1, false)
+		assert.NoError(t, err)
+		subs = append(subs, sub)
+	}
+	_, err := hub.subscribe(1, false)
+	assert.Error(t, err)
+	_, err = hub.subscribe(2, false)
+	assert.NoError(t, err)
+
+	subs[0].Close()
+	_, err = hub.subscribe(1, false)
+	assert.NoError(t, err)
+
+	hub.dropAll()
+	assert.Empty(t, hub.subscribers)
+	_, open := <-subs[1].Events
+	assert.False(t, open)
+}
@@ -0,0 +1,338 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"gbs/internal/config"
+	"gbs/internal/models"
+	"gbs/internal/repository"
+	"gbs/pkg/logger"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	rsaKeyBits            = 2048
+	keyRefreshInterval    = time.Minute
+	minUnknownKeyInterval = 10 * time.Second
+)
+
+// signingKey is a key the access tokens are signed or verified with. For
+// HS256 both sides are the shared secret and the key is never published.
+type signingKey struct {
+	kid       string
+	method    jwt.SigningMethod
+	private   interface{}
+	public    interface{}
+	createdAt time.Time
+}
+
+// keyRing holds every key that may have signed a token which is still valid.
+// The newest key is used for signing, older ones only for verification until
+// the tokens they signed expire.
+type keyRing struct {
+	mu       sync.RWMutex
+	keys     map[string]*signingKey
+	active   *signingKey
+	loadedAt time.Time
+}
+
+var ring = &keyRing{}
+
+// StartKeyRotation loads the signing keys and keeps them up to date: a new key
+// is generated once the active one is older than the rotation interval, and
+// keys created by other instances are picked up on the next refresh.
+var StartKeyRotation = func() {
+	if err := refreshKeys(); err != nil {
+		logger.Fatal("Couldn't load signing keys: " + err.Error())
+	}
+	go func() {
+		ticker := time.NewTicker(keyRefreshInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := refreshKeys(); err != nil {
+				logger.Error("Couldn't refresh signing keys: " + err.Error())
+			}
+		}
+	}()
+}
+
+// JWKS returns the public parts of the keys that may have signed a valid token.
+var JWKS = func() (models.JWKSResponse, error) {
+	if _, err := currentSigningKey(); err != nil {
+		return models.JWKSResponse{}, err
+	}
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+	response := models.JWKSResponse{Keys: []models.JSONWebKey{}}
+	for _, key := range ring.keys {
+		if jwk, ok := toJWK(key); ok {
+			response.Keys = append(response.Keys, jwk)
+		}
+	}
+	return response, nil
+}
+
+var currentSigningKey = func() (*signingKey, error) {
+	ring.mu.RLock()
+	active := ring.active
+	ring.mu.RUnlock()
+	if active != nil {
+		return active, nil
+	}
+	if err := refreshKeys(); err != nil {
+		return nil, err
+	}
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+	if ring.active == nil {
+		return nil, fmt.Errorf("no signing key available")
+	}
+	return ring.active, nil
+}
+
+// verificationKey looks a key up by kid. An unknown kid may come from a key
+// another instance has just rotated in, so the ring is reloaded, but not more
+// often than minUnknownKeyInterval to keep forged kids from hammering the db.
+var verificationKey = func(kid string) (*signingKey, error) {
+	active, err := currentSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := active.method.(*jwt.SigningMethodHMAC); ok {
+		return active, nil
+	}
+	ring.mu.RLock()
+	key, ok := ring.keys[kid]
+	stale := time.Since(ring.loadedAt) > minUnknownKeyInterval
+	ring.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+	if !stale {
+		return nil, fmt.Errorf("unknown key id")
+	}
+	if err = refreshKeys(); err != nil {
+		return nil, err
+	}
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+	if key, ok = ring.keys[kid]; !ok {
+		return nil, fmt.Errorf("unknown key id")
+	}
+	return key, nil
+}
+
+var refreshKeys = func() error {
+	cfg := config.GetConfig()
+	algorithm := cfg.Security.JwtAlgorithm
+	if algorithm == "" || algorithm == jwt.SigningMethodHS256.Alg() {
+		key := &signingKey{
+			kid:     "hs256",
+			method:  jwt.SigningMethodHS256,
+			private: []byte(cfg.Security.JwtSecret),
+			public:  []byte(cfg.Security.JwtSecret),
+		}
+		ring.mu.Lock()
+		ring.keys = map[string]*signingKey{key.kid: key}
+		ring.active = key
+		ring.loadedAt = time.Now()
+		ring.mu.Unlock()
+		return nil
+	}
+
+	rotation, err := time.ParseDuration(cfg.Security.JwtKeyRotationInterval)
+	if err != nil {
+		logger.Fatal("Invalid jwt key rotation interval " + cfg.Security.JwtKeyRotationInterval)
+	}
+	tokenLifespan, err := time.ParseDuration(cfg.Security.TokenExpiry)
+	if err != nil {
+		logger.Fatal("Invalid token lifespan " + cfg.Security.TokenExpiry)
+	}
+	rotateBefore := time.Now().Add(-rotation)
+
+	ring.mu.RLock()
+	needsRotation := ring.active == nil || ring.active.createdAt.Before(rotateBefore)
+	ring.mu.RUnlock()
+	if needsRotation {
+		if err = rotateKey(algorithm, rotateBefore); err != nil {
+			return err
+		}
+	}
+
+	stored, err := repository.GetSigningKeys(algorithm, rotateBefore.Add(-tokenLifespan))
+	if err != nil {
+		return err
+	}
+	keys := make(map[string]*signingKey, len(stored))
+	var active *signingKey
+	for _, s := range stored {
+		key, err := decodeSigningKey(s, cfg.Security.JwtSecret)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Couldn't decode signing key %s: %s", s.KeyID, err.Error()))
+			continue
+		}
+		keys[key.kid] = key
+		if active == nil || key.createdAt.After(active.createdAt) {
+			active = key
+		}
+	}
+	if active == nil {
+		return fmt.Errorf("no signing key available")
+	}
+	ring.mu.Lock()
+	ring.keys = keys
+	ring.active = active
+	ring.loadedAt = time.Now()
+	ring.mu.Unlock()
+	return nil
+}
+
+// rotateKey stores a fresh key unless some instance has already created one
+// after rotateBefore.
+var rotateKey = func(algorithm string, rotateBefore time.Time) error {
+	private, err := generateSigningKey(algorithm)
+	if err != nil {
+		return err
+	}
+	kid, err := generateKeyID()
+	if err != nil {
+		return err
+	}
+	sealed, err := sealPrivateKey(private, config.GetConfig().Security.JwtSecret)
+	if err != nil {
+		return err
+	}
+	created, err := repository.CreateSigningKey(models.StoredSigningKey{KeyID: kid, Algorithm: algorithm, PrivateKey: sealed}, rotateBefore)
+	if err != nil {
+		return err
+	}
+	if created {
+		logger.Info(fmt.Sprintf("Rotated %s signing key, new kid %s", algorithm, kid))
+	}
+	return nil
+}
+
+func generateSigningKey(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case jwt.SigningMethodRS256.Alg():
+		return rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case jwt.SigningMethodEdDSA.Alg():
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		return private, err
+	}
+	return nil, fmt.Errorf("unsupported jwt algorithm %s", algorithm)
+}
+
+func generateKeyID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		logger.Error("Key ID generation error")
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// sealPrivateKey encrypts a PKCS#8 encoded key with AES-GCM keyed by the jwt
+// secret, so that a database dump alone isn't enough to forge tokens.
+func sealPrivateKey(private crypto.Signer, secret string) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newKeyCipher(secret)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	block := &pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: aead.Seal(nonce, nonce, der, nil)}
+	return string(pem.EncodeToMemory(block)), nil
+}
+
+func openPrivateKey(sealed, secret string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(sealed))
+	if block == nil {
+		return nil, fmt.Errorf("invalid key encoding")
+	}
+	aead, err := newKeyCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	if len(block.Bytes) < aead.NonceSize() {
+		return nil, fmt.Errorf("invalid key encoding")
+	}
+	nonce, ciphertext := block.Bytes[:aead.NonceSize()], block.Bytes[aead.NonceSize():]
+	der, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decrypt key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type")
+	}
+	return signer, nil
+}
+
+func newKeyCipher(secret string) (cipher.AEAD, error) {
+	sum := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func decodeSigningKey(stored models.StoredSigningKey, secret string) (*signingKey, error) {
+	private, err := openPrivateKey(stored.PrivateKey, secret)
+	if err != nil {
+		return nil, err
+	}
+	method := jwt.GetSigningMethod(stored.Algorithm)
+	if method == nil {
+		return nil, fmt.Errorf("unsupported jwt algorithm %s", stored.Algorithm)
+	}
+	return &signingKey{
+		kid:       stored.KeyID,
+		method:    method,
+		private:   private,
+		public:    private.Public(),
+		createdAt: stored.CreatedAt,
+	}, nil
+}
+
+func toJWK(key *signingKey) (models.JSONWebKey, bool) {
+	jwk := models.JSONWebKey{KeyID: key.kid, Use: "sig", Algorithm: key.method.Alg()}
+	switch public := key.public.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	default:
+		return models.JSONWebKey{}, false
+	}
+	return jwk, true
+}
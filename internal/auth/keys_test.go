@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"gbs/internal/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSealedKeyRoundTrip(t *testing.T) {
+	for _, algorithm := range []string{"RS256", "EdDSA"} {
+		private, err := generateSigningKey(algorithm)
+		assert.NoError(t, err)
+
+		sealed, err := sealPrivateKey(private, "secret")
+		assert.NoError(t, err)
+		assert.NotContains(t, sealed, "BEGIN PRIVATE KEY", "key must not be stored in plain text")
+
+		key, err := decodeSigningKey(models.StoredSigningKey{KeyID: "kid", Algorithm: algorithm, PrivateKey: sealed, CreatedAt: time.Now()}, "secret")
+		assert.NoError(t, err)
+		assert.Equal(t, algorithm, key.method.Alg())
+
+		_, err = openPrivateKey(sealed, "other secret")
+		assert.Error(t, err, "key must not open with a different secret")
+	}
+}
+
+func TestSignAndVerifyWithDecodedKey(t *testing.T) {
+	for _, algorithm := range []string{"RS256", "EdDSA"} {
+		private, err := generateSigningKey(algorithm)
+		assert.NoError(t, err)
+		sealed, err := sealPrivateKey(private, "secret")
+		assert.NoError(t, err)
+		key, err := decodeSigningKey(models.StoredSigningKey{KeyID: "kid", Algorithm: algorithm, PrivateKey: sealed}, "secret")
+		assert.NoError(t, err)
+
+		signed, err := jwt.NewWithClaims(key.method, jwt.RegisteredClaims{Subject: "1"}).SignedString(key.private)
+		assert.NoError(t, err)
+		token, err := jwt.Parse(signed, func(*jwt.Token) (interface{}, error) { return key.public, nil })
+		assert.NoError(t, err)
+		assert.True(t, token.Valid)
+	}
+}
+
+func TestToJWK(t *testing.T) {
+	private, err := generateSigningKey("RS256")
+	assert.NoError(t, err)
+	jwk, ok := toJWK(&signingKey{kid: "rsa", method: jwt.SigningMethodRS256, public: private.Public()})
+	assert.True(t, ok)
+	assert.Equal(t, "RSA", jwk.KeyType)
+	assert.Equal(t, "AQAB", jwk.E)
+	assert.Len(t, jwk.N, 342, "2048-bit modulus should encode to 342 base64url characters")
+	assert.IsType(t, &rsa.PublicKey{}, private.Public())
+
+	private, err = generateSigningKey("EdDSA")
+	assert.NoError(t, err)
+	jwk, ok = toJWK(&signingKey{kid: "ed", method: jwt.SigningMethodEdDSA, public: private.Public()})
+	assert.True(t, ok)
+	assert.Equal(t, "OKP", jwk.KeyType)
+	assert.Equal(t, "Ed25519", jwk.Curve)
+	assert.Len(t, jwk.X, 43)
+	assert.IsType(t, ed25519.PublicKey{}, private.Public())
+
+	_, ok = toJWK(&signingKey{kid: "hs256", method: jwt.SigningMethodHS256, public: []byte("secret")})
+	assert.False(t, ok, "shared secrets must never be published")
+}
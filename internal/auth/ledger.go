@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"gbs/internal/config"
+	"gbs/internal/models"
+	"gbs/internal/repository"
+	"gbs/pkg/logger"
+	"sync"
+	"time"
+)
+
+// ledgerChains are the hash chained ledger tables, see log_transaction and
+// log_print_money.
+var ledgerChains = []string{"transactions", "print_money"}
+
+type ledgerSigner struct {
+	keyID   string
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+}
+
+var (
+	ledgerKeyOnce sync.Once
+	ledgerKey     *ledgerSigner
+)
+
+// deriveLedgerKey derives the Ed25519 key checkpoints are signed with from
+// the jwt secret. It never touches the database, so write access to the
+// ledger isn't enough to sign a rewritten chain.
+func deriveLedgerKey(secret string) *ledgerSigner {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("gbs ledger signing key"))
+	private := ed25519.NewKeyFromSeed(mac.Sum(nil))
+	public := private.Public().(ed25519.PublicKey)
+	fingerprint := sha256.Sum256(public)
+	return &ledgerSigner{keyID: hex.EncodeToString(fingerprint[:8]), private: private, public: public}
+}
+
+func currentLedgerKey() *ledgerSigner {
+	ledgerKeyOnce.Do(func() {
+		ledgerKey = deriveLedgerKey(config.GetConfig().Security.JwtSecret)
+	})
+	return ledgerKey
+}
+
+// LedgerKey returns the public key ledger checkpoints and transfer receipts
+// are signed with.
+var LedgerKey = func() models.LedgerKeyResponse {
+	key := currentLedgerKey()
+	return models.LedgerKeyResponse{
+		KeyID:     key.keyID,
+		Algorithm: "Ed25519",
+		PublicKey: base64.StdEncoding.EncodeToString(key.public),
+	}
+}
+
+func checkpointMessage(chain string, lastID int, hash []byte) []byte {
+	return []byte(fmt.Sprintf("gbs-ledger-checkpoint\n%s\n%d\n%x", chain, lastID, hash))
+}
+
+func signCheckpoint(key *ledgerSigner, head models.LedgerHead) models.LedgerCheckpoint {
+	return models.LedgerCheckpoint{
+		Chain:     head.Chain,
+		LastID:    head.LastID,
+		Hash:      head.Hash,
+		KeyID:     key.keyID,
+		Signature: ed25519.Sign(key.private, checkpointMessage(head.Chain, head.LastID, head.Hash)),
+	}
+}
+
+// checkCheckpoint compares a checkpoint against the row it pins and returns
+// what is wrong with it, or "" if nothing is.
+func checkCheckpoint(key *ledgerSigner, checkpoint models.LedgerCheckpoint, storedHash []byte) string {
+	if !ed25519.Verify(key.public, checkpointMessage(checkpoint.Chain, checkpoint.LastID, checkpoint.Hash), checkpoint.Signature) {
+		return "invalid signature"
+	}
+	if storedHash == nil {
+		return "checkpointed row is missing"
+	}
+	if !bytes.Equal(storedHash, checkpoint.Hash) {
+		return "row hash differs from checkpoint"
+	}
+	return ""
+}
+
+// StartLedgerCheckpoints signs the head of every ledger chain periodically.
+// Chains that haven't grown since their last checkpoint are skipped.
+var StartLedgerCheckpoints = func() {
+	interval, err := time.ParseDuration(config.GetConfig().Security.LedgerCheckpointInterval)
+	if err != nil || interval <= 0 {
+		logger.Warn("Ledger checkpoints are disabled, invalid interval " + config.GetConfig().Security.LedgerCheckpointInterval)
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := createLedgerCheckpoints(); err != nil {
+				logger.Error("Couldn't create ledger checkpoints: " + err.Error())
+			}
+		}
+	}()
+}
+
+func createLedgerCheckpoints() error {
+	heads, err := repository.GetLedgerHeads()
+	if err != nil {
+		return err
+	}
+	latest, err := repository.GetLatestLedgerCheckpoints()
+	if err != nil {
+		return err
+	}
+	checkpointed := make(map[string]int, len(latest))
+	for _, checkpoint := range latest {
+		checkpointed[checkpoint.Chain] = checkpoint.LastID
+	}
+	key := currentLedgerKey()
+	for _, head := range heads {
+		if lastID, ok := checkpointed[head.Chain]; ok && lastID == head.LastID {
+			continue
+		}
+		if err = repository.CreateLedgerCheckpoint(signCheckpoint(key, head)); err != nil {
+			return err
+		}
+		logger.Info(fmt.Sprintf("Ledger checkpoint for %s at row %d", head.Chain, head.LastID))
+	}
+	return nil
+}
+
+// VerifyLedger walks every ledger chain and checks the checkpoints signed
+// with the current key. Checkpoints signed with an older key (the jwt secret
+// was changed since) can't be checked and are counted as skipped.
+var VerifyLedger = func(initiatorID int) (models.LedgerVerificationResponse, error) {
+	response := models.LedgerVerificationResponse{Valid: true, Chains: []models.LedgerChainReport{}}
+	key := currentLedgerKey()
+	for _, chain := range ledgerChains {
+		checked, brokenID, problem, err := repository.VerifyLedgerChain(initiatorID, chain)
+		if err != nil {
+			return models.LedgerVerificationResponse{}, err
+		}
+		report := models.LedgerChainReport{Chain: chain, CheckedRows: checked, BrokenID: brokenID, Problem: problem}
+
+		checkpoints, err := repository.GetLedgerCheckpoints(chain)
+		if err != nil {
+			return models.LedgerVerificationResponse{}, err
+		}
+		for _, checkpoint := range checkpoints {
+			if checkpoint.KeyID != key.keyID {
+				report.SkippedCheckpoints++
+				continue
+			}
+			report.Checkpoints++
+			storedHash, err := repository.GetLedgerEntryHash(chain, checkpoint.LastID)
+			if err != nil {
+				return models.LedgerVerificationResponse{}, err
+			}
+			if checkpointProblem := checkCheckpoint(key, checkpoint, storedHash); checkpointProblem != "" {
+				checkpointID := checkpoint.CheckpointID
+				report.BrokenCheckpointID = &checkpointID
+				report.CheckpointProblem = &checkpointProblem
+				break
+			}
+		}
+		if report.BrokenID != nil || report.BrokenCheckpointID != nil {
+			response.Valid = false
+		}
+		response.Chains = append(response.Chains, report)
+	}
+	return response, nil
+}
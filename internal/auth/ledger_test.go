@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"testing"
+
+	"gbs/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeriveLedgerKey(t *testing.T) {
+	key := deriveLedgerKey("secret")
+	assert.Equal(t, key.keyID, deriveLedgerKey("secret").keyID, "key must be stable across instances")
+	assert.NotEqual(t, key.keyID, deriveLedgerKey("other secret").keyID)
+	assert.Len(t, key.keyID, 16)
+}
+
+func TestCheckCheckpoint(t *testing.T) {
+	key := deriveLedgerKey("secret")
+	hash := []byte{1, 2, 3}
+	checkpoint := signCheckpoint(key, models.LedgerHead{Chain: "transactions", LastID: 7, Hash: hash})
+
+	assert.Equal(t, "", checkCheckpoint(key, checkpoint, hash))
+	assert.Equal(t, "row hash differs from checkpoint", checkCheckpoint(key, checkpoint, []byte{1, 2, 4}))
+	assert.Equal(t, "checkpointed row is missing", checkCheckpoint(key, checkpoint, nil))
+
+	moved := checkpoint
+	moved.LastID = 8
+	assert.Equal(t, "invalid signature", checkCheckpoint(key, moved, hash), "signature must cover the row id")
+	assert.Equal(t, "invalid signature", checkCheckpoint(deriveLedgerKey("other secret"), checkpoint, hash))
+}
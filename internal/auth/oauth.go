@@ -0,0 +1,312 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"gbs/internal/config"
+	"gbs/internal/models"
+	"gbs/internal/repository"
+	"gbs/pkg/logger"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Error codes from RFC 6749 section 5.2 and 4.1.2.1.
+const (
+	OAuthInvalidRequest          = "invalid_request"
+	OAuthInvalidClient           = "invalid_client"
+	OAuthInvalidGrant            = "invalid_grant"
+	OAuthUnauthorizedClient      = "unauthorized_client"
+	OAuthUnsupportedGrantType    = "unsupported_grant_type"
+	OAuthUnsupportedResponseType = "unsupported_response_type"
+	OAuthInvalidScope            = "invalid_scope"
+	OAuthAccessDenied            = "access_denied"
+	OAuthServerError             = "server_error"
+)
+
+const (
+	maxOAuthClientName = 64
+	oauthSecretSize    = 32
+	oauthCodeSize      = 32
+)
+
+var (
+	pkceVerifierPattern  = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+	pkceChallengePattern = regexp.MustCompile(`^[A-Za-z0-9\-_]{43}$`)
+)
+
+// OAuthError carries an RFC 6749 error code that can be returned to the
+// client as is.
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func (e *OAuthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// RegisterOAuthClient registers a client owned by ownerID. Confidential clients
+// get a secret, which is returned only once; public clients have to rely on
+// PKCE alone and can't use the client credentials grant.
+var RegisterOAuthClient = func(ownerID int, name string, redirectURIs, scopes []string, confidential bool) (string, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxOAuthClientName {
+		return "", "", fmt.Errorf("invalid client name")
+	}
+	if len(redirectURIs) == 0 {
+		return "", "", fmt.Errorf("at least one redirect uri is required")
+	}
+	for _, uri := range redirectURIs {
+		if err := validateRedirectURI(uri); err != nil {
+			return "", "", err
+		}
+	}
+	if len(scopes) == 0 {
+		return "", "", fmt.Errorf("at least one scope is required")
+	}
+	clientID, err := generateTokenID()
+	if err != nil {
+		return "", "", err
+	}
+	var secret, secretHash string
+	if confidential {
+		if secret, err = generateOpaqueToken(oauthSecretSize); err != nil {
+			return "", "", err
+		}
+		secretHash = hashOpaqueToken(secret)
+	}
+	if err = repository.CreateOAuthClient(ownerID, clientID, secretHash, name, redirectURIs, parseScope(strings.Join(scopes, " "))); err != nil {
+		return "", "", err
+	}
+	return clientID, secret, nil
+}
+
+var GetOAuthClient = func(clientID string) (*models.OAuthClient, error) {
+	client, _, err := repository.GetOAuthClient(clientID)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, &OAuthError{OAuthInvalidClient, "unknown client"}
+	}
+	return client, nil
+}
+
+// AuthenticateOAuthClient checks the secret of confidential clients. Public
+// clients are identified by their ID only.
+var AuthenticateOAuthClient = func(clientID, secret string) (*models.OAuthClient, error) {
+	client, secretHash, err := repository.GetOAuthClient(clientID)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, &OAuthError{OAuthInvalidClient, "unknown client"}
+	}
+	if secretHash == "" {
+		if secret != "" {
+			return nil, &OAuthError{OAuthInvalidClient, "public clients have no secret"}
+		}
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(hashOpaqueToken(secret)), []byte(secretHash)) != 1 {
+		return nil, &OAuthError{OAuthInvalidClient, "invalid client credentials"}
+	}
+	return client, nil
+}
+
+// ValidRedirectURI reports whether uri is one of the client's registered
+// redirect uris. Only exact matches are accepted.
+func ValidRedirectURI(client *models.OAuthClient, uri string) bool {
+	for _, registered := range client.RedirectURIs {
+		if registered == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// RequestedScopes resolves the scope parameter of a request against the scopes
+// the client was registered with. An empty scope requests all of them.
+func RequestedScopes(client *models.OAuthClient, scope string) ([]string, error) {
+	requested := parseScope(scope)
+	if len(requested) == 0 {
+		return client.Scopes, nil
+	}
+	for _, s := range requested {
+		if !scopeAllows(client.Scopes, s) {
+			return nil, &OAuthError{OAuthInvalidScope, "scope " + s + " is not allowed for this client"}
+		}
+	}
+	return requested, nil
+}
+
+// VerifyCredentials checks a login and password, plus the second factor when
+// the user has it enabled, without issuing any tokens.
+var VerifyCredentials = func(login, password, code string) (int, error) {
+	id, hash, err := repository.GetUserIDHash(login)
+	if err != nil {
+		return 0, err
+	}
+	if hash == "" || !compareHashes(hash, password) {
+		return 0, fmt.Errorf("invalid credentials")
+	}
+	_, twoFactorEnabled, err := repository.GetTOTP(id)
+	if err != nil {
+		return 0, err
+	}
+	if twoFactorEnabled {
+		ok, err := verifySecondFactor(id, code)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			return 0, fmt.Errorf("invalid two-factor code")
+		}
+	}
+	return id, nil
+}
+
+// CreateAuthorizationCode issues a short-lived code the client exchanges for
+// tokens. Only the S256 PKCE method is supported.
+var CreateAuthorizationCode = func(client *models.OAuthClient, userID int, redirectURI string, scopes []string, codeChallenge string) (string, error) {
+	if !pkceChallengePattern.MatchString(codeChallenge) {
+		return "", &OAuthError{OAuthInvalidRequest, "invalid code_challenge"}
+	}
+	duration, err := time.ParseDuration(config.GetConfig().Security.OAuthCodeExpiry)
+	if err != nil {
+		logger.Fatal("Invalid oauth code expiry " + config.GetConfig().Security.OAuthCodeExpiry)
+	}
+	code, err := generateOpaqueToken(oauthCodeSize)
+	if err != nil {
+		return "", err
+	}
+	err = repository.CreateAuthorizationCode(hashOpaqueToken(code), client.ClientID, userID, redirectURI, scopes, codeChallenge, time.Now().Add(duration))
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+var ExchangeAuthorizationCode = func(client *models.OAuthClient, code, redirectURI, codeVerifier string, info models.ClientInfo) (models.OAuthTokenResponse, error) {
+	userID, scopes, codeChallenge, err := repository.ConsumeAuthorizationCode(hashOpaqueToken(code), client.ClientID, redirectURI)
+	if err != nil {
+		return models.OAuthTokenResponse{}, err
+	}
+	if userID == -1 {
+		return models.OAuthTokenResponse{}, &OAuthError{OAuthInvalidGrant, "invalid or expired authorization code"}
+	}
+	if !verifyPKCE(codeVerifier, codeChallenge) {
+		return models.OAuthTokenResponse{}, &OAuthError{OAuthInvalidGrant, "invalid code_verifier"}
+	}
+	token, err := generateScopedJWT(userID, client.ClientID, scopes)
+	if err != nil {
+		return models.OAuthTokenResponse{}, err
+	}
+	refreshToken, err := repository.CreateOAuthRefreshToken(userID, refreshTokenExpiresAt(), info, client.ClientID, scopes)
+	if err != nil {
+		return models.OAuthTokenResponse{}, err
+	}
+	return oauthTokenResponse(token, refreshToken, scopes), nil
+}
+
+// ClientCredentialsToken issues a token that lets a confidential client act as
+// the user that registered it. No refresh token is issued, the client can
+// simply ask for a new token.
+var ClientCredentialsToken = func(client *models.OAuthClient, scope string) (models.OAuthTokenResponse, error) {
+	if !client.Confidential {
+		return models.OAuthTokenResponse{}, &OAuthError{OAuthUnauthorizedClient, "public clients can't use client credentials"}
+	}
+	scopes, err := RequestedScopes(client, scope)
+	if err != nil {
+		return models.OAuthTokenResponse{}, err
+	}
+	token, err := generateScopedJWT(client.OwnerID, client.ClientID, scopes)
+	if err != nil {
+		return models.OAuthTokenResponse{}, err
+	}
+	return oauthTokenResponse(token, "", scopes), nil
+}
+
+var RefreshOAuthToken = func(client *models.OAuthClient, refreshToken string) (models.OAuthTokenResponse, error) {
+	userID, newRefreshToken, scopes, err := repository.RotateRefreshToken(refreshToken, refreshTokenExpiresAt(), client.ClientID)
+	if err != nil {
+		return models.OAuthTokenResponse{}, err
+	}
+	if userID == -2 {
+		logger.Warn("OAuth refresh token reuse detected, token family revoked")
+		return models.OAuthTokenResponse{}, &OAuthError{OAuthInvalidGrant, "refresh token reuse detected"}
+	}
+	if userID == -1 {
+		return models.OAuthTokenResponse{}, &OAuthError{OAuthInvalidGrant, "invalid refresh token"}
+	}
+	token, err := generateScopedJWT(userID, client.ClientID, scopes)
+	if err != nil {
+		return models.OAuthTokenResponse{}, err
+	}
+	return oauthTokenResponse(token, newRefreshToken, scopes), nil
+}
+
+func oauthTokenResponse(token, refreshToken string, scopes []string) models.OAuthTokenResponse {
+	tokenLifespan, err := time.ParseDuration(config.GetConfig().Security.TokenExpiry)
+	if err != nil {
+		logger.Fatal("Invalid token lifespan " + config.GetConfig().Security.TokenExpiry)
+	}
+	return models.OAuthTokenResponse{
+		AccessToken:  token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(tokenLifespan.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(scopes, " "),
+	}
+}
+
+// validateRedirectURI accepts absolute https uris, and plain http only for
+// loopback addresses used by native apps and local development.
+func validateRedirectURI(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || u.Host == "" || u.Fragment != "" {
+		return fmt.Errorf("invalid redirect uri %s", raw)
+	}
+	switch u.Scheme {
+	case "https":
+		return nil
+	case "http":
+		host := u.Hostname()
+		if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+			return nil
+		}
+	}
+	return fmt.Errorf("redirect uri %s must use https", raw)
+}
+
+// verifyPKCE checks the verifier against an S256 challenge (RFC 7636).
+func verifyPKCE(verifier, challenge string) bool {
+	if !pkceVerifierPattern.MatchString(verifier) {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+func generateOpaqueToken(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		logger.Error("Opaque token generation error")
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"testing"
+
+	"gbs/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// RFC 7636 appendix B.
+func TestVerifyPKCE(t *testing.T) {
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	assert.True(t, verifyPKCE(verifier, challenge))
+	assert.False(t, verifyPKCE(verifier+"x", challenge))
+	assert.False(t, verifyPKCE("short", challenge), "verifier must be at least 43 characters")
+	assert.True(t, pkceChallengePattern.MatchString(challenge))
+}
+
+func TestValidateRedirectURI(t *testing.T) {
+	for _, uri := range []string{
+		"https://plugin.example.com/callback",
+		"http://localhost:3000/callback",
+		"http://127.0.0.1/callback",
+		"http://[::1]:8080/cb",
+	} {
+		assert.NoError(t, validateRedirectURI(uri), uri)
+	}
+	for _, uri := range []string{
+		"",
+		"/callback",
+		"http://plugin.example.com/callback",
+		"https://plugin.example.com/callback#fragment",
+		"javascript:alert(1)",
+	} {
+		assert.Error(t, validateRedirectURI(uri), uri)
+	}
+}
+
+func TestRequestedScopes(t *testing.T) {
+	client := &models.OAuthClient{Scopes: []string{"send_funds", "audit_funds"}}
+
+	scopes, err := RequestedScopes(client, "")
+	assert.NoError(t, err)
+	assert.Equal(t, client.Scopes, scopes, "empty scope should request all client scopes")
+
+	scopes, err = RequestedScopes(client, "send_funds send_funds")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"send_funds"}, scopes)
+
+	_, err = RequestedScopes(client, "send_funds print_money")
+	assert.Error(t, err)
+	assert.Equal(t, OAuthInvalidScope, err.(*OAuthError).Code)
+}
+
+func TestAccessClaimsAllows(t *testing.T) {
+	var missing *AccessClaims
+	assert.True(t, missing.Allows("print_money"))
+	assert.True(t, (&AccessClaims{UserID: 1}).Allows("print_money"), "first-party tokens are not limited")
+
+	scoped := &AccessClaims{UserID: 1, ClientID: "client", Scope: "send_funds audit_funds"}
+	assert.True(t, scoped.Allows("audit_funds"))
+	assert.False(t, scoped.Allows("print_money"))
+	assert.False(t, (&AccessClaims{UserID: 1, ClientID: "client"}).Allows("send_funds"), "empty scope grants nothing")
+}
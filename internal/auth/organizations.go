@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"fmt"
+	"gbs/internal/models"
+	"gbs/internal/repository"
+)
+
+// OrganizationRoles are the roles members can hold. Viewers read balances
+// and history, spenders also transfer within their daily limits and admins
+// transfer without limits and manage members.
+var OrganizationRoles = []string{"viewer", "spender", "admin"}
+
+// OrganizationSpenderRoles are the roles that can transfer from the
+// organization balance.
+var OrganizationSpenderRoles = []string{"spender", "admin"}
+
+func validOrganizationRole(role string) bool {
+	for _, r := range OrganizationRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateOrganization opens an organization account with the initiator as
+// its first admin. Organization names share the namespace of usernames.
+var CreateOrganization = func(initiatorID int, name string) (int, error) {
+	if !validateUsername(name) {
+		return 0, fmt.Errorf("invalid organization name")
+	}
+	return repository.CreateOrganization(initiatorID, name)
+}
+
+// SetOrganizationMember adds a member or changes their role.
+var SetOrganizationMember = func(initiatorID, organizationID, userID int, role string) error {
+	if !validOrganizationRole(role) {
+		return fmt.Errorf("role must be viewer, spender or admin")
+	}
+	return repository.SetOrganizationMember(initiatorID, organizationID, userID, role)
+}
+
+// SetOrganizationSpendLimit sets how much a spender can transfer from the
+// organization in a currency within a day. A limit of 0 blocks the currency.
+var SetOrganizationSpendLimit = func(initiatorID int, req models.SpendLimitRequest) error {
+	if req.Currency == "" {
+		return fmt.Errorf("currency is required")
+	}
+	if req.DailyLimit < 0 {
+		return fmt.Errorf("daily limit can not be negative")
+	}
+	return repository.SetOrganizationSpendLimit(initiatorID, req)
+}
+
+// ActsForOrganization reports whether userID holds one of roles in the
+// organization account accountID.
+var ActsForOrganization = func(accountID, userID int, roles ...string) bool {
+	role, err := repository.GetOrganizationRole(accountID, userID)
+	if err != nil || role == "" {
+		return false
+	}
+	if len(roles) == 0 {
+		return true
+	}
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"testing"
+
+	"gbs/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetOrganizationMemberRejectsUnknownRole(t *testing.T) {
+	for _, role := range []string{"", "owner", "Admin"} {
+		assert.EqualError(t, SetOrganizationMember(1, 6, 7, role), "role must be viewer, spender or admin", role)
+	}
+}
+
+func TestSetOrganizationSpendLimitValidation(t *testing.T) {
+	req := models.SpendLimitRequest{OrganizationID: 6, UserID: 7, DailyLimit: 100}
+	assert.EqualError(t, SetOrganizationSpendLimit(1, req), "currency is required")
+
+	req.Currency = "USD"
+	req.DailyLimit = -1
+	assert.EqualError(t, SetOrganizationSpendLimit(1, req), "daily limit can not be negative")
+}
@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"fmt"
+	"gbs/internal/repository"
+	"time"
+	"unicode/utf8"
+)
+
+// maxMemoLength bounds memos of payment requests and escrow contracts.
+const maxMemoLength = 256
+
+// PaymentRequestStatuses are the statuses a payment request can be listed by.
+// Only pending requests can change, into any of the others.
+var PaymentRequestStatuses = []string{"pending", "paid", "declined", "cancelled", "expired"}
+
+// CreatePaymentRequest asks payerID to pay the initiator, until expiresAt if
+// it isn't nil.
+var CreatePaymentRequest = func(initiatorID, payerID int, currency string, amount int, memo string, expiresAt *time.Time) (int, error) {
+	if utf8.RuneCountInString(memo) > maxMemoLength {
+		return 0, fmt.Errorf("memo is too long")
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return 0, fmt.Errorf("expiry must be in the future")
+	}
+	return repository.CreatePaymentRequest(initiatorID, payerID, currency, amount, memo, expiresAt)
+}
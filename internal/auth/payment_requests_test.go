@@ -0,0 +1,18 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreatePaymentRequestValidation(t *testing.T) {
+	_, err := CreatePaymentRequest(1, 2, "USD", 100, strings.Repeat("ä", maxMemoLength+1), nil)
+	assert.EqualError(t, err, "memo is too long")
+
+	expiresAt := time.Now().Add(-time.Minute)
+	_, err = CreatePaymentRequest(1, 2, "USD", 100, "dinner", &expiresAt)
+	assert.EqualError(t, err, "expiry must be in the future")
+}
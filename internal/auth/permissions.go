@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"fmt"
+	"gbs/internal/repository"
+	"gbs/pkg/logger"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	maxPermissionName        = 32
+	maxPermissionDescription = 256
+	permissionExpiryInterval = time.Minute
+)
+
+// Custom permissions live in a plugin's namespace, e.g. shop.manage_orders,
+// so they can't collide with the built-in ones or with each other.
+var customPermissionPattern = regexp.MustCompile(`^[a-z][a-z0-9_]*\.[a-z][a-z0-9_]*$`)
+
+// RegisterPermission adds a custom permission that can be granted and checked
+// like the built-in ones. adminOnly permissions can only be granted by
+// administrators.
+var RegisterPermission = func(initiatorID int, name, description string, adminOnly bool) (int, error) {
+	if !validCustomPermission(name) {
+		return 0, fmt.Errorf("invalid permission name, expected namespace.name")
+	}
+	description = strings.TrimSpace(description)
+	if len(description) > maxPermissionDescription {
+		return 0, fmt.Errorf("permission description is too long")
+	}
+	return repository.CreatePermission(initiatorID, name, description, adminOnly)
+}
+
+var DeletePermission = func(initiatorID, permissionID int) error {
+	userIDs, err := repository.DeletePermission(initiatorID, permissionID)
+	if err != nil {
+		return err
+	}
+	for _, userID := range userIDs {
+		InvalidateTokenState(userID)
+	}
+	return nil
+}
+
+// ModifyPermission grants a permission directly, until expiresAt if it isn't
+// nil, or revokes it.
+var ModifyPermission = func(initiatorID, userID, permissionID int, enabled bool, expiresAt *time.Time) error {
+	if enabled {
+		if expiresAt != nil && !expiresAt.After(time.Now()) {
+			return fmt.Errorf("expiry must be in the future")
+		}
+		return repository.SetPermission(initiatorID, userID, permissionID, expiresAt)
+	}
+	if err := repository.UnsetPermission(initiatorID, userID, permissionID); err != nil {
+		return err
+	}
+	InvalidateTokenState(userID)
+	return nil
+}
+
+// StartPermissionExpiry removes expired grants in the background. SQL checks
+// already ignore them, this keeps the table clean, records the expiry in the
+// grant history and drops cached tokens of the users who lost a permission.
+var StartPermissionExpiry = func() {
+	go func() {
+		ticker := time.NewTicker(permissionExpiryInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			expirePermissions()
+		}
+	}()
+}
+
+func expirePermissions() {
+	userIDs, err := repository.DeleteExpiredPermissions()
+	if err != nil {
+		logger.Error("Couldn't delete expired permissions: " + err.Error())
+		return
+	}
+	for _, userID := range userIDs {
+		InvalidateTokenState(userID)
+	}
+}
+
+func validCustomPermission(name string) bool {
+	return len(name) <= maxPermissionName && customPermissionPattern.MatchString(name)
+}
@@ -0,0 +1,25 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidCustomPermission(t *testing.T) {
+	assert.True(t, validCustomPermission("shop.manage_orders"))
+	assert.True(t, validCustomPermission("game2.ban_players"))
+	assert.False(t, validCustomPermission("manage_orders"), "custom permissions must be namespaced")
+	assert.False(t, validCustomPermission("shop.orders.manage"))
+	assert.False(t, validCustomPermission("Shop.manage_orders"))
+	assert.False(t, validCustomPermission(".manage_orders"))
+	assert.False(t, validCustomPermission("shop."+strings.Repeat("a", 32)), "name must fit the permissions column")
+}
+
+func TestModifyPermissionRejectsPastExpiry(t *testing.T) {
+	expiresAt := time.Now().Add(-time.Minute)
+	err := ModifyPermission(1, 2, 3, true, &expiresAt)
+	assert.EqualError(t, err, "expiry must be in the future")
+}
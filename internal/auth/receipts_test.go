@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"gbs/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReceiptSignature(t *testing.T) {
+	key := deriveLedgerKey("secret")
+	created := time.Date(2024, 5, 1, 12, 30, 0, 123456000, time.FixedZone("", 3*3600))
+	receipt := signReceipt(key, models.TransactionReceipt{
+		TransactionID: 42, SenderID: 5, ReceiverID: 6, Currency: "USD", Amount: 1000, Fee: 10, CreatedAt: created,
+	})
+	assert.Equal(t, key.keyID, receipt.KeyID)
+	assert.True(t, verifyReceipt(key, receipt))
+
+	encoded, err := json.Marshal(receipt)
+	assert.NoError(t, err)
+	var decoded models.TransactionReceipt
+	assert.NoError(t, json.Unmarshal(encoded, &decoded))
+	assert.True(t, verifyReceipt(key, decoded), "receipt must survive a JSON round trip")
+
+	tampered := decoded
+	tampered.Amount = 100000
+	assert.False(t, verifyReceipt(key, tampered))
+
+	tampered = decoded
+	tampered.ReceiverID = 7
+	assert.False(t, verifyReceipt(key, tampered))
+
+	assert.False(t, verifyReceipt(deriveLedgerKey("other secret"), decoded))
+
+	tampered = decoded
+	tampered.Signature = "not base64!"
+	assert.False(t, verifyReceipt(key, tampered))
+
+	tampered = decoded
+	tampered.ReceiverWallet = "savings"
+	assert.False(t, verifyReceipt(key, tampered))
+
+	internal := signReceipt(key, models.TransactionReceipt{
+		TransactionID: 43, SenderID: 5, ReceiverID: 5, SenderWallet: "savings", Currency: "USD", Amount: 500, CreatedAt: created,
+	})
+	assert.True(t, verifyReceipt(key, internal))
+	tampered = internal
+	tampered.SenderWallet = "business"
+	assert.False(t, verifyReceipt(key, tampered))
+}
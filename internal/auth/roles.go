@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"fmt"
+	"gbs/internal/repository"
+	"regexp"
+)
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{1,31}$`)
+
+// CreateRole registers a named bundle of permissions. Permissions are passed
+// by name, duplicates are ignored.
+var CreateRole = func(initiatorID int, name string, permissions []string) (int, error) {
+	if !roleNamePattern.MatchString(name) {
+		return 0, fmt.Errorf("invalid role name")
+	}
+	return repository.CreateRole(initiatorID, name, uniquePermissions(permissions))
+}
+
+// UpdateRole replaces the permissions of a role. Holders of the role have
+// their tokens invalidated, as they may have just lost a permission.
+var UpdateRole = func(initiatorID, roleID int, permissions []string) error {
+	userIDs, err := repository.UpdateRolePermissions(initiatorID, roleID, uniquePermissions(permissions))
+	if err != nil {
+		return err
+	}
+	for _, userID := range userIDs {
+		InvalidateTokenState(userID)
+	}
+	return nil
+}
+
+var DeleteRole = func(initiatorID, roleID int) error {
+	userIDs, err := repository.DeleteRole(initiatorID, roleID)
+	if err != nil {
+		return err
+	}
+	for _, userID := range userIDs {
+		InvalidateTokenState(userID)
+	}
+	return nil
+}
+
+// ModifyRole assigns a role to a user or takes it away.
+var ModifyRole = func(initiatorID, userID, roleID int, enabled bool) error {
+	if enabled {
+		return repository.AssignRole(initiatorID, userID, roleID)
+	}
+	if err := repository.UnassignRole(initiatorID, userID, roleID); err != nil {
+		return err
+	}
+	InvalidateTokenState(userID)
+	return nil
+}
+
+func uniquePermissions(permissions []string) []string {
+	seen := make(map[string]bool, len(permissions))
+	unique := []string{}
+	for _, permission := range permissions {
+		if !seen[permission] {
+			seen[permission] = true
+			unique = append(unique, permission)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,21 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoleNamePattern(t *testing.T) {
+	assert.True(t, roleNamePattern.MatchString("merchant"))
+	assert.True(t, roleNamePattern.MatchString("support_2"))
+	assert.False(t, roleNamePattern.MatchString("m"))
+	assert.False(t, roleNamePattern.MatchString("Merchant"))
+	assert.False(t, roleNamePattern.MatchString("2fa_team"))
+	assert.False(t, roleNamePattern.MatchString("a_role_name_that_is_way_too_long_"))
+}
+
+func TestUniquePermissions(t *testing.T) {
+	assert.Equal(t, []string{"send_funds", "receive_funds"}, uniquePermissions([]string{"send_funds", "receive_funds", "send_funds"}))
+	assert.Equal(t, []string{}, uniquePermissions(nil), "a nil slice must become an empty sql array")
+}
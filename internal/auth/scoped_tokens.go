@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"fmt"
+	"gbs/internal/config"
+	"gbs/internal/models"
+	"gbs/internal/repository"
+	"gbs/pkg/logger"
+	"strings"
+	"time"
+)
+
+// Operations a down-scoped token can be limited to.
+const (
+	OperationReadAccount       = "read_account"
+	OperationReadBalances      = "read_balances"
+	OperationReadHistory       = "read_history"
+	OperationTransfer          = "transfer"
+	OperationPrintMoney        = "print_money"
+	OperationManagePermissions = "manage_permissions"
+	OperationRegisterUsers     = "register_users"
+)
+
+var knownOperations = map[string]struct{}{
+	OperationReadAccount:       {},
+	OperationReadBalances:      {},
+	OperationReadHistory:       {},
+	OperationTransfer:          {},
+	OperationPrintMoney:        {},
+	OperationManagePermissions: {},
+	OperationRegisterUsers:     {},
+}
+
+// CreateScopedToken mints an access token that can only be used for the given
+// operations. Transfers can additionally be capped per currency; a cap is the
+// total the token may transfer over its lifetime, and currencies without a cap
+// are not allowed at all. Scoped tokens come without a refresh token. The
+// permission checks of the database still apply on top of the scope.
+var CreateScopedToken = func(userID int, operations []string, limits []models.TransferLimit, expiresIn string) (string, string, time.Time, error) {
+	operations, err := normalizeOperations(operations)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	if err = validateTransferLimits(operations, limits); err != nil {
+		return "", "", time.Time{}, err
+	}
+	lifespan, err := scopedTokenLifespan(expiresIn)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	claims := &AccessClaims{UserID: userID, Operations: operations, TransferLimits: limits}
+	token, err := signAccessToken(claims, lifespan)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	return token, claims.ID, claims.ExpiresAt.Time, nil
+}
+
+// RevokeScopedToken puts a token the user minted on the denylist. The token
+// itself isn't available, so it stays there for the longest possible lifetime.
+var RevokeScopedToken = func(userID int, tokenID string) error {
+	if tokenID == "" {
+		return fmt.Errorf("invalid token id")
+	}
+	maxLifespan := scopedTokenMaxLifespan()
+	if err := repository.RevokeAccessToken(tokenID, userID, time.Now().Add(maxLifespan)); err != nil {
+		return err
+	}
+	InvalidateTokenState(userID)
+	return nil
+}
+
+// Scoped reports whether the token was minted with CreateScopedToken.
+func (c *AccessClaims) Scoped() bool {
+	return c != nil && len(c.Operations) > 0
+}
+
+// AllowsOperation reports whether the token may be used for operation. Only
+// scoped tokens are limited.
+func (c *AccessClaims) AllowsOperation(operation string) bool {
+	if !c.Scoped() {
+		return true
+	}
+	for _, o := range c.Operations {
+		if o == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// ReserveTransfer counts a transfer towards the limits of a scoped token. The
+// reservation has to be released with ReleaseTransfer if the transfer fails.
+var ReserveTransfer = func(claims *AccessClaims, currency string, amount int) error {
+	if !claims.Scoped() || len(claims.TransferLimits) == 0 {
+		return nil
+	}
+	if amount <= 0 {
+		return fmt.Errorf("invalid amount")
+	}
+	for _, limit := range claims.TransferLimits {
+		if limit.Currency != currency {
+			continue
+		}
+		reserved, err := repository.ReserveTokenSpending(claims.ID, currency, amount, limit.MaxAmount, claims.ExpiresAt.Time)
+		if err != nil {
+			return err
+		}
+		if !reserved {
+			return fmt.Errorf("transfer exceeds the token limit for %s", currency)
+		}
+		return nil
+	}
+	return fmt.Errorf("token does not allow transfers in %s", currency)
+}
+
+var ReleaseTransfer = func(claims *AccessClaims, currency string, amount int) {
+	if !claims.Scoped() || len(claims.TransferLimits) == 0 {
+		return
+	}
+	if err := repository.ReleaseTokenSpending(claims.ID, currency, amount); err != nil {
+		logger.Error("Couldn't release token spending: " + err.Error())
+	}
+}
+
+func normalizeOperations(operations []string) ([]string, error) {
+	normalized := parseScope(strings.Join(operations, " "))
+	if len(normalized) == 0 {
+		return nil, fmt.Errorf("at least one operation is required")
+	}
+	for _, o := range normalized {
+		if _, ok := knownOperations[o]; !ok {
+			return nil, fmt.Errorf("unknown operation %s", o)
+		}
+	}
+	return normalized, nil
+}
+
+func validateTransferLimits(operations []string, limits []models.TransferLimit) error {
+	if len(limits) == 0 {
+		return nil
+	}
+	if !(&AccessClaims{Operations: operations}).AllowsOperation(OperationTransfer) {
+		return fmt.Errorf("transfer limits require the %s operation", OperationTransfer)
+	}
+	seen := make(map[string]struct{}, len(limits))
+	for _, limit := range limits {
+		if limit.Currency == "" || limit.MaxAmount <= 0 {
+			return fmt.Errorf("invalid transfer limit")
+		}
+		if _, ok := seen[limit.Currency]; ok {
+			return fmt.Errorf("duplicate transfer limit for %s", limit.Currency)
+		}
+		seen[limit.Currency] = struct{}{}
+	}
+	return nil
+}
+
+func scopedTokenLifespan(expiresIn string) (time.Duration, error) {
+	if expiresIn == "" {
+		expiresIn = config.GetConfig().Security.TokenExpiry
+	}
+	lifespan, err := time.ParseDuration(expiresIn)
+	if err != nil || lifespan <= 0 {
+		return 0, fmt.Errorf("invalid expires_in")
+	}
+	if lifespan > scopedTokenMaxLifespan() {
+		return 0, fmt.Errorf("expires_in exceeds %s", config.GetConfig().Security.ScopedTokenMaxExpiry)
+	}
+	return lifespan, nil
+}
+
+func scopedTokenMaxLifespan() time.Duration {
+	maxLifespan, err := time.ParseDuration(config.GetConfig().Security.ScopedTokenMaxExpiry)
+	if err != nil {
+		logger.Fatal("Invalid scoped token max expiry " + config.GetConfig().Security.ScopedTokenMaxExpiry)
+	}
+	return maxLifespan
+}
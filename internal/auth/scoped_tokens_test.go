@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"testing"
+
+	"gbs/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeOperations(t *testing.T) {
+	ops, err := normalizeOperations([]string{"read_balances", "transfer", "read_balances"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"read_balances", "transfer"}, ops)
+
+	_, err = normalizeOperations(nil)
+	assert.Error(t, err, "a scoped token needs at least one operation")
+
+	_, err = normalizeOperations([]string{"read_balances", "steal_funds"})
+	assert.Error(t, err)
+}
+
+func TestValidateTransferLimits(t *testing.T) {
+	transfer := []string{OperationTransfer}
+	assert.NoError(t, validateTransferLimits(transfer, nil))
+	assert.NoError(t, validateTransferLimits(transfer, []models.TransferLimit{{Currency: "USD", MaxAmount: 100}}))
+
+	assert.Error(t, validateTransferLimits([]string{OperationReadBalances}, []models.TransferLimit{{Currency: "USD", MaxAmount: 100}}),
+		"limits without the transfer operation make no sense")
+	assert.Error(t, validateTransferLimits(transfer, []models.TransferLimit{{Currency: "USD", MaxAmount: 0}}))
+	assert.Error(t, validateTransferLimits(transfer, []models.TransferLimit{{Currency: "", MaxAmount: 10}}))
+	assert.Error(t, validateTransferLimits(transfer, []models.TransferLimit{
+		{Currency: "USD", MaxAmount: 10},
+		{Currency: "USD", MaxAmount: 20},
+	}))
+}
+
+func TestAllowsOperation(t *testing.T) {
+	var missing *AccessClaims
+	assert.True(t, missing.AllowsOperation(OperationPrintMoney))
+	assert.True(t, (&AccessClaims{UserID: 1}).AllowsOperation(OperationPrintMoney), "regular tokens are not limited")
+
+	scoped := &AccessClaims{UserID: 1, Operations: []string{OperationReadBalances}}
+	assert.True(t, scoped.Scoped())
+	assert.True(t, scoped.AllowsOperation(OperationReadBalances))
+	assert.False(t, scoped.AllowsOperation(OperationTransfer))
+	assert.False(t, scoped.AllowsOperation(""), "routes without an operation are closed to scoped tokens")
+}
+
+func TestReserveTransferWithoutLimits(t *testing.T) {
+	assert.NoError(t, ReserveTransfer(nil, "USD", 100))
+	assert.NoError(t, ReserveTransfer(&AccessClaims{Operations: []string{OperationTransfer}}, "USD", 100))
+
+	limited := &AccessClaims{Operations: []string{OperationTransfer}, TransferLimits: []models.TransferLimit{{Currency: "USD", MaxAmount: 100}}}
+	assert.Error(t, ReserveTransfer(limited, "EUR", 1), "currencies without a limit are not allowed")
+	assert.Error(t, ReserveTransfer(limited, "USD", -5))
+}
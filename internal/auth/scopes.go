@@ -0,0 +1,42 @@
+package auth
+
+import "strings"
+
+// Allows reports whether the token may be used for an operation that needs one
+// of the given permissions. Only tokens issued to OAuth clients are limited.
+func (c *AccessClaims) Allows(permissions ...string) bool {
+	if c == nil || c.ClientID == "" {
+		return true
+	}
+	return scopeAllows(strings.Fields(c.Scope), permissions...)
+}
+
+// scopeAllows reports whether granted covers one of the required permissions.
+// Granting administrator covers everything, like it does in the database.
+func scopeAllows(granted []string, required ...string) bool {
+	for _, g := range granted {
+		if g == "administrator" {
+			return true
+		}
+		for _, r := range required {
+			if g == r {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseScope splits a space separated scope string, dropping duplicates.
+func parseScope(scope string) []string {
+	scopes := []string{}
+	seen := make(map[string]struct{})
+	for _, s := range strings.Fields(scope) {
+		if _, ok := seen[s]; ok {
+			continue
+		}
+		seen[s] = struct{}{}
+		scopes = append(scopes, s)
+	}
+	return scopes
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"gbs/internal/config"
+	"gbs/internal/repository"
+	"gbs/pkg/logger"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+)
+
+const tokenStateCacheSize = 10000
+
+// tokenState is the per-user data needed to decide whether an otherwise valid
+// access token was revoked. It is cached so that authenticated requests don't
+// hit the database; the TTL bounds how long another instance may keep
+// accepting a revoked token.
+type tokenState struct {
+	version int
+	revoked map[string]struct{}
+}
+
+var (
+	tokenStateCache     *expirable.LRU[int, tokenState]
+	tokenStateCacheOnce sync.Once
+)
+
+func getTokenStateCache() *expirable.LRU[int, tokenState] {
+	tokenStateCacheOnce.Do(func() {
+		ttl, err := time.ParseDuration(config.GetConfig().Security.TokenStateCacheTTL)
+		if err != nil {
+			logger.Fatal("Invalid token state cache ttl " + config.GetConfig().Security.TokenStateCacheTTL)
+		}
+		tokenStateCache = expirable.NewLRU[int, tokenState](tokenStateCacheSize, nil, ttl)
+	})
+	return tokenStateCache
+}
+
+var getTokenState = func(userID int) (tokenState, error) {
+	cache := getTokenStateCache()
+	if state, ok := cache.Get(userID); ok {
+		return state, nil
+	}
+	version, revokedIDs, err := repository.GetTokenState(userID)
+	if err != nil {
+		return tokenState{}, err
+	}
+	state := tokenState{version: version, revoked: make(map[string]struct{}, len(revokedIDs))}
+	for _, id := range revokedIDs {
+		state.revoked[id] = struct{}{}
+	}
+	cache.Add(userID, state)
+	return state, nil
+}
+
+// InvalidateTokenState drops the cached token state of a user. It has to be
+// called after anything that bumps the user's token version.
+var InvalidateTokenState = func(userID int) {
+	getTokenStateCache().Remove(userID)
+}
+
+// RevokeAccessToken puts a single access token on the denylist until it expires.
+var RevokeAccessToken = func(claims *AccessClaims) error {
+	expiresAt := time.Now()
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+	if err := repository.RevokeAccessToken(claims.ID, claims.UserID, expiresAt); err != nil {
+		return err
+	}
+	InvalidateTokenState(claims.UserID)
+	return nil
+}
+
+var generateTokenID = func() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		logger.Error("Token ID generation error")
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
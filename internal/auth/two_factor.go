@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"gbs/internal/config"
+	"gbs/internal/models"
+	"gbs/internal/repository"
+	"gbs/pkg/logger"
+	"gbs/pkg/totp"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	totpSkew        = 1
+	backupCodeCount = 10
+	backupCodeSize  = 10
+	backupCharset   = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+// TwoFactorRequiredError is returned by Login instead of tokens when the user
+// has two-factor authentication enabled. The challenge has to be exchanged for
+// tokens with VerifyTwoFactorLogin.
+type TwoFactorRequiredError struct {
+	Challenge string
+	ExpiresAt time.Time
+}
+
+func (e *TwoFactorRequiredError) Error() string {
+	return "two-factor authentication required"
+}
+
+var EnrollTOTP = func(userID int) (string, string, error) {
+	username, err := repository.GetUsername(userID)
+	if err != nil {
+		return "", "", err
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		logger.Error("TOTP secret generation error")
+		return "", "", err
+	}
+	if err = repository.BeginTOTPEnrollment(userID, secret); err != nil {
+		return "", "", err
+	}
+	return secret, totp.URI(config.GetConfig().Security.TOTPIssuer, username, secret), nil
+}
+
+var ConfirmTOTP = func(userID int, code string) ([]string, error) {
+	secret, enabled, err := repository.GetTOTP(userID)
+	if err != nil {
+		return nil, err
+	}
+	if secret == "" || enabled {
+		return nil, fmt.Errorf("two-factor enrollment was not started")
+	}
+	step, ok := totp.Validate(secret, code, time.Now(), totpSkew)
+	if !ok {
+		return nil, fmt.Errorf("invalid two-factor code")
+	}
+	codes, hashes, err := generateBackupCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err = repository.ConfirmTOTP(userID, step, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+var DisableTOTP = func(userID int, code string) error {
+	ok, err := verifySecondFactor(userID, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("invalid two-factor code")
+	}
+	return repository.DisableTOTP(userID)
+}
+
+var VerifyTwoFactorLogin = func(challenge, code string, client models.ClientInfo) (string, string, error) {
+	cfg := config.GetConfig()
+	lockout, err := time.ParseDuration(cfg.Security.LockoutDuration)
+	if err != nil {
+		logger.Fatal("Invalid lockout duration format")
+	}
+	userID, err := repository.GetUserByLoginChallenge(challenge, cfg.Security.MaxLoginAttempts, time.Now().Add(-lockout))
+	if err != nil {
+		return "", "", err
+	}
+	if userID == -1 {
+		return "", "", fmt.Errorf("invalid or expired challenge")
+	}
+	ok, err := verifySecondFactor(userID, code)
+	if err != nil {
+		return "", "", err
+	}
+	if !ok {
+		if err = repository.RegisterLoginChallengeFailure(challenge); err != nil {
+			return "", "", err
+		}
+		return "", "", fmt.Errorf("invalid two-factor code")
+	}
+	consumed, err := repository.ConsumeLoginChallenge(challenge)
+	if err != nil {
+		return "", "", err
+	}
+	if !consumed {
+		return "", "", fmt.Errorf("invalid or expired challenge")
+	}
+	return issueTokens(userID, client)
+}
+
+var createLoginChallenge = func(userID int) error {
+	duration, err := time.ParseDuration(config.GetConfig().Security.TwoFactorChallengeExpiry)
+	if err != nil {
+		logger.Fatal("Invalid two-factor challenge expiry " + config.GetConfig().Security.TwoFactorChallengeExpiry)
+	}
+	expiresAt := time.Now().Add(duration)
+	challenge, err := repository.CreateLoginChallenge(userID, expiresAt)
+	if err != nil {
+		return err
+	}
+	return &TwoFactorRequiredError{Challenge: challenge, ExpiresAt: expiresAt}
+}
+
+// verifySecondFactor accepts either a TOTP code that was not used before or
+// an unused backup code.
+var verifySecondFactor = func(userID int, code string) (bool, error) {
+	secret, enabled, err := repository.GetTOTP(userID)
+	if err != nil {
+		return false, err
+	}
+	if !enabled {
+		return false, fmt.Errorf("two-factor authentication is not enabled")
+	}
+	if step, ok := totp.Validate(secret, code, time.Now(), totpSkew); ok {
+		return repository.UseTOTPStep(userID, step)
+	}
+	return repository.UseTOTPBackupCode(userID, hashBackupCode(code))
+}
+
+var generateBackupCodes = func() ([]string, []string, error) {
+	codes := make([]string, backupCodeCount)
+	hashes := make([]string, backupCodeCount)
+	for i := range codes {
+		b := make([]byte, backupCodeSize)
+		for j := range b {
+			randByte, err := rand.Int(rand.Reader, big.NewInt(int64(len(backupCharset))))
+			if err != nil {
+				logger.Error("Backup code generation error")
+				return nil, nil, err
+			}
+			b[j] = backupCharset[randByte.Int64()]
+		}
+		codes[i] = string(b[:backupCodeSize/2]) + "-" + string(b[backupCodeSize/2:])
+		hashes[i] = hashBackupCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+func hashBackupCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,23 @@
+package auth
+
+import (
+	"fmt"
+	"gbs/internal/repository"
+	"regexp"
+)
+
+var walletNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// ValidWalletName reports whether name can name a wallet: 1 to 32 lowercase
+// letters, digits, underscores or dashes.
+func ValidWalletName(name string) bool {
+	return walletNamePattern.MatchString(name)
+}
+
+// CreateWallet adds a named wallet next to the user's primary one.
+var CreateWallet = func(initiatorID, userID int, name string) error {
+	if !ValidWalletName(name) {
+		return fmt.Errorf("invalid wallet name")
+	}
+	return repository.CreateWallet(initiatorID, userID, name)
+}
@@ -0,0 +1,16 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidWalletName(t *testing.T) {
+	for _, name := range []string{"primary", "savings", "business-2024", "a", "rainy_day"} {
+		assert.True(t, ValidWalletName(name), name)
+	}
+	for _, name := range []string{"", "Savings", "my wallet", "tax.2024", "ünïcode", "abcdefghijklmnopqrstuvwxyz0123456"} {
+		assert.False(t, ValidWalletName(name), name)
+	}
+}
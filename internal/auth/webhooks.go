@@ -0,0 +1,349 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"gbs/internal/config"
+	"gbs/internal/models"
+	"gbs/internal/repository"
+	"gbs/pkg/logger"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	webhookSecretSize   = 32
+	webhookSecretPrefix = "whsec_"
+	maxWebhookURLLength = 2048
+	maxWebhookErrorSize = 512
+)
+
+// WebhookEventTypes are the events written to the outbox, see enqueue_event.
+var WebhookEventTypes = []string{"transfer", "print_money", "permission_change", "registration", "payment_request", "escrow"}
+
+// CreateWebhook subscribes url to the initiator's events of the given types,
+// or to everyone's with allEvents. The returned secret signs every delivery
+// and is only shown once.
+var CreateWebhook = func(initiatorID int, rawURL string, eventTypes []string, allEvents bool) (int, string, error) {
+	if err := validWebhookURL(rawURL, config.GetConfig().Webhooks.AllowHTTP); err != nil {
+		return 0, "", err
+	}
+	eventTypes, err := validWebhookEventTypes(eventTypes)
+	if err != nil {
+		return 0, "", err
+	}
+	secret, err := generateOpaqueToken(webhookSecretSize)
+	if err != nil {
+		return 0, "", err
+	}
+	secret = webhookSecretPrefix + secret
+	sealed, err := sealWebhookSecret(secret, config.GetConfig().Security.JwtSecret)
+	if err != nil {
+		return 0, "", err
+	}
+	subscriptionID, err := repository.CreateWebhookSubscription(initiatorID, rawURL, eventTypes, allEvents, sealed)
+	if err != nil {
+		return 0, "", err
+	}
+	return subscriptionID, secret, nil
+}
+
+// lookupWebhookHost resolves webhook hosts when they are registered.
+var lookupWebhookHost = net.LookupIP
+
+// validWebhookURL accepts https URLs, and http ones with allowHTTP, whose host
+// is public. Hosts that resolve to internal addresses are rejected here, the
+// delivery client checks again at connect time against DNS rebinding.
+func validWebhookURL(rawURL string, allowHTTP bool) error {
+	if len(rawURL) > maxWebhookURLLength {
+		return fmt.Errorf("webhook url is too long")
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Hostname() == "" {
+		return fmt.Errorf("invalid webhook url")
+	}
+	if parsed.Scheme != "https" && (parsed.Scheme != "http" || !allowHTTP) {
+		return fmt.Errorf("webhook url must use https")
+	}
+	host := strings.ToLower(strings.TrimSuffix(parsed.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("webhook url must point to a public host")
+	}
+	addresses := []net.IP{net.ParseIP(host)}
+	if addresses[0] == nil {
+		// Hosts that don't resolve yet are left to the delivery client.
+		addresses, _ = lookupWebhookHost(host)
+	}
+	for _, ip := range addresses {
+		if !publicWebhookAddress(ip) {
+			return fmt.Errorf("webhook url must point to a public host")
+		}
+	}
+	return nil
+}
+
+// publicWebhookAddress reports whether deliveries may connect to ip. Loopback,
+// private, link-local (including cloud metadata), unspecified and multicast
+// addresses are internal.
+func publicWebhookAddress(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
+
+func validWebhookEventTypes(eventTypes []string) ([]string, error) {
+	eventTypes = uniquePermissions(eventTypes)
+	if len(eventTypes) == 0 {
+		return nil, fmt.Errorf("no event types")
+	}
+	for _, eventType := range eventTypes {
+		known := false
+		for _, t := range WebhookEventTypes {
+			known = known || t == eventType
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown event type %s", eventType)
+		}
+	}
+	return eventTypes, nil
+}
+
+// sealWebhookSecret encrypts a webhook secret like signing keys are, it has
+// to be readable again to sign deliveries.
+func sealWebhookSecret(secret, key string) (string, error) {
+	aead, err := newKeyCipher(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(secret), nil)), nil
+}
+
+func openWebhookSecret(sealed, key string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", fmt.Errorf("invalid secret encoding")
+	}
+	aead, err := newKeyCipher(key)
+	if err != nil {
+		return "", err
+	}
+	if len(raw) < aead.NonceSize() {
+		return "", fmt.Errorf("invalid secret encoding")
+	}
+	secret, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("couldn't decrypt secret")
+	}
+	return string(secret), nil
+}
+
+// webhookSignature is sent as X-GBS-Signature. Receivers recompute it over the
+// X-GBS-Timestamp header and the raw body, and should reject old timestamps.
+func webhookSignature(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookRetryDelay backs off exponentially from base after the given number
+// of failed attempts, capped at max.
+func webhookRetryDelay(failures int, base, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < failures && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		return max
+	}
+	return delay
+}
+
+type webhookDispatcher struct {
+	client      *http.Client
+	secretKey   string
+	retryBase   time.Duration
+	retryMax    time.Duration
+	maxAttempts int
+	batchSize   int
+}
+
+// newWebhookClient returns the delivery client. It only connects to addresses
+// allowed reports true for, checked after DNS resolution, and never uses a
+// proxy that could connect elsewhere on its behalf.
+func newWebhookClient(timeout time.Duration, allowed func(net.IP) bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !allowed(ip) {
+				return fmt.Errorf("webhook address %s is not allowed", host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		// A redirect could point a signed delivery anywhere, receivers have to
+		// answer directly.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// StartWebhookDelivery polls the outbox for due deliveries and posts them.
+var StartWebhookDelivery = func() {
+	cfg := config.GetConfig().Webhooks
+	durations := make([]time.Duration, 4)
+	for i, value := range []string{cfg.PollInterval, cfg.Timeout, cfg.RetryBase, cfg.RetryMax} {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			logger.Warn("Webhook delivery is disabled, invalid webhooks config value " + value)
+			return
+		}
+		durations[i] = d
+	}
+	if cfg.MaxAttempts <= 0 || cfg.BatchSize <= 0 {
+		logger.Warn("Webhook delivery is disabled, max_attempts and batch_size must be positive")
+		return
+	}
+	pollInterval, timeout := durations[0], durations[1]
+	dispatcher := &webhookDispatcher{
+		client:      newWebhookClient(timeout, publicWebhookAddress),
+		secretKey:   config.GetConfig().Security.JwtSecret,
+		retryBase:   durations[2],
+		retryMax:    durations[3],
+		maxAttempts: cfg.MaxAttempts,
+		batchSize:   cfg.BatchSize,
+	}
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			for {
+				claimed, err := dispatcher.deliverBatch(2 * timeout)
+				if err != nil {
+					logger.Error("Couldn't deliver webhooks: " + err.Error())
+				}
+				if err != nil || claimed < dispatcher.batchSize {
+					break
+				}
+			}
+		}
+	}()
+}
+
+// deliverBatch claims a batch of due deliveries and posts them concurrently.
+func (d *webhookDispatcher) deliverBatch(lease time.Duration) (int, error) {
+	deliveries, err := repository.ClaimWebhookDeliveries(d.batchSize, lease)
+	if err != nil {
+		return 0, err
+	}
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		go func(delivery models.PendingWebhookDelivery) {
+			defer wg.Done()
+			d.finish(delivery, d.deliver(delivery))
+		}(delivery)
+	}
+	wg.Wait()
+	return len(deliveries), nil
+}
+
+type webhookResult struct {
+	statusCode *int
+	err        error
+}
+
+func (d *webhookDispatcher) deliver(delivery models.PendingWebhookDelivery) webhookResult {
+	secret, err := openWebhookSecret(delivery.SealedSecret, d.secretKey)
+	if err != nil {
+		return webhookResult{err: err}
+	}
+	return postWebhook(d.client, delivery, secret, time.Now())
+}
+
+func postWebhook(client *http.Client, delivery models.PendingWebhookDelivery, secret string, now time.Time) webhookResult {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return webhookResult{err: err}
+	}
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return webhookResult{err: err}
+	}
+	timestamp := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "GBS-Webhooks/1")
+	req.Header.Set("X-GBS-Event", delivery.Event.Type)
+	req.Header.Set("X-GBS-Delivery", strconv.FormatInt(delivery.DeliveryID, 10))
+	req.Header.Set("X-GBS-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-GBS-Signature", webhookSignature(secret, timestamp, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return webhookResult{err: err}
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	statusCode := resp.StatusCode
+	if statusCode < 200 || statusCode > 299 {
+		return webhookResult{statusCode: &statusCode, err: fmt.Errorf("receiver answered %d", statusCode)}
+	}
+	return webhookResult{statusCode: &statusCode}
+}
+
+func (d *webhookDispatcher) finish(delivery models.PendingWebhookDelivery, result webhookResult) {
+	if result.err == nil {
+		if err := repository.CompleteWebhookDelivery(delivery.DeliveryID, *result.statusCode); err != nil {
+			logger.Error(fmt.Sprintf("Couldn't complete webhook delivery %d: %s", delivery.DeliveryID, err.Error()))
+		}
+		return
+	}
+	message := result.err.Error()
+	if len(message) > maxWebhookErrorSize {
+		message = message[:maxWebhookErrorSize]
+	}
+	failures := delivery.Attempts + 1
+	dead := failures >= d.maxAttempts
+	var nextAttempt *time.Time
+	if !dead {
+		next := time.Now().Add(webhookRetryDelay(failures, d.retryBase, d.retryMax))
+		nextAttempt = &next
+	} else {
+		logger.Warn(fmt.Sprintf("Webhook delivery %d is dead after %d attempts: %s", delivery.DeliveryID, failures, message))
+	}
+	if err := repository.FailWebhookDelivery(delivery.DeliveryID, result.statusCode, message, dead, nextAttempt); err != nil {
+		logger.Error(fmt.Sprintf("Couldn't record webhook delivery %d failure: %s", delivery.DeliveryID, err.Error()))
+	}
+}
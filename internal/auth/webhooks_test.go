@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"gbs/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookSecretSealing(t *testing.T) {
+	sealed, err := sealWebhookSecret("whsec_abc", "secret")
+	assert.NoError(t, err)
+	assert.NotContains(t, sealed, "whsec_abc")
+
+	secret, err := openWebhookSecret(sealed, "secret")
+	assert.NoError(t, err)
+	assert.Equal(t, "whsec_abc", secret)
+
+	_, err = openWebhookSecret(sealed, "other secret")
+	assert.Error(t, err)
+	_, err = openWebhookSecret("not base64!", "secret")
+	assert.Error(t, err)
+}
+
+func TestPostWebhookSignsDelivery(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	var received models.Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, err := strconv.ParseInt(r.Header.Get("X-GBS-Timestamp"), 10, 64)
+		assert.NoError(t, err)
+		assert.Equal(t, webhookSignature("whsec_abc", timestamp, body), r.Header.Get("X-GBS-Signature"))
+		assert.Equal(t, "transfer", r.Header.Get("X-GBS-Event"))
+		assert.Equal(t, "7", r.Header.Get("X-GBS-Delivery"))
+		assert.NoError(t, json.Unmarshal(body, &received))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	delivery := models.PendingWebhookDelivery{
+		DeliveryID: 7,
+		URL:        server.URL,
+		Event:      models.Event{EventID: 3, Type: "transfer", Data: json.RawMessage(`{"amount":100}`), CreatedAt: now},
+	}
+	result := postWebhook(newWebhookClient(time.Second, anyWebhookAddress), delivery, "whsec_abc", now)
+	assert.NoError(t, result.err)
+	assert.Equal(t, http.StatusNoContent, *result.statusCode)
+	assert.Equal(t, int64(3), received.EventID)
+	assert.JSONEq(t, `{"amount":100}`, string(received.Data))
+
+	assert.NotEqual(t, webhookSignature("whsec_abc", now.Unix(), []byte("{}")), webhookSignature("whsec_abc", now.Unix()+1, []byte("{}")))
+}
+
+func TestPostWebhookFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	client := newWebhookClient(time.Second, anyWebhookAddress)
+	result := postWebhook(client, models.PendingWebhookDelivery{URL: server.URL}, "s", time.Now())
+	assert.Error(t, result.err)
+	assert.Equal(t, http.StatusInternalServerError, *result.statusCode)
+
+	result = postWebhook(client, models.PendingWebhookDelivery{URL: server.URL + "/redirect"}, "s", time.Now())
+	assert.Error(t, result.err, "redirects must not be followed")
+	assert.Equal(t, http.StatusFound, *result.statusCode)
+
+	server.Close()
+	result = postWebhook(client, models.PendingWebhookDelivery{URL: server.URL}, "s", time.Now())
+	assert.Error(t, result.err)
+	assert.Nil(t, result.statusCode)
+}
+
+func TestWebhookRetryDelay(t *testing.T) {
+	base, max := 30*time.Second, 10*time.Minute
+	assert.Equal(t, 30*time.Second, webhookRetryDelay(1, base, max))
+	assert.Equal(t, time.Minute, webhookRetryDelay(2, base, max))
+	assert.Equal(t, 8*time.Minute, webhookRetryDelay(5, base, max))
+	assert.Equal(t, max, webhookRetryDelay(6, base, max))
+	assert.Equal(t, max, webhookRetryDelay(100, base, max))
+}
+
+func anyWebhookAddress(net.IP) bool {
+	return true
+}
+
+func TestWebhookClientRefusesInternalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("delivery reached a loopback address")
+	}))
+	defer server.Close()
+
+	result := postWebhook(newWebhookClient(time.Second, publicWebhookAddress), models.PendingWebhookDelivery{URL: server.URL}, "s", time.Now())
+	assert.Error(t, result.err)
+	assert.Nil(t, result.statusCode)
+}
+
+func TestValidateWebhook(t *testing.T) {
+	lookup := lookupWebhookHost
+	defer func() { lookupWebhookHost = lookup }()
+	lookupWebhookHost = func(host string) ([]net.IP, error) {
+		switch host {
+		case "example.com":
+			return []net.IP{net.ParseIP("93.184.216.34")}, nil
+		case "rebind.example.com":
+			return []net.IP{net.ParseIP("93.184.216.34"), net.ParseIP("10.0.0.5")}, nil
+		}
+		return nil, fmt.Errorf("no such host")
+	}
+
+	assert.NoError(t, validWebhookURL("https://example.com/hook", false))
+	assert.NoError(t, validWebhookURL("https://unresolved.example.com/hook", false))
+	assert.Error(t, validWebhookURL("http://example.com/hook", false))
+	assert.NoError(t, validWebhookURL("http://example.com/hook", true))
+	assert.Error(t, validWebhookURL("ftp://example.com/hook", true))
+	assert.Error(t, validWebhookURL("https:///hook", false))
+	assert.Error(t, validWebhookURL("not a url", false))
+
+	for _, rawURL := range []string{
+		"https://127.0.0.1/hook",
+		"https://localhost:8080/hook",
+		"https://api.localhost/hook",
+		"https://10.1.2.3/hook",
+		"https://172.16.0.1/hook",
+		"https://192.168.1.10/hook",
+		"https://169.254.169.254/latest/meta-data",
+		"https://0.0.0.0/hook",
+		"https://[::1]/hook",
+		"https://[fd00::1]/hook",
+		"https://[::ffff:127.0.0.1]/hook",
+		"https://rebind.example.com/hook",
+	} {
+		assert.Error(t, validWebhookURL(rawURL, true), rawURL)
+	}
+
+	types, err := validWebhookEventTypes([]string{"transfer", "transfer", "registration"})
+	assert.NoError(t, err)
+	assert.Len(t, types, 2)
+	_, err = validWebhookEventTypes(nil)
+	assert.Error(t, err)
+	_, err = validWebhookEventTypes([]string{"withdrawal"})
+	assert.Error(t, err)
+}
@@ -19,25 +19,51 @@ type Config struct {
 	Logging  LoggingConfig  `json:"logging"`
 	Security SecurityConfig `json:"security"`
 	Core     CoreConfig     `json:"core"`
+	Webhooks WebhookConfig  `json:"webhooks"`
 }
 
 type ServerConfig struct {
-	Host string `json:"host"`
-	Port string `json:"port"`
+	Host           string   `json:"host"`
+	Port           string   `json:"port"`
+	TrustedProxies []string `json:"trusted_proxies"`
 }
 
 type SecurityConfig struct {
-	TokenExpiry             string `json:"token_expiry"`
-	RefreshTokenExpiry      string `json:"refresh_token_expiry"`
-	LockoutDuration         string `json:"lockout_duration"`
-	JwtSecret               string
-	LoginMinLength          int  `json:"login_min_length"`
-	LoginMaxLength          int  `json:"login_max_length"`
-	PasswordMinLength       int  `json:"password_min_length"`
-	PasswordMaxLength       int  `json:"password_max_length"`
-	MaxLoginAttempts        int  `json:"max_login_attempts"`
-	AllowDirectRegistration bool `json:"allow_direct_registration"`
-	RPMForIP                int  `json:"rpm_for_ip"`
+	TokenExpiry              string `json:"token_expiry"`
+	RefreshTokenExpiry       string `json:"refresh_token_expiry"`
+	LockoutDuration          string `json:"lockout_duration"`
+	TwoFactorChallengeExpiry string `json:"two_factor_challenge_expiry"`
+	TOTPIssuer               string `json:"totp_issuer"`
+	JwtAlgorithm             string `json:"jwt_algorithm"`
+	JwtKeyRotationInterval   string `json:"jwt_key_rotation_interval"`
+	JwtIssuer                string `json:"jwt_issuer"`
+	JwtAudience              string `json:"jwt_audience"`
+	TokenStateCacheTTL       string `json:"token_state_cache_ttl"`
+	OAuthCodeExpiry          string `json:"oauth_code_expiry"`
+	ScopedTokenMaxExpiry     string `json:"scoped_token_max_expiry"`
+	LoginAttemptStore        string `json:"login_attempt_store"`
+	LedgerCheckpointInterval string `json:"ledger_checkpoint_interval"`
+	JwtSecret                string
+	LoginMinLength           int             `json:"login_min_length"`
+	LoginMaxLength           int             `json:"login_max_length"`
+	PasswordMinLength        int             `json:"password_min_length"`
+	PasswordMaxLength        int             `json:"password_max_length"`
+	MaxLoginAttempts         int             `json:"max_login_attempts"`
+	MaxLoginAttemptsPerIP    int             `json:"max_login_attempts_per_ip"`
+	AllowDirectRegistration  bool            `json:"allow_direct_registration"`
+	RPMForIP                 int             `json:"rpm_for_ip"`
+	RateLimits               RateLimitConfig `json:"rate_limits"`
+}
+
+type RateLimitConfig struct {
+	Store  string                           `json:"store"`
+	Routes map[string]RateLimitPolicyConfig `json:"routes"`
+}
+
+type RateLimitPolicyConfig struct {
+	Key               string `json:"key"`
+	RequestsPerMinute int    `json:"requests_per_minute"`
+	Burst             int    `json:"burst"`
 }
 
 type LoggingConfig struct {
@@ -57,6 +83,16 @@ type CoreConfig struct {
 	CoreFee int `json:"fee"`
 }
 
+type WebhookConfig struct {
+	PollInterval string `json:"poll_interval"`
+	Timeout      string `json:"timeout"`
+	RetryBase    string `json:"retry_base"`
+	RetryMax     string `json:"retry_max"`
+	MaxAttempts  int    `json:"max_attempts"`
+	BatchSize    int    `json:"batch_size"`
+	AllowHTTP    bool   `json:"allow_http"`
+}
+
 var dotEnvLocation = "configs/.env"
 var fileOpenFunc = os.Open
 
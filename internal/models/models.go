@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type ErrorResponse struct {
 	Message string `json:"message"`
@@ -18,12 +21,71 @@ type AuthResponse struct {
 	RefreshTokenExpiry string `json:"refresh_token_expiry"`
 }
 
+type TwoFactorChallengeResponse struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	Challenge         string `json:"challenge"`
+	ChallengeExpiry   string `json:"challenge_expiry"`
+}
+
+type VerifyTwoFactorRequest struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type TOTPEnrollmentResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type BackupCodesResponse struct {
+	BackupCodes []string `json:"backup_codes"`
+}
+
 type RefreshRequest struct {
 	RefreshToken string `json:"refresh_token"`
 }
 
 type RefreshResponse struct {
-	Token string `json:"token"`
+	Token              string `json:"token"`
+	TokenExpiry        string `json:"token_expiry"`
+	RefreshToken       string `json:"refresh_token"`
+	RefreshTokenExpiry string `json:"refresh_token_expiry"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type ClientInfo struct {
+	UserAgent string
+	IP        string
+}
+
+type Session struct {
+	SessionID  string    `json:"session_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	ClientID   string    `json:"client_id"`
+}
+
+type SessionsResponse struct {
+	Sessions []Session `json:"sessions"`
+}
+
+type RevokeSessionRequest struct {
+	UserID    int    `json:"user_id"`
+	SessionID string `json:"session_id"`
+}
+
+type RevokeUserSessionsRequest struct {
+	UserID int `json:"user_id"`
 }
 
 type BalanceResponse struct {
@@ -31,15 +93,37 @@ type BalanceResponse struct {
 }
 
 type Balance struct {
-	Currency string `json:"currency"`
-	Amount   string `json:"amount"`
+	Currency    string `json:"currency"`
+	Amount      string `json:"amount"`
+	CreditLimit string `json:"credit_limit,omitempty"`
+	Available   string `json:"available,omitempty"`
 }
 
 type TransactionRequest struct {
-	From     int    `json:"from"`
-	To       int    `json:"to"`
-	Currency string `json:"currency"`
-	Amount   int    `json:"amount"`
+	From       int    `json:"from"`
+	To         int    `json:"to"`
+	Currency   string `json:"currency"`
+	Amount     int    `json:"amount"`
+	FromWallet string `json:"from_wallet,omitempty"`
+	ToWallet   string `json:"to_wallet,omitempty"`
+}
+
+type TransactionReceipt struct {
+	TransactionID  int       `json:"transaction_id"`
+	SenderID       int       `json:"sender_id"`
+	ReceiverID     int       `json:"receiver_id"`
+	SenderWallet   string    `json:"sender_wallet,omitempty"`
+	ReceiverWallet string    `json:"receiver_wallet,omitempty"`
+	Currency       string    `json:"currency"`
+	Amount         int64     `json:"amount"`
+	Fee            int64     `json:"fee"`
+	CreatedAt      time.Time `json:"created_at"`
+	KeyID          string    `json:"key_id"`
+	Signature      string    `json:"signature"`
+}
+
+type VerifyReceiptResponse struct {
+	Valid bool `json:"valid"`
 }
 
 type IDResponse struct {
@@ -47,7 +131,8 @@ type IDResponse struct {
 }
 
 type UserPermissionsResponse struct {
-	Permissions []int `json:"permissions"`
+	Permissions []int    `json:"permissions"`
+	Names       []string `json:"names"`
 }
 
 type TransactionAmountResponse struct {
@@ -55,13 +140,15 @@ type TransactionAmountResponse struct {
 }
 
 type Transaction struct {
-	SenderID   int       `json:"sender_id"`
-	ReceiverID int       `json:"receiver_id"`
-	Initiator  int       `json:"initiator"`
-	Currency   string    `json:"currency"`
-	Amount     int       `json:"amount"`
-	Fee        int       `json:"fee"`
-	CreatedAt  time.Time `json:"created_at"`
+	SenderID       int       `json:"sender_id"`
+	ReceiverID     int       `json:"receiver_id"`
+	Initiator      int       `json:"initiator"`
+	Currency       string    `json:"currency"`
+	Amount         int       `json:"amount"`
+	Fee            int       `json:"fee"`
+	CreatedAt      time.Time `json:"created_at"`
+	SenderWallet   *string   `json:"sender_wallet"`
+	ReceiverWallet string    `json:"receiver_wallet"`
 }
 
 type TransactionResponse struct {
@@ -75,9 +162,47 @@ type PrintMoneyRequest struct {
 }
 
 type ModifyPermissionRequest struct {
-	PermissionID int  `json:"permission_id"`
-	UserID       int  `json:"user_id"`
-	Enabled      bool `json:"enabled"`
+	PermissionID int        `json:"permission_id"`
+	Permission   string     `json:"permission"`
+	UserID       int        `json:"user_id"`
+	Enabled      bool       `json:"enabled"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+}
+
+type Permission struct {
+	PermissionID int    `json:"permission_id"`
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	AdminOnly    bool   `json:"admin_only"`
+	Custom       bool   `json:"custom"`
+}
+
+type PermissionsResponse struct {
+	Permissions []Permission `json:"permissions"`
+}
+
+type PermissionHolder struct {
+	UserID    int        `json:"user_id"`
+	Username  string     `json:"username"`
+	Role      *string    `json:"role"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type PermissionHoldersResponse struct {
+	Holders []PermissionHolder `json:"holders"`
+}
+
+type PermissionChange struct {
+	PermissionID   int        `json:"permission_id"`
+	PermissionName string     `json:"permission_name"`
+	Action         string     `json:"action"`
+	InitiatorID    *int       `json:"initiator_id"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+type PermissionHistoryResponse struct {
+	Changes []PermissionChange `json:"changes"`
 }
 
 type ChangePasswordRequest struct {
@@ -90,11 +215,517 @@ type UsernameResponse struct {
 }
 
 type LoginAttempt struct {
-	Count        int
-	BlockedUntil time.Time
+	Count         int
+	WindowStarted time.Time
+	BlockedUntil  time.Time
+}
+
+type LoginLockout struct {
+	Key          string    `json:"key"`
+	Failures     int       `json:"failures"`
+	BlockedUntil time.Time `json:"blocked_until"`
+}
+
+type LoginLockoutsResponse struct {
+	Lockouts []LoginLockout `json:"lockouts"`
+}
+
+type ClearLoginLockoutRequest struct {
+	Key string `json:"key"`
+}
+
+type TokenBucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+type CreateAPIKeyRequest struct {
+	Name        string     `json:"name"`
+	Permissions []string   `json:"permissions"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+type CreateAPIKeyResponse struct {
+	KeyID int    `json:"key_id"`
+	Key   string `json:"key"`
+}
+
+type APIKey struct {
+	KeyID       int        `json:"key_id"`
+	Name        string     `json:"name"`
+	Prefix      string     `json:"prefix"`
+	Restricted  bool       `json:"restricted"`
+	Permissions []string   `json:"permissions"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+}
+
+type APIKeysResponse struct {
+	Keys []APIKey `json:"api_keys"`
+}
+
+type RevokeAPIKeyRequest struct {
+	UserID int `json:"user_id"`
+	KeyID  int `json:"key_id"`
+}
+
+type APIKeyIdentity struct {
+	KeyID       int
+	UserID      int
+	Restricted  bool
+	Permissions []string
+}
+
+type CreateOAuthClientRequest struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes"`
+	Confidential bool     `json:"confidential"`
+}
+
+type CreateOAuthClientResponse struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+type OAuthClient struct {
+	ClientID     string    `json:"client_id"`
+	OwnerID      int       `json:"owner_id"`
+	Name         string    `json:"name"`
+	Confidential bool      `json:"confidential"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type OAuthClientsResponse struct {
+	Clients []OAuthClient `json:"clients"`
+}
+
+type RevokeOAuthClientRequest struct {
+	UserID   int    `json:"user_id"`
+	ClientID string `json:"client_id"`
+}
+
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope"`
+}
+
+type OAuthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+type TransferLimit struct {
+	Currency  string `json:"currency"`
+	MaxAmount int    `json:"max_amount"`
+}
+
+type CreateScopedTokenRequest struct {
+	Operations     []string        `json:"operations"`
+	TransferLimits []TransferLimit `json:"transfer_limits"`
+	ExpiresIn      string          `json:"expires_in"`
+}
+
+type ScopedTokenResponse struct {
+	Token     string    `json:"token"`
+	TokenID   string    `json:"token_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type RevokeScopedTokenRequest struct {
+	TokenID string `json:"token_id"`
+}
+
+type StoredSigningKey struct {
+	KeyID      string
+	Algorithm  string
+	PrivateKey string
+	CreatedAt  time.Time
+}
+
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+type JWKSResponse struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+type Role struct {
+	RoleID      int      `json:"role_id"`
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+type RolesResponse struct {
+	Roles []Role `json:"roles"`
+}
+
+type CreateRoleRequest struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+type CreateRoleResponse struct {
+	RoleID int `json:"role_id"`
+}
+
+type UpdateRoleRequest struct {
+	RoleID      int      `json:"role_id"`
+	Permissions []string `json:"permissions"`
+}
+
+type DeleteRoleRequest struct {
+	RoleID int `json:"role_id"`
+}
+
+type RoleAssignmentRequest struct {
+	UserID  int  `json:"user_id"`
+	RoleID  int  `json:"role_id"`
+	Enabled bool `json:"enabled"`
+}
+
+type CreatePermissionRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	AdminOnly   bool   `json:"admin_only"`
+}
+
+type CreatePermissionResponse struct {
+	PermissionID int `json:"permission_id"`
+}
+
+type DeletePermissionRequest struct {
+	PermissionID int `json:"permission_id"`
+}
+
+type HasPermissionResponse struct {
+	HasPermission bool `json:"has_permission"`
+}
+
+type AuditEvent struct {
+	EventID    int64           `json:"event_id"`
+	ActorID    *int            `json:"actor_id"`
+	TargetID   *int            `json:"target_id"`
+	Action     string          `json:"action"`
+	Parameters json.RawMessage `json:"parameters"`
+	Succeeded  bool            `json:"succeeded"`
+	Error      *string         `json:"error"`
+	IP         *string         `json:"ip"`
+	RequestID  *string         `json:"request_id"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+type AuditEventFilter struct {
+	ActorID  *int
+	TargetID *int
+	Action   *string
+	From     *time.Time
+	To       *time.Time
+}
+
+type AuditEventsResponse struct {
+	Events []AuditEvent `json:"events"`
+}
+
+type LedgerHead struct {
+	Chain  string
+	LastID int
+	Hash   []byte
+}
+
+type LedgerCheckpoint struct {
+	CheckpointID int
+	Chain        string
+	LastID       int
+	Hash         []byte
+	KeyID        string
+	Signature    []byte
+	CreatedAt    time.Time
+}
+
+type LedgerChainReport struct {
+	Chain              string  `json:"chain"`
+	CheckedRows        int64   `json:"checked_rows"`
+	BrokenID           *int    `json:"broken_id"`
+	Problem            *string `json:"problem"`
+	Checkpoints        int     `json:"checkpoints"`
+	SkippedCheckpoints int     `json:"skipped_checkpoints"`
+	BrokenCheckpointID *int    `json:"broken_checkpoint_id"`
+	CheckpointProblem  *string `json:"checkpoint_problem"`
+}
+
+type LedgerVerificationResponse struct {
+	Valid  bool                `json:"valid"`
+	Chains []LedgerChainReport `json:"chains"`
+}
+
+type LedgerKeyResponse struct {
+	KeyID     string `json:"key_id"`
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"public_key"`
+}
+
+type CreateWebhookRequest struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	AllEvents  bool     `json:"all_events"`
+}
+
+type CreateWebhookResponse struct {
+	SubscriptionID int    `json:"subscription_id"`
+	Secret         string `json:"secret"`
+}
+
+type WebhookSubscription struct {
+	SubscriptionID int       `json:"subscription_id"`
+	URL            string    `json:"url"`
+	EventTypes     []string  `json:"event_types"`
+	AllEvents      bool      `json:"all_events"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type WebhookSubscriptionsResponse struct {
+	Subscriptions []WebhookSubscription `json:"subscriptions"`
+}
+
+type DeleteWebhookRequest struct {
+	SubscriptionID int `json:"subscription_id"`
+}
+
+type WebhookDelivery struct {
+	DeliveryID     int64     `json:"delivery_id"`
+	SubscriptionID int       `json:"subscription_id"`
+	EventID        int64     `json:"event_id"`
+	EventType      string    `json:"event_type"`
+	Status         string    `json:"status"`
+	Attempts       int       `json:"attempts"`
+	LastStatusCode *int      `json:"last_status_code"`
+	LastError      *string   `json:"last_error"`
+	NextAttemptAt  time.Time `json:"next_attempt_at"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type WebhookDeliveriesResponse struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+type RedeliverWebhookRequest struct {
+	DeliveryID int64 `json:"delivery_id"`
+}
+
+type PendingWebhookDelivery struct {
+	DeliveryID   int64
+	Attempts     int
+	URL          string
+	SealedSecret string
+	Event        Event
+}
+
+type Event struct {
+	EventID   int64           `json:"id"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+type EventNotification struct {
+	Event
+	UserIDs []int `json:"user_ids"`
+}
+
+type CreatePaymentRequestRequest struct {
+	PayerID   int        `json:"payer_id"`
+	Currency  string     `json:"currency"`
+	Amount    int        `json:"amount"`
+	Memo      string     `json:"memo"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type CreatePaymentRequestResponse struct {
+	RequestID int `json:"request_id"`
+}
+
+type PaymentRequest struct {
+	RequestID     int        `json:"request_id"`
+	RequesterID   int        `json:"requester_id"`
+	PayerID       int        `json:"payer_id"`
+	Currency      string     `json:"currency"`
+	Amount        int64      `json:"amount"`
+	Memo          string     `json:"memo"`
+	Status        string     `json:"status"`
+	ExpiresAt     *time.Time `json:"expires_at"`
+	TransactionID *int       `json:"transaction_id"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ResolvedAt    *time.Time `json:"resolved_at"`
+}
+
+type PaymentRequestsResponse struct {
+	Requests []PaymentRequest `json:"requests"`
+}
+
+type PaymentRequestActionRequest struct {
+	RequestID int `json:"request_id"`
+}
+
+type CreateEscrowRequest struct {
+	PayeeID        int       `json:"payee_id"`
+	ArbiterID      *int      `json:"arbiter_id"`
+	Currency       string    `json:"currency"`
+	Amount         int       `json:"amount"`
+	Memo           string    `json:"memo"`
+	Deadline       time.Time `json:"deadline"`
+	DeadlineAction string    `json:"deadline_action"`
+}
+
+type CreateEscrowResponse struct {
+	EscrowID int `json:"escrow_id"`
+}
+
+type Escrow struct {
+	EscrowID       int        `json:"escrow_id"`
+	PayerID        int        `json:"payer_id"`
+	PayeeID        int        `json:"payee_id"`
+	ArbiterID      *int       `json:"arbiter_id"`
+	Currency       string     `json:"currency"`
+	Amount         int64      `json:"amount"`
+	Memo           string     `json:"memo"`
+	Status         string     `json:"status"`
+	Deadline       time.Time  `json:"deadline"`
+	DeadlineAction string     `json:"deadline_action"`
+	PayerVote      *string    `json:"payer_vote"`
+	PayeeVote      *string    `json:"payee_vote"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ResolvedAt     *time.Time `json:"resolved_at"`
+}
+
+type EscrowsResponse struct {
+	Escrows []Escrow `json:"escrows"`
+}
+
+type ConfirmEscrowRequest struct {
+	EscrowID int    `json:"escrow_id"`
+	Outcome  string `json:"outcome"`
+}
+
+type ConfirmEscrowResponse struct {
+	Status string `json:"status"`
+}
+
+type EscrowLog struct {
+	Action        string    `json:"action"`
+	ActorID       *int      `json:"actor_id"`
+	TransactionID *int      `json:"transaction_id"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type EscrowLogsResponse struct {
+	Logs []EscrowLog `json:"logs"`
+}
+
+type Wallet struct {
+	Name     string    `json:"name"`
+	Balances []Balance `json:"balances"`
+}
+
+type WalletsResponse struct {
+	Wallets []Wallet `json:"wallets"`
+}
+
+type WalletRequest struct {
+	UserID int    `json:"user_id"`
+	Name   string `json:"name"`
+}
+
+type CreateOrganizationRequest struct {
+	Name string `json:"name"`
+}
+
+type CreateOrganizationResponse struct {
+	OrganizationID int `json:"organization_id"`
+}
+
+type Organization struct {
+	OrganizationID int       `json:"organization_id"`
+	Name           string    `json:"name"`
+	Role           string    `json:"role"`
+	MemberSince    time.Time `json:"member_since"`
+}
+
+type OrganizationsResponse struct {
+	Organizations []Organization `json:"organizations"`
+}
+
+type OrganizationMemberRequest struct {
+	OrganizationID int    `json:"organization_id"`
+	UserID         int    `json:"user_id"`
+	Role           string `json:"role,omitempty"`
+}
+
+type SpendLimitRequest struct {
+	OrganizationID int    `json:"organization_id"`
+	UserID         int    `json:"user_id"`
+	Currency       string `json:"currency"`
+	DailyLimit     int    `json:"daily_limit"`
+}
+
+type SpendLimit struct {
+	Currency   string `json:"currency"`
+	DailyLimit int64  `json:"daily_limit"`
+	SpentToday int64  `json:"spent_today"`
+}
+
+type OrganizationMember struct {
+	UserID      int          `json:"user_id"`
+	Username    string       `json:"username"`
+	Role        string       `json:"role"`
+	MemberSince time.Time    `json:"member_since"`
+	SpendLimits []SpendLimit `json:"spend_limits"`
+}
+
+type OrganizationMembersResponse struct {
+	Members []OrganizationMember `json:"members"`
+}
+
+type CreditLimitRequest struct {
+	UserID      int    `json:"user_id"`
+	Currency    string `json:"currency"`
+	CreditLimit int    `json:"credit_limit"`
+}
+
+type CreditLimitChange struct {
+	Currency    string    `json:"currency"`
+	OldLimit    int64     `json:"old_limit"`
+	NewLimit    int64     `json:"new_limit"`
+	InitiatorID *int      `json:"initiator_id"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type CreditLimitHistoryResponse struct {
+	Changes []CreditLimitChange `json:"changes"`
+}
+
+type Overdraft struct {
+	UserID      int    `json:"user_id"`
+	Username    string `json:"username"`
+	Currency    string `json:"currency"`
+	Amount      int64  `json:"amount"`
+	CreditLimit int64  `json:"credit_limit"`
 }
 
-type RateLimitInfo struct {
-	Requests  int
-	ResetTime time.Time
+type OverdraftsResponse struct {
+	Overdrafts []Overdraft `json:"overdrafts"`
 }
@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"gbs/pkg/logger"
+	"github.com/lib/pq"
+	"time"
+)
+
+func GetTokenState(userID int) (int, []string, error) {
+	var version int
+	var revoked []string
+	err := db.QueryRow(`
+		SELECT users.token_version,
+		       COALESCE(array_agg(revoked_access_tokens.jti) FILTER (WHERE revoked_access_tokens.jti IS NOT NULL), '{}')
+		FROM users
+		LEFT JOIN revoked_access_tokens
+		       ON revoked_access_tokens.user_id = users.id
+		      AND revoked_access_tokens.expires_at > now()
+		WHERE users.id = $1
+		GROUP BY users.token_version
+	`, userID).Scan(&version, pq.Array(&revoked))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil, fmt.Errorf("user not found: %d", userID)
+		}
+		logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
+		return 0, nil, err
+	}
+	return version, revoked, nil
+}
+
+func RevokeAccessToken(jti string, userID int, expiresAt time.Time) error {
+	_, err := db.Exec("SELECT revoke_access_token($1, $2, $3)", jti, userID, expiresAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (revoke_access_token): %s", err.Error()))
+		return fmt.Errorf("internal database error")
+	}
+	return nil
+}
+
+// ReserveTokenSpending adds amount to what a scoped token has transferred in
+// currency, unless that would exceed limit.
+func ReserveTokenSpending(jti, currency string, amount, limit int, expiresAt time.Time) (bool, error) {
+	var reserved bool
+	err := db.QueryRow("SELECT reserve_token_spending($1, $2, $3, $4, $5)", jti, currency, amount, limit, expiresAt).Scan(&reserved)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Database error (reserve_token_spending): %s", err.Error()))
+		return false, fmt.Errorf("internal database error")
+	}
+	return reserved, nil
+}
+
+func ReleaseTokenSpending(jti, currency string, amount int) error {
+	_, err := db.Exec("SELECT release_token_spending($1, $2, $3)", jti, currency, amount)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Database error (release_token_spending): %s", err.Error()))
+		return fmt.Errorf("internal database error")
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"gbs/internal/models"
+	"gbs/pkg/logger"
+	"github.com/lib/pq"
+	"time"
+)
+
+func CreateAPIKey(userID int, name, keyHash, prefix string, permissions []string, expiresAt *time.Time) (int, error) {
+	var keyID int
+	err := db.QueryRow("SELECT create_api_key($1, $2, $3, $4, $5, $6)",
+		userID, name, keyHash, prefix, pq.Array(permissions), expiresAt).Scan(&keyID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return 0, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (create_api_key): %s", err.Error()))
+		return 0, fmt.Errorf("internal database error")
+	}
+	return keyID, nil
+}
+
+// GetAPIKeyIdentity returns nil when the key is unknown, revoked or expired.
+func GetAPIKeyIdentity(keyHash string) (*models.APIKeyIdentity, error) {
+	var identity models.APIKeyIdentity
+	err := db.QueryRow("SELECT * FROM get_api_key_identity($1)", keyHash).Scan(
+		&identity.KeyID,
+		&identity.UserID,
+		&identity.Restricted,
+		pq.Array(&identity.Permissions),
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		logger.Error(fmt.Sprintf("Database error (get_api_key_identity): %s", err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	return &identity, nil
+}
+
+func GetAPIKeys(initiatorID, userID int) ([]models.APIKey, error) {
+	keys := []models.APIKey{}
+	rows, err := db.Query("SELECT * FROM get_api_keys($1, $2)", initiatorID, userID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return nil, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (get_api_keys): %s", err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key models.APIKey
+		var permissions []string
+		err = rows.Scan(
+			&key.KeyID,
+			&key.Name,
+			&key.Prefix,
+			&key.Restricted,
+			pq.Array(&permissions),
+			&key.CreatedAt,
+			&key.ExpiresAt,
+			&key.LastUsedAt,
+		)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
+			return nil, fmt.Errorf("internal database error")
+		}
+		if key.Restricted {
+			key.Permissions = append([]string{}, permissions...)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func RevokeAPIKey(initiatorID, userID, keyID int) error {
+	_, err := db.Exec("SELECT revoke_api_key($1, $2, $3)", initiatorID, userID, keyID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (revoke_api_key): %s", err.Error()))
+		return fmt.Errorf("internal database error")
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"gbs/internal/models"
+	"gbs/pkg/logger"
+	"github.com/lib/pq"
+)
+
+func RecordAuditEvent(event models.AuditEvent) error {
+	_, err := db.Exec("SELECT record_audit_event($1, $2, $3, $4::jsonb, $5, $6, $7, $8)",
+		event.ActorID,
+		event.TargetID,
+		event.Action,
+		string(event.Parameters),
+		event.Succeeded,
+		event.Error,
+		event.IP,
+		event.RequestID,
+	)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (record_audit_event): %s", err.Error()))
+		return fmt.Errorf("internal database error")
+	}
+	return nil
+}
+
+func GetAuditEvents(initiatorID int, filter models.AuditEventFilter, limit, offset int) ([]models.AuditEvent, error) {
+	events := []models.AuditEvent{}
+	rows, err := db.Query("SELECT * FROM get_audit_events($1, $2, $3, $4, $5, $6, $7, $8)",
+		initiatorID,
+		filter.ActorID,
+		filter.TargetID,
+		filter.Action,
+		filter.From,
+		filter.To,
+		limit,
+		offset,
+	)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return nil, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (get_audit_events): %s", err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var event models.AuditEvent
+		var parameters []byte
+		err = rows.Scan(
+			&event.EventID,
+			&event.ActorID,
+			&event.TargetID,
+			&event.Action,
+			&parameters,
+			&event.Succeeded,
+			&event.Error,
+			&event.IP,
+			&event.RequestID,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
+			return nil, fmt.Errorf("internal database error")
+		}
+		event.Parameters = json.RawMessage(parameters)
+		events = append(events, event)
+	}
+	if err = rows.Err(); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return nil, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (get_audit_events): %s", err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	return events, nil
+}
@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"fmt"
+	"gbs/internal/models"
+	"gbs/pkg/logger"
+	"github.com/lib/pq"
+)
+
+func SetCreditLimit(initiatorID int, req models.CreditLimitRequest) error {
+	_, err := db.Exec("SELECT set_credit_limit($1, $2, $3, $4)", initiatorID, req.UserID, req.Currency, req.CreditLimit)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (set_credit_limit): %s", err.Error()))
+		return fmt.Errorf("internal database error")
+	}
+	return nil
+}
+
+func GetCreditLimitHistory(initiatorID, userID, limit, offset int) ([]models.CreditLimitChange, error) {
+	changes := []models.CreditLimitChange{}
+	rows, err := db.Query("SELECT * FROM get_credit_limit_history($1, $2, $3, $4)", initiatorID, userID, limit, offset)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return nil, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (get_credit_limit_history): %s", err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var change models.CreditLimitChange
+		if err = rows.Scan(&change.Currency, &change.OldLimit, &change.NewLimit, &change.InitiatorID, &change.CreatedAt); err != nil {
+			logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
+			return nil, fmt.Errorf("internal database error")
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+func GetOverdrafts(initiatorID, limit, offset int) ([]models.Overdraft, error) {
+	overdrafts := []models.Overdraft{}
+	rows, err := db.Query("SELECT * FROM get_overdrafts($1, $2, $3)", initiatorID, limit, offset)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return nil, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (get_overdrafts): %s", err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var overdraft models.Overdraft
+		if err = rows.Scan(&overdraft.UserID, &overdraft.Username, &overdraft.Currency, &overdraft.Amount, &overdraft.CreditLimit); err != nil {
+			logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
+			return nil, fmt.Errorf("internal database error")
+		}
+		overdrafts = append(overdrafts, overdraft)
+	}
+	return overdrafts, nil
+}
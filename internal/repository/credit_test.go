@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"testing"
+
+	"gbs/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type creditTransfer struct {
+	name     string
+	currency string
+	amount   int
+	err      string
+}
+
+func runCreditTransfers(t *testing.T, debtor, payee int, transfers []creditTransfer) {
+	t.Helper()
+	for _, transfer := range transfers {
+		_, err := TransferMoney(debtor, payee, debtor, transfer.currency, transfer.amount, PrimaryWallet, PrimaryWallet)
+		if transfer.err == "" {
+			assert.NoError(t, err, transfer.name)
+		} else {
+			assert.EqualError(t, err, transfer.err, transfer.name)
+		}
+	}
+}
+
+func TestCreditLimit(t *testing.T) {
+	openTestDB(t)
+
+	debtor := createTestUser(t, "debtor", "send_funds", "receive_funds")
+	payee := createTestUser(t, "payee", "receive_funds")
+
+	assert.EqualError(t, SetCreditLimit(debtor, models.CreditLimitRequest{UserID: debtor, Currency: "USD", CreditLimit: 1000}),
+		"Credit: Insufficient permissions")
+	require.NoError(t, SetCreditLimit(1, models.CreditLimitRequest{UserID: debtor, Currency: "USD", CreditLimit: 1000}))
+
+	// Transfers run in order against an empty wallet with 1000 USD of credit.
+	runCreditTransfers(t, debtor, payee, []creditTransfer{
+		{"within the limit", "USD", 600, ""},
+		{"past the limit", "USD", 401, "Transaction: Insufficient funds"},
+		{"up to the limit", "USD", 400, ""},
+		{"with the limit used up", "USD", 1, "Transaction: Insufficient funds"},
+		{"currency without a credit line", "EUR", 1, "Transaction: Insufficient funds"},
+	})
+	assert.Equal(t, int64(-1000), balanceOf(t, debtor, PrimaryWallet, "USD"))
+
+	// Credit lines only cover the primary wallet.
+	require.NoError(t, CreateWallet(debtor, debtor, "savings"))
+	_, err := TransferMoney(debtor, debtor, debtor, "USD", 1, "savings", PrimaryWallet)
+	assert.EqualError(t, err, "Transaction: Insufficient funds")
+	assert.Equal(t, int64(0), balanceOf(t, debtor, "savings", "USD"))
+
+	balances, err := GetBalances(debtor, debtor, PrimaryWallet)
+	require.NoError(t, err)
+	assert.Equal(t, []models.Balance{{Currency: "USD", Amount: "-1000", CreditLimit: "1000", Available: "0"}}, balances)
+
+	_, err = GetOverdrafts(debtor, 10, 0)
+	assert.EqualError(t, err, "Credit: Insufficient permissions")
+	overdrafts, err := GetOverdrafts(1, 10, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []models.Overdraft{{UserID: debtor, Username: "debtor", Currency: "USD", Amount: -1000, CreditLimit: 1000}}, overdrafts)
+
+	// Lowering the limit below the debt keeps the debt and stops spending
+	// until the balance is back within the new limit.
+	require.NoError(t, SetCreditLimit(1, models.CreditLimitRequest{UserID: debtor, Currency: "USD", CreditLimit: 500}))
+	runCreditTransfers(t, debtor, payee, []creditTransfer{
+		{"below the lowered limit", "USD", 1, "Transaction: Insufficient funds"},
+	})
+	fundTestUser(t, debtor, "USD", 600)
+	runCreditTransfers(t, debtor, payee, []creditTransfer{
+		{"up to the lowered limit", "USD", 100, ""},
+		{"past the lowered limit", "USD", 1, "Transaction: Insufficient funds"},
+	})
+	assert.Equal(t, int64(-500), balanceOf(t, debtor, PrimaryWallet, "USD"))
+
+	history, err := GetCreditLimitHistory(debtor, debtor, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, [2]int64{1000, 500}, [2]int64{history[0].OldLimit, history[0].NewLimit})
+	assert.Equal(t, [2]int64{0, 1000}, [2]int64{history[1].OldLimit, history[1].NewLimit})
+
+	// Removing the line leaves the debt, and any spending is refused.
+	require.NoError(t, SetCreditLimit(1, models.CreditLimitRequest{UserID: debtor, Currency: "USD", CreditLimit: 0}))
+	runCreditTransfers(t, debtor, payee, []creditTransfer{
+		{"without a credit line", "USD", 1, "Transaction: Insufficient funds"},
+	})
+	overdrafts, err = GetOverdrafts(1, 10, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []models.Overdraft{{UserID: debtor, Username: "debtor", Currency: "USD", Amount: -500, CreditLimit: 0}}, overdrafts)
+}
@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"gbs/internal/config"
+	"gbs/internal/models"
+	"gbs/pkg/logger"
+	"github.com/lib/pq"
+)
+
+func CreateEscrow(initiatorID int, req models.CreateEscrowRequest) (int, error) {
+	var escrowID int
+	err := db.QueryRow(
+		"SELECT create_escrow($1, $2, $3, $4, $5, $6, $7, $8)",
+		initiatorID, req.PayeeID, req.ArbiterID, req.Currency, req.Amount, req.Memo, req.Deadline, req.DeadlineAction,
+	).Scan(&escrowID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return 0, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (create_escrow): %s", err.Error()))
+		return 0, fmt.Errorf("internal database error")
+	}
+	return escrowID, nil
+}
+
+// ConfirmEscrow records the outcome the initiator wants and returns the
+// contract status afterwards, settled ones pay the core fee on release.
+func ConfirmEscrow(initiatorID, escrowID int, outcome string) (string, error) {
+	var status string
+	err := db.QueryRow("SELECT confirm_escrow($1, $2, $3, $4)", initiatorID, escrowID, outcome, config.GetConfig().Core.CoreFee).Scan(&status)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return "", fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (confirm_escrow): %s", err.Error()))
+		return "", fmt.Errorf("internal database error")
+	}
+	return status, nil
+}
+
+func GetDueEscrows(limit int) ([]int, error) {
+	return queryIDs("get_due_escrows", "SELECT * FROM get_due_escrows($1)", limit)
+}
+
+func SettleEscrowDeadline(escrowID int) error {
+	_, err := db.Exec("SELECT settle_escrow_deadline($1, $2)", escrowID, config.GetConfig().Core.CoreFee)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (settle_escrow_deadline): %s", err.Error()))
+		return fmt.Errorf("internal database error")
+	}
+	return nil
+}
+
+// FailEscrowSettlement records that settling a due contract failed, so it is
+// retried later instead of holding back the others.
+func FailEscrowSettlement(escrowID int, settlementErr string) error {
+	_, err := db.Exec("SELECT fail_escrow_settlement($1, $2)", escrowID, settlementErr)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Database error (fail_escrow_settlement): %s", err.Error()))
+		return fmt.Errorf("internal database error")
+	}
+	return nil
+}
+
+func GetEscrows(initiatorID int, status string, limit, offset int) ([]models.Escrow, error) {
+	escrows := []models.Escrow{}
+	var statusParam *string
+	if status != "" {
+		statusParam = &status
+	}
+	rows, err := db.Query("SELECT * FROM get_escrows($1, $2, $3, $4)", initiatorID, statusParam, limit, offset)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return nil, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (get_escrows): %s", err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var escrow models.Escrow
+		var arbiterID sql.NullInt64
+		var payerVote, payeeVote sql.NullString
+		var resolvedAt sql.NullTime
+		err = rows.Scan(
+			&escrow.EscrowID,
+			&escrow.PayerID,
+			&escrow.PayeeID,
+			&arbiterID,
+			&escrow.Currency,
+			&escrow.Amount,
+			&escrow.Memo,
+			&escrow.Status,
+			&escrow.Deadline,
+			&escrow.DeadlineAction,
+			&payerVote,
+			&payeeVote,
+			&escrow.CreatedAt,
+			&resolvedAt,
+		)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
+			return nil, fmt.Errorf("internal database error")
+		}
+		if arbiterID.Valid {
+			id := int(arbiterID.Int64)
+			escrow.ArbiterID = &id
+		}
+		if payerVote.Valid {
+			escrow.PayerVote = &payerVote.String
+		}
+		if payeeVote.Valid {
+			escrow.PayeeVote = &payeeVote.String
+		}
+		if resolvedAt.Valid {
+			escrow.ResolvedAt = &resolvedAt.Time
+		}
+		escrows = append(escrows, escrow)
+	}
+	return escrows, nil
+}
+
+func GetEscrowLogs(initiatorID, escrowID int) ([]models.EscrowLog, error) {
+	logs := []models.EscrowLog{}
+	rows, err := db.Query("SELECT * FROM get_escrow_logs($1, $2)", initiatorID, escrowID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return nil, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (get_escrow_logs): %s", err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var log models.EscrowLog
+		var actorID, transactionID sql.NullInt64
+		if err = rows.Scan(&log.Action, &actorID, &transactionID, &log.CreatedAt); err != nil {
+			logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
+			return nil, fmt.Errorf("internal database error")
+		}
+		if actorID.Valid {
+			id := int(actorID.Int64)
+			log.ActorID = &id
+		}
+		if transactionID.Valid {
+			id := int(transactionID.Int64)
+			log.TransactionID = &id
+		}
+		logs = append(logs, log)
+	}
+	return logs, nil
+}
@@ -0,0 +1,230 @@
+package repository
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"gbs/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type escrowVote struct {
+	party   string
+	outcome string
+	status  string
+}
+
+func TestEscrowSettlement(t *testing.T) {
+	openTestDB(t)
+
+	var escrowAccountID int
+	require.NoError(t, db.QueryRow("SELECT id FROM users WHERE system_account = 'escrow'").Scan(&escrowAccountID))
+
+	// A release pays the payee 1000 less the 1% core fee.
+	tests := []struct {
+		name           string
+		payeeReceives  bool
+		deadlineAction string
+		votes          []escrowVote
+		expire         bool
+		status         string
+		payerDelta     int64
+		payeeDelta     int64
+	}{
+		{
+			name:           "payer and payee release",
+			payeeReceives:  true,
+			deadlineAction: "refund",
+			votes:          []escrowVote{{"payer", "release", "funded"}, {"payee", "release", "released"}},
+			status:         "released",
+			payerDelta:     -1000,
+			payeeDelta:     990,
+		},
+		{
+			name:           "payer and payee refund",
+			payeeReceives:  true,
+			deadlineAction: "release",
+			votes:          []escrowVote{{"payee", "refund", "funded"}, {"payer", "refund", "refunded"}},
+			status:         "refunded",
+		},
+		{
+			name:           "arbiter refunds over the payer",
+			payeeReceives:  true,
+			deadlineAction: "release",
+			votes:          []escrowVote{{"payer", "release", "funded"}, {"arbiter", "refund", "refunded"}},
+			status:         "refunded",
+		},
+		{
+			name:           "arbiter releases",
+			payeeReceives:  true,
+			deadlineAction: "refund",
+			votes:          []escrowVote{{"arbiter", "release", "released"}},
+			status:         "released",
+			payerDelta:     -1000,
+			payeeDelta:     990,
+		},
+		{
+			name:           "disagreement refunded at the deadline",
+			payeeReceives:  true,
+			deadlineAction: "refund",
+			votes:          []escrowVote{{"payer", "refund", "funded"}, {"payee", "release", "funded"}},
+			expire:         true,
+			status:         "refunded",
+		},
+		{
+			name:           "released at the deadline",
+			payeeReceives:  true,
+			deadlineAction: "release",
+			expire:         true,
+			status:         "released",
+			payerDelta:     -1000,
+			payeeDelta:     990,
+		},
+		{
+			name:           "release the payee can't receive refunded at the deadline",
+			deadlineAction: "release",
+			expire:         true,
+			status:         "refunded",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payeePermissions := []string{}
+			if tt.payeeReceives {
+				payeePermissions = append(payeePermissions, "receive_funds")
+			}
+			parties := map[string]int{
+				"payer":   createTestUser(t, fmt.Sprintf("payer%d", i), "send_funds", "receive_funds"),
+				"payee":   createTestUser(t, fmt.Sprintf("payee%d", i), payeePermissions...),
+				"arbiter": createTestUser(t, fmt.Sprintf("arbiter%d", i)),
+			}
+			arbiterID := parties["arbiter"]
+			fundTestUser(t, parties["payer"], "USD", 1000)
+
+			escrowID, err := CreateEscrow(parties["payer"], models.CreateEscrowRequest{
+				PayeeID:        parties["payee"],
+				ArbiterID:      &arbiterID,
+				Currency:       "USD",
+				Amount:         1000,
+				Deadline:       time.Now().Add(time.Hour),
+				DeadlineAction: tt.deadlineAction,
+			})
+			require.NoError(t, err)
+			assert.Equal(t, int64(0), balanceOf(t, parties["payer"], PrimaryWallet, "USD"))
+			assert.Equal(t, int64(1000), balanceOf(t, escrowAccountID, PrimaryWallet, "USD"))
+
+			for _, vote := range tt.votes {
+				status, err := ConfirmEscrow(parties[vote.party], escrowID, vote.outcome)
+				require.NoError(t, err, vote.party)
+				assert.Equal(t, vote.status, status, vote.party)
+			}
+
+			if tt.expire {
+				_, err = db.Exec("UPDATE escrows SET deadline = now() - interval '1 second' WHERE id = $1", escrowID)
+				require.NoError(t, err)
+
+				_, err = ConfirmEscrow(parties["arbiter"], escrowID, "release")
+				assert.EqualError(t, err, "Escrow: Deadline has passed")
+
+				due, err := GetDueEscrows(100)
+				require.NoError(t, err)
+				assert.Contains(t, due, escrowID)
+				require.NoError(t, SettleEscrowDeadline(escrowID))
+
+				due, err = GetDueEscrows(100)
+				require.NoError(t, err)
+				assert.NotContains(t, due, escrowID)
+			}
+
+			var status string
+			require.NoError(t, db.QueryRow("SELECT status FROM escrows WHERE id = $1", escrowID).Scan(&status))
+			assert.Equal(t, tt.status, status)
+			assert.Equal(t, 1000+tt.payerDelta, balanceOf(t, parties["payer"], PrimaryWallet, "USD"))
+			assert.Equal(t, tt.payeeDelta, balanceOf(t, parties["payee"], PrimaryWallet, "USD"))
+			assert.Equal(t, int64(0), balanceOf(t, escrowAccountID, PrimaryWallet, "USD"))
+
+			_, err = ConfirmEscrow(parties["payer"], escrowID, "refund")
+			assert.Error(t, err, "settled contracts can't be confirmed again")
+		})
+	}
+}
+
+func TestEscrowAccountCannotLogIn(t *testing.T) {
+	openTestDB(t)
+
+	var escrowAccountID int
+	require.NoError(t, db.QueryRow("SELECT id FROM users WHERE system_account = 'escrow'").Scan(&escrowAccountID))
+
+	_, _, err := GetUserIDHash("system escrow")
+	assert.Error(t, err)
+	assert.EqualError(t, ChangePassword(1, escrowAccountID, "hash"), "Change password: Account can not log in")
+
+	var managesFunds bool
+	require.NoError(t, db.QueryRow("SELECT has_permission($1, 'manage_user_funds', 'administrator')", escrowAccountID).Scan(&managesFunds))
+	assert.False(t, managesFunds)
+}
+
+func TestDueEscrowsPostponeFailedSettlements(t *testing.T) {
+	openTestDB(t)
+
+	payer := createTestUser(t, "payer", "send_funds", "receive_funds")
+	payee := createTestUser(t, "payee", "receive_funds")
+	fundTestUser(t, payer, "USD", 2000)
+
+	var escrowIDs []int
+	for i := 0; i < 2; i++ {
+		escrowID, err := CreateEscrow(payer, models.CreateEscrowRequest{
+			PayeeID:        payee,
+			Currency:       "USD",
+			Amount:         1000,
+			Deadline:       time.Now().Add(time.Hour),
+			DeadlineAction: "refund",
+		})
+		require.NoError(t, err)
+		escrowIDs = append(escrowIDs, escrowID)
+	}
+	failing, settling := escrowIDs[0], escrowIDs[1]
+
+	// The first contract is due first and can't be paid out, the escrow
+	// account doesn't hold its amount.
+	_, err := db.Exec("UPDATE escrows SET deadline = now() - interval '2 seconds', amount = 5000 WHERE id = $1", failing)
+	require.NoError(t, err)
+	_, err = db.Exec("UPDATE escrows SET deadline = now() - interval '1 second' WHERE id = $1", settling)
+	require.NoError(t, err)
+
+	due, err := GetDueEscrows(1)
+	require.NoError(t, err)
+	assert.Equal(t, []int{failing}, due)
+	err = SettleEscrowDeadline(failing)
+	require.EqualError(t, err, "Transaction: Insufficient funds")
+	require.NoError(t, FailEscrowSettlement(failing, err.Error()))
+
+	due, err = GetDueEscrows(1)
+	require.NoError(t, err)
+	assert.Equal(t, []int{settling}, due)
+	require.NoError(t, SettleEscrowDeadline(settling))
+
+	due, err = GetDueEscrows(100)
+	require.NoError(t, err)
+	assert.Empty(t, due)
+
+	var attempts int
+	var lastError string
+	var postponed bool
+	require.NoError(t, db.QueryRow(
+		"SELECT settlement_attempts, last_settlement_error, next_settlement_at > now() FROM escrows WHERE id = $1", failing,
+	).Scan(&attempts, &lastError, &postponed))
+	assert.Equal(t, 1, attempts)
+	assert.Equal(t, "Transaction: Insufficient funds", lastError)
+	assert.True(t, postponed)
+
+	_, err = db.Exec("UPDATE escrows SET next_settlement_at = now() - interval '1 second' WHERE id = $1", failing)
+	require.NoError(t, err)
+	due, err = GetDueEscrows(100)
+	require.NoError(t, err)
+	assert.Equal(t, []int{failing}, due)
+}
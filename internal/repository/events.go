@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"gbs/internal/models"
+	"gbs/pkg/logger"
+	"github.com/lib/pq"
+	"time"
+)
+
+// EventsChannel is notified by enqueue_event with every event as JSON.
+const EventsChannel = "gbs_events"
+
+// ListenEvents opens a dedicated connection listening on EventsChannel. A nil
+// notification means the connection was lost and notifications may be missing.
+func ListenEvents() (*pq.Listener, error) {
+	listener := pq.NewListener(databaseDSN(), 5*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			logger.Error("Event listener connection error: " + err.Error())
+		}
+	})
+	if err := listener.Listen(EventsChannel); err != nil {
+		listener.Close()
+		logger.Error(fmt.Sprintf("Database error (listen %s): %s", EventsChannel, err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	return listener, nil
+}
+
+func GetEvents(initiatorID int, afterID int64, allEvents bool, limit int) ([]models.Event, error) {
+	events := []models.Event{}
+	rows, err := db.Query("SELECT * FROM get_events($1, $2, $3, $4)", initiatorID, afterID, allEvents, limit)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return nil, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (get_events): %s", err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var event models.Event
+		var payload []byte
+		if err = rows.Scan(&event.EventID, &event.Type, &payload, &event.CreatedAt); err != nil {
+			logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
+			return nil, fmt.Errorf("internal database error")
+		}
+		event.Data = json.RawMessage(payload)
+		events = append(events, event)
+	}
+	return events, nil
+}
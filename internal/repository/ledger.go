@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"fmt"
+	"gbs/internal/models"
+	"gbs/pkg/logger"
+	"github.com/lib/pq"
+)
+
+// VerifyLedgerChain walks a ledger hash chain. brokenID is nil if every row
+// matches its hash and links to the one before it.
+func VerifyLedgerChain(initiatorID int, chain string) (checked int64, brokenID *int, problem *string, err error) {
+	err = db.QueryRow("SELECT * FROM verify_ledger_chain($1, $2)", initiatorID, chain).Scan(&checked, &brokenID, &problem)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return 0, nil, nil, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (verify_ledger_chain): %s", err.Error()))
+		return 0, nil, nil, fmt.Errorf("internal database error")
+	}
+	return checked, brokenID, problem, nil
+}
+
+func GetLedgerHeads() ([]models.LedgerHead, error) {
+	heads := []models.LedgerHead{}
+	rows, err := db.Query("SELECT * FROM get_ledger_heads()")
+	if err != nil {
+		logger.Error(fmt.Sprintf("Database error (get_ledger_heads): %s", err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var head models.LedgerHead
+		if err = rows.Scan(&head.Chain, &head.LastID, &head.Hash); err != nil {
+			logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
+			return nil, fmt.Errorf("internal database error")
+		}
+		heads = append(heads, head)
+	}
+	return heads, nil
+}
+
+// GetLedgerEntryHash returns the stored hash of a ledger row, nil if the row
+// doesn't exist.
+func GetLedgerEntryHash(chain string, id int) ([]byte, error) {
+	var hash []byte
+	err := db.QueryRow("SELECT get_ledger_entry_hash($1, $2)", chain, id).Scan(&hash)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return nil, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (get_ledger_entry_hash): %s", err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	return hash, nil
+}
+
+func CreateLedgerCheckpoint(checkpoint models.LedgerCheckpoint) error {
+	_, err := db.Exec("SELECT create_ledger_checkpoint($1, $2, $3, $4, $5)",
+		checkpoint.Chain, checkpoint.LastID, checkpoint.Hash, checkpoint.KeyID, checkpoint.Signature)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (create_ledger_checkpoint): %s", err.Error()))
+		return fmt.Errorf("internal database error")
+	}
+	return nil
+}
+
+// GetLatestLedgerCheckpoints returns the newest checkpoint of each chain.
+func GetLatestLedgerCheckpoints() ([]models.LedgerCheckpoint, error) {
+	return queryLedgerCheckpoints("get_latest_ledger_checkpoints", "SELECT * FROM get_latest_ledger_checkpoints()")
+}
+
+// GetLedgerCheckpoints returns every checkpoint of a chain, oldest first.
+func GetLedgerCheckpoints(chain string) ([]models.LedgerCheckpoint, error) {
+	return queryLedgerCheckpoints("get_ledger_checkpoints", "SELECT * FROM get_ledger_checkpoints($1)", chain)
+}
+
+func queryLedgerCheckpoints(function, query string, args ...interface{}) ([]models.LedgerCheckpoint, error) {
+	checkpoints := []models.LedgerCheckpoint{}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Database error (%s): %s", function, err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var checkpoint models.LedgerCheckpoint
+		err = rows.Scan(
+			&checkpoint.CheckpointID,
+			&checkpoint.Chain,
+			&checkpoint.LastID,
+			&checkpoint.Hash,
+			&checkpoint.KeyID,
+			&checkpoint.Signature,
+			&checkpoint.CreatedAt,
+		)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
+			return nil, fmt.Errorf("internal database error")
+		}
+		checkpoints = append(checkpoints, checkpoint)
+	}
+	return checkpoints, nil
+}
@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"gbs/internal/models"
+	"gbs/pkg/logger"
+	"github.com/lib/pq"
+	"time"
+)
+
+func CheckLockoutPermissions(initiatorID int) error {
+	_, err := db.Exec("SELECT check_lockout_permissions($1)", initiatorID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (check_lockout_permissions): %s", err.Error()))
+		return fmt.Errorf("internal database error")
+	}
+	return nil
+}
+
+// GetLoginLockout returns when the lockout of key ends, or the zero time if
+// key isn't locked out.
+func GetLoginLockout(key string) (time.Time, error) {
+	var blockedUntil sql.NullTime
+	err := db.QueryRow("SELECT get_login_lockout($1)", key).Scan(&blockedUntil)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Database error (get_login_lockout): %s", err.Error()))
+		return time.Time{}, fmt.Errorf("internal database error")
+	}
+	return blockedUntil.Time, nil
+}
+
+// RegisterLoginFailure counts a failed login for key and returns when the
+// resulting lockout ends, or the zero time if key isn't locked out yet.
+func RegisterLoginFailure(key string, maxAttempts int, lockout time.Duration) (time.Time, error) {
+	var blockedUntil sql.NullTime
+	err := db.QueryRow("SELECT register_login_failure($1, $2, $3)", key, maxAttempts, int(lockout.Seconds())).Scan(&blockedUntil)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Database error (register_login_failure): %s", err.Error()))
+		return time.Time{}, fmt.Errorf("internal database error")
+	}
+	return blockedUntil.Time, nil
+}
+
+func ClearLoginLockout(key string) (bool, error) {
+	var cleared bool
+	err := db.QueryRow("SELECT clear_login_lockout($1)", key).Scan(&cleared)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Database error (clear_login_lockout): %s", err.Error()))
+		return false, fmt.Errorf("internal database error")
+	}
+	return cleared, nil
+}
+
+func GetLoginLockouts() ([]models.LoginLockout, error) {
+	lockouts := []models.LoginLockout{}
+	rows, err := db.Query("SELECT * FROM get_login_lockouts()")
+	if err != nil {
+		logger.Error(fmt.Sprintf("Database error (get_login_lockouts): %s", err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var lockout models.LoginLockout
+		if err = rows.Scan(&lockout.Key, &lockout.Failures, &lockout.BlockedUntil); err != nil {
+			logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
+			return nil, fmt.Errorf("internal database error")
+		}
+		lockouts = append(lockouts, lockout)
+	}
+	return lockouts, nil
+}
+
+func DeleteExpiredLoginLockouts(lockout time.Duration) error {
+	_, err := db.Exec("SELECT delete_expired_login_lockouts($1)", int(lockout.Seconds()))
+	if err != nil {
+		logger.Error(fmt.Sprintf("Database error (delete_expired_login_lockouts): %s", err.Error()))
+		return fmt.Errorf("internal database error")
+	}
+	return nil
+}
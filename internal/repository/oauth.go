@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"gbs/internal/models"
+	"gbs/pkg/logger"
+	"github.com/lib/pq"
+	"time"
+)
+
+func CreateOAuthClient(ownerID int, clientID, secretHash, name string, redirectURIs, scopes []string) error {
+	_, err := db.Exec("SELECT create_oauth_client($1, $2, $3, $4, $5, $6)",
+		ownerID, clientID, sql.NullString{String: secretHash, Valid: secretHash != ""}, name, pq.Array(redirectURIs), pq.Array(scopes))
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (create_oauth_client): %s", err.Error()))
+		return fmt.Errorf("internal database error")
+	}
+	return nil
+}
+
+// GetOAuthClient returns a nil client when it doesn't exist or was revoked.
+// The secret hash is empty for public clients.
+func GetOAuthClient(clientID string) (*models.OAuthClient, string, error) {
+	client := models.OAuthClient{ClientID: clientID}
+	var secretHash sql.NullString
+	err := db.QueryRow("SELECT * FROM get_oauth_client($1)", clientID).Scan(
+		&secretHash,
+		&client.OwnerID,
+		&client.Name,
+		pq.Array(&client.RedirectURIs),
+		pq.Array(&client.Scopes),
+		&client.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, "", nil
+		}
+		logger.Error(fmt.Sprintf("Database error (get_oauth_client): %s", err.Error()))
+		return nil, "", fmt.Errorf("internal database error")
+	}
+	client.Confidential = secretHash.Valid
+	return &client, secretHash.String, nil
+}
+
+func GetOAuthClients(initiatorID, userID int) ([]models.OAuthClient, error) {
+	clients := []models.OAuthClient{}
+	rows, err := db.Query("SELECT * FROM get_oauth_clients($1, $2)", initiatorID, userID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return nil, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (get_oauth_clients): %s", err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		client := models.OAuthClient{OwnerID: userID}
+		err = rows.Scan(
+			&client.ClientID,
+			&client.Confidential,
+			&client.Name,
+			pq.Array(&client.RedirectURIs),
+			pq.Array(&client.Scopes),
+			&client.CreatedAt,
+		)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
+			return nil, fmt.Errorf("internal database error")
+		}
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+func RevokeOAuthClient(initiatorID, userID int, clientID string) error {
+	_, err := db.Exec("SELECT revoke_oauth_client($1, $2, $3)", initiatorID, userID, clientID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (revoke_oauth_client): %s", err.Error()))
+		return fmt.Errorf("internal database error")
+	}
+	return nil
+}
+
+func CreateAuthorizationCode(codeHash, clientID string, userID int, redirectURI string, scopes []string, codeChallenge string, expiresAt time.Time) error {
+	_, err := db.Exec("SELECT create_authorization_code($1, $2, $3, $4, $5, $6, $7)",
+		codeHash, clientID, userID, redirectURI, pq.Array(scopes), codeChallenge, expiresAt)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Database error (create_authorization_code): %s", err.Error()))
+		return fmt.Errorf("internal database error")
+	}
+	return nil
+}
+
+// ConsumeAuthorizationCode marks a code as used and returns what it was issued
+// for. userID is -1 when the code is unknown, expired, already used or was
+// issued to another client or redirect uri.
+func ConsumeAuthorizationCode(codeHash, clientID, redirectURI string) (int, []string, string, error) {
+	var userID int
+	var scopes []string
+	var codeChallenge string
+	err := db.QueryRow("SELECT * FROM consume_authorization_code($1, $2, $3)", codeHash, clientID, redirectURI).
+		Scan(&userID, pq.Array(&scopes), &codeChallenge)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return -1, nil, "", nil
+		}
+		logger.Error(fmt.Sprintf("Database error (consume_authorization_code): %s", err.Error()))
+		return -1, nil, "", fmt.Errorf("internal database error")
+	}
+	return userID, scopes, codeChallenge, nil
+}
+
+func CreateOAuthRefreshToken(userID int, expiresAt time.Time, client models.ClientInfo, clientID string, scopes []string) (string, error) {
+	var token string
+	err := db.QueryRow("SELECT create_refresh_token($1, $2, $3, $4, $5, $6)",
+		userID, expiresAt, client.UserAgent, client.IP, clientID, pq.Array(scopes)).Scan(&token)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Database error (create_refresh_token): %s", err.Error()))
+		return "", err
+	}
+	return token, nil
+}
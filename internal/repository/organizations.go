@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"gbs/internal/models"
+	"gbs/pkg/logger"
+	"github.com/lib/pq"
+)
+
+func CreateOrganization(initiatorID int, name string) (int, error) {
+	var organizationID int
+	err := db.QueryRow("SELECT create_organization($1, $2)", initiatorID, name).Scan(&organizationID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return 0, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (create_organization): %s", err.Error()))
+		return 0, fmt.Errorf("internal database error")
+	}
+	return organizationID, nil
+}
+
+// GetOrganizationRole returns the role of a user in an organization, empty
+// when the account isn't an organization the user belongs to.
+func GetOrganizationRole(organizationID, userID int) (string, error) {
+	var role sql.NullString
+	err := db.QueryRow("SELECT organization_role($1, $2)", organizationID, userID).Scan(&role)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		logger.Error(fmt.Sprintf("Database error (organization_role): %s", err.Error()))
+		return "", fmt.Errorf("internal database error")
+	}
+	return role.String, nil
+}
+
+func SetOrganizationMember(initiatorID, organizationID, userID int, role string) error {
+	return execOrganizationAction("set_organization_member", "SELECT set_organization_member($1, $2, $3, $4)", initiatorID, organizationID, userID, role)
+}
+
+func RemoveOrganizationMember(initiatorID, organizationID, userID int) error {
+	return execOrganizationAction("remove_organization_member", "SELECT remove_organization_member($1, $2, $3)", initiatorID, organizationID, userID)
+}
+
+func SetOrganizationSpendLimit(initiatorID int, req models.SpendLimitRequest) error {
+	return execOrganizationAction(
+		"set_organization_spend_limit",
+		"SELECT set_organization_spend_limit($1, $2, $3, $4, $5)",
+		initiatorID, req.OrganizationID, req.UserID, req.Currency, req.DailyLimit,
+	)
+}
+
+func execOrganizationAction(function, query string, args ...interface{}) error {
+	_, err := db.Exec(query, args...)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (%s): %s", function, err.Error()))
+		return fmt.Errorf("internal database error")
+	}
+	return nil
+}
+
+func GetOrganizations(initiatorID int) ([]models.Organization, error) {
+	organizations := []models.Organization{}
+	rows, err := db.Query("SELECT * FROM get_organizations($1)", initiatorID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return nil, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (get_organizations): %s", err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var organization models.Organization
+		if err = rows.Scan(&organization.OrganizationID, &organization.Name, &organization.Role, &organization.MemberSince); err != nil {
+			logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
+			return nil, fmt.Errorf("internal database error")
+		}
+		organizations = append(organizations, organization)
+	}
+	return organizations, nil
+}
+
+func GetOrganizationMembers(initiatorID, organizationID int) ([]models.OrganizationMember, error) {
+	members := []models.OrganizationMember{}
+	rows, err := db.Query("SELECT * FROM get_organization_members($1, $2)", initiatorID, organizationID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return nil, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (get_organization_members): %s", err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var member models.OrganizationMember
+		var currency sql.NullString
+		var dailyLimit, spent sql.NullInt64
+		if err = rows.Scan(&member.UserID, &member.Username, &member.Role, &member.MemberSince, &currency, &dailyLimit, &spent); err != nil {
+			logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
+			return nil, fmt.Errorf("internal database error")
+		}
+		if len(members) == 0 || members[len(members)-1].UserID != member.UserID {
+			member.SpendLimits = []models.SpendLimit{}
+			members = append(members, member)
+		}
+		if currency.Valid {
+			last := &members[len(members)-1]
+			last.SpendLimits = append(last.SpendLimits, models.SpendLimit{
+				Currency: currency.String, DailyLimit: dailyLimit.Int64, SpentToday: spent.Int64,
+			})
+		}
+	}
+	return members, nil
+}
@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"fmt"
+	"testing"
+
+	"gbs/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrganizationSpending(t *testing.T) {
+	openTestDB(t)
+
+	owner := createTestUser(t, "owner", "create_organizations", "send_funds", "receive_funds")
+	spender := createTestUser(t, "spender", "send_funds")
+	viewer := createTestUser(t, "viewer", "send_funds")
+	outsider := createTestUser(t, "outsider", "send_funds")
+	recipient := createTestUser(t, "recipient", "receive_funds")
+
+	organization, err := CreateOrganization(owner, "acme")
+	require.NoError(t, err)
+	require.NoError(t, SetOrganizationMember(owner, organization, spender, "spender"))
+	require.NoError(t, SetOrganizationMember(owner, organization, viewer, "viewer"))
+	require.NoError(t, SetOrganizationSpendLimit(owner, models.SpendLimitRequest{
+		OrganizationID: organization, UserID: spender, Currency: "USD", DailyLimit: 100,
+	}))
+	fundTestUser(t, organization, "USD", 10000)
+	fundTestUser(t, organization, "EUR", 1000)
+
+	// Steps run in order, spenders are limited by what they already spent.
+	steps := []struct {
+		name      string
+		initiator int
+		currency  string
+		amount    int
+		err       string
+	}{
+		{"spender within the limit", spender, "USD", 60, ""},
+		{"spender over the limit", spender, "USD", 50, "Organizations: Daily spending limit exceeded"},
+		{"spender up to the limit", spender, "USD", 40, ""},
+		{"spender with the limit used up", spender, "USD", 1, "Organizations: Daily spending limit exceeded"},
+		{"spender without a limit in the currency", spender, "EUR", 10, "Organizations: Daily spending limit exceeded"},
+		{"admin without a limit", owner, "USD", 5000, ""},
+		{"viewer", viewer, "USD", 10, "Transaction: Initiator is not the sender and does not have permission to manage funds"},
+		{"outsider", outsider, "USD", 10, "Transaction: Initiator is not the sender and does not have permission to manage funds"},
+		{"admin over the balance", owner, "USD", 5000, "Transaction: Insufficient funds"},
+	}
+	for _, step := range steps {
+		_, err := TransferMoney(organization, recipient, step.initiator, step.currency, step.amount, PrimaryWallet, PrimaryWallet)
+		if step.err == "" {
+			assert.NoError(t, err, step.name)
+		} else {
+			assert.EqualError(t, err, step.err, step.name)
+		}
+	}
+
+	// 60, 40 and 5000 left, the recipient got them less the 1% fee.
+	assert.Equal(t, int64(4900), balanceOf(t, organization, PrimaryWallet, "USD"))
+	assert.Equal(t, int64(1000), balanceOf(t, organization, PrimaryWallet, "EUR"))
+	assert.Equal(t, int64(59+39+4950), balanceOf(t, recipient, PrimaryWallet, "USD"))
+
+	var sendFunds int
+	require.NoError(t, db.QueryRow("SELECT id FROM permissions WHERE name = 'send_funds'").Scan(&sendFunds))
+	require.NoError(t, UnsetPermission(1, organization, sendFunds))
+	_, err = TransferMoney(organization, recipient, owner, "USD", 10, PrimaryWallet, PrimaryWallet)
+	assert.EqualError(t, err, `Transaction: Sender does not have "send_funds" permission`)
+}
+
+func TestCreateOrganization(t *testing.T) {
+	openTestDB(t)
+
+	plain := createTestUser(t, "plain", "send_funds", "receive_funds")
+	_, err := CreateOrganization(plain, "refused")
+	assert.EqualError(t, err, "Organizations: Insufficient permissions")
+
+	// Organizations get no more of send_funds and receive_funds than their
+	// creator holds.
+	tests := []struct {
+		name        string
+		permissions []string
+		sends       bool
+		receives    bool
+	}{
+		{"creator sends and receives", []string{"create_organizations", "send_funds", "receive_funds"}, true, true},
+		{"creator only sends", []string{"create_organizations", "send_funds"}, true, false},
+		{"creator neither sends nor receives", []string{"create_organizations"}, false, false},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			creator := createTestUser(t, fmt.Sprintf("creator%d", i), tt.permissions...)
+			name := fmt.Sprintf("organization%d", i)
+			organization, err := CreateOrganization(creator, name)
+			require.NoError(t, err)
+
+			var sends, receives bool
+			require.NoError(t, db.QueryRow(
+				"SELECT has_permission($1, 'send_funds'), has_permission($1, 'receive_funds')", organization,
+			).Scan(&sends, &receives))
+			assert.Equal(t, tt.sends, sends)
+			assert.Equal(t, tt.receives, receives)
+
+			role, err := GetOrganizationRole(organization, creator)
+			require.NoError(t, err)
+			assert.Equal(t, "admin", role)
+
+			_, _, err = GetUserIDHash(name)
+			assert.Error(t, err, "organizations can't log in")
+		})
+	}
+}
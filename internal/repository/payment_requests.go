@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"gbs/internal/config"
+	"gbs/internal/models"
+	"gbs/pkg/logger"
+	"github.com/lib/pq"
+	"time"
+)
+
+func CreatePaymentRequest(initiatorID, payerID int, currency string, amount int, memo string, expiresAt *time.Time) (int, error) {
+	var requestID int
+	err := db.QueryRow("SELECT create_payment_request($1, $2, $3, $4, $5, $6)", initiatorID, payerID, currency, amount, memo, expiresAt).Scan(&requestID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return 0, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (create_payment_request): %s", err.Error()))
+		return 0, fmt.Errorf("internal database error")
+	}
+	return requestID, nil
+}
+
+func scanPaymentRequest(scanner interface{ Scan(...interface{}) error }) (models.PaymentRequest, error) {
+	var request models.PaymentRequest
+	var expiresAt, resolvedAt sql.NullTime
+	var transactionID sql.NullInt64
+	err := scanner.Scan(
+		&request.RequestID,
+		&request.RequesterID,
+		&request.PayerID,
+		&request.Currency,
+		&request.Amount,
+		&request.Memo,
+		&request.Status,
+		&expiresAt,
+		&transactionID,
+		&request.CreatedAt,
+		&resolvedAt,
+	)
+	if err != nil {
+		return request, err
+	}
+	if expiresAt.Valid {
+		request.ExpiresAt = &expiresAt.Time
+	}
+	if transactionID.Valid {
+		id := int(transactionID.Int64)
+		request.TransactionID = &id
+	}
+	if resolvedAt.Valid {
+		request.ResolvedAt = &resolvedAt.Time
+	}
+	return request, nil
+}
+
+func GetPaymentRequest(initiatorID, requestID int) (models.PaymentRequest, error) {
+	request, err := scanPaymentRequest(db.QueryRow("SELECT * FROM get_payment_request($1, $2)", initiatorID, requestID))
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return request, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (get_payment_request): %s", err.Error()))
+		return request, fmt.Errorf("internal database error")
+	}
+	return request, nil
+}
+
+// GetPaymentRequests lists requests the initiator has to pay when incoming is
+// true, the ones they sent otherwise. An empty status returns all of them.
+func GetPaymentRequests(initiatorID int, incoming bool, status string, limit, offset int) ([]models.PaymentRequest, error) {
+	requests := []models.PaymentRequest{}
+	var statusParam *string
+	if status != "" {
+		statusParam = &status
+	}
+	rows, err := db.Query("SELECT * FROM get_payment_requests($1, $2, $3, $4, $5)", initiatorID, incoming, statusParam, limit, offset)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return nil, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (get_payment_requests): %s", err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		request, err := scanPaymentRequest(rows)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
+			return nil, fmt.Errorf("internal database error")
+		}
+		requests = append(requests, request)
+	}
+	return requests, nil
+}
+
+// AcceptPaymentRequest pays a request with the core fee, like TransferMoney.
+func AcceptPaymentRequest(initiatorID, requestID int) (models.TransactionReceipt, error) {
+	var receipt models.TransactionReceipt
+	err := db.QueryRow("SELECT * FROM accept_payment_request($1, $2, $3)", initiatorID, requestID, config.GetConfig().Core.CoreFee).Scan(
+		&receipt.TransactionID,
+		&receipt.SenderID,
+		&receipt.ReceiverID,
+		&receipt.Currency,
+		&receipt.Amount,
+		&receipt.Fee,
+		&receipt.CreatedAt,
+	)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return models.TransactionReceipt{}, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (accept_payment_request): %s", err.Error()))
+		return models.TransactionReceipt{}, fmt.Errorf("internal database error")
+	}
+	return receipt, nil
+}
+
+func DeclinePaymentRequest(initiatorID, requestID int) error {
+	return execPaymentRequestAction("decline_payment_request", "SELECT decline_payment_request($1, $2)", initiatorID, requestID)
+}
+
+func CancelPaymentRequest(initiatorID, requestID int) error {
+	return execPaymentRequestAction("cancel_payment_request", "SELECT cancel_payment_request($1, $2)", initiatorID, requestID)
+}
+
+func execPaymentRequestAction(function, query string, initiatorID, requestID int) error {
+	_, err := db.Exec(query, initiatorID, requestID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (%s): %s", function, err.Error()))
+		return fmt.Errorf("internal database error")
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"gbs/internal/models"
+	"gbs/pkg/logger"
+	"github.com/lib/pq"
+)
+
+func CreatePermission(initiatorID int, name, description string, adminOnly bool) (int, error) {
+	var permissionID int
+	err := db.QueryRow("SELECT create_permission($1, $2, $3, $4)", initiatorID, name, description, adminOnly).Scan(&permissionID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return 0, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (create_permission): %s", err.Error()))
+		return 0, fmt.Errorf("internal database error")
+	}
+	return permissionID, nil
+}
+
+// DeletePermission deletes a custom permission and returns the users that
+// held it.
+func DeletePermission(initiatorID, permissionID int) ([]int, error) {
+	return queryIDs("delete_permission", "SELECT * FROM delete_permission($1, $2)", initiatorID, permissionID)
+}
+
+// HasPermission reports whether a user holds the permission, directly or
+// through a role. Administrators hold every permission.
+func HasPermission(userID int, permission string) (bool, error) {
+	var allowed bool
+	err := db.QueryRow("SELECT has_permission($1, $2, 'administrator')", userID, permission).Scan(&allowed)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Database error (has_permission): %s", err.Error()))
+		return false, fmt.Errorf("internal database error")
+	}
+	return allowed, nil
+}
+
+// DeleteExpiredPermissions drops the grants that have run out and returns the
+// users who lost one.
+func DeleteExpiredPermissions() ([]int, error) {
+	return queryIDs("delete_expired_permissions", "SELECT * FROM delete_expired_permissions()")
+}
+
+func GetPermissionHistory(initiatorID, userID, limit, offset int) ([]models.PermissionChange, error) {
+	changes := []models.PermissionChange{}
+	rows, err := db.Query("SELECT * FROM get_permission_history($1, $2, $3, $4)", initiatorID, userID, limit, offset)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return nil, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (get_permission_history): %s", err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var change models.PermissionChange
+		err = rows.Scan(
+			&change.PermissionID,
+			&change.PermissionName,
+			&change.Action,
+			&change.InitiatorID,
+			&change.ExpiresAt,
+			&change.CreatedAt,
+		)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
+			return nil, fmt.Errorf("internal database error")
+		}
+		changes = append(changes, change)
+	}
+	if err = rows.Err(); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return nil, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (get_permission_history): %s", err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	return changes, nil
+}
+
+func GetPermissions() ([]models.Permission, error) {
+	permissions := []models.Permission{}
+	rows, err := db.Query("SELECT * FROM get_permissions()")
+	if err != nil {
+		logger.Error(fmt.Sprintf("Database error (get_permissions): %s", err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var permission models.Permission
+		err = rows.Scan(
+			&permission.PermissionID,
+			&permission.Name,
+			&permission.Description,
+			&permission.AdminOnly,
+			&permission.Custom,
+		)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
+			return nil, fmt.Errorf("internal database error")
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, nil
+}
+
+func GetPermissionID(name string) (int, error) {
+	var permissionID int
+	err := db.QueryRow("SELECT id FROM permissions WHERE name = $1", name).Scan(&permissionID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("permission not found: %s", name)
+		}
+		logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
+		return 0, fmt.Errorf("internal database error")
+	}
+	return permissionID, nil
+}
+
+// GetPermissionHolders lists the users holding a permission, one entry per
+// direct grant or role granting it.
+func GetPermissionHolders(initiatorID int, permission string, limit, offset int) ([]models.PermissionHolder, error) {
+	holders := []models.PermissionHolder{}
+	rows, err := db.Query("SELECT * FROM get_permission_holders($1, $2, $3, $4)", initiatorID, permission, limit, offset)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return nil, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (get_permission_holders): %s", err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var holder models.PermissionHolder
+		if err = rows.Scan(&holder.UserID, &holder.Username, &holder.Role, &holder.ExpiresAt); err != nil {
+			logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
+			return nil, fmt.Errorf("internal database error")
+		}
+		holders = append(holders, holder)
+	}
+	if err = rows.Err(); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return nil, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (get_permission_holders): %s", err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	return holders, nil
+}
@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnsetPermissionInvalidatesTokensOnlyOnRevocation(t *testing.T) {
+	openTestDB(t)
+
+	userID := createTestUser(t, "holder", "send_funds")
+	permissionID := func(name string) int {
+		var id int
+		require.NoError(t, db.QueryRow("SELECT id FROM permissions WHERE name = $1", name).Scan(&id))
+		return id
+	}
+	tokenVersion := func() int {
+		var version int
+		require.NoError(t, db.QueryRow("SELECT token_version FROM users WHERE id = $1", userID).Scan(&version))
+		return version
+	}
+
+	require.NoError(t, UnsetPermission(1, userID, permissionID("receive_funds")))
+	assert.Equal(t, 0, tokenVersion(), "revoking a permission the user doesn't hold")
+
+	require.NoError(t, UnsetPermission(1, userID, permissionID("send_funds")))
+	assert.Equal(t, 1, tokenVersion())
+
+	require.NoError(t, UnsetPermission(1, userID, permissionID("send_funds")))
+	assert.Equal(t, 1, tokenVersion(), "revoking it again")
+}
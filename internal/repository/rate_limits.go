@@ -0,0 +1,29 @@
+package repository
+
+import (
+	"fmt"
+	"gbs/pkg/logger"
+	"time"
+)
+
+// TakeRateLimitToken refills the bucket of key and takes a token from it if
+// there is one. It returns whether a token was taken and how many are left.
+func TakeRateLimitToken(key string, capacity int, refillPerSecond float64) (bool, float64, error) {
+	var allowed bool
+	var tokens float64
+	err := db.QueryRow("SELECT * FROM take_rate_limit_token($1, $2, $3)", key, capacity, refillPerSecond).Scan(&allowed, &tokens)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Database error (take_rate_limit_token): %s", err.Error()))
+		return false, 0, fmt.Errorf("internal database error")
+	}
+	return allowed, tokens, nil
+}
+
+func DeleteIdleRateLimitBuckets(idle time.Duration) error {
+	_, err := db.Exec("SELECT delete_idle_rate_limit_buckets($1)", int(idle.Seconds()))
+	if err != nil {
+		logger.Error(fmt.Sprintf("Database error (delete_idle_rate_limit_buckets): %s", err.Error()))
+		return fmt.Errorf("internal database error")
+	}
+	return nil
+}
@@ -14,12 +14,16 @@ import (
 
 var db *sql.DB
 
-func InitDB() {
+func databaseDSN() string {
 	cfg := config.GetConfig()
-	dsn := fmt.Sprintf(
+	return fmt.Sprintf(
 		"host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
 		cfg.Database.Host, cfg.Database.Port, cfg.Database.User, cfg.Database.Password, cfg.Database.DBName, cfg.Database.SSLMode,
 	)
+}
+
+func InitDB() {
+	dsn := databaseDSN()
 
 	var err error
 	db, err = sql.Open("postgres", dsn)
@@ -38,7 +42,7 @@ func GetUserIDHash(username string) (int, string, error) {
 	var userID int
 	var passwordHash string
 
-	err := db.QueryRow("SELECT id, password_hash FROM users WHERE username = $1", username).Scan(&userID, &passwordHash)
+	err := db.QueryRow("SELECT id, password_hash FROM users WHERE username = $1 AND NOT no_login", username).Scan(&userID, &passwordHash)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			logger.Warn(fmt.Sprintf("User not found: %s", username))
@@ -67,8 +71,8 @@ func RegisterUser(username string, passwordHash string) (int, error) {
 	return userID, nil
 }
 
-func GetBalances(initiatorID, userID int) ([]models.Balance, error) {
-	rows, err := db.Query("SELECT * FROM get_balances($1, $2)", initiatorID, userID)
+func GetBalances(initiatorID, userID int, wallet string) ([]models.Balance, error) {
+	rows, err := db.Query("SELECT * FROM get_balances($1, $2, $3)", initiatorID, userID, wallet)
 	var res []models.Balance
 	if err != nil {
 		if pqErr, ok := err.(*pq.Error); ok {
@@ -83,7 +87,7 @@ func GetBalances(initiatorID, userID int) ([]models.Balance, error) {
 	defer rows.Close()
 	for rows.Next() {
 		var balance models.Balance
-		err = rows.Scan(&balance.Currency, &balance.Amount)
+		err = rows.Scan(&balance.Currency, &balance.Amount, &balance.CreditLimit, &balance.Available)
 		if err != nil {
 			logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
 			return res, err
@@ -93,17 +97,31 @@ func GetBalances(initiatorID, userID int) ([]models.Balance, error) {
 	return res, nil
 }
 
-func TransferMoney(from int, to int, initiator int, currency string, amount int) error {
-	_, err := db.Exec("SELECT proceed_transaction($1, $2, $3, $4, $5, $6)", from, to, initiator, currency, amount, config.GetConfig().Core.CoreFee)
+// TransferMoney moves funds between wallets and returns an unsigned receipt
+// of the transfer. Moves between wallets of the same user are free of fees.
+func TransferMoney(from int, to int, initiator int, currency string, amount int, fromWallet, toWallet string) (models.TransactionReceipt, error) {
+	receipt := models.TransactionReceipt{SenderID: from, ReceiverID: to, Currency: currency, Amount: int64(amount)}
+	if fromWallet != PrimaryWallet {
+		receipt.SenderWallet = fromWallet
+	}
+	if toWallet != PrimaryWallet {
+		receipt.ReceiverWallet = toWallet
+	}
+	fee := config.GetConfig().Core.CoreFee
+	if from == to {
+		fee = 0
+	}
+	err := db.QueryRow("SELECT * FROM proceed_transaction($1, $2, $3, $4, $5, $6, $7, $8)", from, to, initiator, currency, amount, fee, fromWallet, toWallet).
+		Scan(&receipt.TransactionID, &receipt.Fee, &receipt.CreatedAt)
 	if err != nil {
 		if pqErr, ok := err.(*pq.Error); ok {
-			return fmt.Errorf(pqErr.Message)
+			return models.TransactionReceipt{}, fmt.Errorf(pqErr.Message)
 		} else {
 			logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
-			return fmt.Errorf("internal database error")
+			return models.TransactionReceipt{}, fmt.Errorf("internal database error")
 		}
 	}
-	return nil
+	return receipt, nil
 }
 
 func GetUserID(username string) (int, error) {
@@ -130,32 +148,34 @@ func GetUsername(userID int) (string, error) {
 	return username, nil
 }
 
-func GetUserPermissions(userID int) ([]int, error) {
-	var permissions []int
-	rows, err := db.Query("SELECT permission_id FROM user_permission WHERE user_id = $1", userID)
+// GetUserPermissions returns the IDs and names of a user's effective
+// permissions, ordered by ID.
+func GetUserPermissions(userID int) ([]int, []string, error) {
+	permissions := []int{}
+	names := []string{}
+	rows, err := db.Query("SELECT * FROM get_user_permission_names($1)", userID)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return permissions, nil
-		}
 		logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
-		return permissions, err
+		return permissions, names, err
 	}
 	defer rows.Close()
 	for rows.Next() {
 		var permission int
-		err = rows.Scan(&permission)
+		var name string
+		err = rows.Scan(&permission, &name)
 		if err != nil {
 			logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
-			return permissions, err
+			return permissions, names, err
 		}
 		permissions = append(permissions, permission)
+		names = append(names, name)
 	}
-	return permissions, nil
+	return permissions, names, nil
 }
 
-func GetTransactionCount(initiatorID, userID int) (int, error) {
+func GetTransactionCount(initiatorID, userID int, wallet string) (int, error) {
 	var amount int
-	err := db.QueryRow("SELECT * FROM get_amount_of_user_transactions($1, $2)", initiatorID, userID).Scan(&amount)
+	err := db.QueryRow("SELECT * FROM get_amount_of_user_transactions($1, $2, $3)", initiatorID, userID, wallet).Scan(&amount)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return 0, fmt.Errorf("user does not have any transactions: %d", userID)
@@ -165,9 +185,9 @@ func GetTransactionCount(initiatorID, userID int) (int, error) {
 	return amount, nil
 }
 
-func GetTransactionsHistory(initiatorID, userID, limit, offset int) ([]models.Transaction, error) {
+func GetTransactionsHistory(initiatorID, userID, limit, offset int, wallet string) ([]models.Transaction, error) {
 	var transactions []models.Transaction
-	rows, err := db.Query("SELECT * FROM get_transaction_history($1, $2, $3, $4)", initiatorID, userID, limit, offset)
+	rows, err := db.Query("SELECT * FROM get_transaction_history($1, $2, $3, $4, $5)", initiatorID, userID, limit, offset, wallet)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, fmt.Errorf("user does not have any transactions: %d", userID)
@@ -185,6 +205,8 @@ func GetTransactionsHistory(initiatorID, userID, limit, offset int) ([]models.Tr
 			&transaction.Amount,
 			&transaction.Fee,
 			&transaction.CreatedAt,
+			&transaction.SenderWallet,
+			&transaction.ReceiverWallet,
 		)
 		if err != nil {
 			logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
@@ -208,8 +230,10 @@ func PrintMoney(receiverID, initiatorID, amount int, currency string) error {
 	return nil
 }
 
-func SetPermission(initiatorID, userID, permissionID int) error {
-	_, err := db.Exec("SELECT set_permission($1, $2, $3)", initiatorID, userID, permissionID)
+// SetPermission grants a permission directly. A nil expiresAt grants it
+// until it is revoked.
+func SetPermission(initiatorID, userID, permissionID int, expiresAt *time.Time) error {
+	_, err := db.Exec("SELECT set_permission($1, $2, $3, $4)", initiatorID, userID, permissionID, expiresAt)
 	if err != nil {
 		if pqErr, ok := err.(*pq.Error); ok {
 			return fmt.Errorf(pqErr.Message)
@@ -236,13 +260,7 @@ func UnsetPermission(initiatorID, userID, permissionID int) error {
 
 func CheckRegistrationPermissions(initiatorID int) bool {
 	var allowed bool
-	err := db.QueryRow(`
-		SELECT EXISTS (
-			SELECT 1 FROM user_permission 
-			WHERE user_id = $1 
-			AND permission_id IN (1, 4)
-		)
-	`, initiatorID).Scan(&allowed)
+	err := db.QueryRow("SELECT has_permission($1, 'administrator', 'control_user_accounts')", initiatorID).Scan(&allowed)
 	if err != nil {
 		logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
 		return false
@@ -273,9 +291,9 @@ func DoesDefaultUsersInitialized() bool {
 	return hash.Valid && hash.String != ""
 }
 
-func CreateRefreshToken(userID int, expiresAt time.Time) (string, error) {
+func CreateRefreshToken(userID int, expiresAt time.Time, client models.ClientInfo) (string, error) {
 	var token string
-	err := db.QueryRow("SELECT create_refresh_token($1, $2)", userID, expiresAt).Scan(&token)
+	err := db.QueryRow("SELECT create_refresh_token($1, $2, $3, $4)", userID, expiresAt, client.UserAgent, client.IP).Scan(&token)
 	if err != nil {
 		logger.Error(fmt.Sprintf("Database error (create_refresh_token): %s", err.Error()))
 		return "", err
@@ -295,15 +313,20 @@ func InvalidateRefreshTokens(userID int) error {
 	return nil
 }
 
-func GetUserByRefreshToken(token string) (int, error) {
+// RotateRefreshToken returns the scopes the token was issued with. They are
+// nil for first-party tokens, which are only accepted when clientID is empty.
+func RotateRefreshToken(token string, expiresAt time.Time, clientID string) (int, string, []string, error) {
 	var userID int
-	err := db.QueryRow("SELECT is_refresh_token_valid($1)", token).Scan(&userID)
+	var newToken sql.NullString
+	var scopes []string
+	err := db.QueryRow("SELECT owner_id, new_token, scopes FROM rotate_refresh_token($1, $2, $3)",
+		token, expiresAt, sql.NullString{String: clientID, Valid: clientID != ""}).Scan(&userID, &newToken, pq.Array(&scopes))
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return -1, nil
+		if pqErr, ok := err.(*pq.Error); ok {
+			return -1, "", nil, fmt.Errorf(pqErr.Message)
 		}
-		logger.Error(fmt.Sprintf("Database error (is_refresh_token_valid): %s", err.Error()))
-		return -1, err
+		logger.Error(fmt.Sprintf("Database error (rotate_refresh_token): %s", err.Error()))
+		return -1, "", nil, err
 	}
-	return userID, nil
+	return userID, newToken.String, scopes, nil
 }
@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+)
+
+// testDatabaseEnv names the variable holding the DSN of a Postgres database
+// the repository tests may use. Tests that move money run the migrations in
+// a schema of their own there and are skipped when it isn't set.
+const testDatabaseEnv = "GBS_TEST_DATABASE_DSN"
+
+// openTestDB points the package at a fresh copy of the schema and returns
+// the connection. Everything is dropped when the test ends.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn, ok := os.LookupEnv(testDatabaseEnv)
+	if !ok {
+		t.Skipf("%s is not set", testDatabaseEnv)
+	}
+
+	// The config, and with it the core fee, is read relative to the
+	// repository root.
+	if _, ok := os.LookupEnv("GBS_JWT_KEY"); !ok {
+		t.Setenv("GBS_JWT_KEY", "test")
+	}
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(filepath.Join("..", "..")))
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	conn, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+	// A single connection keeps the search_path below for every query.
+	conn.SetMaxOpenConns(1)
+
+	schema := "gbs_test_" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	_, err = conn.Exec(fmt.Sprintf("CREATE SCHEMA %s; SET search_path TO %s, public", schema, schema))
+	require.NoError(t, err)
+
+	previous := db
+	db = conn
+	t.Cleanup(func() {
+		db = previous
+		conn.Exec(fmt.Sprintf("DROP SCHEMA %s CASCADE", schema))
+		conn.Close()
+	})
+
+	migrations, err := filepath.Glob(filepath.Join("db", "migrations", "*.sql"))
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	for _, migration := range migrations {
+		script, err := os.ReadFile(migration)
+		require.NoError(t, err)
+		_, err = conn.Exec(string(script))
+		require.NoError(t, err, migration)
+	}
+	return conn
+}
+
+// createTestUser registers a user holding the given permissions.
+func createTestUser(t *testing.T, username string, permissions ...string) int {
+	t.Helper()
+	userID, err := RegisterUser(username, "")
+	require.NoError(t, err)
+	require.NotZero(t, userID)
+	_, err = db.Exec(
+		"INSERT INTO user_permission(user_id, permission_id) SELECT $1, id FROM permissions WHERE name = ANY($2)",
+		userID, pq.Array(permissions),
+	)
+	require.NoError(t, err)
+	return userID
+}
+
+// fundTestUser prints money to the primary wallet of a user as adm.
+func fundTestUser(t *testing.T, userID int, currency string, amount int) {
+	t.Helper()
+	require.NoError(t, PrintMoney(userID, 1, amount, currency))
+}
+
+// balanceOf reads a wallet balance directly, 0 when there is none.
+func balanceOf(t *testing.T, userID int, wallet, currency string) int64 {
+	t.Helper()
+	var amount int64
+	err := db.QueryRow(
+		"SELECT coalesce(sum(amount), 0) FROM balances WHERE user_id = $1 AND wallet = $2 AND currency = $3",
+		userID, wallet, currency,
+	).Scan(&amount)
+	require.NoError(t, err)
+	return amount
+}
+
+// openTestSession opens a second session on the schema of openTestDB, for
+// tests that need two transactions at once.
+func openTestSession(t *testing.T) *sql.DB {
+	t.Helper()
+	var schema string
+	require.NoError(t, db.QueryRow("SELECT current_schema()").Scan(&schema))
+
+	conn, err := sql.Open("postgres", os.Getenv(testDatabaseEnv))
+	require.NoError(t, err)
+	conn.SetMaxOpenConns(1)
+	t.Cleanup(func() { conn.Close() })
+	_, err = conn.Exec(fmt.Sprintf("SET search_path TO %s, public", schema))
+	require.NoError(t, err)
+	return conn
+}
@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"fmt"
+	"gbs/internal/models"
+	"gbs/pkg/logger"
+	"github.com/lib/pq"
+)
+
+func CreateRole(initiatorID int, name string, permissions []string) (int, error) {
+	var roleID int
+	err := db.QueryRow("SELECT create_role($1, $2, $3)", initiatorID, name, pq.Array(permissions)).Scan(&roleID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return 0, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (create_role): %s", err.Error()))
+		return 0, fmt.Errorf("internal database error")
+	}
+	return roleID, nil
+}
+
+// UpdateRolePermissions replaces the permissions of a role and returns the
+// users holding it.
+func UpdateRolePermissions(initiatorID, roleID int, permissions []string) ([]int, error) {
+	return queryIDs("update_role_permissions", "SELECT * FROM update_role_permissions($1, $2, $3)", initiatorID, roleID, pq.Array(permissions))
+}
+
+// DeleteRole deletes a role and returns the users that held it.
+func DeleteRole(initiatorID, roleID int) ([]int, error) {
+	return queryIDs("delete_role", "SELECT * FROM delete_role($1, $2)", initiatorID, roleID)
+}
+
+func GetRoles() ([]models.Role, error) {
+	return queryRoles("get_roles", "SELECT * FROM get_roles()")
+}
+
+func GetUserRoles(userID int) ([]models.Role, error) {
+	return queryRoles("get_user_roles", "SELECT * FROM get_user_roles($1)", userID)
+}
+
+func AssignRole(initiatorID, userID, roleID int) error {
+	_, err := db.Exec("SELECT assign_role($1, $2, $3)", initiatorID, userID, roleID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (assign_role): %s", err.Error()))
+		return fmt.Errorf("internal database error")
+	}
+	return nil
+}
+
+func UnassignRole(initiatorID, userID, roleID int) error {
+	_, err := db.Exec("SELECT unassign_role($1, $2, $3)", initiatorID, userID, roleID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (unassign_role): %s", err.Error()))
+		return fmt.Errorf("internal database error")
+	}
+	return nil
+}
+
+func queryIDs(function, query string, args ...interface{}) ([]int, error) {
+	ids := []int{}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return nil, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (%s): %s", function, err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
+			return nil, fmt.Errorf("internal database error")
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return nil, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (%s): %s", function, err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	return ids, nil
+}
+
+func queryRoles(function, query string, args ...interface{}) ([]models.Role, error) {
+	roles := []models.Role{}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Database error (%s): %s", function, err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var role models.Role
+		if err = rows.Scan(&role.RoleID, &role.Name, pq.Array(&role.Permissions)); err != nil {
+			logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
+			return nil, fmt.Errorf("internal database error")
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
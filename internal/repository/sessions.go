@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"gbs/internal/models"
+	"gbs/pkg/logger"
+	"github.com/lib/pq"
+)
+
+func GetSessions(initiatorID, userID int) ([]models.Session, error) {
+	sessions := []models.Session{}
+	rows, err := db.Query("SELECT * FROM get_sessions($1, $2)", initiatorID, userID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return nil, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (get_sessions): %s", err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var session models.Session
+		var userAgent, ip, clientID sql.NullString
+		err = rows.Scan(
+			&session.SessionID,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.ExpiresAt,
+			&userAgent,
+			&ip,
+			&clientID,
+		)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
+			return nil, fmt.Errorf("internal database error")
+		}
+		session.UserAgent = userAgent.String
+		session.IP = ip.String
+		session.ClientID = clientID.String
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+func RevokeSession(initiatorID, userID int, sessionID string) error {
+	_, err := db.Exec("SELECT revoke_session($1, $2, $3)", initiatorID, userID, sessionID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (revoke_session): %s", err.Error()))
+		return fmt.Errorf("internal database error")
+	}
+	return nil
+}
+
+func RevokeUserSessions(initiatorID, userID int) error {
+	_, err := db.Exec("SELECT revoke_user_sessions($1, $2)", initiatorID, userID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (revoke_user_sessions): %s", err.Error()))
+		return fmt.Errorf("internal database error")
+	}
+	return nil
+}
+
+func RevokeSessionByToken(userID int, token string) (bool, error) {
+	var revoked bool
+	err := db.QueryRow("SELECT revoke_session_by_token($1, $2)", userID, token).Scan(&revoked)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return false, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (revoke_session_by_token): %s", err.Error()))
+		return false, fmt.Errorf("internal database error")
+	}
+	return revoked, nil
+}
@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"fmt"
+	"gbs/internal/models"
+	"gbs/pkg/logger"
+	"github.com/lib/pq"
+	"time"
+)
+
+func GetSigningKeys(algorithm string, createdAfter time.Time) ([]models.StoredSigningKey, error) {
+	var keys []models.StoredSigningKey
+	rows, err := db.Query(`
+		SELECT kid, algorithm, private_key, created_at
+		FROM signing_keys
+		WHERE algorithm = $1
+		  AND created_at > $2
+		ORDER BY created_at DESC
+	`, algorithm, createdAfter)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key models.StoredSigningKey
+		if err = rows.Scan(&key.KeyID, &key.Algorithm, &key.PrivateKey, &key.CreatedAt); err != nil {
+			logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func CreateSigningKey(key models.StoredSigningKey, rotateBefore time.Time) (bool, error) {
+	var created bool
+	err := db.QueryRow("SELECT create_signing_key($1, $2, $3, $4)", key.KeyID, key.Algorithm, key.PrivateKey, rotateBefore).Scan(&created)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return false, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (create_signing_key): %s", err.Error()))
+		return false, fmt.Errorf("internal database error")
+	}
+	return created, nil
+}
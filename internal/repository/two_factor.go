@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"gbs/pkg/logger"
+	"github.com/lib/pq"
+	"time"
+)
+
+func GetTOTP(userID int) (string, bool, error) {
+	var secret string
+	var enabled bool
+	err := db.QueryRow("SELECT secret, enabled FROM user_totp WHERE user_id = $1", userID).Scan(&secret, &enabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", false, nil
+		}
+		logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
+		return "", false, err
+	}
+	return secret, enabled, nil
+}
+
+func BeginTOTPEnrollment(userID int, secret string) error {
+	_, err := db.Exec("SELECT begin_totp_enrollment($1, $2)", userID, secret)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (begin_totp_enrollment): %s", err.Error()))
+		return fmt.Errorf("internal database error")
+	}
+	return nil
+}
+
+func ConfirmTOTP(userID int, step int64, backupCodeHashes []string) error {
+	_, err := db.Exec("SELECT confirm_totp($1, $2, $3)", userID, step, pq.Array(backupCodeHashes))
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (confirm_totp): %s", err.Error()))
+		return fmt.Errorf("internal database error")
+	}
+	return nil
+}
+
+func DisableTOTP(userID int) error {
+	_, err := db.Exec("SELECT disable_totp($1)", userID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (disable_totp): %s", err.Error()))
+		return fmt.Errorf("internal database error")
+	}
+	return nil
+}
+
+func UseTOTPStep(userID int, step int64) (bool, error) {
+	var accepted bool
+	err := db.QueryRow("SELECT use_totp_step($1, $2)", userID, step).Scan(&accepted)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Database error (use_totp_step): %s", err.Error()))
+		return false, err
+	}
+	return accepted, nil
+}
+
+func UseTOTPBackupCode(userID int, codeHash string) (bool, error) {
+	var accepted bool
+	err := db.QueryRow("SELECT use_totp_backup_code($1, $2)", userID, codeHash).Scan(&accepted)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Database error (use_totp_backup_code): %s", err.Error()))
+		return false, err
+	}
+	return accepted, nil
+}
+
+func CreateLoginChallenge(userID int, expiresAt time.Time) (string, error) {
+	var challenge string
+	err := db.QueryRow("SELECT create_login_challenge($1, $2)", userID, expiresAt).Scan(&challenge)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Database error (create_login_challenge): %s", err.Error()))
+		return "", err
+	}
+	return challenge, nil
+}
+
+func GetUserByLoginChallenge(challenge string, maxAttempts int, windowStart time.Time) (int, error) {
+	var userID int
+	err := db.QueryRow("SELECT get_login_challenge_user($1, $2, $3)", challenge, maxAttempts, windowStart).Scan(&userID)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Database error (get_login_challenge_user): %s", err.Error()))
+		return -1, err
+	}
+	return userID, nil
+}
+
+func RegisterLoginChallengeFailure(challenge string) error {
+	_, err := db.Exec("SELECT register_login_challenge_failure($1)", challenge)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Database error (register_login_challenge_failure): %s", err.Error()))
+		return fmt.Errorf("internal database error")
+	}
+	return nil
+}
+
+func ConsumeLoginChallenge(challenge string) (bool, error) {
+	var consumed bool
+	err := db.QueryRow("SELECT consume_login_challenge($1)", challenge).Scan(&consumed)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Database error (consume_login_challenge): %s", err.Error()))
+		return false, err
+	}
+	return consumed, nil
+}
@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"gbs/internal/models"
+	"gbs/pkg/logger"
+	"github.com/lib/pq"
+)
+
+// PrimaryWallet is the wallet every user has, used when none is given.
+const PrimaryWallet = "primary"
+
+func CreateWallet(initiatorID, userID int, name string) error {
+	_, err := db.Exec("SELECT create_wallet($1, $2, $3)", initiatorID, userID, name)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (create_wallet): %s", err.Error()))
+		return fmt.Errorf("internal database error")
+	}
+	return nil
+}
+
+func DeleteWallet(initiatorID, userID int, name string) error {
+	_, err := db.Exec("SELECT delete_wallet($1, $2, $3)", initiatorID, userID, name)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (delete_wallet): %s", err.Error()))
+		return fmt.Errorf("internal database error")
+	}
+	return nil
+}
+
+func GetWallets(initiatorID, userID int) ([]models.Wallet, error) {
+	wallets := []models.Wallet{}
+	rows, err := db.Query("SELECT * FROM get_wallets($1, $2)", initiatorID, userID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return nil, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (get_wallets): %s", err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var currency, amount sql.NullString
+		if err = rows.Scan(&name, &currency, &amount); err != nil {
+			logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
+			return nil, fmt.Errorf("internal database error")
+		}
+		if len(wallets) == 0 || wallets[len(wallets)-1].Name != name {
+			wallets = append(wallets, models.Wallet{Name: name, Balances: []models.Balance{}})
+		}
+		if currency.Valid {
+			wallet := &wallets[len(wallets)-1]
+			wallet.Balances = append(wallet.Balances, models.Balance{Currency: currency.String, Amount: amount.String})
+		}
+	}
+	return wallets, nil
+}
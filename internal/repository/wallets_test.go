@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteWallet(t *testing.T) {
+	openTestDB(t)
+
+	owner := createTestUser(t, "owner", "send_funds", "receive_funds")
+	require.NoError(t, CreateWallet(owner, owner, "savings"))
+	fundTestUser(t, owner, "USD", 100)
+	_, err := TransferMoney(owner, owner, owner, "USD", 100, PrimaryWallet, "savings")
+	require.NoError(t, err)
+
+	assert.EqualError(t, DeleteWallet(owner, owner, "savings"), "Wallets: Wallet is not empty")
+
+	_, err = TransferMoney(owner, owner, owner, "USD", 100, "savings", PrimaryWallet)
+	require.NoError(t, err)
+	require.NoError(t, DeleteWallet(owner, owner, "savings"))
+	assert.EqualError(t, DeleteWallet(owner, owner, "savings"), "Wallets: Wallet does not exist")
+
+	_, err = TransferMoney(owner, owner, owner, "USD", 100, PrimaryWallet, "savings")
+	assert.EqualError(t, err, "Wallets: Wallet does not exist")
+	assert.Equal(t, int64(100), balanceOf(t, owner, PrimaryWallet, "USD"))
+
+	// Balances of named wallets can't outlive the wallet.
+	_, err = db.Exec("INSERT INTO balances(user_id, wallet, currency, amount) VALUES ($1, 'savings', 'USD', 0)", owner)
+	assert.Error(t, err)
+}
+
+func TestDeleteWalletWaitsForTransfers(t *testing.T) {
+	openTestDB(t)
+
+	owner := createTestUser(t, "owner", "send_funds", "receive_funds")
+	require.NoError(t, CreateWallet(owner, owner, "savings"))
+	fundTestUser(t, owner, "USD", 100)
+
+	// An open transfer into the wallet holds it until it commits.
+	tx, err := openTestSession(t).Begin()
+	require.NoError(t, err)
+	_, err = tx.Exec("SELECT * FROM transfer_funds($1, $1, $1, 'USD', 100, 0, 'primary', 'savings')", owner)
+	require.NoError(t, err)
+
+	deleted := make(chan error, 1)
+	go func() { deleted <- DeleteWallet(owner, owner, "savings") }()
+
+	select {
+	case err = <-deleted:
+		t.Fatalf("wallet deleted during a transfer into it: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	require.NoError(t, tx.Commit())
+	assert.EqualError(t, <-deleted, "Wallets: Wallet is not empty")
+	assert.Equal(t, int64(100), balanceOf(t, owner, "savings", "USD"))
+}
@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"gbs/internal/models"
+	"gbs/pkg/logger"
+	"github.com/lib/pq"
+	"time"
+)
+
+func CreateWebhookSubscription(initiatorID int, url string, eventTypes []string, allEvents bool, sealedSecret string) (int, error) {
+	var subscriptionID int
+	err := db.QueryRow("SELECT create_webhook_subscription($1, $2, $3, $4, $5)",
+		initiatorID, url, pq.Array(eventTypes), allEvents, sealedSecret).Scan(&subscriptionID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return 0, fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (create_webhook_subscription): %s", err.Error()))
+		return 0, fmt.Errorf("internal database error")
+	}
+	return subscriptionID, nil
+}
+
+func GetWebhookSubscriptions(initiatorID int) ([]models.WebhookSubscription, error) {
+	subscriptions := []models.WebhookSubscription{}
+	rows, err := db.Query("SELECT * FROM get_webhook_subscriptions($1)", initiatorID)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Database error (get_webhook_subscriptions): %s", err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var subscription models.WebhookSubscription
+		err = rows.Scan(
+			&subscription.SubscriptionID,
+			&subscription.URL,
+			pq.Array(&subscription.EventTypes),
+			&subscription.AllEvents,
+			&subscription.CreatedAt,
+		)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
+			return nil, fmt.Errorf("internal database error")
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, nil
+}
+
+func DeleteWebhookSubscription(initiatorID, subscriptionID int) error {
+	_, err := db.Exec("SELECT delete_webhook_subscription($1, $2)", initiatorID, subscriptionID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (delete_webhook_subscription): %s", err.Error()))
+		return fmt.Errorf("internal database error")
+	}
+	return nil
+}
+
+// ClaimWebhookDeliveries hands out up to limit due deliveries, which won't be
+// handed out again before lease has passed.
+func ClaimWebhookDeliveries(limit int, lease time.Duration) ([]models.PendingWebhookDelivery, error) {
+	deliveries := []models.PendingWebhookDelivery{}
+	rows, err := db.Query("SELECT * FROM claim_webhook_deliveries($1, $2)", limit, int(lease.Seconds()))
+	if err != nil {
+		logger.Error(fmt.Sprintf("Database error (claim_webhook_deliveries): %s", err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var delivery models.PendingWebhookDelivery
+		var payload []byte
+		err = rows.Scan(
+			&delivery.DeliveryID,
+			&delivery.Attempts,
+			&delivery.URL,
+			&delivery.SealedSecret,
+			&delivery.Event.EventID,
+			&delivery.Event.Type,
+			&payload,
+			&delivery.Event.CreatedAt,
+		)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
+			return nil, fmt.Errorf("internal database error")
+		}
+		delivery.Event.Data = json.RawMessage(payload)
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+func CompleteWebhookDelivery(deliveryID int64, statusCode int) error {
+	_, err := db.Exec("SELECT complete_webhook_delivery($1, $2)", deliveryID, statusCode)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Database error (complete_webhook_delivery): %s", err.Error()))
+		return fmt.Errorf("internal database error")
+	}
+	return nil
+}
+
+// FailWebhookDelivery records a failed attempt. A dead delivery is not retried
+// until it is redelivered manually, otherwise it is retried at nextAttempt.
+func FailWebhookDelivery(deliveryID int64, statusCode *int, deliveryErr string, dead bool, nextAttempt *time.Time) error {
+	_, err := db.Exec("SELECT fail_webhook_delivery($1, $2, $3, $4, $5)", deliveryID, statusCode, deliveryErr, dead, nextAttempt)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Database error (fail_webhook_delivery): %s", err.Error()))
+		return fmt.Errorf("internal database error")
+	}
+	return nil
+}
+
+// GetWebhookDeliveries lists the deliveries of the initiator's subscriptions,
+// newest first. An empty status matches every status.
+func GetWebhookDeliveries(initiatorID int, status string, limit, offset int) ([]models.WebhookDelivery, error) {
+	var statusFilter *string
+	if status != "" {
+		statusFilter = &status
+	}
+	deliveries := []models.WebhookDelivery{}
+	rows, err := db.Query("SELECT * FROM get_webhook_deliveries($1, $2, $3, $4)", initiatorID, statusFilter, limit, offset)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Database error (get_webhook_deliveries): %s", err.Error()))
+		return nil, fmt.Errorf("internal database error")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var delivery models.WebhookDelivery
+		err = rows.Scan(
+			&delivery.DeliveryID,
+			&delivery.SubscriptionID,
+			&delivery.EventID,
+			&delivery.EventType,
+			&delivery.Status,
+			&delivery.Attempts,
+			&delivery.LastStatusCode,
+			&delivery.LastError,
+			&delivery.NextAttemptAt,
+			&delivery.CreatedAt,
+		)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Database error: %s", err.Error()))
+			return nil, fmt.Errorf("internal database error")
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+func RedeliverWebhook(initiatorID int, deliveryID int64) error {
+	_, err := db.Exec("SELECT redeliver_webhook($1, $2)", initiatorID, deliveryID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			return fmt.Errorf(pqErr.Message)
+		}
+		logger.Error(fmt.Sprintf("Database error (redeliver_webhook): %s", err.Error()))
+		return fmt.Errorf("internal database error")
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"gbs/internal/auth"
+	"gbs/internal/models"
+	"gbs/internal/repository"
+	"gbs/pkg/logger"
+)
+
+// CreateAPIKey godoc
+// @Summary Create API Key
+// @Description Issue a long-lived API key for the current user, to be sent in the X-API-Key header. Omit permissions for a key with all of the user's permissions, or list a subset of them to restrict the key. The key is returned only once.
+// @Tags auth, api-keys
+// @Accept json
+// @Produce json
+// @Param body body models.CreateAPIKeyRequest true "Key name, permissions and optional expiry"
+// @Success 200 {object} models.CreateAPIKeyResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /api/v1/createAPIKey [post]
+func CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	logger.Info("CreateAPIKey endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("CreateAPIKey: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	userID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("CreateAPIKey: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.CreateAPIKeyRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("CreateAPIKey: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("CreateAPIKey: Creating key %q for userID=%d", req.Name, userID))
+	keyID, key, err := auth.CreateAPIKey(userID, req.Name, req.Permissions, req.ExpiresAt)
+	recordAudit(r, "create_api_key", userID, map[string]interface{}{"key_id": keyID, "name": req.Name, "permissions": req.Permissions, "expires_at": req.ExpiresAt}, err)
+	if err != nil {
+		logger.Error("CreateAPIKey: Operation failed: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info(fmt.Sprintf("CreateAPIKey: Key %d created successfully", keyID))
+	json.NewEncoder(w).Encode(models.CreateAPIKeyResponse{KeyID: keyID, Key: key})
+}
+
+// GetAPIKeys godoc
+// @Summary Get API Keys
+// @Description List active API keys of a user. Viewing other users' keys requires administrator or control_user_accounts permission.
+// @Tags auth, api-keys
+// @Accept json
+// @Produce json
+// @Param id query int true "Target user ID"
+// @Success 200 {object} models.APIKeysResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/getAPIKeys [get]
+func GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	logger.Info("GetAPIKeys endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		logger.Warn("GetAPIKeys: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	targetUserID, err := parseQueryInt(r, "id")
+	if err != nil {
+		logger.Error("GetAPIKeys: Missing or invalid id parameter")
+		errorResponse(w, http.StatusBadRequest, "Missing or invalid id parameter")
+		return
+	}
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("GetAPIKeys: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("GetAPIKeys: targetUserID=%d, initiatorID=%d", targetUserID, initiatorID))
+	keys, err := repository.GetAPIKeys(initiatorID, targetUserID)
+	if err != nil {
+		logger.Error("GetAPIKeys: Failed to get api keys: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Failed to get api keys")
+		return
+	}
+	logger.Info("GetAPIKeys: API keys successfully fetched")
+	json.NewEncoder(w).Encode(models.APIKeysResponse{Keys: keys})
+}
+
+// RevokeAPIKey godoc
+// @Summary Revoke API Key
+// @Description Revoke an API key of a user. Revoking other users' keys requires administrator or control_user_accounts permission.
+// @Tags auth, api-keys
+// @Accept json
+// @Produce json
+// @Param body body models.RevokeAPIKeyRequest true "Key to revoke"
+// @Success 200 {string} string "OK"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/revokeAPIKey [post]
+func RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	logger.Info("RevokeAPIKey endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("RevokeAPIKey: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("RevokeAPIKey: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.RevokeAPIKeyRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("RevokeAPIKey: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("RevokeAPIKey: Revoking key %d of userID=%d by initiatorID=%d", req.KeyID, req.UserID, initiatorID))
+	err := repository.RevokeAPIKey(initiatorID, req.UserID, req.KeyID)
+	recordAudit(r, "revoke_api_key", req.UserID, map[string]interface{}{"key_id": req.KeyID}, err)
+	if err != nil {
+		logger.Error("RevokeAPIKey: Operation failed: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info("RevokeAPIKey: Key revoked successfully")
+	w.WriteHeader(http.StatusOK)
+}
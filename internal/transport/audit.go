@@ -0,0 +1,146 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gbs/internal/models"
+	"gbs/internal/repository"
+	"gbs/pkg/logger"
+)
+
+// recordAudit stores a privileged call in the audit log, whether it succeeded
+// or not. targetID is the user acted upon, 0 if there is none. Failing to
+// record is logged but doesn't fail the request, the action already happened.
+func recordAudit(r *http.Request, action string, targetID int, parameters interface{}, actionErr error) {
+	event := models.AuditEvent{
+		Action:    action,
+		Succeeded: actionErr == nil,
+	}
+	if actorID, ok := r.Context().Value(userIDKey).(int); ok {
+		event.ActorID = &actorID
+	}
+	if targetID != 0 {
+		event.TargetID = &targetID
+	}
+	if actionErr != nil {
+		message := actionErr.Error()
+		event.Error = &message
+	}
+	if ip := clientIP(r); ip != "" {
+		event.IP = &ip
+	}
+	if id := requestID(r); id != "" {
+		event.RequestID = &id
+	}
+	encoded, err := json.Marshal(parameters)
+	if err != nil || parameters == nil {
+		encoded = []byte("{}")
+	}
+	event.Parameters = encoded
+
+	if err := repository.RecordAuditEvent(event); err != nil {
+		logger.Error(fmt.Sprintf("Couldn't record audit event %s (request %s): %s", action, requestID(r), err.Error()))
+	}
+}
+
+// parseAuditEventFilter reads the optional actor_id, target_id, action, from
+// and to query parameters. Times are RFC 3339.
+func parseAuditEventFilter(r *http.Request) (models.AuditEventFilter, error) {
+	var filter models.AuditEventFilter
+	query := r.URL.Query()
+	for _, param := range []struct {
+		key  string
+		dest **int
+	}{{"actor_id", &filter.ActorID}, {"target_id", &filter.TargetID}} {
+		if query.Get(param.key) == "" {
+			continue
+		}
+		value, err := parseQueryInt(r, param.key)
+		if err != nil {
+			return filter, fmt.Errorf("invalid %s parameter", param.key)
+		}
+		*param.dest = &value
+	}
+	if action := query.Get("action"); action != "" {
+		filter.Action = &action
+	}
+	for _, param := range []struct {
+		key  string
+		dest **time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		if query.Get(param.key) == "" {
+			continue
+		}
+		value, err := time.Parse(time.RFC3339, query.Get(param.key))
+		if err != nil {
+			return filter, fmt.Errorf("invalid %s parameter", param.key)
+		}
+		*param.dest = &value
+	}
+	return filter, nil
+}
+
+// GetAuditEvents godoc
+// @Summary Get Audit Events
+// @Description Query the audit log of privileged calls, newest first. Every filter is optional. Requires audit_funds or administrator permission.
+// @Tags audit
+// @Accept json
+// @Produce json
+// @Param actor_id query int false "User who made the call"
+// @Param target_id query int false "User acted upon"
+// @Param action query string false "Action, e.g. set_permission"
+// @Param from query string false "Earliest time, RFC 3339"
+// @Param to query string false "Latest time (exclusive), RFC 3339"
+// @Param page query int true "Page number"
+// @Success 200 {object} models.AuditEventsResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /api/v1/getAuditEvents [get]
+func GetAuditEvents(w http.ResponseWriter, r *http.Request) {
+	logger.Info("GetAuditEvents endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		logger.Warn("GetAuditEvents: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("GetAuditEvents: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if !requirePermission(w, r, "audit_funds") {
+		return
+	}
+
+	page, err := parseQueryInt(r, "page")
+	if err != nil {
+		logger.Error("GetAuditEvents: Missing or invalid page parameter")
+		errorResponse(w, http.StatusBadRequest, "Missing or invalid page parameter")
+		return
+	}
+	filter, err := parseAuditEventFilter(r)
+	if err != nil {
+		logger.Error("GetAuditEvents: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	limit, offset := parsePage(page)
+	logger.Debug(fmt.Sprintf("GetAuditEvents: initiatorID=%d, limit=%d, offset=%d", initiatorID, limit, offset))
+	events, err := repository.GetAuditEvents(initiatorID, filter, limit, offset)
+	if err != nil {
+		logger.Error("GetAuditEvents: Failed to get audit events: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info("GetAuditEvents: Audit events successfully fetched")
+	json.NewEncoder(w).Encode(models.AuditEventsResponse{Events: events})
+}
@@ -7,15 +7,75 @@ import (
 	"strings"
 
 	"gbs/internal/auth"
+	"gbs/internal/models"
 	"gbs/pkg/logger"
 )
 
 type contextKey string
 
-const userIDKey contextKey = "userID"
+const (
+	userIDKey contextKey = "userID"
+	claimsKey contextKey = "claims"
+	apiKeyKey contextKey = "apiKey"
+)
+
+const apiKeyHeader = "X-API-Key"
+
+// routeOperations maps routes to the operation a scoped token needs to use
+// them. Routes that aren't listed can't be used with scoped tokens at all.
+var routeOperations = map[string]string{
+	"/api/v1/getUserID":                  auth.OperationReadAccount,
+	"/api/v1/getUsername":                auth.OperationReadAccount,
+	"/api/v1/getUserPermissions":         auth.OperationReadAccount,
+	"/api/v1/getUserRoles":               auth.OperationReadAccount,
+	"/api/v1/hasPermission":              auth.OperationReadAccount,
+	"/api/v1/getPermissionHistory":       auth.OperationReadAccount,
+	"/api/v1/getPermissions":             auth.OperationReadAccount,
+	"/api/v1/getPermissionHolders":       auth.OperationReadAccount,
+	"/api/v1/getRoles":                   auth.OperationReadAccount,
+	"/api/v1/getOrganizations":           auth.OperationReadAccount,
+	"/api/v1/getOrganizationMembers":     auth.OperationReadAccount,
+	"/api/v1/getBalances":                auth.OperationReadBalances,
+	"/api/v1/getWallets":                 auth.OperationReadBalances,
+	"/api/v1/getCreditLimitHistory":      auth.OperationReadBalances,
+	"/api/v1/getTransactionCount":        auth.OperationReadHistory,
+	"/api/v1/getTransactionsHistory":     auth.OperationReadHistory,
+	"/api/v1/streamEvents":               auth.OperationReadHistory,
+	"/api/v1/getIncomingPaymentRequests": auth.OperationReadHistory,
+	"/api/v1/getOutgoingPaymentRequests": auth.OperationReadHistory,
+	"/api/v1/getPaymentRequest":          auth.OperationReadHistory,
+	"/api/v1/getEscrows":                 auth.OperationReadHistory,
+	"/api/v1/getEscrowLogs":              auth.OperationReadHistory,
+	"/api/v1/transaction":                auth.OperationTransfer,
+	"/api/v1/acceptPaymentRequest":       auth.OperationTransfer,
+	"/api/v1/createEscrow":               auth.OperationTransfer,
+	"/api/v1/printMoney":                 auth.OperationPrintMoney,
+	"/api/v1/modifyPermission":           auth.OperationManagePermissions,
+	"/api/v1/modifyRole":                 auth.OperationManagePermissions,
+	"/api/v1/register":                   auth.OperationRegisterUsers,
+}
 
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if apiKey := r.Header.Get(apiKeyHeader); apiKey != "" {
+			identity, err := auth.AuthenticateAPIKey(apiKey)
+			if err != nil {
+				logger.Debug("Unauthorized: invalid api key")
+				errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+				return
+			}
+			logger.Debug(fmt.Sprintf("Authenticated userID: %d with api key %d", identity.UserID, identity.KeyID))
+
+			ctx := context.WithValue(r.Context(), userIDKey, identity.UserID)
+			ctx = context.WithValue(ctx, apiKeyKey, identity)
+			r = r.WithContext(ctx)
+			if !limitAuthenticatedRequest(w, r) {
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
 			logger.Debug("Missing Authorization header")
@@ -24,15 +84,57 @@ func AuthMiddleware(next http.Handler) http.Handler {
 		}
 
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		userID, err := auth.GetUserIDFromJWT(tokenString)
+		claims, err := auth.ParseJWT(tokenString)
 		if err != nil {
 			logger.Debug("Unauthorized: invalid token")
 			errorResponse(w, http.StatusUnauthorized, "Unauthorized")
 			return
 		}
-		logger.Debug(fmt.Sprintf("Authenticated userID: %d", userID))
+		if !claims.AllowsOperation(routeOperations[r.URL.Path]) {
+			logger.Debug(fmt.Sprintf("Forbidden: scoped token of userID %d used for %s", claims.UserID, r.URL.Path))
+			errorResponse(w, http.StatusForbidden, "Token does not allow this operation")
+			return
+		}
+		logger.Debug(fmt.Sprintf("Authenticated userID: %d", claims.UserID))
 
-		ctx := context.WithValue(r.Context(), userIDKey, userID)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		ctx := context.WithValue(r.Context(), userIDKey, claims.UserID)
+		ctx = context.WithValue(ctx, claimsKey, claims)
+		r = r.WithContext(ctx)
+		if !limitAuthenticatedRequest(w, r) {
+			return
+		}
+		next.ServeHTTP(w, r)
 	})
 }
+
+// SessionAuthMiddleware only accepts access tokens. It guards account
+// management, which a leaked API key must not be able to take over.
+func SessionAuthMiddleware(next http.Handler) http.Handler {
+	return AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(apiKeyKey).(*models.APIKeyIdentity); ok {
+			logger.Debug("Forbidden: api key used for a session-only endpoint")
+			errorResponse(w, http.StatusForbidden, "API keys can't be used for this endpoint")
+			return
+		}
+		if claims, ok := r.Context().Value(claimsKey).(*auth.AccessClaims); ok && claims.ClientID != "" {
+			logger.Debug("Forbidden: oauth token used for a session-only endpoint")
+			errorResponse(w, http.StatusForbidden, "OAuth tokens can't be used for this endpoint")
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// requirePermission is an internal helper that rejects requests made with an
+// API key or an OAuth token that wasn't granted any of the given permissions.
+func requirePermission(w http.ResponseWriter, r *http.Request, permissions ...string) bool {
+	identity, _ := r.Context().Value(apiKeyKey).(*models.APIKeyIdentity)
+	claims, _ := r.Context().Value(claimsKey).(*auth.AccessClaims)
+	if auth.APIKeyAllows(identity, permissions...) && claims.Allows(permissions...) {
+		return true
+	}
+	userID, _ := r.Context().Value(userIDKey).(int)
+	logger.Warn(fmt.Sprintf("Credentials of userID=%d don't grant %v for %s", userID, permissions, r.URL.Path))
+	errorResponse(w, http.StatusForbidden, "Credentials do not grant this operation")
+	return false
+}
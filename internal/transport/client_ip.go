@@ -0,0 +1,145 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"gbs/pkg/logger"
+)
+
+const clientIPKey contextKey = "clientIP"
+
+// trustedProxies are the networks whose forwarding headers are believed.
+// Requests from anywhere else are attributed to their RemoteAddr.
+var trustedProxies []*net.IPNet
+
+func parseTrustedProxies(entries []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %s", entry)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %s", entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// ClientIPMiddleware resolves the client IP once and stores it in the request
+// context for rate limiting, lockouts, sessions and logs.
+func ClientIPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := resolveClientIP(r, trustedProxies)
+		logger.Debug(fmt.Sprintf("%s %s from %s", r.Method, r.URL.Path, ip))
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey, ip)))
+	})
+}
+
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey).(string); ok {
+		return ip
+	}
+	return resolveClientIP(r, trustedProxies)
+}
+
+// resolveClientIP walks the forwarding chain from the nearest hop outwards and
+// returns the first address that isn't a trusted proxy. Headers are only read
+// while the hop that would have set them is trusted, so a client can't spoof
+// its address by sending them itself.
+func resolveClientIP(r *http.Request, trusted []*net.IPNet) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	if !isTrustedProxy(remote, trusted) {
+		return remote
+	}
+	chain := forwardedChain(r)
+	ip := remote
+	for i := len(chain) - 1; i >= 0; i-- {
+		hop := chain[i]
+		if net.ParseIP(hop) == nil {
+			return ip
+		}
+		ip = hop
+		if !isTrustedProxy(ip, trusted) {
+			return ip
+		}
+	}
+	return ip
+}
+
+func isTrustedProxy(ip string, trusted []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range trusted {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedChain returns the client addresses recorded by proxies, from the
+// original client to the nearest proxy. The standard Forwarded header wins
+// over X-Forwarded-For when both are present.
+func forwardedChain(r *http.Request) []string {
+	var chain []string
+	if values := r.Header.Values("Forwarded"); len(values) > 0 {
+		for _, element := range splitHeaderList(values) {
+			node := ""
+			for _, pair := range strings.Split(element, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					node = value
+				}
+			}
+			chain = append(chain, forwardedNodeIP(node))
+		}
+		return chain
+	}
+	for _, entry := range splitHeaderList(r.Header.Values("X-Forwarded-For")) {
+		chain = append(chain, forwardedNodeIP(entry))
+	}
+	return chain
+}
+
+func splitHeaderList(values []string) []string {
+	var items []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// forwardedNodeIP strips quotes, brackets and ports from a node such as
+// "[2001:db8::1]:4711" or 192.0.2.1:80. Obfuscated and unknown nodes come
+// back as they are and fail to parse as an IP.
+func forwardedNodeIP(node string) string {
+	node = strings.Trim(strings.TrimSpace(node), `"`)
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+}
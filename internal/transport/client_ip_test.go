@@ -0,0 +1,50 @@
+package transport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveClientIP(t *testing.T) {
+	trusted, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1", "fd00::/8"})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		expected   string
+	}{
+		{"direct client", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"untrusted hop can't spoof", "203.0.113.7:5000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.2:5000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"spoofed entry before real client", "10.0.0.2:5000", map[string]string{"X-Forwarded-For": "1.1.1.1, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.2:5000", map[string]string{"X-Forwarded-For": "198.51.100.1, 192.168.1.1, 10.0.0.3"}, "198.51.100.1"},
+		{"only trusted hops", "10.0.0.2:5000", map[string]string{"X-Forwarded-For": "10.0.0.3"}, "10.0.0.3"},
+		{"garbage stops the walk", "10.0.0.2:5000", map[string]string{"X-Forwarded-For": "198.51.100.1, nonsense"}, "10.0.0.2"},
+		{"forwarded header", "10.0.0.2:5000", map[string]string{"Forwarded": `for=198.51.100.1;proto=https, for="[2001:db8::1]:4711"`}, "2001:db8::1"},
+		{"forwarded wins over x-forwarded-for", "10.0.0.2:5000", map[string]string{"Forwarded": "for=198.51.100.1", "X-Forwarded-For": "198.51.100.2"}, "198.51.100.1"},
+		{"obfuscated node", "10.0.0.2:5000", map[string]string{"Forwarded": "for=_hidden"}, "10.0.0.2"},
+		{"trusted ipv6 proxy", "[fd00::1]:5000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for key, value := range tt.headers {
+				r.Header.Set(key, value)
+			}
+			assert.Equal(t, tt.expected, resolveClientIP(r, trusted))
+		})
+	}
+}
+
+func TestParseTrustedProxiesRejectsGarbage(t *testing.T) {
+	_, err := parseTrustedProxies([]string{"not-an-ip"})
+	assert.Error(t, err)
+	_, err = parseTrustedProxies([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,165 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"gbs/internal/auth"
+	"gbs/internal/models"
+	"gbs/internal/repository"
+	"gbs/pkg/logger"
+)
+
+// SetCreditLimit godoc
+// @Summary Set Credit Limit
+// @Description Grant a user a credit line in a currency, so transfers from their primary wallet can take the balance below zero down to minus the limit. A limit of 0 removes the credit line. Lowering a limit below the current overdraft only stops further spending. Every change is recorded. Requires administrator permission.
+// @Tags credit
+// @Accept json
+// @Produce json
+// @Param body body models.CreditLimitRequest true "User, currency and limit"
+// @Success 200 {string} string "OK"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /api/v1/setCreditLimit [post]
+func SetCreditLimit(w http.ResponseWriter, r *http.Request) {
+	logger.Info("SetCreditLimit endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("SetCreditLimit: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("SetCreditLimit: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.CreditLimitRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("SetCreditLimit: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if !requirePermission(w, r, "administrator") {
+		return
+	}
+
+	err := auth.SetCreditLimit(initiatorID, req)
+	recordAudit(r, "set_credit_limit", req.UserID, map[string]interface{}{"currency": req.Currency, "credit_limit": req.CreditLimit}, err)
+	if err != nil {
+		logger.Error("SetCreditLimit: Failed to set credit limit: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info(fmt.Sprintf("SetCreditLimit: userID=%d has a credit limit of %d %s", req.UserID, req.CreditLimit, req.Currency))
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetCreditLimitHistory godoc
+// @Summary Get Credit Limit History
+// @Description Changes of the credit limits of a user, newest first. Requires audit_funds or administrator permission for other users.
+// @Tags credit
+// @Produce json
+// @Param id query int true "Target user ID"
+// @Param page query int true "Page number"
+// @Success 200 {object} models.CreditLimitHistoryResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /api/v1/getCreditLimitHistory [get]
+func GetCreditLimitHistory(w http.ResponseWriter, r *http.Request) {
+	logger.Info("GetCreditLimitHistory endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		logger.Warn("GetCreditLimitHistory: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("GetCreditLimitHistory: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	targetUserID, err := parseQueryInt(r, "id")
+	if err != nil {
+		logger.Error("GetCreditLimitHistory: Missing or invalid id parameter")
+		errorResponse(w, http.StatusBadRequest, "Missing or invalid id parameter")
+		return
+	}
+	page, err := parseQueryInt(r, "page")
+	if err != nil {
+		logger.Error("GetCreditLimitHistory: Missing or invalid page parameter")
+		errorResponse(w, http.StatusBadRequest, "Missing or invalid page parameter")
+		return
+	}
+	if targetUserID != initiatorID && !requirePermission(w, r, "audit_funds") {
+		return
+	}
+
+	limit, offset := parsePage(page)
+	changes, err := repository.GetCreditLimitHistory(initiatorID, targetUserID, limit, offset)
+	if err != nil {
+		logger.Error("GetCreditLimitHistory: Failed to get history: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	json.NewEncoder(w).Encode(models.CreditLimitHistoryResponse{Changes: changes})
+}
+
+// GetOverdrafts godoc
+// @Summary Get Overdrafts
+// @Description Users whose balance is currently below zero, the deepest overdraft first, with their credit limit. Requires audit_funds or administrator permission.
+// @Tags credit
+// @Produce json
+// @Param page query int true "Page number"
+// @Success 200 {object} models.OverdraftsResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /api/v1/getOverdrafts [get]
+func GetOverdrafts(w http.ResponseWriter, r *http.Request) {
+	logger.Info("GetOverdrafts endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		logger.Warn("GetOverdrafts: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("GetOverdrafts: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	page, err := parseQueryInt(r, "page")
+	if err != nil {
+		logger.Error("GetOverdrafts: Missing or invalid page parameter")
+		errorResponse(w, http.StatusBadRequest, "Missing or invalid page parameter")
+		return
+	}
+	if !requirePermission(w, r, "audit_funds") {
+		return
+	}
+
+	limit, offset := parsePage(page)
+	overdrafts, err := repository.GetOverdrafts(initiatorID, limit, offset)
+	if err != nil {
+		logger.Error("GetOverdrafts: Failed to get overdrafts: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	json.NewEncoder(w).Encode(models.OverdraftsResponse{Overdrafts: overdrafts})
+}
@@ -0,0 +1,221 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"gbs/internal/auth"
+	"gbs/internal/models"
+	"gbs/internal/repository"
+	"gbs/pkg/logger"
+)
+
+// CreateEscrow godoc
+// @Summary Create Escrow
+// @Description Move funds from the current user into escrow for a payee. The contract is released to the payee or refunded when payer and payee confirm the same outcome, when the optional arbiter decides, or at the deadline with deadline_action (release or refund, refund by default). Requires send_funds permission.
+// @Tags escrow
+// @Accept json
+// @Produce json
+// @Param body body models.CreateEscrowRequest true "Escrow contract"
+// @Success 200 {object} models.CreateEscrowResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /api/v1/createEscrow [post]
+func CreateEscrow(w http.ResponseWriter, r *http.Request) {
+	logger.Info("CreateEscrow endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("CreateEscrow: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("CreateEscrow: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.CreateEscrowRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("CreateEscrow: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if !requirePermission(w, r, "send_funds") {
+		return
+	}
+	claims, _ := r.Context().Value(claimsKey).(*auth.AccessClaims)
+	if err := auth.ReserveTransfer(claims, req.Currency, req.Amount); err != nil {
+		logger.Warn("CreateEscrow: Rejected by token limits: " + err.Error())
+		errorResponse(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("CreateEscrow: from %d to %d, currency: %s, amount: %d", initiatorID, req.PayeeID, req.Currency, req.Amount))
+	escrowID, err := auth.CreateEscrow(initiatorID, req)
+	if err != nil {
+		auth.ReleaseTransfer(claims, req.Currency, req.Amount)
+		logger.Error("CreateEscrow: Failed to create escrow: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info(fmt.Sprintf("CreateEscrow: Escrow %d funded", escrowID))
+	json.NewEncoder(w).Encode(models.CreateEscrowResponse{EscrowID: escrowID})
+}
+
+// ConfirmEscrow godoc
+// @Summary Confirm Escrow Outcome
+// @Description Confirm that an escrow contract should be released to the payee or refunded to the payer. The arbiter settles the contract alone, payer and payee once both confirmed the same outcome. Confirmations are refused once the deadline has passed, the deadline action settles the contract then. Returns the contract status afterwards.
+// @Tags escrow
+// @Accept json
+// @Produce json
+// @Param body body models.ConfirmEscrowRequest true "Contract and outcome, release or refund"
+// @Success 200 {object} models.ConfirmEscrowResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/confirmEscrow [post]
+func ConfirmEscrow(w http.ResponseWriter, r *http.Request) {
+	logger.Info("ConfirmEscrow endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("ConfirmEscrow: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("ConfirmEscrow: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.ConfirmEscrowRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("ConfirmEscrow: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("ConfirmEscrow: userID=%d confirms %s of escrow %d", initiatorID, req.Outcome, req.EscrowID))
+	status, err := auth.ConfirmEscrow(initiatorID, req.EscrowID, req.Outcome)
+	if err != nil {
+		logger.Error("ConfirmEscrow: Failed to confirm escrow: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info(fmt.Sprintf("ConfirmEscrow: Escrow %d is %s", req.EscrowID, status))
+	json.NewEncoder(w).Encode(models.ConfirmEscrowResponse{Status: status})
+}
+
+// GetEscrows godoc
+// @Summary Get Escrows
+// @Description Escrow contracts the current user is payer, payee or arbiter of, newest first. status filters by funded, released or refunded.
+// @Tags escrow
+// @Produce json
+// @Param page query int true "Page number"
+// @Param status query string false "Contract status"
+// @Success 200 {object} models.EscrowsResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/getEscrows [get]
+func GetEscrows(w http.ResponseWriter, r *http.Request) {
+	logger.Info("GetEscrows endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		logger.Warn("GetEscrows: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("GetEscrows: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	page, err := parseQueryInt(r, "page")
+	if err != nil {
+		logger.Error("GetEscrows: Missing or invalid page parameter")
+		errorResponse(w, http.StatusBadRequest, "Missing or invalid page parameter")
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && !validEscrowStatus(status) {
+		logger.Error("GetEscrows: Invalid status parameter " + status)
+		errorResponse(w, http.StatusBadRequest, "invalid status parameter")
+		return
+	}
+
+	limit, offset := parsePage(page)
+	logger.Debug(fmt.Sprintf("GetEscrows: initiatorID=%d, status=%s, limit=%d, offset=%d", initiatorID, status, limit, offset))
+	escrows, err := repository.GetEscrows(initiatorID, status, limit, offset)
+	if err != nil {
+		logger.Error("GetEscrows: Failed to get escrows: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info("GetEscrows: Escrows successfully fetched")
+	json.NewEncoder(w).Encode(models.EscrowsResponse{Escrows: escrows})
+}
+
+func validEscrowStatus(status string) bool {
+	for _, s := range auth.EscrowStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// GetEscrowLogs godoc
+// @Summary Get Escrow Logs
+// @Description Steps of an escrow contract: funding, confirmations and settlement with their transactions. Available to the parties and to users with audit_funds or administrator permission.
+// @Tags escrow
+// @Produce json
+// @Param id query int true "Escrow ID"
+// @Success 200 {object} models.EscrowLogsResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/getEscrowLogs [get]
+func GetEscrowLogs(w http.ResponseWriter, r *http.Request) {
+	logger.Info("GetEscrowLogs endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		logger.Warn("GetEscrowLogs: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("GetEscrowLogs: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	escrowID, err := parseQueryInt(r, "id")
+	if err != nil {
+		logger.Error("GetEscrowLogs: Missing or invalid id parameter")
+		errorResponse(w, http.StatusBadRequest, "Missing or invalid id parameter")
+		return
+	}
+
+	logs, err := repository.GetEscrowLogs(initiatorID, escrowID)
+	if err != nil {
+		logger.Error("GetEscrowLogs: Failed to get escrow logs: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info("GetEscrowLogs: Escrow logs successfully fetched")
+	json.NewEncoder(w).Encode(models.EscrowLogsResponse{Logs: logs})
+}
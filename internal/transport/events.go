@@ -0,0 +1,200 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gbs/internal/auth"
+	"gbs/internal/models"
+	"gbs/internal/repository"
+	"gbs/pkg/logger"
+)
+
+const (
+	streamHeartbeat  = 25 * time.Second
+	streamReplayPage = 500
+)
+
+// parseLastEventID reads where a stream resumes from, the Last-Event-ID
+// header browsers send on reconnect or the last_event_id query parameter.
+// Without either only new events are streamed.
+func parseLastEventID(r *http.Request) (int64, bool, error) {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("last_event_id")
+	}
+	if value == "" {
+		return 0, false, nil
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id < 0 {
+		return 0, false, fmt.Errorf("invalid last event id")
+	}
+	return id, true, nil
+}
+
+// writeSSE writes one server-sent event. id is omitted when 0.
+func writeSSE(w io.Writer, id int64, event string, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id != 0 {
+		if _, err = fmt.Fprintf(w, "id: %d\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, encoded)
+	return err
+}
+
+func balanceEvent(event string) bool {
+	return event == "transfer" || event == "print_money"
+}
+
+// StreamEvents godoc
+// @Summary Stream Events
+// @Description Server-sent event stream of transfer, print_money, permission_change, registration, payment_request and escrow events that concern the current user, followed by a balance event with the balances of all the user's wallets whenever they may have changed. all=true streams every event and requires audit_funds or administrator permission. Reconnecting with Last-Event-ID (or last_event_id) replays the events missed in between.
+// @Tags events
+// @Produce text/event-stream
+// @Param all query bool false "Stream all events"
+// @Param last_event_id query int false "Resume after this event"
+// @Success 200 {string} string "Event stream"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
+// @Router /api/v1/streamEvents [get]
+func StreamEvents(w http.ResponseWriter, r *http.Request) {
+	logger.Info("StreamEvents endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		logger.Warn("StreamEvents: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("StreamEvents: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	allEvents := r.URL.Query().Get("all") == "true"
+	if allEvents && !requirePermission(w, r, "audit_funds") {
+		return
+	}
+	lastEventID, resume, err := parseLastEventID(r)
+	if err != nil {
+		logger.Error("StreamEvents: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		logger.Error("StreamEvents: Response writer doesn't support streaming")
+		errorResponse(w, http.StatusInternalServerError, "Streaming is not supported")
+		return
+	}
+
+	// Subscribe before replaying so nothing committed in between is missed,
+	// live events already replayed are skipped by id.
+	sub, err := auth.SubscribeEvents(initiatorID, allEvents)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("StreamEvents: userID=%d: %s", initiatorID, err.Error()))
+		errorResponse(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	defer sub.Close()
+
+	var replay []models.Event
+	if resume {
+		replay, err = repository.GetEvents(initiatorID, lastEventID, allEvents, streamReplayPage)
+		if err != nil {
+			logger.Error("StreamEvents: Failed to replay events: " + err.Error())
+			errorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	wallets, err := repository.GetWallets(initiatorID, initiatorID)
+	if err != nil {
+		logger.Error("StreamEvents: Failed to get balances: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	logger.Debug(fmt.Sprintf("StreamEvents: Streaming events for userID=%d, all=%v, resume=%v", initiatorID, allEvents, resume))
+
+	for len(replay) > 0 {
+		for _, event := range replay {
+			if err = writeSSE(w, event.EventID, event.Type, event); err != nil {
+				return
+			}
+			lastEventID = event.EventID
+		}
+		flusher.Flush()
+		if len(replay) < streamReplayPage {
+			break
+		}
+		if replay, err = repository.GetEvents(initiatorID, lastEventID, allEvents, streamReplayPage); err != nil {
+			logger.Error("StreamEvents: Failed to replay events: " + err.Error())
+			return
+		}
+	}
+	if err = writeSSE(w, 0, "balance", models.WalletsResponse{Wallets: wallets}); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	// Streams end with the access token they were opened with, clients
+	// reconnect with a fresh one and resume.
+	var expired <-chan time.Time
+	if claims, ok := r.Context().Value(claimsKey).(*auth.AccessClaims); ok && claims.ExpiresAt != nil {
+		timer := time.NewTimer(time.Until(claims.ExpiresAt.Time))
+		defer timer.Stop()
+		expired = timer.C
+	}
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-expired:
+			logger.Debug(fmt.Sprintf("StreamEvents: Access token of userID=%d expired, closing stream", initiatorID))
+			return
+		case <-heartbeat.C:
+			if _, err = io.WriteString(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-sub.Events:
+			if !ok {
+				return
+			}
+			if event.EventID <= lastEventID {
+				continue
+			}
+			if err = writeSSE(w, event.EventID, event.Type, event.Event); err != nil {
+				return
+			}
+			if balanceEvent(event.Type) && auth.EventConcerns(event, initiatorID) {
+				if wallets, err = repository.GetWallets(initiatorID, initiatorID); err == nil {
+					err = writeSSE(w, 0, "balance", models.WalletsResponse{Wallets: wallets})
+				}
+				if err != nil {
+					return
+				}
+			}
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,42 @@
+package transport
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLastEventID(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/streamEvents?last_event_id=7", nil)
+	id, resume, err := parseLastEventID(r)
+	assert.NoError(t, err)
+	assert.True(t, resume)
+	assert.Equal(t, int64(7), id)
+
+	r.Header.Set("Last-Event-ID", "42")
+	id, _, err = parseLastEventID(r)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), id, "the header wins over the query parameter")
+
+	r = httptest.NewRequest(http.MethodGet, "/api/v1/streamEvents", nil)
+	_, resume, err = parseLastEventID(r)
+	assert.NoError(t, err)
+	assert.False(t, resume)
+
+	r = httptest.NewRequest(http.MethodGet, "/api/v1/streamEvents?last_event_id=-1", nil)
+	_, _, err = parseLastEventID(r)
+	assert.Error(t, err)
+}
+
+func TestWriteSSE(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, writeSSE(&buf, 5, "transfer", map[string]string{"note": "a\nb"}))
+	assert.Equal(t, "id: 5\nevent: transfer\ndata: {\"note\":\"a\\nb\"}\n\n", buf.String())
+
+	buf.Reset()
+	assert.NoError(t, writeSSE(&buf, 0, "balance", []int{1}))
+	assert.Equal(t, "event: balance\ndata: [1]\n\n", buf.String())
+}
@@ -2,6 +2,7 @@ package transport
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -15,12 +16,13 @@ import (
 
 // Login godoc
 // @Summary User Login
-// @Description Authenticate a user and return JWT and refresh token.
+// @Description Authenticate a user and return JWT and refresh token. Users with two-factor authentication enabled receive a challenge instead, to be completed at /api/v1/verifyTOTP.
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param body body models.AuthRequest true "Login credentials"
 // @Success 200 {object} models.AuthResponse
+// @Success 202 {object} models.TwoFactorChallengeResponse
 // @Failure 400 {object} models.ErrorResponse
 // @Failure 401 {object} models.ErrorResponse
 // @Router /api/v1/login [post]
@@ -44,6 +46,7 @@ func Login(w http.ResponseWriter, r *http.Request) {
 func Register(w http.ResponseWriter, r *http.Request) {
 	logger.Info("Register endpoint hit")
 	allowRegistration := config.GetConfig().Security.AllowDirectRegistration
+	register := auth.RegisterUser
 	if !allowRegistration {
 		initiatorID, ok := r.Context().Value(userIDKey).(int)
 		if !ok {
@@ -51,26 +54,45 @@ func Register(w http.ResponseWriter, r *http.Request) {
 			errorResponse(w, http.StatusUnauthorized, "Unauthorized")
 			return
 		}
+		if !requirePermission(w, r, "control_user_accounts") {
+			return
+		}
 		allowRegistration = repository.CheckRegistrationPermissions(initiatorID)
 		logger.Debug(fmt.Sprintf("Register: Registration permission check result: %v", allowRegistration))
+		register = auditedRegistration(r)
 	}
 	if allowRegistration {
 		logger.Info("Register: Registration allowed, proceeding with authentication")
-		authenticate(w, r, auth.RegisterUser)
+		authenticate(w, r, register)
 	} else {
 		logger.Warn("Register: Registration not allowed")
 		errorResponse(w, http.StatusForbidden, "Registration not allowed")
 	}
 }
 
+// auditedRegistration wraps RegisterUser to record registrations made on
+// behalf of others in the audit log.
+func auditedRegistration(r *http.Request) func(string, string, models.ClientInfo) (string, string, error) {
+	return func(username, password string, client models.ClientInfo) (string, string, error) {
+		token, refreshToken, err := auth.RegisterUser(username, password, client)
+		targetID := 0
+		if err == nil {
+			targetID, _ = repository.GetUserID(username)
+		}
+		recordAudit(r, "register_user", targetID, map[string]interface{}{"username": username}, err)
+		return token, refreshToken, err
+	}
+}
+
 // GetTransactionsHistory godoc
 // @Summary Get Transactions History
-// @Description Retrieve the transactions history for a specified user.
+// @Description Retrieve the transactions history of a wallet of a specified user, the primary wallet by default.
 // @Tags transactions
 // @Accept json
 // @Produce json
 // @Param id query int true "Target user ID"
 // @Param page query int true "Page number"
+// @Param wallet query string false "Wallet name, primary by default"
 // @Success 200 {object} models.TransactionResponse
 // @Failure 400 {object} models.ErrorResponse
 // @Failure 401 {object} models.ErrorResponse
@@ -106,9 +128,20 @@ func GetTransactionsHistory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	wallet, err := parseWallet(r)
+	if err != nil {
+		logger.Error("GetTransactionsHistory: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !requireAccountAccess(w, r, targetUserID, "audit_funds") {
+		return
+	}
+
 	limit, offset := parsePage(page)
 	logger.Debug(fmt.Sprintf("GetTransactionsHistory: targetUserID=%d, initiatorID=%d, limit=%d, offset=%d", targetUserID, initiatorID, limit, offset))
-	history, err := repository.GetTransactionsHistory(initiatorID, targetUserID, limit, offset)
+	history, err := repository.GetTransactionsHistory(initiatorID, targetUserID, limit, offset, wallet)
 	if err != nil {
 		logger.Error("GetTransactionsHistory: Failed to get transactions history: " + err.Error())
 		errorResponse(w, http.StatusBadRequest, "Failed to get transactions history")
@@ -120,11 +153,12 @@ func GetTransactionsHistory(w http.ResponseWriter, r *http.Request) {
 
 // GetTransactionCount godoc
 // @Summary Get Transaction Count
-// @Description Retrieve the number of transactions for a specified user.
+// @Description Retrieve the number of transactions of a wallet of a specified user, the primary wallet by default.
 // @Tags transactions
 // @Accept json
 // @Produce json
 // @Param id query int true "Target user ID"
+// @Param wallet query string false "Wallet name, primary by default"
 // @Success 200 {object} models.TransactionAmountResponse
 // @Failure 400 {object} models.ErrorResponse
 // @Failure 401 {object} models.ErrorResponse
@@ -153,8 +187,19 @@ func GetTransactionCount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	wallet, err := parseWallet(r)
+	if err != nil {
+		logger.Error("GetTransactionCount: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !requireAccountAccess(w, r, targetUserID, "audit_funds") {
+		return
+	}
+
 	logger.Debug(fmt.Sprintf("GetTransactionCount: targetUserID=%d, initiatorID=%d", targetUserID, initiatorID))
-	amount, err := repository.GetTransactionCount(initiatorID, targetUserID)
+	amount, err := repository.GetTransactionCount(initiatorID, targetUserID, wallet)
 	if err != nil {
 		logger.Error("GetTransactionCount: Failed to get transaction count: " + err.Error())
 		errorResponse(w, http.StatusBadRequest, "Failed to get transaction count")
@@ -166,7 +211,7 @@ func GetTransactionCount(w http.ResponseWriter, r *http.Request) {
 
 // GetUserPermissions godoc
 // @Summary Get User Permissions
-// @Description Retrieve the permissions for a specified user.
+// @Description Retrieve the effective permissions for a specified user, including those granted through roles. IDs and names are returned in the same order.
 // @Tags users
 // @Accept json
 // @Produce json
@@ -193,14 +238,14 @@ func GetUserPermissions(w http.ResponseWriter, r *http.Request) {
 	}
 
 	logger.Debug(fmt.Sprintf("GetUserPermissions: Fetching permissions for userID=%d", userID))
-	permissions, err := repository.GetUserPermissions(userID)
+	permissions, names, err := repository.GetUserPermissions(userID)
 	if err != nil {
 		logger.Error("GetUserPermissions: Failed to get user permissions: " + err.Error())
 		errorResponse(w, http.StatusInternalServerError, "Failed to get user permissions")
 		return
 	}
 	logger.Info("GetUserPermissions: User permissions successfully fetched")
-	json.NewEncoder(w).Encode(models.UserPermissionsResponse{Permissions: permissions})
+	json.NewEncoder(w).Encode(models.UserPermissionsResponse{Permissions: permissions, Names: names})
 }
 
 // GetUserID godoc
@@ -281,11 +326,12 @@ func GetUsername(w http.ResponseWriter, r *http.Request) {
 
 // GetBalance godoc
 // @Summary Get User Balances
-// @Description Retrieve account balances for a given user ID.
+// @Description Retrieve account balances of a wallet of a given user ID, the primary wallet by default. Each balance comes with the credit limit of its currency and the amount available to spend, balance plus credit limit. Credit lines only cover primary wallets.
 // @Tags users, balances
 // @Accept json
 // @Produce json
 // @Param id query int true "Target user ID"
+// @Param wallet query string false "Wallet name, primary by default"
 // @Success 200 {object} models.BalanceResponse
 // @Failure 400 {object} models.ErrorResponse
 // @Failure 401 {object} models.ErrorResponse
@@ -313,9 +359,19 @@ func GetBalance(w http.ResponseWriter, r *http.Request) {
 		errorResponse(w, http.StatusBadRequest, "User ID is required")
 		return
 	}
+	wallet, err := parseWallet(r)
+	if err != nil {
+		logger.Error("GetBalance: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !requireAccountAccess(w, r, targetUserID, "audit_funds") {
+		return
+	}
 
 	logger.Debug(fmt.Sprintf("GetBalance: Fetching balances for targetUserID=%d by initiatorID=%d", targetUserID, initiatorID))
-	balances, err := repository.GetBalances(initiatorID, targetUserID)
+	balances, err := repository.GetBalances(initiatorID, targetUserID, wallet)
 	if err != nil {
 		logger.Error("GetBalance: Failed to get user balances: " + err.Error())
 		errorResponse(w, http.StatusBadRequest, "Failed to get user balances")
@@ -327,12 +383,12 @@ func GetBalance(w http.ResponseWriter, r *http.Request) {
 
 // Transaction godoc
 // @Summary Perform a Transaction
-// @Description Execute a money transfer between users.
+// @Description Execute a money transfer between users, or between wallets of one user without a fee. Spenders and admins of an organization transfer from it with from set to its ID, spenders within their daily limits. from_wallet and to_wallet default to the primary wallets. Returns a receipt signed with the GBS key, verifiable offline with the key from getLedgerKey or through verifyReceipt.
 // @Tags transactions
 // @Accept json
 // @Produce json
 // @Param body body models.TransactionRequest true "Transaction details"
-// @Success 200 {string} string "OK"
+// @Success 200 {object} models.TransactionReceipt
 // @Failure 400 {object} models.ErrorResponse
 // @Failure 401 {object} models.ErrorResponse
 // @Router /api/v1/transaction [post]
@@ -360,14 +416,41 @@ func Transaction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	logger.Debug(fmt.Sprintf("Transaction: Processing transfer from %d to %d, currency: %s, amount: %d", req.From, req.To, req.Currency, req.Amount))
-	if err := repository.TransferMoney(req.From, req.To, userID, req.Currency, req.Amount); err != nil {
+	fromWallet, toWallet := walletOrPrimary(req.FromWallet), walletOrPrimary(req.ToWallet)
+	if !auth.ValidWalletName(fromWallet) || !auth.ValidWalletName(toWallet) {
+		logger.Error("Transaction: Invalid wallet name")
+		errorResponse(w, http.StatusBadRequest, "invalid wallet name")
+		return
+	}
+
+	permission := "send_funds"
+	if req.From != userID && !auth.ActsForOrganization(req.From, userID, auth.OrganizationSpenderRoles...) {
+		permission = "manage_user_funds"
+	}
+	if !requirePermission(w, r, permission) {
+		return
+	}
+	claims, _ := r.Context().Value(claimsKey).(*auth.AccessClaims)
+	if err := auth.ReserveTransfer(claims, req.Currency, req.Amount); err != nil {
+		logger.Warn("Transaction: Rejected by token limits: " + err.Error())
+		errorResponse(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("Transaction: Processing transfer from %d/%s to %d/%s, currency: %s, amount: %d", req.From, fromWallet, req.To, toWallet, req.Currency, req.Amount))
+	receipt, err := repository.TransferMoney(req.From, req.To, userID, req.Currency, req.Amount, fromWallet, toWallet)
+	if req.From != userID {
+		recordAudit(r, "transfer_on_behalf", req.From, map[string]interface{}{"to": req.To, "currency": req.Currency, "amount": req.Amount}, err)
+	}
+	if err != nil {
+		auth.ReleaseTransfer(claims, req.Currency, req.Amount)
 		logger.Error("Transaction: Transfer failed: " + err.Error())
 		errorResponse(w, http.StatusBadRequest, err.Error())
 		return
 	}
 	logger.Info("Transaction: Completed successfully")
 	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(auth.SignReceipt(receipt))
 }
 
 // PrintMoney godoc
@@ -405,8 +488,14 @@ func PrintMoney(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !requirePermission(w, r, "print_money") {
+		return
+	}
+
 	logger.Debug(fmt.Sprintf("PrintMoney: Processing for receiverID=%d, amount=%d, currency=%s", req.ReceiverID, req.Amount, req.Currency))
-	if err := repository.PrintMoney(req.ReceiverID, userID, req.Amount, req.Currency); err != nil {
+	err := repository.PrintMoney(req.ReceiverID, userID, req.Amount, req.Currency)
+	recordAudit(r, "print_money", req.ReceiverID, map[string]interface{}{"currency": req.Currency, "amount": req.Amount}, err)
+	if err != nil {
 		logger.Error("PrintMoney: Operation failed: " + err.Error())
 		errorResponse(w, http.StatusBadRequest, err.Error())
 		return
@@ -417,7 +506,7 @@ func PrintMoney(w http.ResponseWriter, r *http.Request) {
 
 // RefreshJWT godoc
 // @Summary Refresh JWT Token
-// @Description Refresh the JWT token using a valid refresh token.
+// @Description Exchange a refresh token for a new JWT and a new refresh token. Refresh tokens are single-use: reusing a rotated token revokes the whole session.
 // @Tags auth
 // @Accept json
 // @Produce json
@@ -444,19 +533,24 @@ func RefreshJWT(w http.ResponseWriter, r *http.Request) {
 	}
 
 	logger.Debug("RefreshJWT: Attempting to refresh JWT")
-	token, err := auth.RefreshJWT(req.RefreshToken)
-	if err != nil || token == "" {
+	token, refreshToken, err := auth.RefreshJWT(req.RefreshToken)
+	if err != nil {
 		logger.Error("RefreshJWT: Failed to refresh token: " + err.Error())
 		errorResponse(w, http.StatusUnauthorized, "Invalid token")
 		return
 	}
 	logger.Info("RefreshJWT: Token successfully refreshed")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(models.RefreshResponse{Token: token})
+	json.NewEncoder(w).Encode(models.RefreshResponse{
+		Token:              token,
+		TokenExpiry:        config.GetConfig().Security.TokenExpiry,
+		RefreshToken:       refreshToken,
+		RefreshTokenExpiry: config.GetConfig().Security.RefreshTokenExpiry,
+	})
 }
 
 // authenticate is an internal helper for authentication.
-func authenticate(w http.ResponseWriter, r *http.Request, authFunc func(string, string) (string, string, error)) {
+func authenticate(w http.ResponseWriter, r *http.Request, authFunc func(string, string, models.ClientInfo) (string, string, error)) {
 	logger.Info("Authentication attempt")
 	w.Header().Set("Content-Type", "application/json")
 	if r.Method != http.MethodPost {
@@ -474,22 +568,37 @@ func authenticate(w http.ResponseWriter, r *http.Request, authFunc func(string,
 	}
 
 	logger.Debug("authenticate: Attempting authentication for username: " + req.Username)
-	if !checkLoginAttempt(req.Username) {
+	if !checkLoginAttempt(r, req.Username) {
 		logger.Warn("authenticate: Too many login attempts for username: " + req.Username)
 		errorResponse(w, http.StatusUnauthorized, "Too many login attempts, try again later")
 		return
 	}
 
-	token, refreshToken, err := authFunc(req.Username, req.Password)
+	token, refreshToken, err := authFunc(req.Username, req.Password, clientInfo(r))
+	var challenge *auth.TwoFactorRequiredError
+	if errors.As(err, &challenge) {
+		logger.Info("authenticate: Two-factor confirmation required for username: " + req.Username)
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(models.TwoFactorChallengeResponse{
+			TwoFactorRequired: true,
+			Challenge:         challenge.Challenge,
+			ChallengeExpiry:   config.GetConfig().Security.TwoFactorChallengeExpiry,
+		})
+		return
+	}
 	if err != nil || token == "" || refreshToken == "" {
 		logger.Error("authenticate: Authentication failed for username: " + req.Username + " - " + err.Error())
-		registerFailedAttempt(req.Username)
+		registerFailedAttempt(r, req.Username)
 		errorResponse(w, http.StatusUnauthorized, "Invalid credentials")
 		return
 	}
 	resetLoginAttempts(req.Username)
 	logger.Info("authenticate: User " + req.Username + " authenticated successfully")
+	writeAuthResponse(w, token, refreshToken)
+}
 
+// writeAuthResponse is an internal helper that sends freshly issued tokens.
+func writeAuthResponse(w http.ResponseWriter, token, refreshToken string) {
 	resp := models.AuthResponse{
 		Token:              token,
 		TokenExpiry:        config.GetConfig().Security.TokenExpiry,
@@ -502,7 +611,7 @@ func authenticate(w http.ResponseWriter, r *http.Request, authFunc func(string,
 
 // ModifyPermission godoc
 // @Summary Modify User Permission
-// @Description Change a user's permission settings.
+// @Description Grant a permission to a user or revoke it. The permission is given by name or by permission_id. A grant with expires_at, e.g. manage_user_funds for a support agent, stops counting at that time.
 // @Tags users, permissions
 // @Accept json
 // @Produce json
@@ -535,13 +644,27 @@ func ModifyPermission(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !requirePermission(w, r, "manage_user_permissions") {
+		return
+	}
+
+	if req.Permission != "" {
+		permissionID, err := repository.GetPermissionID(req.Permission)
+		if err != nil {
+			logger.Error("ModifyPermission: Unknown permission: " + err.Error())
+			errorResponse(w, http.StatusBadRequest, "Permission does not exist")
+			return
+		}
+		req.PermissionID = permissionID
+	}
+
 	logger.Debug(fmt.Sprintf("ModifyPermission: Changing permission for userID=%d, permissionID=%d, enabled=%v", req.UserID, req.PermissionID, req.Enabled))
-	var err error
+	err := auth.ModifyPermission(userID, req.UserID, req.PermissionID, req.Enabled, req.ExpiresAt)
+	action := "unset_permission"
 	if req.Enabled {
-		err = repository.SetPermission(userID, req.UserID, req.PermissionID)
-	} else {
-		err = repository.UnsetPermission(userID, req.UserID, req.PermissionID)
+		action = "set_permission"
 	}
+	recordAudit(r, action, req.UserID, map[string]interface{}{"permission_id": req.PermissionID, "expires_at": req.ExpiresAt}, err)
 	if err != nil {
 		logger.Error("ModifyPermission: Operation failed: " + err.Error())
 		errorResponse(w, http.StatusBadRequest, err.Error())
@@ -587,7 +710,11 @@ func ChangePassword(w http.ResponseWriter, r *http.Request) {
 	}
 
 	logger.Debug(fmt.Sprintf("ChangePassword: Attempting password change for userID=%d, targetUserID=%d", userID, req.UserID))
-	if err := auth.ChangePassword(userID, req.UserID, req.Password); err != nil {
+	err := auth.ChangePassword(userID, req.UserID, req.Password)
+	if req.UserID != userID {
+		recordAudit(r, "reset_user_password", req.UserID, nil, err)
+	}
+	if err != nil {
 		logger.Error("ChangePassword: Operation failed: " + err.Error())
 		errorResponse(w, http.StatusBadRequest, err.Error())
 		return
@@ -0,0 +1,37 @@
+package transport
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"gbs/internal/auth"
+	"gbs/pkg/logger"
+)
+
+// JWKS godoc
+// @Summary JSON Web Key Set
+// @Description Public keys that access tokens are verified with, selected by the kid header of the token. Empty when tokens are signed with the shared HS256 secret.
+// @Tags auth
+// @Produce json
+// @Success 200 {object} models.JWKSResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /.well-known/jwks.json [get]
+func JWKS(w http.ResponseWriter, r *http.Request) {
+	logger.Info("JWKS endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		logger.Warn("JWKS: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	keys, err := auth.JWKS()
+	if err != nil {
+		logger.Error("JWKS: Failed to load signing keys: " + err.Error())
+		errorResponse(w, http.StatusInternalServerError, "Failed to load signing keys")
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(keys)
+}
@@ -0,0 +1,109 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"gbs/internal/auth"
+	"gbs/internal/models"
+	"gbs/pkg/logger"
+)
+
+// VerifyLedger godoc
+// @Summary Verify Ledger
+// @Description Walk the hash chains over transaction and print money logs and check the signed checkpoints. Reports the first broken row or checkpoint of each chain. Requires audit_funds or administrator permission.
+// @Tags audit
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.LedgerVerificationResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /api/v1/verifyLedger [get]
+func VerifyLedger(w http.ResponseWriter, r *http.Request) {
+	logger.Info("VerifyLedger endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		logger.Warn("VerifyLedger: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("VerifyLedger: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if !requirePermission(w, r, "audit_funds") {
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("VerifyLedger: Verifying ledger for initiatorID=%d", initiatorID))
+	report, err := auth.VerifyLedger(initiatorID)
+	if err != nil {
+		logger.Error("VerifyLedger: Verification failed: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !report.Valid {
+		logger.Warn("VerifyLedger: Ledger chain is broken")
+	}
+	logger.Info("VerifyLedger: Ledger verified")
+	json.NewEncoder(w).Encode(report)
+}
+
+// GetLedgerKey godoc
+// @Summary Ledger Signing Key
+// @Description Public Ed25519 key that ledger checkpoints and transaction receipts are signed with, base64 encoded.
+// @Tags audit
+// @Produce json
+// @Success 200 {object} models.LedgerKeyResponse
+// @Router /api/v1/getLedgerKey [get]
+func GetLedgerKey(w http.ResponseWriter, r *http.Request) {
+	logger.Info("GetLedgerKey endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		logger.Warn("GetLedgerKey: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(auth.LedgerKey())
+}
+
+// VerifyReceipt godoc
+// @Summary Verify Transaction Receipt
+// @Description Check that a receipt returned by the transaction endpoint was signed by GBS and hasn't been altered. Receipts can also be verified offline with the key from getLedgerKey.
+// @Tags transactions
+// @Accept json
+// @Produce json
+// @Param body body models.TransactionReceipt true "Receipt as returned by the transaction endpoint"
+// @Success 200 {object} models.VerifyReceiptResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Router /api/v1/verifyReceipt [post]
+func VerifyReceipt(w http.ResponseWriter, r *http.Request) {
+	logger.Info("VerifyReceipt endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("VerifyReceipt: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	var receipt models.TransactionReceipt
+	if err := parseJSONRequest(r, &receipt); err != nil {
+		logger.Error("VerifyReceipt: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	valid := auth.VerifyReceipt(receipt)
+	logger.Debug(fmt.Sprintf("VerifyReceipt: Receipt for transaction %d valid=%v", receipt.TransactionID, valid))
+	json.NewEncoder(w).Encode(models.VerifyReceiptResponse{Valid: valid})
+}
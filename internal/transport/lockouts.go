@@ -0,0 +1,119 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"gbs/internal/models"
+	"gbs/internal/repository"
+	"gbs/pkg/logger"
+)
+
+// GetLoginLockouts godoc
+// @Summary Get Login Lockouts
+// @Description List usernames and IPs that are currently locked out after too many failed logins. Requires administrator or control_user_accounts permission.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.LoginLockoutsResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /api/v1/getLoginLockouts [get]
+func GetLoginLockouts(w http.ResponseWriter, r *http.Request) {
+	logger.Info("GetLoginLockouts endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		logger.Warn("GetLoginLockouts: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("GetLoginLockouts: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if !requirePermission(w, r, "control_user_accounts") {
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("GetLoginLockouts: initiatorID=%d", initiatorID))
+	if err := repository.CheckLockoutPermissions(initiatorID); err != nil {
+		logger.Error("GetLoginLockouts: Permission check failed: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	lockouts, err := loginAttempts.Lockouts()
+	if err != nil {
+		logger.Error("GetLoginLockouts: Failed to get lockouts: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Failed to get lockouts")
+		return
+	}
+	logger.Info("GetLoginLockouts: Lockouts successfully fetched")
+	json.NewEncoder(w).Encode(models.LoginLockoutsResponse{Lockouts: lockouts})
+}
+
+// ClearLoginLockout godoc
+// @Summary Clear Login Lockout
+// @Description Lift a lockout and forget the failed logins counted for it. Keys are listed by getLoginLockouts, e.g. "user:alice" or "ip:203.0.113.7". Requires administrator or control_user_accounts permission.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param body body models.ClearLoginLockoutRequest true "Lockout to clear"
+// @Success 200 {string} string "OK"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /api/v1/clearLoginLockout [post]
+func ClearLoginLockout(w http.ResponseWriter, r *http.Request) {
+	logger.Info("ClearLoginLockout endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("ClearLoginLockout: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("ClearLoginLockout: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if !requirePermission(w, r, "control_user_accounts") {
+		return
+	}
+
+	var req models.ClearLoginLockoutRequest
+	if err := parseJSONRequest(r, &req); err != nil || req.Key == "" {
+		logger.Error("ClearLoginLockout: Invalid request body")
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("ClearLoginLockout: Clearing %s by initiatorID=%d", req.Key, initiatorID))
+	if err := repository.CheckLockoutPermissions(initiatorID); err != nil {
+		logger.Error("ClearLoginLockout: Permission check failed: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	cleared, err := loginAttempts.Reset(req.Key)
+	recordAudit(r, "clear_login_lockout", 0, map[string]interface{}{"key": req.Key, "cleared": cleared}, err)
+	if err != nil {
+		logger.Error("ClearLoginLockout: Operation failed: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Failed to clear lockout")
+		return
+	}
+	if !cleared {
+		logger.Warn("ClearLoginLockout: No lockout for " + req.Key)
+		errorResponse(w, http.StatusBadRequest, "Lockout does not exist")
+		return
+	}
+	logger.Info("ClearLoginLockout: Lockout cleared successfully")
+	w.WriteHeader(http.StatusOK)
+}
@@ -1,38 +1,224 @@
 package transport
 
 import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
 	"time"
 
 	"gbs/internal/config"
 	"gbs/internal/models"
+	"gbs/internal/repository"
 	"gbs/pkg/logger"
 )
 
-func checkLoginAttempt(username string) bool {
-	if value, ok := failedLogins.Load(username); ok {
-		if attempt, ok := value.(*models.LoginAttempt); ok {
-			if time.Now().Before(attempt.BlockedUntil) {
-				return false
-			}
+const maxLockoutKeyLength = 128
+
+// LoginAttemptStore keeps track of failed logins per key, a key being a
+// username or a client IP. Implementations must be safe for concurrent use.
+type LoginAttemptStore interface {
+	// BlockedUntil returns when the lockout of key ends, or the zero time if
+	// key isn't locked out.
+	BlockedUntil(key string) (time.Time, error)
+	// RegisterFailure counts a failed login. Once maxAttempts failures happen
+	// within lockout, key is locked out for lockout. It returns when that
+	// lockout ends, or the zero time if there is none.
+	RegisterFailure(key string, maxAttempts int, lockout time.Duration) (time.Time, error)
+	// Reset forgets the failures of key and lifts its lockout.
+	Reset(key string) (bool, error)
+	// Lockouts lists the keys that are currently locked out.
+	Lockouts() ([]models.LoginLockout, error)
+	// Cleanup drops counters and lockouts that have run out.
+	Cleanup(lockout time.Duration) error
+}
+
+var loginAttempts LoginAttemptStore = newMemoryLoginAttemptStore()
+
+func newLoginAttemptStore(kind string) LoginAttemptStore {
+	switch kind {
+	case "", "postgres":
+		return postgresLoginAttemptStore{}
+	case "memory":
+		return newMemoryLoginAttemptStore()
+	}
+	logger.Fatal("Invalid login attempt store " + kind)
+	return nil
+}
+
+// postgresLoginAttemptStore shares lockouts between instances and keeps them
+// across restarts.
+type postgresLoginAttemptStore struct{}
+
+func (postgresLoginAttemptStore) BlockedUntil(key string) (time.Time, error) {
+	return repository.GetLoginLockout(key)
+}
+
+func (postgresLoginAttemptStore) RegisterFailure(key string, maxAttempts int, lockout time.Duration) (time.Time, error) {
+	return repository.RegisterLoginFailure(key, maxAttempts, lockout)
+}
+
+func (postgresLoginAttemptStore) Reset(key string) (bool, error) {
+	return repository.ClearLoginLockout(key)
+}
+
+func (postgresLoginAttemptStore) Lockouts() ([]models.LoginLockout, error) {
+	return repository.GetLoginLockouts()
+}
+
+func (postgresLoginAttemptStore) Cleanup(lockout time.Duration) error {
+	return repository.DeleteExpiredLoginLockouts(lockout)
+}
+
+// memoryLoginAttemptStore is local to the process. It's meant for tests and
+// single instance setups without a shared database.
+type memoryLoginAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]*models.LoginAttempt
+	now      func() time.Time
+}
+
+func newMemoryLoginAttemptStore() *memoryLoginAttemptStore {
+	return &memoryLoginAttemptStore{attempts: map[string]*models.LoginAttempt{}, now: time.Now}
+}
+
+func (s *memoryLoginAttemptStore) BlockedUntil(key string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if attempt, ok := s.attempts[key]; ok && s.now().Before(attempt.BlockedUntil) {
+		return attempt.BlockedUntil, nil
+	}
+	return time.Time{}, nil
+}
+
+func (s *memoryLoginAttemptStore) RegisterFailure(key string, maxAttempts int, lockout time.Duration) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	attempt, ok := s.attempts[key]
+	if !ok {
+		attempt = &models.LoginAttempt{}
+		s.attempts[key] = attempt
+	}
+	if now.Before(attempt.BlockedUntil) {
+		attempt.Count++
+		return attempt.BlockedUntil, nil
+	}
+	if !attempt.BlockedUntil.IsZero() || !now.Before(attempt.WindowStarted.Add(lockout)) {
+		*attempt = models.LoginAttempt{WindowStarted: now}
+	}
+	attempt.Count++
+	if attempt.Count >= maxAttempts {
+		attempt.BlockedUntil = now.Add(lockout)
+	}
+	return attempt.BlockedUntil, nil
+}
+
+func (s *memoryLoginAttemptStore) Reset(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.attempts[key]
+	delete(s.attempts, key)
+	return ok, nil
+}
+
+func (s *memoryLoginAttemptStore) Lockouts() ([]models.LoginLockout, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	lockouts := []models.LoginLockout{}
+	for key, attempt := range s.attempts {
+		if now.Before(attempt.BlockedUntil) {
+			lockouts = append(lockouts, models.LoginLockout{Key: key, Failures: attempt.Count, BlockedUntil: attempt.BlockedUntil})
+		}
+	}
+	sort.Slice(lockouts, func(i, j int) bool {
+		return lockouts[i].BlockedUntil.After(lockouts[j].BlockedUntil)
+	})
+	return lockouts, nil
+}
+
+func (s *memoryLoginAttemptStore) Cleanup(lockout time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for key, attempt := range s.attempts {
+		if attempt.BlockedUntil.IsZero() && !now.Before(attempt.WindowStarted.Add(lockout)) ||
+			!attempt.BlockedUntil.IsZero() && !now.Before(attempt.BlockedUntil) {
+			delete(s.attempts, key)
+		}
+	}
+	return nil
+}
+
+func usernameLockoutKey(username string) string {
+	return lockoutKey("user:" + username)
+}
+
+func ipLockoutKey(r *http.Request) string {
+	return lockoutKey("ip:" + clientIP(r))
+}
+
+func lockoutKey(key string) string {
+	if len(key) > maxLockoutKeyLength {
+		return key[:maxLockoutKeyLength]
+	}
+	return key
+}
+
+func lockoutDuration() time.Duration {
+	duration, err := time.ParseDuration(config.GetConfig().Security.LockoutDuration)
+	if err != nil {
+		logger.Fatal("Invalid lockout duration format")
+	}
+	return duration
+}
+
+// checkLoginAttempt reports whether neither the username nor the IP of the
+// request is locked out. It fails closed when the store is unavailable.
+func checkLoginAttempt(r *http.Request, username string) bool {
+	for _, key := range []string{usernameLockoutKey(username), ipLockoutKey(r)} {
+		blockedUntil, err := loginAttempts.BlockedUntil(key)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Couldn't check lockout of %s: %s", key, err.Error()))
+			return false
+		}
+		if !blockedUntil.IsZero() {
+			return false
 		}
 	}
 	return true
 }
 
-func registerFailedAttempt(username string) {
-	value, _ := failedLogins.LoadOrStore(username, &models.LoginAttempt{})
-	attempt := value.(*models.LoginAttempt)
-	attempt.Count++
-	if attempt.Count >= config.GetConfig().Security.MaxLoginAttempts {
-		duration, err := time.ParseDuration(config.GetConfig().Security.LockoutDuration)
+// registerFailedAttempt counts a failure against both the username and the IP.
+// The IP limit is higher, it catches one client trying many usernames.
+func registerFailedAttempt(r *http.Request, username string) {
+	cfg := config.GetConfig().Security
+	duration := lockoutDuration()
+	limits := map[string]int{
+		usernameLockoutKey(username): cfg.MaxLoginAttempts,
+		ipLockoutKey(r):              cfg.MaxLoginAttemptsPerIP,
+	}
+	for key, maxAttempts := range limits {
+		if maxAttempts <= 0 {
+			continue
+		}
+		blockedUntil, err := loginAttempts.RegisterFailure(key, maxAttempts, duration)
 		if err != nil {
-			logger.Fatal("Invalid lockout duration format")
+			logger.Error(fmt.Sprintf("Couldn't register failed login of %s: %s", key, err.Error()))
+			continue
+		}
+		if !blockedUntil.IsZero() {
+			logger.Warn(fmt.Sprintf("Login locked out for %s until %s", key, blockedUntil.Format(time.RFC3339)))
 		}
-		attempt.BlockedUntil = time.Now().Add(duration)
 	}
-	failedLogins.Store(username, attempt)
 }
 
+// resetLoginAttempts clears the username after a successful login. The IP
+// counter is kept, otherwise one valid account would let a client keep guessing
+// the passwords of others.
 func resetLoginAttempts(username string) {
-	failedLogins.Delete(username)
+	if _, err := loginAttempts.Reset(usernameLockoutKey(username)); err != nil {
+		logger.Error("Couldn't reset failed logins of " + username + ": " + err.Error())
+	}
 }
@@ -0,0 +1,82 @@
+package transport
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLoginAttemptStore() (*memoryLoginAttemptStore, *time.Time) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := newMemoryLoginAttemptStore()
+	store.now = func() time.Time { return now }
+	return store, &now
+}
+
+func TestMemoryStoreLocksOutAfterMaxAttempts(t *testing.T) {
+	store, now := newTestLoginAttemptStore()
+
+	for i := 0; i < 2; i++ {
+		blockedUntil, err := store.RegisterFailure("user:alice", 3, 5*time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, blockedUntil.IsZero())
+	}
+	blockedUntil, err := store.RegisterFailure("user:alice", 3, 5*time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(5*time.Minute), blockedUntil)
+
+	blockedUntil, _ = store.BlockedUntil("user:alice")
+	assert.False(t, blockedUntil.IsZero())
+	blockedUntil, _ = store.BlockedUntil("user:bob")
+	assert.True(t, blockedUntil.IsZero(), "other keys must not be affected")
+
+	*now = now.Add(5 * time.Minute)
+	blockedUntil, _ = store.BlockedUntil("user:alice")
+	assert.True(t, blockedUntil.IsZero(), "lockout must run out")
+
+	blockedUntil, _ = store.RegisterFailure("user:alice", 3, 5*time.Minute)
+	assert.True(t, blockedUntil.IsZero(), "count must start over after a lockout")
+}
+
+func TestMemoryStoreForgetsOldFailures(t *testing.T) {
+	store, now := newTestLoginAttemptStore()
+
+	store.RegisterFailure("ip:203.0.113.7", 2, time.Minute)
+	*now = now.Add(2 * time.Minute)
+	blockedUntil, _ := store.RegisterFailure("ip:203.0.113.7", 2, time.Minute)
+	assert.True(t, blockedUntil.IsZero(), "failures outside the window must not count")
+
+	blockedUntil, _ = store.RegisterFailure("ip:203.0.113.7", 2, time.Minute)
+	assert.False(t, blockedUntil.IsZero())
+}
+
+func TestMemoryStoreListAndReset(t *testing.T) {
+	store, now := newTestLoginAttemptStore()
+
+	store.RegisterFailure("user:alice", 1, time.Minute)
+	store.RegisterFailure("user:bob", 5, time.Minute)
+
+	lockouts, err := store.Lockouts()
+	assert.NoError(t, err)
+	assert.Len(t, lockouts, 1)
+	assert.Equal(t, "user:alice", lockouts[0].Key)
+	assert.Equal(t, 1, lockouts[0].Failures)
+
+	cleared, _ := store.Reset("user:alice")
+	assert.True(t, cleared)
+	cleared, _ = store.Reset("user:alice")
+	assert.False(t, cleared)
+	lockouts, _ = store.Lockouts()
+	assert.Empty(t, lockouts)
+
+	*now = now.Add(time.Minute)
+	assert.NoError(t, store.Cleanup(time.Minute))
+	assert.Empty(t, store.attempts, "expired counters must be cleaned up")
+}
+
+func TestLockoutKeyIsBounded(t *testing.T) {
+	long := usernameLockoutKey(string(make([]byte, 500)))
+	assert.Len(t, long, maxLockoutKeyLength)
+	assert.Equal(t, "user:alice", usernameLockoutKey("alice"))
+}
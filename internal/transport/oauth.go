@@ -0,0 +1,418 @@
+package transport
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+
+	"gbs/internal/auth"
+	"gbs/internal/models"
+	"gbs/internal/repository"
+	"gbs/pkg/logger"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var templates = template.Must(template.ParseFS(templateFS, "templates/*.html"))
+
+// authorizationRequest holds the parameters of an authorization request. They
+// are carried through the consent form as hidden fields.
+type authorizationRequest struct {
+	ResponseType        string
+	ClientID            string
+	ClientName          string
+	RedirectURI         string
+	Scope               string
+	Scopes              []string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Error               string
+}
+
+// OAuthAuthorize godoc
+// @Summary OAuth2 Authorization Endpoint
+// @Description Authorization code flow with PKCE (S256 only). GET renders the consent screen; POST submits the user's credentials and decision and redirects back to the client with a code or an error.
+// @Tags oauth
+// @Accept x-www-form-urlencoded
+// @Produce html
+// @Param response_type query string true "Must be code"
+// @Param client_id query string true "Client ID"
+// @Param redirect_uri query string true "One of the client's registered redirect uris"
+// @Param scope query string false "Space separated permission names, defaults to all scopes of the client"
+// @Param state query string false "Opaque value returned to the client"
+// @Param code_challenge query string true "BASE64URL(SHA256(code_verifier))"
+// @Param code_challenge_method query string true "Must be S256"
+// @Success 200 {string} string "Consent screen"
+// @Success 302 {string} string "Redirect to the client"
+// @Failure 400 {string} string "Error page"
+// @Router /oauth/authorize [get]
+func OAuthAuthorize(w http.ResponseWriter, r *http.Request) {
+	logger.Info("OAuthAuthorize endpoint hit")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		logger.Warn("OAuthAuthorize: Invalid method " + r.Method)
+		w.Header().Set("Content-Type", "application/json")
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	values := r.URL.Query()
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			logger.Error("OAuthAuthorize: Invalid form: " + err.Error())
+			renderTemplate(w, http.StatusBadRequest, "oauth_error.html", authorizationRequest{Error: "Invalid request"})
+			return
+		}
+		values = r.PostForm
+	}
+	req := authorizationRequest{
+		ResponseType:        values.Get("response_type"),
+		ClientID:            values.Get("client_id"),
+		RedirectURI:         values.Get("redirect_uri"),
+		Scope:               values.Get("scope"),
+		State:               values.Get("state"),
+		CodeChallenge:       values.Get("code_challenge"),
+		CodeChallengeMethod: values.Get("code_challenge_method"),
+	}
+
+	// Without a known client and redirect uri there is nowhere safe to
+	// redirect to, so those errors are shown to the user instead.
+	client, err := auth.GetOAuthClient(req.ClientID)
+	if err != nil {
+		logger.Error("OAuthAuthorize: Unknown client: " + err.Error())
+		renderTemplate(w, http.StatusBadRequest, "oauth_error.html", authorizationRequest{Error: "Unknown client"})
+		return
+	}
+	if !auth.ValidRedirectURI(client, req.RedirectURI) {
+		logger.Error("OAuthAuthorize: Redirect uri is not registered for client " + req.ClientID)
+		renderTemplate(w, http.StatusBadRequest, "oauth_error.html", authorizationRequest{Error: "Invalid redirect uri"})
+		return
+	}
+	req.ClientName = client.Name
+
+	if req.ResponseType != "code" {
+		redirectWithError(w, r, req, auth.OAuthUnsupportedResponseType, "only the code response type is supported")
+		return
+	}
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+		redirectWithError(w, r, req, auth.OAuthInvalidRequest, "PKCE with the S256 method is required")
+		return
+	}
+	req.Scopes, err = auth.RequestedScopes(client, req.Scope)
+	if err != nil {
+		redirectWithError(w, r, req, auth.OAuthInvalidScope, err.Error())
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		logger.Debug(fmt.Sprintf("OAuthAuthorize: Showing consent screen for client %s", req.ClientID))
+		renderTemplate(w, http.StatusOK, "consent.html", req)
+		return
+	}
+
+	if values.Get("action") != "approve" {
+		logger.Info(fmt.Sprintf("OAuthAuthorize: Access denied for client %s", req.ClientID))
+		redirectWithError(w, r, req, auth.OAuthAccessDenied, "the user denied the request")
+		return
+	}
+
+	username := values.Get("username")
+	if !checkLoginAttempt(r, username) {
+		logger.Warn("OAuthAuthorize: Too many login attempts for username: " + username)
+		req.Error = "Too many login attempts, try again later"
+		renderTemplate(w, http.StatusUnauthorized, "consent.html", req)
+		return
+	}
+	userID, err := auth.VerifyCredentials(username, values.Get("password"), values.Get("code"))
+	if err != nil {
+		logger.Error("OAuthAuthorize: Authentication failed for username: " + username + " - " + err.Error())
+		registerFailedAttempt(r, username)
+		req.Error = "Invalid credentials"
+		renderTemplate(w, http.StatusUnauthorized, "consent.html", req)
+		return
+	}
+	resetLoginAttempts(username)
+
+	code, err := auth.CreateAuthorizationCode(client, userID, req.RedirectURI, req.Scopes, req.CodeChallenge)
+	if err != nil {
+		logger.Error("OAuthAuthorize: Failed to create authorization code: " + err.Error())
+		var oauthErr *auth.OAuthError
+		if errors.As(err, &oauthErr) {
+			redirectWithError(w, r, req, oauthErr.Code, oauthErr.Description)
+			return
+		}
+		redirectWithError(w, r, req, auth.OAuthServerError, "failed to create authorization code")
+		return
+	}
+	logger.Info(fmt.Sprintf("OAuthAuthorize: userID=%d authorized client %s", userID, req.ClientID))
+	redirectToClient(w, r, req, url.Values{"code": {code}})
+}
+
+// OAuthToken godoc
+// @Summary OAuth2 Token Endpoint
+// @Description Exchange an authorization code (with its PKCE code_verifier), client credentials or a refresh token for an access token. Confidential clients authenticate with HTTP Basic or client_id and client_secret form fields.
+// @Tags oauth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param grant_type formData string true "authorization_code, client_credentials or refresh_token"
+// @Param client_id formData string false "Client ID, unless HTTP Basic is used"
+// @Param client_secret formData string false "Client secret of confidential clients, unless HTTP Basic is used"
+// @Param code formData string false "Authorization code"
+// @Param redirect_uri formData string false "Redirect uri used in the authorization request"
+// @Param code_verifier formData string false "PKCE code verifier"
+// @Param refresh_token formData string false "Refresh token"
+// @Param scope formData string false "Requested scopes for client_credentials"
+// @Success 200 {object} models.OAuthTokenResponse
+// @Failure 400 {object} models.OAuthErrorResponse
+// @Failure 401 {object} models.OAuthErrorResponse
+// @Router /oauth/token [post]
+func OAuthToken(w http.ResponseWriter, r *http.Request) {
+	logger.Info("OAuthToken endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	if r.Method != http.MethodPost {
+		logger.Warn("OAuthToken: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := r.ParseForm(); err != nil {
+		logger.Error("OAuthToken: Invalid form: " + err.Error())
+		oauthErrorResponse(w, &auth.OAuthError{Code: auth.OAuthInvalidRequest, Description: "invalid form"})
+		return
+	}
+
+	clientID, clientSecret, basic := r.BasicAuth()
+	if !basic {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	client, err := auth.AuthenticateOAuthClient(clientID, clientSecret)
+	if err != nil {
+		logger.Error("OAuthToken: Client authentication failed: " + err.Error())
+		if basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="gbs"`)
+		}
+		oauthErrorResponse(w, err)
+		return
+	}
+
+	grantType := r.PostForm.Get("grant_type")
+	logger.Debug(fmt.Sprintf("OAuthToken: grant_type=%s, client=%s", grantType, client.ClientID))
+	var resp models.OAuthTokenResponse
+	switch grantType {
+	case "authorization_code":
+		resp, err = auth.ExchangeAuthorizationCode(client, r.PostForm.Get("code"), r.PostForm.Get("redirect_uri"), r.PostForm.Get("code_verifier"), clientInfo(r))
+	case "client_credentials":
+		resp, err = auth.ClientCredentialsToken(client, r.PostForm.Get("scope"))
+	case "refresh_token":
+		resp, err = auth.RefreshOAuthToken(client, r.PostForm.Get("refresh_token"))
+	default:
+		err = &auth.OAuthError{Code: auth.OAuthUnsupportedGrantType, Description: "unsupported grant type " + grantType}
+	}
+	if err != nil {
+		logger.Error("OAuthToken: Failed to issue token: " + err.Error())
+		oauthErrorResponse(w, err)
+		return
+	}
+	logger.Info(fmt.Sprintf("OAuthToken: Token issued to client %s", client.ClientID))
+	json.NewEncoder(w).Encode(resp)
+}
+
+// CreateOAuthClient godoc
+// @Summary Register OAuth Client
+// @Description Register an OAuth2 client owned by the current user. Scopes are permission names the client may ask for. Confidential clients receive a secret, returned only once, and may use the client credentials grant to act as the owner.
+// @Tags oauth
+// @Accept json
+// @Produce json
+// @Param body body models.CreateOAuthClientRequest true "Client details"
+// @Success 200 {object} models.CreateOAuthClientResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/createOAuthClient [post]
+func CreateOAuthClient(w http.ResponseWriter, r *http.Request) {
+	logger.Info("CreateOAuthClient endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("CreateOAuthClient: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	userID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("CreateOAuthClient: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.CreateOAuthClientRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("CreateOAuthClient: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("CreateOAuthClient: Registering client %q for userID=%d", req.Name, userID))
+	clientID, secret, err := auth.RegisterOAuthClient(userID, req.Name, req.RedirectURIs, req.Scopes, req.Confidential)
+	if err != nil {
+		logger.Error("CreateOAuthClient: Operation failed: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info("CreateOAuthClient: Client registered successfully")
+	json.NewEncoder(w).Encode(models.CreateOAuthClientResponse{ClientID: clientID, ClientSecret: secret})
+}
+
+// GetOAuthClients godoc
+// @Summary Get OAuth Clients
+// @Description List OAuth2 clients registered by a user. Viewing other users' clients requires administrator or control_user_accounts permission.
+// @Tags oauth
+// @Accept json
+// @Produce json
+// @Param id query int true "Target user ID"
+// @Success 200 {object} models.OAuthClientsResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/getOAuthClients [get]
+func GetOAuthClients(w http.ResponseWriter, r *http.Request) {
+	logger.Info("GetOAuthClients endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		logger.Warn("GetOAuthClients: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	targetUserID, err := parseQueryInt(r, "id")
+	if err != nil {
+		logger.Error("GetOAuthClients: Missing or invalid id parameter")
+		errorResponse(w, http.StatusBadRequest, "Missing or invalid id parameter")
+		return
+	}
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("GetOAuthClients: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("GetOAuthClients: targetUserID=%d, initiatorID=%d", targetUserID, initiatorID))
+	clients, err := repository.GetOAuthClients(initiatorID, targetUserID)
+	if err != nil {
+		logger.Error("GetOAuthClients: Failed to get oauth clients: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Failed to get oauth clients")
+		return
+	}
+	logger.Info("GetOAuthClients: OAuth clients successfully fetched")
+	json.NewEncoder(w).Encode(models.OAuthClientsResponse{Clients: clients})
+}
+
+// RevokeOAuthClient godoc
+// @Summary Revoke OAuth Client
+// @Description Revoke an OAuth2 client together with every refresh token issued to it. Revoking other users' clients requires administrator or control_user_accounts permission.
+// @Tags oauth
+// @Accept json
+// @Produce json
+// @Param body body models.RevokeOAuthClientRequest true "Client to revoke"
+// @Success 200 {string} string "OK"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/revokeOAuthClient [post]
+func RevokeOAuthClient(w http.ResponseWriter, r *http.Request) {
+	logger.Info("RevokeOAuthClient endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("RevokeOAuthClient: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("RevokeOAuthClient: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.RevokeOAuthClientRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("RevokeOAuthClient: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("RevokeOAuthClient: Revoking client %s of userID=%d by initiatorID=%d", req.ClientID, req.UserID, initiatorID))
+	err := repository.RevokeOAuthClient(initiatorID, req.UserID, req.ClientID)
+	recordAudit(r, "revoke_oauth_client", req.UserID, map[string]interface{}{"client_id": req.ClientID}, err)
+	if err != nil {
+		logger.Error("RevokeOAuthClient: Operation failed: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info("RevokeOAuthClient: Client revoked successfully")
+	w.WriteHeader(http.StatusOK)
+}
+
+// renderTemplate is an internal helper that renders an html page.
+func renderTemplate(w http.ResponseWriter, statusCode int, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(statusCode)
+	if err := templates.ExecuteTemplate(w, name, data); err != nil {
+		logger.Error("Failed to render template " + name + ": " + err.Error())
+	}
+}
+
+// redirectToClient is an internal helper that sends the user agent back to the
+// client's redirect uri with the given parameters and the request state.
+func redirectToClient(w http.ResponseWriter, r *http.Request, req authorizationRequest, params url.Values) {
+	target, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		renderTemplate(w, http.StatusBadRequest, "oauth_error.html", authorizationRequest{Error: "Invalid redirect uri"})
+		return
+	}
+	query := target.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	target.RawQuery = query.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+func redirectWithError(w http.ResponseWriter, r *http.Request, req authorizationRequest, code, description string) {
+	logger.Debug(fmt.Sprintf("OAuthAuthorize: Redirecting with error %s: %s", code, description))
+	redirectToClient(w, r, req, url.Values{"error": {code}, "error_description": {description}})
+}
+
+// oauthErrorResponse is an internal helper that sends an RFC 6749 error.
+func oauthErrorResponse(w http.ResponseWriter, err error) {
+	var oauthErr *auth.OAuthError
+	if !errors.As(err, &oauthErr) {
+		oauthErr = &auth.OAuthError{Code: auth.OAuthServerError, Description: "internal error"}
+	}
+	status := http.StatusBadRequest
+	switch oauthErr.Code {
+	case auth.OAuthInvalidClient:
+		status = http.StatusUnauthorized
+	case auth.OAuthServerError:
+		status = http.StatusInternalServerError
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(models.OAuthErrorResponse{Error: oauthErr.Code, ErrorDescription: oauthErr.Description})
+}
@@ -0,0 +1,288 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"gbs/internal/auth"
+	"gbs/internal/models"
+	"gbs/internal/repository"
+	"gbs/pkg/logger"
+)
+
+// requireAccountAccess lets users act on their own account and on
+// organizations they hold one of roles in, any role if none are given.
+// Acting on other accounts needs permission.
+func requireAccountAccess(w http.ResponseWriter, r *http.Request, accountID int, permission string, roles ...string) bool {
+	userID, _ := r.Context().Value(userIDKey).(int)
+	if accountID == userID || auth.ActsForOrganization(accountID, userID, roles...) {
+		return true
+	}
+	return requirePermission(w, r, permission)
+}
+
+// CreateOrganization godoc
+// @Summary Create Organization
+// @Description Open an organization account shared by several users, with the current user as its first admin. Organizations can't log in. They receive funds like a user and members spend from it through transaction with from set to its ID. The organization gets send_funds and receive_funds only if the creator holds them. Requires create_organizations permission.
+// @Tags organizations
+// @Accept json
+// @Produce json
+// @Param body body models.CreateOrganizationRequest true "Organization name"
+// @Success 200 {object} models.CreateOrganizationResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /api/v1/createOrganization [post]
+func CreateOrganization(w http.ResponseWriter, r *http.Request) {
+	logger.Info("CreateOrganization endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("CreateOrganization: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("CreateOrganization: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.CreateOrganizationRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("CreateOrganization: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if !requirePermission(w, r, "create_organizations") {
+		return
+	}
+
+	organizationID, err := auth.CreateOrganization(initiatorID, req.Name)
+	if err != nil {
+		logger.Error("CreateOrganization: Failed to create organization: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info(fmt.Sprintf("CreateOrganization: Organization %d created by userID=%d", organizationID, initiatorID))
+	json.NewEncoder(w).Encode(models.CreateOrganizationResponse{OrganizationID: organizationID})
+}
+
+// GetOrganizations godoc
+// @Summary Get Organizations
+// @Description Organizations the current user is a member of, with their role.
+// @Tags organizations
+// @Produce json
+// @Success 200 {object} models.OrganizationsResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/getOrganizations [get]
+func GetOrganizations(w http.ResponseWriter, r *http.Request) {
+	logger.Info("GetOrganizations endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		logger.Warn("GetOrganizations: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("GetOrganizations: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	organizations, err := repository.GetOrganizations(initiatorID)
+	if err != nil {
+		logger.Error("GetOrganizations: Failed to get organizations: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	json.NewEncoder(w).Encode(models.OrganizationsResponse{Organizations: organizations})
+}
+
+// GetOrganizationMembers godoc
+// @Summary Get Organization Members
+// @Description Members of an organization with their roles, the daily spending limits of spenders and how much of them was spent during the last 24 hours. Available to members and to users with audit_funds permission.
+// @Tags organizations
+// @Produce json
+// @Param id query int true "Organization ID"
+// @Success 200 {object} models.OrganizationMembersResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/getOrganizationMembers [get]
+func GetOrganizationMembers(w http.ResponseWriter, r *http.Request) {
+	logger.Info("GetOrganizationMembers endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		logger.Warn("GetOrganizationMembers: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("GetOrganizationMembers: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	organizationID, err := parseQueryInt(r, "id")
+	if err != nil {
+		logger.Error("GetOrganizationMembers: Missing or invalid id parameter")
+		errorResponse(w, http.StatusBadRequest, "Missing or invalid id parameter")
+		return
+	}
+
+	members, err := repository.GetOrganizationMembers(initiatorID, organizationID)
+	if err != nil {
+		logger.Error("GetOrganizationMembers: Failed to get members: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	json.NewEncoder(w).Encode(models.OrganizationMembersResponse{Members: members})
+}
+
+// SetOrganizationMember godoc
+// @Summary Set Organization Member
+// @Description Add a user to an organization or change their role: viewer (reads balances and history), spender (also transfers within daily limits) or admin (transfers without limits and manages members). An organization always keeps at least one admin. Requires the admin role in the organization.
+// @Tags organizations
+// @Accept json
+// @Produce json
+// @Param body body models.OrganizationMemberRequest true "Organization, user and role"
+// @Success 200 {string} string "OK"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/setOrganizationMember [post]
+func SetOrganizationMember(w http.ResponseWriter, r *http.Request) {
+	logger.Info("SetOrganizationMember endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("SetOrganizationMember: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("SetOrganizationMember: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.OrganizationMemberRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("SetOrganizationMember: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	err := auth.SetOrganizationMember(initiatorID, req.OrganizationID, req.UserID, req.Role)
+	recordAudit(r, "set_organization_member", req.UserID, map[string]interface{}{"organization_id": req.OrganizationID, "role": req.Role}, err)
+	if err != nil {
+		logger.Error("SetOrganizationMember: Failed to set member: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info(fmt.Sprintf("SetOrganizationMember: userID=%d is %s of organization %d", req.UserID, req.Role, req.OrganizationID))
+	w.WriteHeader(http.StatusOK)
+}
+
+// RemoveOrganizationMember godoc
+// @Summary Remove Organization Member
+// @Description Remove a member and their spending limits from an organization. Requires the admin role in the organization, members can always remove themselves. The last admin can't leave.
+// @Tags organizations
+// @Accept json
+// @Produce json
+// @Param body body models.OrganizationMemberRequest true "Organization and user"
+// @Success 200 {string} string "OK"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/removeOrganizationMember [post]
+func RemoveOrganizationMember(w http.ResponseWriter, r *http.Request) {
+	logger.Info("RemoveOrganizationMember endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("RemoveOrganizationMember: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("RemoveOrganizationMember: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.OrganizationMemberRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("RemoveOrganizationMember: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	err := repository.RemoveOrganizationMember(initiatorID, req.OrganizationID, req.UserID)
+	recordAudit(r, "remove_organization_member", req.UserID, map[string]interface{}{"organization_id": req.OrganizationID}, err)
+	if err != nil {
+		logger.Error("RemoveOrganizationMember: Failed to remove member: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info(fmt.Sprintf("RemoveOrganizationMember: userID=%d removed from organization %d", req.UserID, req.OrganizationID))
+	w.WriteHeader(http.StatusOK)
+}
+
+// SetOrganizationSpendLimit godoc
+// @Summary Set Organization Spending Limit
+// @Description Set how much a spender can transfer from the organization in a currency within 24 hours. Spenders can't spend currencies without a limit. Requires the admin role in the organization.
+// @Tags organizations
+// @Accept json
+// @Produce json
+// @Param body body models.SpendLimitRequest true "Spender, currency and daily limit"
+// @Success 200 {string} string "OK"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/setOrganizationSpendLimit [post]
+func SetOrganizationSpendLimit(w http.ResponseWriter, r *http.Request) {
+	logger.Info("SetOrganizationSpendLimit endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("SetOrganizationSpendLimit: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("SetOrganizationSpendLimit: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.SpendLimitRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("SetOrganizationSpendLimit: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	err := auth.SetOrganizationSpendLimit(initiatorID, req)
+	recordAudit(r, "set_organization_spend_limit", req.UserID, req, err)
+	if err != nil {
+		logger.Error("SetOrganizationSpendLimit: Failed to set limit: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info(fmt.Sprintf("SetOrganizationSpendLimit: userID=%d can spend %d %s a day from organization %d", req.UserID, req.DailyLimit, req.Currency, req.OrganizationID))
+	w.WriteHeader(http.StatusOK)
+}
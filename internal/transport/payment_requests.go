@@ -0,0 +1,310 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"gbs/internal/auth"
+	"gbs/internal/models"
+	"gbs/internal/repository"
+	"gbs/pkg/logger"
+)
+
+// CreatePaymentRequest godoc
+// @Summary Create Payment Request
+// @Description Ask another user to pay the current user. The payer can accept the request, which transfers the money like the transaction endpoint, or decline it. The requester can cancel it while it is pending. expires_at is optional.
+// @Tags payment requests
+// @Accept json
+// @Produce json
+// @Param body body models.CreatePaymentRequestRequest true "Payment request"
+// @Success 200 {object} models.CreatePaymentRequestResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/createPaymentRequest [post]
+func CreatePaymentRequest(w http.ResponseWriter, r *http.Request) {
+	logger.Info("CreatePaymentRequest endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("CreatePaymentRequest: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("CreatePaymentRequest: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.CreatePaymentRequestRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("CreatePaymentRequest: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("CreatePaymentRequest: from %d to payer %d, currency: %s, amount: %d", initiatorID, req.PayerID, req.Currency, req.Amount))
+	requestID, err := auth.CreatePaymentRequest(initiatorID, req.PayerID, req.Currency, req.Amount, req.Memo, req.ExpiresAt)
+	if err != nil {
+		logger.Error("CreatePaymentRequest: Failed to create payment request: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info(fmt.Sprintf("CreatePaymentRequest: Payment request %d created", requestID))
+	json.NewEncoder(w).Encode(models.CreatePaymentRequestResponse{RequestID: requestID})
+}
+
+// GetPaymentRequest godoc
+// @Summary Get Payment Request
+// @Description A payment request the current user sent or has to pay.
+// @Tags payment requests
+// @Produce json
+// @Param id query int true "Payment request ID"
+// @Success 200 {object} models.PaymentRequest
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/getPaymentRequest [get]
+func GetPaymentRequest(w http.ResponseWriter, r *http.Request) {
+	logger.Info("GetPaymentRequest endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		logger.Warn("GetPaymentRequest: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("GetPaymentRequest: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	requestID, err := parseQueryInt(r, "id")
+	if err != nil {
+		logger.Error("GetPaymentRequest: Missing or invalid id parameter")
+		errorResponse(w, http.StatusBadRequest, "Missing or invalid id parameter")
+		return
+	}
+
+	request, err := repository.GetPaymentRequest(initiatorID, requestID)
+	if err != nil {
+		logger.Error("GetPaymentRequest: Failed to get payment request: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info("GetPaymentRequest: Payment request successfully fetched")
+	json.NewEncoder(w).Encode(request)
+}
+
+// GetIncomingPaymentRequests godoc
+// @Summary Get Incoming Payment Requests
+// @Description Payment requests the current user has to pay, newest first. status filters by pending, paid, declined, cancelled or expired.
+// @Tags payment requests
+// @Produce json
+// @Param page query int true "Page number"
+// @Param status query string false "Request status"
+// @Success 200 {object} models.PaymentRequestsResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/getIncomingPaymentRequests [get]
+func GetIncomingPaymentRequests(w http.ResponseWriter, r *http.Request) {
+	listPaymentRequests(w, r, "GetIncomingPaymentRequests", true)
+}
+
+// GetOutgoingPaymentRequests godoc
+// @Summary Get Outgoing Payment Requests
+// @Description Payment requests the current user sent, newest first. status filters by pending, paid, declined, cancelled or expired.
+// @Tags payment requests
+// @Produce json
+// @Param page query int true "Page number"
+// @Param status query string false "Request status"
+// @Success 200 {object} models.PaymentRequestsResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/getOutgoingPaymentRequests [get]
+func GetOutgoingPaymentRequests(w http.ResponseWriter, r *http.Request) {
+	listPaymentRequests(w, r, "GetOutgoingPaymentRequests", false)
+}
+
+func listPaymentRequests(w http.ResponseWriter, r *http.Request, name string, incoming bool) {
+	logger.Info(name + " endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		logger.Warn(name + ": Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error(name + ": Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	page, err := parseQueryInt(r, "page")
+	if err != nil {
+		logger.Error(name + ": Missing or invalid page parameter")
+		errorResponse(w, http.StatusBadRequest, "Missing or invalid page parameter")
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && !validPaymentRequestStatus(status) {
+		logger.Error(name + ": Invalid status parameter " + status)
+		errorResponse(w, http.StatusBadRequest, "invalid status parameter")
+		return
+	}
+
+	limit, offset := parsePage(page)
+	logger.Debug(fmt.Sprintf("%s: initiatorID=%d, status=%s, limit=%d, offset=%d", name, initiatorID, status, limit, offset))
+	requests, err := repository.GetPaymentRequests(initiatorID, incoming, status, limit, offset)
+	if err != nil {
+		logger.Error(name + ": Failed to get payment requests: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info(name + ": Payment requests successfully fetched")
+	json.NewEncoder(w).Encode(models.PaymentRequestsResponse{Requests: requests})
+}
+
+func validPaymentRequestStatus(status string) bool {
+	for _, s := range auth.PaymentRequestStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// AcceptPaymentRequest godoc
+// @Summary Accept Payment Request
+// @Description Pay a pending payment request addressed to the current user. The money is transferred like with the transaction endpoint, including fees and send_funds permission, and a signed receipt is returned.
+// @Tags payment requests
+// @Accept json
+// @Produce json
+// @Param body body models.PaymentRequestActionRequest true "Payment request to pay"
+// @Success 200 {object} models.TransactionReceipt
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /api/v1/acceptPaymentRequest [post]
+func AcceptPaymentRequest(w http.ResponseWriter, r *http.Request) {
+	logger.Info("AcceptPaymentRequest endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("AcceptPaymentRequest: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("AcceptPaymentRequest: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.PaymentRequestActionRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("AcceptPaymentRequest: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if !requirePermission(w, r, "send_funds") {
+		return
+	}
+
+	request, err := repository.GetPaymentRequest(initiatorID, req.RequestID)
+	if err != nil {
+		logger.Error("AcceptPaymentRequest: Failed to get payment request: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	claims, _ := r.Context().Value(claimsKey).(*auth.AccessClaims)
+	if err = auth.ReserveTransfer(claims, request.Currency, int(request.Amount)); err != nil {
+		logger.Warn("AcceptPaymentRequest: Rejected by token limits: " + err.Error())
+		errorResponse(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("AcceptPaymentRequest: Paying request %d of %d by %d", req.RequestID, request.RequesterID, initiatorID))
+	receipt, err := repository.AcceptPaymentRequest(initiatorID, req.RequestID)
+	if err != nil {
+		auth.ReleaseTransfer(claims, request.Currency, int(request.Amount))
+		logger.Error("AcceptPaymentRequest: Payment failed: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info(fmt.Sprintf("AcceptPaymentRequest: Payment request %d paid", req.RequestID))
+	json.NewEncoder(w).Encode(auth.SignReceipt(receipt))
+}
+
+// DeclinePaymentRequest godoc
+// @Summary Decline Payment Request
+// @Description Decline a pending payment request addressed to the current user.
+// @Tags payment requests
+// @Accept json
+// @Produce json
+// @Param body body models.PaymentRequestActionRequest true "Payment request to decline"
+// @Success 200 {string} string "OK"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/declinePaymentRequest [post]
+func DeclinePaymentRequest(w http.ResponseWriter, r *http.Request) {
+	resolvePaymentRequest(w, r, "DeclinePaymentRequest", repository.DeclinePaymentRequest)
+}
+
+// CancelPaymentRequest godoc
+// @Summary Cancel Payment Request
+// @Description Cancel a pending payment request the current user sent.
+// @Tags payment requests
+// @Accept json
+// @Produce json
+// @Param body body models.PaymentRequestActionRequest true "Payment request to cancel"
+// @Success 200 {string} string "OK"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/cancelPaymentRequest [post]
+func CancelPaymentRequest(w http.ResponseWriter, r *http.Request) {
+	resolvePaymentRequest(w, r, "CancelPaymentRequest", repository.CancelPaymentRequest)
+}
+
+func resolvePaymentRequest(w http.ResponseWriter, r *http.Request, name string, resolve func(initiatorID, requestID int) error) {
+	logger.Info(name + " endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn(name + ": Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error(name + ": Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.PaymentRequestActionRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error(name + ": Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := resolve(initiatorID, req.RequestID); err != nil {
+		logger.Error(name + ": Failed: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info(fmt.Sprintf("%s: Payment request %d resolved", name, req.RequestID))
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,310 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"gbs/internal/auth"
+	"gbs/internal/models"
+	"gbs/internal/repository"
+	"gbs/pkg/logger"
+)
+
+// CreatePermission godoc
+// @Summary Register Custom Permission
+// @Description Register a plugin permission such as "shop.manage_orders". It is granted with modifyPermission and roles like the built-in ones. Requires administrator permission.
+// @Tags permissions
+// @Accept json
+// @Produce json
+// @Param body body models.CreatePermissionRequest true "Namespaced name, description and whether only administrators may grant it"
+// @Success 201 {object} models.CreatePermissionResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /api/v1/createPermission [post]
+func CreatePermission(w http.ResponseWriter, r *http.Request) {
+	logger.Info("CreatePermission endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("CreatePermission: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("CreatePermission: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if !requirePermission(w, r, "administrator") {
+		return
+	}
+
+	var req models.CreatePermissionRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("CreatePermission: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("CreatePermission: Registering %s by initiatorID=%d", req.Name, initiatorID))
+	permissionID, err := auth.RegisterPermission(initiatorID, req.Name, req.Description, req.AdminOnly)
+	recordAudit(r, "create_permission", 0, map[string]interface{}{"permission_id": permissionID, "name": req.Name, "admin_only": req.AdminOnly}, err)
+	if err != nil {
+		logger.Error("CreatePermission: Operation failed: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info("CreatePermission: Permission registered successfully")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.CreatePermissionResponse{PermissionID: permissionID})
+}
+
+// DeletePermission godoc
+// @Summary Delete Custom Permission
+// @Description Delete a custom permission and take it away from every user, role, API key and OAuth client. Built-in permissions can't be deleted. Requires administrator permission.
+// @Tags permissions
+// @Accept json
+// @Produce json
+// @Param body body models.DeletePermissionRequest true "Permission to delete"
+// @Success 200 {string} string "OK"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /api/v1/deletePermission [post]
+func DeletePermission(w http.ResponseWriter, r *http.Request) {
+	logger.Info("DeletePermission endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("DeletePermission: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("DeletePermission: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if !requirePermission(w, r, "administrator") {
+		return
+	}
+
+	var req models.DeletePermissionRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("DeletePermission: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("DeletePermission: Deleting permission %d by initiatorID=%d", req.PermissionID, initiatorID))
+	err := auth.DeletePermission(initiatorID, req.PermissionID)
+	recordAudit(r, "delete_permission", 0, map[string]interface{}{"permission_id": req.PermissionID}, err)
+	if err != nil {
+		logger.Error("DeletePermission: Operation failed: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info("DeletePermission: Permission deleted successfully")
+	w.WriteHeader(http.StatusOK)
+}
+
+// HasPermission godoc
+// @Summary Check User Permission
+// @Description Check whether a user holds a permission, directly or through a role. Administrators hold every permission.
+// @Tags users, permissions
+// @Accept json
+// @Produce json
+// @Param id query int true "User ID"
+// @Param permission query string true "Permission name, e.g. shop.manage_orders"
+// @Success 200 {object} models.HasPermissionResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/hasPermission [get]
+func HasPermission(w http.ResponseWriter, r *http.Request) {
+	logger.Info("HasPermission endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		logger.Warn("HasPermission: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	userID, err := parseQueryInt(r, "id")
+	if err != nil {
+		logger.Error("HasPermission: Missing or invalid id parameter")
+		errorResponse(w, http.StatusBadRequest, "Missing or invalid id parameter")
+		return
+	}
+	permission := r.URL.Query().Get("permission")
+	if permission == "" {
+		logger.Error("HasPermission: Missing permission parameter")
+		errorResponse(w, http.StatusBadRequest, "Missing permission parameter")
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("HasPermission: Checking %s for userID=%d", permission, userID))
+	allowed, err := repository.HasPermission(userID, permission)
+	if err != nil {
+		logger.Error("HasPermission: Failed to check permission: " + err.Error())
+		errorResponse(w, http.StatusInternalServerError, "Failed to check permission")
+		return
+	}
+	logger.Info("HasPermission: Permission successfully checked")
+	json.NewEncoder(w).Encode(models.HasPermissionResponse{HasPermission: allowed})
+}
+
+// GetPermissionHistory godoc
+// @Summary Get Permission History
+// @Description Retrieve the grants, revocations and expiries of a user's direct permissions, newest first. Users can read their own history; reading someone else's requires manage_user_permissions or audit_funds permission.
+// @Tags users, permissions
+// @Accept json
+// @Produce json
+// @Param id query int true "User ID"
+// @Param page query int true "Page number"
+// @Success 200 {object} models.PermissionHistoryResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /api/v1/getPermissionHistory [get]
+func GetPermissionHistory(w http.ResponseWriter, r *http.Request) {
+	logger.Info("GetPermissionHistory endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		logger.Warn("GetPermissionHistory: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	targetUserID, err := parseQueryInt(r, "id")
+	if err != nil {
+		logger.Error("GetPermissionHistory: Missing or invalid id parameter")
+		errorResponse(w, http.StatusBadRequest, "Missing or invalid id parameter")
+		return
+	}
+
+	page, err := parseQueryInt(r, "page")
+	if err != nil {
+		logger.Error("GetPermissionHistory: Missing or invalid page parameter")
+		errorResponse(w, http.StatusBadRequest, "Missing or invalid page parameter")
+		return
+	}
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("GetPermissionHistory: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	if targetUserID != initiatorID && !requirePermission(w, r, "manage_user_permissions", "audit_funds") {
+		return
+	}
+
+	limit, offset := parsePage(page)
+	logger.Debug(fmt.Sprintf("GetPermissionHistory: targetUserID=%d, initiatorID=%d, limit=%d, offset=%d", targetUserID, initiatorID, limit, offset))
+	changes, err := repository.GetPermissionHistory(initiatorID, targetUserID, limit, offset)
+	if err != nil {
+		logger.Error("GetPermissionHistory: Failed to get permission history: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info("GetPermissionHistory: Permission history successfully fetched")
+	json.NewEncoder(w).Encode(models.PermissionHistoryResponse{Changes: changes})
+}
+
+// GetPermissions godoc
+// @Summary Get Permissions
+// @Description List all permissions, built-in and custom, with their names and descriptions.
+// @Tags permissions
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.PermissionsResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/getPermissions [get]
+func GetPermissions(w http.ResponseWriter, r *http.Request) {
+	logger.Info("GetPermissions endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		logger.Warn("GetPermissions: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	permissions, err := repository.GetPermissions()
+	if err != nil {
+		logger.Error("GetPermissions: Failed to get permissions: " + err.Error())
+		errorResponse(w, http.StatusInternalServerError, "Failed to get permissions")
+		return
+	}
+	logger.Info("GetPermissions: Permissions successfully fetched")
+	json.NewEncoder(w).Encode(models.PermissionsResponse{Permissions: permissions})
+}
+
+// GetPermissionHolders godoc
+// @Summary Get Permission Holders
+// @Description List the users holding a permission, one entry per direct grant or role granting it. Administrators aren't listed unless granted it. Requires manage_user_permissions or audit_funds permission.
+// @Tags permissions
+// @Accept json
+// @Produce json
+// @Param permission query string true "Permission name"
+// @Param page query int true "Page number"
+// @Success 200 {object} models.PermissionHoldersResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /api/v1/getPermissionHolders [get]
+func GetPermissionHolders(w http.ResponseWriter, r *http.Request) {
+	logger.Info("GetPermissionHolders endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		logger.Warn("GetPermissionHolders: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	permission := r.URL.Query().Get("permission")
+	if permission == "" {
+		logger.Error("GetPermissionHolders: Missing permission parameter")
+		errorResponse(w, http.StatusBadRequest, "Missing permission parameter")
+		return
+	}
+
+	page, err := parseQueryInt(r, "page")
+	if err != nil {
+		logger.Error("GetPermissionHolders: Missing or invalid page parameter")
+		errorResponse(w, http.StatusBadRequest, "Missing or invalid page parameter")
+		return
+	}
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("GetPermissionHolders: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if !requirePermission(w, r, "manage_user_permissions", "audit_funds") {
+		return
+	}
+
+	limit, offset := parsePage(page)
+	logger.Debug(fmt.Sprintf("GetPermissionHolders: permission=%s, initiatorID=%d, limit=%d, offset=%d", permission, initiatorID, limit, offset))
+	holders, err := repository.GetPermissionHolders(initiatorID, permission, limit, offset)
+	if err != nil {
+		logger.Error("GetPermissionHolders: Failed to get permission holders: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info("GetPermissionHolders: Permission holders successfully fetched")
+	json.NewEncoder(w).Encode(models.PermissionHoldersResponse{Holders: holders})
+}
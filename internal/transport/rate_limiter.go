@@ -1,7 +1,9 @@
 package transport
 
 import (
-	"net"
+	"fmt"
+	"hash/fnv"
+	"math"
 	"net/http"
 	"strconv"
 	"sync"
@@ -9,77 +11,307 @@ import (
 
 	"gbs/internal/config"
 	"gbs/internal/models"
-	lru "github.com/hashicorp/golang-lru/v2"
+	"gbs/internal/repository"
+	"gbs/pkg/logger"
 )
 
+// Subjects a rate limit policy can be keyed by.
+const (
+	rateLimitByIP     = "ip"
+	rateLimitByUser   = "user"
+	rateLimitByAPIKey = "api_key"
+)
+
+const (
+	rateLimitShards          = 32
+	rateLimitCleanupInterval = 5 * time.Minute
+)
+
+// RateLimitPolicy is a token bucket that holds up to Burst requests and is
+// refilled at RequestsPerMinute.
+type RateLimitPolicy struct {
+	Name              string
+	Key               string
+	RequestsPerMinute int
+	Burst             int
+}
+
+func (p RateLimitPolicy) refillPerSecond() float64 {
+	return float64(p.RequestsPerMinute) / 60
+}
+
+// fillTime is how long an empty bucket takes to fill up again.
+func (p RateLimitPolicy) fillTime() time.Duration {
+	return time.Duration(float64(p.Burst) / p.refillPerSecond() * float64(time.Second))
+}
+
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// RateLimiter takes tokens from per-key buckets. Implementations must be safe
+// for concurrent use.
+type RateLimiter interface {
+	// Allow takes a token from the bucket of key, creating a full one for
+	// keys it hasn't seen.
+	Allow(key string, policy RateLimitPolicy) (RateLimitResult, error)
+	// Cleanup drops buckets that haven't been used for idle. A bucket idle
+	// for longer than its fill time is full, so dropping it changes nothing.
+	Cleanup(idle time.Duration) error
+}
+
 var (
-	failedLogins     = sync.Map{} // map[string]*models.LoginAttempt
-	rateLimiterCache *lru.Cache[string, *models.RateLimitInfo]
-	rateLimiterMu    sync.Mutex
-	maxRequests      = config.GetConfig().Security.RPMForIP
-	timeWindow       = time.Minute
+	rateLimiter     RateLimiter = newMemoryRateLimiter()
+	globalRateLimit RateLimitPolicy
+	routeRateLimits = map[string]RateLimitPolicy{}
 )
 
-func cleanupExpiredAttempts() {
-	now := time.Now()
-	failedLogins.Range(func(key, value interface{}) bool {
-		if attempt, ok := value.(*models.LoginAttempt); ok {
-			if now.After(attempt.BlockedUntil) {
-				failedLogins.Delete(key)
+func newRateLimiter(kind string) RateLimiter {
+	switch kind {
+	case "", "memory":
+		return newMemoryRateLimiter()
+	case "postgres":
+		return postgresRateLimiter{}
+	}
+	logger.Fatal("Invalid rate limit store " + kind)
+	return nil
+}
+
+func newRateLimitPolicy(name string, cfg config.RateLimitPolicyConfig) RateLimitPolicy {
+	policy := RateLimitPolicy{Name: name, Key: cfg.Key, RequestsPerMinute: cfg.RequestsPerMinute, Burst: cfg.Burst}
+	if policy.Key == "" {
+		policy.Key = rateLimitByIP
+	}
+	if policy.Key != rateLimitByIP && policy.Key != rateLimitByUser && policy.Key != rateLimitByAPIKey {
+		logger.Fatal(fmt.Sprintf("Invalid rate limit key %s for %s", policy.Key, name))
+	}
+	if policy.RequestsPerMinute <= 0 {
+		logger.Fatal("Invalid rate limit for " + name)
+	}
+	if policy.Burst <= 0 {
+		policy.Burst = policy.RequestsPerMinute
+	}
+	return policy
+}
+
+// takeToken refills bucket for the time since it was last used and takes a
+// token from it if there is a whole one.
+func takeToken(bucket *models.TokenBucket, policy RateLimitPolicy, now time.Time) bool {
+	if elapsed := now.Sub(bucket.UpdatedAt).Seconds(); elapsed > 0 {
+		bucket.Tokens = math.Min(float64(policy.Burst), bucket.Tokens+elapsed*policy.refillPerSecond())
+	}
+	bucket.UpdatedAt = now
+	if bucket.Tokens < 1 {
+		return false
+	}
+	bucket.Tokens--
+	return true
+}
+
+func rateLimitResult(policy RateLimitPolicy, tokens float64, allowed bool) RateLimitResult {
+	rate := policy.refillPerSecond()
+	result := RateLimitResult{
+		Allowed:   allowed,
+		Limit:     policy.Burst,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((float64(policy.Burst) - tokens) / rate * float64(time.Second)),
+	}
+	if !allowed {
+		result.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+	return result
+}
+
+// memoryRateLimiter keeps the buckets of this process only. The buckets are
+// spread over shards so requests for different keys rarely share a lock.
+type memoryRateLimiter struct {
+	shards [rateLimitShards]rateLimitShard
+	now    func() time.Time
+}
+
+type rateLimitShard struct {
+	mu      sync.Mutex
+	buckets map[string]*models.TokenBucket
+}
+
+func newMemoryRateLimiter() *memoryRateLimiter {
+	limiter := &memoryRateLimiter{now: time.Now}
+	for i := range limiter.shards {
+		limiter.shards[i].buckets = map[string]*models.TokenBucket{}
+	}
+	return limiter
+}
+
+func (l *memoryRateLimiter) shard(key string) *rateLimitShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &l.shards[h.Sum32()%rateLimitShards]
+}
+
+func (l *memoryRateLimiter) Allow(key string, policy RateLimitPolicy) (RateLimitResult, error) {
+	shard := l.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	now := l.now()
+	bucket, ok := shard.buckets[key]
+	if !ok {
+		bucket = &models.TokenBucket{Tokens: float64(policy.Burst), UpdatedAt: now}
+		shard.buckets[key] = bucket
+	}
+	allowed := takeToken(bucket, policy, now)
+	return rateLimitResult(policy, bucket.Tokens, allowed), nil
+}
+
+func (l *memoryRateLimiter) Cleanup(idle time.Duration) error {
+	cutoff := l.now().Add(-idle)
+	for i := range l.shards {
+		shard := &l.shards[i]
+		shard.mu.Lock()
+		for key, bucket := range shard.buckets {
+			if !bucket.UpdatedAt.After(cutoff) {
+				delete(shard.buckets, key)
 			}
 		}
-		return true
-	})
+		shard.mu.Unlock()
+	}
+	return nil
+}
+
+// postgresRateLimiter shares the buckets between instances.
+type postgresRateLimiter struct{}
+
+func (postgresRateLimiter) Allow(key string, policy RateLimitPolicy) (RateLimitResult, error) {
+	allowed, tokens, err := repository.TakeRateLimitToken(key, policy.Burst, policy.refillPerSecond())
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	return rateLimitResult(policy, tokens, allowed), nil
+}
+
+func (postgresRateLimiter) Cleanup(idle time.Duration) error {
+	return repository.DeleteIdleRateLimitBuckets(idle)
 }
 
 func Init() {
+	proxies, err := parseTrustedProxies(config.GetConfig().Server.TrustedProxies)
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+	trustedProxies = proxies
+	cfg := config.GetConfig().Security
+	loginAttempts = newLoginAttemptStore(cfg.LoginAttemptStore)
+	rateLimiter = newRateLimiter(cfg.RateLimits.Store)
+	if cfg.RPMForIP > 0 {
+		globalRateLimit = newRateLimitPolicy("global", config.RateLimitPolicyConfig{Key: rateLimitByIP, RequestsPerMinute: cfg.RPMForIP})
+	}
+	maxFillTime := time.Minute
+	if globalRateLimit.RequestsPerMinute > 0 {
+		maxFillTime = globalRateLimit.fillTime()
+	}
+	for route, policyConfig := range cfg.RateLimits.Routes {
+		policy := newRateLimitPolicy(route, policyConfig)
+		routeRateLimits[route] = policy
+		if policy.fillTime() > maxFillTime {
+			maxFillTime = policy.fillTime()
+		}
+	}
+
 	go func() {
 		for {
-			time.Sleep(5 * time.Minute)
-			cleanupExpiredAttempts()
+			time.Sleep(rateLimitCleanupInterval)
+			if err := loginAttempts.Cleanup(lockoutDuration()); err != nil {
+				logger.Error("Couldn't clean up login lockouts: " + err.Error())
+			}
+			if err := rateLimiter.Cleanup(maxFillTime); err != nil {
+				logger.Error("Couldn't clean up rate limit buckets: " + err.Error())
+			}
 		}
 	}()
-
-	var err error
-	rateLimiterCache, err = lru.New[string, *models.RateLimitInfo](1000)
-	if err != nil {
-		panic(err)
-	}
 }
 
+// RateLimitMiddleware applies the global per-IP limit and the route's policy
+// when it's keyed by IP. Policies keyed by user or API key are applied by
+// AuthMiddleware once the caller is known.
 func RateLimitMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip, _, err := net.SplitHostPort(r.RemoteAddr)
-		if err != nil {
-			errorResponse(w, http.StatusInternalServerError, "Failed to determine IP")
+		policies := []RateLimitPolicy{globalRateLimit}
+		if policy, ok := routeRateLimits[r.URL.Path]; ok && policy.Key == rateLimitByIP {
+			policies = append(policies, policy)
+		}
+		if !limitRequest(w, r, policies...) {
 			return
 		}
+		next.ServeHTTP(w, r)
+	})
+}
 
-		rateLimiterMu.Lock()
-		defer rateLimiterMu.Unlock()
+// limitAuthenticatedRequest applies the route's policy when it's keyed by the
+// caller, which is only known after authentication.
+func limitAuthenticatedRequest(w http.ResponseWriter, r *http.Request) bool {
+	policy, ok := routeRateLimits[r.URL.Path]
+	if !ok || policy.Key == rateLimitByIP {
+		return true
+	}
+	return limitRequest(w, r, policy)
+}
 
-		now := time.Now()
-		entry, found := rateLimiterCache.Get(ip)
-		if !found {
-			entry = &models.RateLimitInfo{
-				Requests:  1,
-				ResetTime: now.Add(timeWindow),
-			}
-			rateLimiterCache.Add(ip, entry)
-		} else {
-			if now.After(entry.ResetTime) {
-				entry.Requests = 1
-				entry.ResetTime = now.Add(timeWindow)
-			} else {
-				entry.Requests++
-				if entry.Requests > maxRequests {
-					w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(entry.ResetTime).Seconds())))
-					errorResponse(w, http.StatusTooManyRequests, "Too many requests")
-					return
-				}
-			}
+// limitRequest takes a token for every policy and answers 429 as soon as one
+// of them runs out. The limiter failing lets the request through: an outage of
+// the store shouldn't take the whole API down with it.
+func limitRequest(w http.ResponseWriter, r *http.Request, policies ...RateLimitPolicy) bool {
+	for _, policy := range policies {
+		if policy.RequestsPerMinute <= 0 {
+			continue
+		}
+		subject, ok := rateLimitSubject(r, policy)
+		if !ok {
+			continue
+		}
+		result, err := rateLimiter.Allow(policy.Name+"|"+subject, policy)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Rate limiter failed for %s: %s", subject, err.Error()))
+			continue
+		}
+		setRateLimitHeaders(w, policy, result)
+		if !result.Allowed {
+			logger.Warn(fmt.Sprintf("Rate limit %s exceeded by %s", policy.Name, subject))
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			errorResponse(w, http.StatusTooManyRequests, "Too many requests")
+			return false
+		}
+	}
+	return true
+}
+
+func rateLimitSubject(r *http.Request, policy RateLimitPolicy) (string, bool) {
+	if policy.Key == rateLimitByAPIKey {
+		if identity, ok := r.Context().Value(apiKeyKey).(*models.APIKeyIdentity); ok {
+			return fmt.Sprintf("api_key:%d", identity.KeyID), true
 		}
+	}
+	if policy.Key == rateLimitByUser || policy.Key == rateLimitByAPIKey {
+		userID, ok := r.Context().Value(userIDKey).(int)
+		return fmt.Sprintf("user:%d", userID), ok
+	}
+	return "ip:" + clientIP(r), true
+}
 
-		next.ServeHTTP(w, r)
-	})
+// setRateLimitHeaders reports the policy closest to running out, which is the
+// one the client has to pace itself by.
+func setRateLimitHeaders(w http.ResponseWriter, policy RateLimitPolicy, result RateLimitResult) {
+	if current, err := strconv.Atoi(w.Header().Get("RateLimit-Remaining")); err == nil && current < result.Remaining && result.Allowed {
+		return
+	}
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+	w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=60;burst=%d", policy.RequestsPerMinute, policy.Burst))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
 }
@@ -0,0 +1,85 @@
+package transport
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gbs/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucketRefills(t *testing.T) {
+	policy := RateLimitPolicy{Name: "test", Key: rateLimitByIP, RequestsPerMinute: 60, Burst: 2}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	bucket := &models.TokenBucket{Tokens: 2, UpdatedAt: now}
+
+	assert.True(t, takeToken(bucket, policy, now))
+	assert.True(t, takeToken(bucket, policy, now))
+	assert.False(t, takeToken(bucket, policy, now), "burst must be spent")
+
+	assert.False(t, takeToken(bucket, policy, now.Add(500*time.Millisecond)))
+	assert.True(t, takeToken(bucket, policy, now.Add(time.Second)), "one token must be refilled per second")
+
+	takeToken(bucket, policy, now.Add(time.Hour))
+	assert.Equal(t, 1.0, bucket.Tokens, "bucket must not fill past its burst")
+}
+
+func TestRateLimitResult(t *testing.T) {
+	policy := RateLimitPolicy{Name: "test", Key: rateLimitByIP, RequestsPerMinute: 30, Burst: 10}
+
+	result := rateLimitResult(policy, 4.5, true)
+	assert.Equal(t, 10, result.Limit)
+	assert.Equal(t, 4, result.Remaining)
+	assert.Equal(t, 11*time.Second, result.Reset)
+	assert.Zero(t, result.RetryAfter)
+
+	result = rateLimitResult(policy, 0.5, false)
+	assert.Equal(t, time.Second, result.RetryAfter)
+}
+
+func TestMemoryRateLimiterKeepsKeysApart(t *testing.T) {
+	limiter := newMemoryRateLimiter()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+	policy := RateLimitPolicy{Name: "test", Key: rateLimitByIP, RequestsPerMinute: 60, Burst: 1}
+
+	result, err := limiter.Allow("a", policy)
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	result, _ = limiter.Allow("a", policy)
+	assert.False(t, result.Allowed)
+	result, _ = limiter.Allow("b", policy)
+	assert.True(t, result.Allowed, "buckets must be per key")
+
+	now = now.Add(time.Minute)
+	assert.NoError(t, limiter.Cleanup(time.Second))
+	for i := range limiter.shards {
+		assert.Empty(t, limiter.shards[i].buckets)
+	}
+}
+
+func TestLimitRequestSetsHeaders(t *testing.T) {
+	rateLimiter = newMemoryRateLimiter()
+	policy := RateLimitPolicy{Name: "/api/v1/transaction", Key: rateLimitByUser, RequestsPerMinute: 60, Burst: 1}
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/transaction", nil)
+	r = r.WithContext(context.WithValue(r.Context(), userIDKey, 7))
+
+	w := httptest.NewRecorder()
+	assert.True(t, limitRequest(w, r, policy))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "60;w=60;burst=1", w.Header().Get("RateLimit-Policy"))
+
+	w = httptest.NewRecorder()
+	assert.False(t, limitRequest(w, r, policy))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	other := httptest.NewRequest(http.MethodPost, "/api/v1/transaction", nil)
+	other = other.WithContext(context.WithValue(other.Context(), userIDKey, 8))
+	assert.True(t, limitRequest(httptest.NewRecorder(), other, policy), "users must not share a bucket")
+}
@@ -0,0 +1,62 @@
+package transport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		keep   bool
+	}{
+		{"generated when missing", "", false},
+		{"caller id is kept", "req-42.a_b", true},
+		{"unsafe characters are replaced", "id\nwith newline", false},
+		{"long ids are replaced", strings.Repeat("a", maxRequestIDSize+1), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			handler := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = requestID(r)
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set(requestIDHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			assert.NotEmpty(t, seen)
+			assert.Equal(t, seen, w.Header().Get(requestIDHeader))
+			if tt.keep {
+				assert.Equal(t, tt.header, seen)
+			} else {
+				assert.NotEqual(t, tt.header, seen)
+				assert.Len(t, seen, 32)
+			}
+		})
+	}
+}
+
+func TestParseAuditEventFilter(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/?actor_id=3&action=print_money&from=2024-01-01T00:00:00Z", nil)
+	filter, err := parseAuditEventFilter(r)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, *filter.ActorID)
+	assert.Nil(t, filter.TargetID)
+	assert.Equal(t, "print_money", *filter.Action)
+	assert.Equal(t, 2024, filter.From.Year())
+	assert.Nil(t, filter.To)
+
+	_, err = parseAuditEventFilter(httptest.NewRequest(http.MethodGet, "/?target_id=abc", nil))
+	assert.EqualError(t, err, "invalid target_id parameter")
+	_, err = parseAuditEventFilter(httptest.NewRequest(http.MethodGet, "/?to=yesterday", nil))
+	assert.EqualError(t, err, "invalid to parameter")
+}
@@ -0,0 +1,290 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"gbs/internal/auth"
+	"gbs/internal/models"
+	"gbs/internal/repository"
+	"gbs/pkg/logger"
+)
+
+// CreateRole godoc
+// @Summary Create Role
+// @Description Create a named role bundling permissions, e.g. "merchant" with send_funds and receive_funds. Requires administrator permission; roles can't include administrator.
+// @Tags permissions
+// @Accept json
+// @Produce json
+// @Param body body models.CreateRoleRequest true "Role name and permission names"
+// @Success 201 {object} models.CreateRoleResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /api/v1/createRole [post]
+func CreateRole(w http.ResponseWriter, r *http.Request) {
+	logger.Info("CreateRole endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("CreateRole: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("CreateRole: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if !requirePermission(w, r, "manage_user_permissions") {
+		return
+	}
+
+	var req models.CreateRoleRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("CreateRole: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("CreateRole: Creating role %s by initiatorID=%d", req.Name, initiatorID))
+	roleID, err := auth.CreateRole(initiatorID, req.Name, req.Permissions)
+	recordAudit(r, "create_role", 0, map[string]interface{}{"role_id": roleID, "name": req.Name, "permissions": req.Permissions}, err)
+	if err != nil {
+		logger.Error("CreateRole: Operation failed: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info("CreateRole: Role created successfully")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.CreateRoleResponse{RoleID: roleID})
+}
+
+// GetRoles godoc
+// @Summary Get Roles
+// @Description List all roles with the permissions they grant.
+// @Tags permissions
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.RolesResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/getRoles [get]
+func GetRoles(w http.ResponseWriter, r *http.Request) {
+	logger.Info("GetRoles endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		logger.Warn("GetRoles: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	roles, err := repository.GetRoles()
+	if err != nil {
+		logger.Error("GetRoles: Failed to get roles: " + err.Error())
+		errorResponse(w, http.StatusInternalServerError, "Failed to get roles")
+		return
+	}
+	logger.Info("GetRoles: Roles successfully fetched")
+	json.NewEncoder(w).Encode(models.RolesResponse{Roles: roles})
+}
+
+// GetUserRoles godoc
+// @Summary Get User Roles
+// @Description Retrieve the roles assigned to a specified user.
+// @Tags users, permissions
+// @Accept json
+// @Produce json
+// @Param id query int true "User ID"
+// @Success 200 {object} models.RolesResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/getUserRoles [get]
+func GetUserRoles(w http.ResponseWriter, r *http.Request) {
+	logger.Info("GetUserRoles endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		logger.Warn("GetUserRoles: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	userID, err := parseQueryInt(r, "id")
+	if err != nil {
+		logger.Error("GetUserRoles: Missing or invalid id parameter")
+		errorResponse(w, http.StatusBadRequest, "Missing or invalid id parameter")
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("GetUserRoles: Fetching roles for userID=%d", userID))
+	roles, err := repository.GetUserRoles(userID)
+	if err != nil {
+		logger.Error("GetUserRoles: Failed to get user roles: " + err.Error())
+		errorResponse(w, http.StatusInternalServerError, "Failed to get user roles")
+		return
+	}
+	logger.Info("GetUserRoles: User roles successfully fetched")
+	json.NewEncoder(w).Encode(models.RolesResponse{Roles: roles})
+}
+
+// UpdateRole godoc
+// @Summary Update Role
+// @Description Replace the permissions of a role. Requires administrator permission; the tokens of every holder are invalidated.
+// @Tags permissions
+// @Accept json
+// @Produce json
+// @Param body body models.UpdateRoleRequest true "Role and its new permission names"
+// @Success 200 {string} string "OK"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /api/v1/updateRole [post]
+func UpdateRole(w http.ResponseWriter, r *http.Request) {
+	logger.Info("UpdateRole endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("UpdateRole: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("UpdateRole: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if !requirePermission(w, r, "manage_user_permissions") {
+		return
+	}
+
+	var req models.UpdateRoleRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("UpdateRole: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("UpdateRole: Updating role %d by initiatorID=%d", req.RoleID, initiatorID))
+	err := auth.UpdateRole(initiatorID, req.RoleID, req.Permissions)
+	recordAudit(r, "update_role", 0, map[string]interface{}{"role_id": req.RoleID, "permissions": req.Permissions}, err)
+	if err != nil {
+		logger.Error("UpdateRole: Operation failed: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info("UpdateRole: Role updated successfully")
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeleteRole godoc
+// @Summary Delete Role
+// @Description Delete a role and take it away from every holder. Requires administrator permission.
+// @Tags permissions
+// @Accept json
+// @Produce json
+// @Param body body models.DeleteRoleRequest true "Role to delete"
+// @Success 200 {string} string "OK"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /api/v1/deleteRole [post]
+func DeleteRole(w http.ResponseWriter, r *http.Request) {
+	logger.Info("DeleteRole endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("DeleteRole: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("DeleteRole: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if !requirePermission(w, r, "manage_user_permissions") {
+		return
+	}
+
+	var req models.DeleteRoleRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("DeleteRole: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("DeleteRole: Deleting role %d by initiatorID=%d", req.RoleID, initiatorID))
+	err := auth.DeleteRole(initiatorID, req.RoleID)
+	recordAudit(r, "delete_role", 0, map[string]interface{}{"role_id": req.RoleID}, err)
+	if err != nil {
+		logger.Error("DeleteRole: Operation failed: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info("DeleteRole: Role deleted successfully")
+	w.WriteHeader(http.StatusOK)
+}
+
+// ModifyRole godoc
+// @Summary Modify User Role
+// @Description Assign a role to a user or take it away. Requires administrator or manage_user_permissions permission; roles granting manage_user_permissions or print_money require administrator.
+// @Tags users, permissions
+// @Accept json
+// @Produce json
+// @Param body body models.RoleAssignmentRequest true "Role assignment details"
+// @Success 200 {string} string "OK"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /api/v1/modifyRole [post]
+func ModifyRole(w http.ResponseWriter, r *http.Request) {
+	logger.Info("ModifyRole endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("ModifyRole: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("ModifyRole: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if !requirePermission(w, r, "manage_user_permissions") {
+		return
+	}
+
+	var req models.RoleAssignmentRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("ModifyRole: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("ModifyRole: Changing role for userID=%d, roleID=%d, enabled=%v", req.UserID, req.RoleID, req.Enabled))
+	err := auth.ModifyRole(initiatorID, req.UserID, req.RoleID, req.Enabled)
+	action := "unassign_role"
+	if req.Enabled {
+		action = "assign_role"
+	}
+	recordAudit(r, action, req.UserID, map[string]interface{}{"role_id": req.RoleID}, err)
+	if err != nil {
+		logger.Error("ModifyRole: Operation failed: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info("ModifyRole: Role modified successfully")
+	w.WriteHeader(http.StatusOK)
+}
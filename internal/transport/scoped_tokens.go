@@ -0,0 +1,103 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"gbs/internal/auth"
+	"gbs/internal/models"
+	"gbs/pkg/logger"
+)
+
+// CreateScopedToken godoc
+// @Summary Create Scoped Token
+// @Description Mint an access token limited to some operations (read_account, read_balances, read_history, transfer, print_money, manage_permissions, register_users), optionally with per-currency caps on the total it may transfer and a custom lifetime. Scoped tokens can't be refreshed and can't manage the account.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param body body models.CreateScopedTokenRequest true "Operations, transfer limits and lifetime"
+// @Success 200 {object} models.ScopedTokenResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /api/v1/createScopedToken [post]
+func CreateScopedToken(w http.ResponseWriter, r *http.Request) {
+	logger.Info("CreateScopedToken endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("CreateScopedToken: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	userID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("CreateScopedToken: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.CreateScopedTokenRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("CreateScopedToken: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("CreateScopedToken: Minting token for userID=%d, operations=%v", userID, req.Operations))
+	token, tokenID, expiresAt, err := auth.CreateScopedToken(userID, req.Operations, req.TransferLimits, req.ExpiresIn)
+	if err != nil {
+		logger.Error("CreateScopedToken: Operation failed: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info("CreateScopedToken: Token minted successfully")
+	json.NewEncoder(w).Encode(models.ScopedTokenResponse{Token: token, TokenID: tokenID, ExpiresAt: expiresAt})
+}
+
+// RevokeScopedToken godoc
+// @Summary Revoke Scoped Token
+// @Description Revoke a scoped token of the current user before it expires.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param body body models.RevokeScopedTokenRequest true "ID of the token to revoke"
+// @Success 200 {string} string "OK"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/revokeScopedToken [post]
+func RevokeScopedToken(w http.ResponseWriter, r *http.Request) {
+	logger.Info("RevokeScopedToken endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("RevokeScopedToken: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	userID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("RevokeScopedToken: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.RevokeScopedTokenRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("RevokeScopedToken: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("RevokeScopedToken: Revoking token %s of userID=%d", req.TokenID, userID))
+	if err := auth.RevokeScopedToken(userID, req.TokenID); err != nil {
+		logger.Error("RevokeScopedToken: Operation failed: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info("RevokeScopedToken: Token revoked successfully")
+	w.WriteHeader(http.StatusOK)
+}
@@ -13,9 +13,35 @@ func Run() {
 	mux := http.NewServeMux()
 
 	mux.Handle("/api/v1/login", RateLimitMiddleware(http.HandlerFunc(Login)))
-	mux.Handle("/api/v1/changePassword", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(ChangePassword))))
+	mux.Handle("/api/v1/changePassword", RateLimitMiddleware(SessionAuthMiddleware(http.HandlerFunc(ChangePassword))))
 
+	mux.Handle("/api/v1/verifyTOTP", RateLimitMiddleware(http.HandlerFunc(VerifyTOTP)))
+	mux.Handle("/api/v1/enrollTOTP", RateLimitMiddleware(SessionAuthMiddleware(http.HandlerFunc(EnrollTOTP))))
+	mux.Handle("/api/v1/confirmTOTP", RateLimitMiddleware(SessionAuthMiddleware(http.HandlerFunc(ConfirmTOTP))))
+	mux.Handle("/api/v1/disableTOTP", RateLimitMiddleware(SessionAuthMiddleware(http.HandlerFunc(DisableTOTP))))
+
+	mux.Handle("/.well-known/jwks.json", RateLimitMiddleware(http.HandlerFunc(JWKS)))
 	mux.Handle("/api/v1/refreshJWT", RateLimitMiddleware(http.HandlerFunc(RefreshJWT)))
+	mux.Handle("/api/v1/logout", RateLimitMiddleware(SessionAuthMiddleware(http.HandlerFunc(Logout))))
+	mux.Handle("/api/v1/logoutEverywhere", RateLimitMiddleware(SessionAuthMiddleware(http.HandlerFunc(LogoutEverywhere))))
+	mux.Handle("/api/v1/getSessions", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(GetSessions))))
+	mux.Handle("/api/v1/revokeSession", RateLimitMiddleware(SessionAuthMiddleware(http.HandlerFunc(RevokeSession))))
+	mux.Handle("/api/v1/revokeUserSessions", RateLimitMiddleware(SessionAuthMiddleware(http.HandlerFunc(RevokeUserSessions))))
+	mux.Handle("/api/v1/getLoginLockouts", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(GetLoginLockouts))))
+	mux.Handle("/api/v1/clearLoginLockout", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(ClearLoginLockout))))
+
+	mux.Handle("/api/v1/createAPIKey", RateLimitMiddleware(SessionAuthMiddleware(http.HandlerFunc(CreateAPIKey))))
+	mux.Handle("/api/v1/getAPIKeys", RateLimitMiddleware(SessionAuthMiddleware(http.HandlerFunc(GetAPIKeys))))
+	mux.Handle("/api/v1/revokeAPIKey", RateLimitMiddleware(SessionAuthMiddleware(http.HandlerFunc(RevokeAPIKey))))
+
+	mux.Handle("/api/v1/createScopedToken", RateLimitMiddleware(SessionAuthMiddleware(http.HandlerFunc(CreateScopedToken))))
+	mux.Handle("/api/v1/revokeScopedToken", RateLimitMiddleware(SessionAuthMiddleware(http.HandlerFunc(RevokeScopedToken))))
+
+	mux.Handle("/oauth/authorize", RateLimitMiddleware(http.HandlerFunc(OAuthAuthorize)))
+	mux.Handle("/oauth/token", RateLimitMiddleware(http.HandlerFunc(OAuthToken)))
+	mux.Handle("/api/v1/createOAuthClient", RateLimitMiddleware(SessionAuthMiddleware(http.HandlerFunc(CreateOAuthClient))))
+	mux.Handle("/api/v1/getOAuthClients", RateLimitMiddleware(SessionAuthMiddleware(http.HandlerFunc(GetOAuthClients))))
+	mux.Handle("/api/v1/revokeOAuthClient", RateLimitMiddleware(SessionAuthMiddleware(http.HandlerFunc(RevokeOAuthClient))))
 	if config.GetConfig().Security.AllowDirectRegistration {
 		mux.Handle("/api/v1/register", RateLimitMiddleware(http.HandlerFunc(Register)))
 	} else {
@@ -31,15 +57,70 @@ func Run() {
 	mux.Handle("/api/v1/printMoney", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(PrintMoney))))
 	mux.Handle("/api/v1/modifyPermission", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(ModifyPermission))))
 
+	mux.Handle("/api/v1/createPermission", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(CreatePermission))))
+	mux.Handle("/api/v1/deletePermission", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(DeletePermission))))
+	mux.Handle("/api/v1/hasPermission", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(HasPermission))))
+	mux.Handle("/api/v1/getPermissionHistory", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(GetPermissionHistory))))
+	mux.Handle("/api/v1/getPermissions", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(GetPermissions))))
+	mux.Handle("/api/v1/getPermissionHolders", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(GetPermissionHolders))))
+
+	mux.Handle("/api/v1/createRole", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(CreateRole))))
+	mux.Handle("/api/v1/getRoles", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(GetRoles))))
+	mux.Handle("/api/v1/getUserRoles", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(GetUserRoles))))
+	mux.Handle("/api/v1/updateRole", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(UpdateRole))))
+	mux.Handle("/api/v1/deleteRole", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(DeleteRole))))
+	mux.Handle("/api/v1/modifyRole", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(ModifyRole))))
+
+	mux.Handle("/api/v1/getAuditEvents", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(GetAuditEvents))))
+	mux.Handle("/api/v1/verifyLedger", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(VerifyLedger))))
+	mux.Handle("/api/v1/getLedgerKey", RateLimitMiddleware(http.HandlerFunc(GetLedgerKey)))
+	mux.Handle("/api/v1/verifyReceipt", RateLimitMiddleware(http.HandlerFunc(VerifyReceipt)))
+
+	mux.Handle("/api/v1/createWebhook", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(CreateWebhook))))
+	mux.Handle("/api/v1/getWebhooks", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(GetWebhooks))))
+	mux.Handle("/api/v1/deleteWebhook", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(DeleteWebhook))))
+	mux.Handle("/api/v1/getWebhookDeliveries", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(GetWebhookDeliveries))))
+	mux.Handle("/api/v1/redeliverWebhook", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(RedeliverWebhook))))
+	mux.Handle("/api/v1/streamEvents", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(StreamEvents))))
+
+	mux.Handle("/api/v1/createPaymentRequest", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(CreatePaymentRequest))))
+	mux.Handle("/api/v1/getPaymentRequest", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(GetPaymentRequest))))
+	mux.Handle("/api/v1/getIncomingPaymentRequests", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(GetIncomingPaymentRequests))))
+	mux.Handle("/api/v1/getOutgoingPaymentRequests", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(GetOutgoingPaymentRequests))))
+	mux.Handle("/api/v1/acceptPaymentRequest", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(AcceptPaymentRequest))))
+	mux.Handle("/api/v1/declinePaymentRequest", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(DeclinePaymentRequest))))
+	mux.Handle("/api/v1/cancelPaymentRequest", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(CancelPaymentRequest))))
+
+	mux.Handle("/api/v1/createEscrow", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(CreateEscrow))))
+	mux.Handle("/api/v1/confirmEscrow", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(ConfirmEscrow))))
+	mux.Handle("/api/v1/getEscrows", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(GetEscrows))))
+	mux.Handle("/api/v1/getEscrowLogs", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(GetEscrowLogs))))
+
+	mux.Handle("/api/v1/getWallets", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(GetWallets))))
+	mux.Handle("/api/v1/createWallet", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(CreateWallet))))
+	mux.Handle("/api/v1/deleteWallet", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(DeleteWallet))))
+
+	mux.Handle("/api/v1/createOrganization", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(CreateOrganization))))
+	mux.Handle("/api/v1/getOrganizations", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(GetOrganizations))))
+	mux.Handle("/api/v1/getOrganizationMembers", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(GetOrganizationMembers))))
+	mux.Handle("/api/v1/setOrganizationMember", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(SetOrganizationMember))))
+	mux.Handle("/api/v1/removeOrganizationMember", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(RemoveOrganizationMember))))
+	mux.Handle("/api/v1/setOrganizationSpendLimit", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(SetOrganizationSpendLimit))))
+
+	mux.Handle("/api/v1/setCreditLimit", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(SetCreditLimit))))
+	mux.Handle("/api/v1/getCreditLimitHistory", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(GetCreditLimitHistory))))
+	mux.Handle("/api/v1/getOverdrafts", RateLimitMiddleware(AuthMiddleware(http.HandlerFunc(GetOverdrafts))))
+
 	Init()
 	logger.Info(fmt.Sprintf("Server listening on %s", addr))
 	corsHandler := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type", apiKeyHeader, requestIDHeader, "Last-Event-ID"},
+		ExposedHeaders:   []string{requestIDHeader, "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy", "Retry-After"},
 		AllowCredentials: false,
 	})
-	handler := corsHandler.Handler(mux)
+	handler := corsHandler.Handler(RequestIDMiddleware(ClientIPMiddleware(mux)))
 	if err := http.ListenAndServe(addr, handler); err != nil {
 		logger.Error(err.Error())
 	}
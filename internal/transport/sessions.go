@@ -0,0 +1,248 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"gbs/internal/auth"
+	"gbs/internal/models"
+	"gbs/internal/repository"
+	"gbs/pkg/logger"
+)
+
+// Logout godoc
+// @Summary Logout
+// @Description Revoke the session the given refresh token belongs to, together with the access token used for this call.
+// @Tags auth, sessions
+// @Accept json
+// @Produce json
+// @Param body body models.LogoutRequest true "Refresh token of the current session"
+// @Success 200 {string} string "OK"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/logout [post]
+func Logout(w http.ResponseWriter, r *http.Request) {
+	logger.Info("Logout endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("Logout: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	userID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("Logout: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.LogoutRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("Logout: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("Logout: Revoking session for userID=%d", userID))
+	revoked, err := repository.RevokeSessionByToken(userID, req.RefreshToken)
+	if err != nil || !revoked {
+		logger.Error(fmt.Sprintf("Logout: Failed to revoke session for userID=%d", userID))
+		errorResponse(w, http.StatusBadRequest, "Invalid refresh token")
+		return
+	}
+	if claims, ok := r.Context().Value(claimsKey).(*auth.AccessClaims); ok {
+		if err := auth.RevokeAccessToken(claims); err != nil {
+			logger.Error("Logout: Failed to revoke access token: " + err.Error())
+			errorResponse(w, http.StatusInternalServerError, "Failed to revoke access token")
+			return
+		}
+	}
+	logger.Info("Logout: Session revoked successfully")
+	w.WriteHeader(http.StatusOK)
+}
+
+// LogoutEverywhere godoc
+// @Summary Logout Everywhere
+// @Description Revoke every session and every access token of the current user.
+// @Tags auth, sessions
+// @Accept json
+// @Produce json
+// @Success 200 {string} string "OK"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/logoutEverywhere [post]
+func LogoutEverywhere(w http.ResponseWriter, r *http.Request) {
+	logger.Info("LogoutEverywhere endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("LogoutEverywhere: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	userID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("LogoutEverywhere: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("LogoutEverywhere: Revoking all sessions for userID=%d", userID))
+	if err := repository.RevokeUserSessions(userID, userID); err != nil {
+		logger.Error("LogoutEverywhere: Operation failed: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	auth.InvalidateTokenState(userID)
+	logger.Info("LogoutEverywhere: Sessions revoked successfully")
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetSessions godoc
+// @Summary Get User Sessions
+// @Description List active sessions of a user. Viewing other users' sessions requires administrator or control_user_accounts permission.
+// @Tags auth, sessions
+// @Accept json
+// @Produce json
+// @Param id query int true "Target user ID"
+// @Success 200 {object} models.SessionsResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/getSessions [get]
+func GetSessions(w http.ResponseWriter, r *http.Request) {
+	logger.Info("GetSessions endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		logger.Warn("GetSessions: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	targetUserID, err := parseQueryInt(r, "id")
+	if err != nil {
+		logger.Error("GetSessions: Missing or invalid id parameter")
+		errorResponse(w, http.StatusBadRequest, "Missing or invalid id parameter")
+		return
+	}
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("GetSessions: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	if targetUserID != initiatorID && !requirePermission(w, r, "control_user_accounts") {
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("GetSessions: targetUserID=%d, initiatorID=%d", targetUserID, initiatorID))
+	sessions, err := repository.GetSessions(initiatorID, targetUserID)
+	if err != nil {
+		logger.Error("GetSessions: Failed to get sessions: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Failed to get sessions")
+		return
+	}
+	logger.Info("GetSessions: Sessions successfully fetched")
+	json.NewEncoder(w).Encode(models.SessionsResponse{Sessions: sessions})
+}
+
+// RevokeSession godoc
+// @Summary Revoke Session
+// @Description Revoke a single session of a user. Revoking other users' sessions requires administrator or control_user_accounts permission.
+// @Tags auth, sessions
+// @Accept json
+// @Produce json
+// @Param body body models.RevokeSessionRequest true "Session to revoke"
+// @Success 200 {string} string "OK"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/revokeSession [post]
+func RevokeSession(w http.ResponseWriter, r *http.Request) {
+	logger.Info("RevokeSession endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("RevokeSession: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("RevokeSession: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.RevokeSessionRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("RevokeSession: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("RevokeSession: Revoking session %s of userID=%d by initiatorID=%d", req.SessionID, req.UserID, initiatorID))
+	err := repository.RevokeSession(initiatorID, req.UserID, req.SessionID)
+	recordAudit(r, "revoke_session", req.UserID, map[string]interface{}{"session_id": req.SessionID}, err)
+	if err != nil {
+		logger.Error("RevokeSession: Operation failed: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info("RevokeSession: Session revoked successfully")
+	w.WriteHeader(http.StatusOK)
+}
+
+// RevokeUserSessions godoc
+// @Summary Revoke All User Sessions
+// @Description Revoke every session and every access token of a user. Requires administrator or control_user_accounts permission unless the target is the current user.
+// @Tags auth, sessions
+// @Accept json
+// @Produce json
+// @Param body body models.RevokeUserSessionsRequest true "Target user"
+// @Success 200 {string} string "OK"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/revokeUserSessions [post]
+func RevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	logger.Info("RevokeUserSessions endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("RevokeUserSessions: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("RevokeUserSessions: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.RevokeUserSessionsRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("RevokeUserSessions: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("RevokeUserSessions: Revoking sessions of userID=%d by initiatorID=%d", req.UserID, initiatorID))
+	err := repository.RevokeUserSessions(initiatorID, req.UserID)
+	recordAudit(r, "revoke_user_sessions", req.UserID, nil, err)
+	if err != nil {
+		logger.Error("RevokeUserSessions: Operation failed: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	auth.InvalidateTokenState(req.UserID)
+	logger.Info("RevokeUserSessions: Sessions revoked successfully")
+	w.WriteHeader(http.StatusOK)
+}
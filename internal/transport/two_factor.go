@@ -0,0 +1,179 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"gbs/internal/auth"
+	"gbs/internal/models"
+	"gbs/pkg/logger"
+)
+
+// EnrollTOTP godoc
+// @Summary Start TOTP Enrollment
+// @Description Generate a new TOTP secret for the current user. Two-factor authentication stays disabled until the secret is confirmed with a valid code.
+// @Tags auth, two-factor
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.TOTPEnrollmentResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/enrollTOTP [post]
+func EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	logger.Info("EnrollTOTP endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("EnrollTOTP: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	userID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("EnrollTOTP: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("EnrollTOTP: Starting enrollment for userID=%d", userID))
+	secret, uri, err := auth.EnrollTOTP(userID)
+	if err != nil {
+		logger.Error("EnrollTOTP: Operation failed: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info("EnrollTOTP: Enrollment started successfully")
+	json.NewEncoder(w).Encode(models.TOTPEnrollmentResponse{Secret: secret, OTPAuthURI: uri})
+}
+
+// ConfirmTOTP godoc
+// @Summary Confirm TOTP Enrollment
+// @Description Enable two-factor authentication by submitting a code from the enrolled authenticator. Returns one-time backup codes that are shown only once.
+// @Tags auth, two-factor
+// @Accept json
+// @Produce json
+// @Param body body models.TwoFactorCodeRequest true "Current TOTP code"
+// @Success 200 {object} models.BackupCodesResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/confirmTOTP [post]
+func ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	logger.Info("ConfirmTOTP endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("ConfirmTOTP: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	userID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("ConfirmTOTP: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.TwoFactorCodeRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("ConfirmTOTP: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("ConfirmTOTP: Confirming enrollment for userID=%d", userID))
+	backupCodes, err := auth.ConfirmTOTP(userID, req.Code)
+	if err != nil {
+		logger.Error("ConfirmTOTP: Operation failed: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info("ConfirmTOTP: Two-factor authentication enabled")
+	json.NewEncoder(w).Encode(models.BackupCodesResponse{BackupCodes: backupCodes})
+}
+
+// DisableTOTP godoc
+// @Summary Disable TOTP
+// @Description Disable two-factor authentication for the current user. Requires a valid TOTP or backup code.
+// @Tags auth, two-factor
+// @Accept json
+// @Produce json
+// @Param body body models.TwoFactorCodeRequest true "TOTP or backup code"
+// @Success 200 {string} string "OK"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/disableTOTP [post]
+func DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	logger.Info("DisableTOTP endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("DisableTOTP: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	userID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("DisableTOTP: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.TwoFactorCodeRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("DisableTOTP: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("DisableTOTP: Disabling two-factor authentication for userID=%d", userID))
+	if err := auth.DisableTOTP(userID, req.Code); err != nil {
+		logger.Error("DisableTOTP: Operation failed: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info("DisableTOTP: Two-factor authentication disabled")
+	w.WriteHeader(http.StatusOK)
+}
+
+// VerifyTOTP godoc
+// @Summary Complete Two-Factor Login
+// @Description Exchange a login challenge and a TOTP or backup code for JWT and refresh token.
+// @Tags auth, two-factor
+// @Accept json
+// @Produce json
+// @Param body body models.VerifyTwoFactorRequest true "Challenge and code"
+// @Success 200 {object} models.AuthResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/verifyTOTP [post]
+func VerifyTOTP(w http.ResponseWriter, r *http.Request) {
+	logger.Info("VerifyTOTP endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("VerifyTOTP: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	var req models.VerifyTwoFactorRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("VerifyTOTP: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	logger.Debug("VerifyTOTP: Attempting to complete two-factor login")
+	token, refreshToken, err := auth.VerifyTwoFactorLogin(req.Challenge, req.Code, clientInfo(r))
+	if err != nil {
+		logger.Error("VerifyTOTP: Verification failed: " + err.Error())
+		errorResponse(w, http.StatusUnauthorized, "Invalid challenge or code")
+		return
+	}
+	logger.Info("VerifyTOTP: Two-factor login completed successfully")
+	writeAuthResponse(w, token, refreshToken)
+}
@@ -5,8 +5,12 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+
+	"gbs/internal/models"
 )
 
+const maxUserAgentLength = 256
+
 func parseJSONRequest(r *http.Request, v interface{}) error {
 	decoder := json.NewDecoder(r.Body)
 	decoder.DisallowUnknownFields()
@@ -40,3 +44,11 @@ func parsePage(page int) (limit, offset int) {
 	offset = (page - 1) * 20
 	return
 }
+
+func clientInfo(r *http.Request) models.ClientInfo {
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	return models.ClientInfo{UserAgent: userAgent, IP: clientIP(r)}
+}
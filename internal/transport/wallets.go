@@ -0,0 +1,177 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"gbs/internal/auth"
+	"gbs/internal/models"
+	"gbs/internal/repository"
+	"gbs/pkg/logger"
+)
+
+// parseWallet reads the optional wallet query parameter, the primary wallet
+// when it is missing.
+func parseWallet(r *http.Request) (string, error) {
+	wallet := r.URL.Query().Get("wallet")
+	if wallet == "" {
+		return repository.PrimaryWallet, nil
+	}
+	if !auth.ValidWalletName(wallet) {
+		return "", fmt.Errorf("invalid wallet parameter")
+	}
+	return wallet, nil
+}
+
+// walletOrPrimary defaults an omitted wallet in a request body.
+func walletOrPrimary(wallet string) string {
+	if wallet == "" {
+		return repository.PrimaryWallet
+	}
+	return wallet
+}
+
+// GetWallets godoc
+// @Summary Get Wallets
+// @Description Wallets of a user with their balances, the primary wallet first. Members of an organization see its wallets, other users require audit_funds or administrator permission.
+// @Tags wallets
+// @Produce json
+// @Param id query int true "Target user ID"
+// @Success 200 {object} models.WalletsResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /api/v1/getWallets [get]
+func GetWallets(w http.ResponseWriter, r *http.Request) {
+	logger.Info("GetWallets endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		logger.Warn("GetWallets: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("GetWallets: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	targetUserID, err := parseQueryInt(r, "id")
+	if err != nil {
+		logger.Error("GetWallets: Missing or invalid id parameter")
+		errorResponse(w, http.StatusBadRequest, "Missing or invalid id parameter")
+		return
+	}
+	if !requireAccountAccess(w, r, targetUserID, "audit_funds") {
+		return
+	}
+
+	wallets, err := repository.GetWallets(initiatorID, targetUserID)
+	if err != nil {
+		logger.Error("GetWallets: Failed to get wallets: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info("GetWallets: Wallets successfully fetched")
+	json.NewEncoder(w).Encode(models.WalletsResponse{Wallets: wallets})
+}
+
+// CreateWallet godoc
+// @Summary Create Wallet
+// @Description Create a named wallet, for example savings, next to the user's primary wallet. Balances, transfers and history are kept per wallet. Admins of an organization manage its wallets, other users require manage_user_funds or administrator permission.
+// @Tags wallets
+// @Accept json
+// @Produce json
+// @Param body body models.WalletRequest true "User and wallet name"
+// @Success 200 {string} string "OK"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /api/v1/createWallet [post]
+func CreateWallet(w http.ResponseWriter, r *http.Request) {
+	logger.Info("CreateWallet endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("CreateWallet: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("CreateWallet: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.WalletRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("CreateWallet: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if !requireAccountAccess(w, r, req.UserID, "manage_user_funds", "admin") {
+		return
+	}
+
+	if err := auth.CreateWallet(initiatorID, req.UserID, req.Name); err != nil {
+		logger.Error("CreateWallet: Failed to create wallet: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info(fmt.Sprintf("CreateWallet: Wallet %s created for userID=%d", req.Name, req.UserID))
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeleteWallet godoc
+// @Summary Delete Wallet
+// @Description Delete an empty wallet. The primary wallet can't be deleted and the history of a deleted wallet stays in the logs. Admins of an organization manage its wallets, other users require manage_user_funds or administrator permission.
+// @Tags wallets
+// @Accept json
+// @Produce json
+// @Param body body models.WalletRequest true "User and wallet name"
+// @Success 200 {string} string "OK"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /api/v1/deleteWallet [post]
+func DeleteWallet(w http.ResponseWriter, r *http.Request) {
+	logger.Info("DeleteWallet endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("DeleteWallet: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("DeleteWallet: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.WalletRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("DeleteWallet: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if !requireAccountAccess(w, r, req.UserID, "manage_user_funds", "admin") {
+		return
+	}
+
+	if err := repository.DeleteWallet(initiatorID, req.UserID, req.Name); err != nil {
+		logger.Error("DeleteWallet: Failed to delete wallet: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info(fmt.Sprintf("DeleteWallet: Wallet %s deleted for userID=%d", req.Name, req.UserID))
+	w.WriteHeader(http.StatusOK)
+}
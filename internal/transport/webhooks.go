@@ -0,0 +1,241 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"gbs/internal/auth"
+	"gbs/internal/models"
+	"gbs/internal/repository"
+	"gbs/pkg/logger"
+)
+
+// CreateWebhook godoc
+// @Summary Create Webhook Subscription
+// @Description Subscribe a URL to transfer, print_money, permission_change, registration, payment_request and escrow events of the current user. all_events subscribes to everyone's events and requires audit_funds or administrator permission. Deliveries are signed with the returned secret, it is only shown once.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param body body models.CreateWebhookRequest true "Webhook subscription"
+// @Success 200 {object} models.CreateWebhookResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/createWebhook [post]
+func CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	logger.Info("CreateWebhook endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("CreateWebhook: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("CreateWebhook: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.CreateWebhookRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("CreateWebhook: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.AllEvents && !requirePermission(w, r, "audit_funds") {
+		return
+	}
+
+	logger.Debug(fmt.Sprintf("CreateWebhook: initiatorID=%d, events=%v, all_events=%v", initiatorID, req.EventTypes, req.AllEvents))
+	subscriptionID, secret, err := auth.CreateWebhook(initiatorID, req.URL, req.EventTypes, req.AllEvents)
+	if req.AllEvents {
+		recordAudit(r, "create_webhook", 0, req, err)
+	}
+	if err != nil {
+		logger.Error("CreateWebhook: Failed to create webhook: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info(fmt.Sprintf("CreateWebhook: Webhook %d created", subscriptionID))
+	json.NewEncoder(w).Encode(models.CreateWebhookResponse{SubscriptionID: subscriptionID, Secret: secret})
+}
+
+// GetWebhooks godoc
+// @Summary Get Webhook Subscriptions
+// @Description Webhook subscriptions of the current user.
+// @Tags webhooks
+// @Produce json
+// @Success 200 {object} models.WebhookSubscriptionsResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/getWebhooks [get]
+func GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	logger.Info("GetWebhooks endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		logger.Warn("GetWebhooks: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("GetWebhooks: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	subscriptions, err := repository.GetWebhookSubscriptions(initiatorID)
+	if err != nil {
+		logger.Error("GetWebhooks: Failed to get webhooks: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info("GetWebhooks: Webhooks successfully fetched")
+	json.NewEncoder(w).Encode(models.WebhookSubscriptionsResponse{Subscriptions: subscriptions})
+}
+
+// DeleteWebhook godoc
+// @Summary Delete Webhook Subscription
+// @Description Delete a webhook subscription of the current user together with its pending deliveries.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param body body models.DeleteWebhookRequest true "Subscription to delete"
+// @Success 200 {string} string "OK"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/deleteWebhook [post]
+func DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	logger.Info("DeleteWebhook endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("DeleteWebhook: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("DeleteWebhook: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.DeleteWebhookRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("DeleteWebhook: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := repository.DeleteWebhookSubscription(initiatorID, req.SubscriptionID); err != nil {
+		logger.Error("DeleteWebhook: Failed to delete webhook: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info(fmt.Sprintf("DeleteWebhook: Webhook %d deleted", req.SubscriptionID))
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetWebhookDeliveries godoc
+// @Summary Get Webhook Deliveries
+// @Description Delivery attempts of the current user's webhooks, newest first. status filters by pending, delivered or dead.
+// @Tags webhooks
+// @Produce json
+// @Param page query int true "Page number"
+// @Param status query string false "pending, delivered or dead"
+// @Success 200 {object} models.WebhookDeliveriesResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/getWebhookDeliveries [get]
+func GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	logger.Info("GetWebhookDeliveries endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		logger.Warn("GetWebhookDeliveries: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("GetWebhookDeliveries: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	page, err := parseQueryInt(r, "page")
+	if err != nil {
+		logger.Error("GetWebhookDeliveries: Missing or invalid page parameter")
+		errorResponse(w, http.StatusBadRequest, "Missing or invalid page parameter")
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && status != "pending" && status != "delivered" && status != "dead" {
+		logger.Error("GetWebhookDeliveries: Invalid status parameter " + status)
+		errorResponse(w, http.StatusBadRequest, "invalid status parameter")
+		return
+	}
+
+	limit, offset := parsePage(page)
+	logger.Debug(fmt.Sprintf("GetWebhookDeliveries: initiatorID=%d, status=%s, limit=%d, offset=%d", initiatorID, status, limit, offset))
+	deliveries, err := repository.GetWebhookDeliveries(initiatorID, status, limit, offset)
+	if err != nil {
+		logger.Error("GetWebhookDeliveries: Failed to get deliveries: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info("GetWebhookDeliveries: Deliveries successfully fetched")
+	json.NewEncoder(w).Encode(models.WebhookDeliveriesResponse{Deliveries: deliveries})
+}
+
+// RedeliverWebhook godoc
+// @Summary Redeliver Webhook
+// @Description Queue a delivery of the current user's webhook again, also one that is dead or already delivered. Attempts start over.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param body body models.RedeliverWebhookRequest true "Delivery to retry"
+// @Success 200 {string} string "OK"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /api/v1/redeliverWebhook [post]
+func RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	logger.Info("RedeliverWebhook endpoint hit")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		logger.Warn("RedeliverWebhook: Invalid method " + r.Method)
+		invalidMethod(w, r)
+		return
+	}
+	defer r.Body.Close()
+
+	initiatorID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		logger.Error("RedeliverWebhook: Unauthorized access (missing userID in context)")
+		errorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.RedeliverWebhookRequest
+	if err := parseJSONRequest(r, &req); err != nil {
+		logger.Error("RedeliverWebhook: Invalid request body: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := repository.RedeliverWebhook(initiatorID, req.DeliveryID); err != nil {
+		logger.Error("RedeliverWebhook: Failed to queue delivery: " + err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Info(fmt.Sprintf("RedeliverWebhook: Delivery %d queued", req.DeliveryID))
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,110 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	Digits = 6
+	Period = 30
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a random 160-bit secret encoded as unpadded base32,
+// the format expected by authenticator apps.
+func GenerateSecret() (string, error) {
+	key := make([]byte, 20)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(key), nil
+}
+
+// GenerateCode returns the code for the time step containing t.
+func GenerateCode(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, uint64(Step(t)), Digits), nil
+}
+
+// Validate checks code against the time step containing t and skew steps on
+// either side of it. On success it returns the matched step so callers can
+// refuse to accept the same code twice.
+func Validate(secret, code string, t time.Time, skew int) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != Digits {
+		return 0, false
+	}
+	if _, err := strconv.Atoi(code); err != nil {
+		return 0, false
+	}
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, false
+	}
+	current := Step(t)
+	for i := -skew; i <= skew; i++ {
+		step := current + int64(i)
+		if step < 0 {
+			continue
+		}
+		if hmac.Equal([]byte(hotp(key, uint64(step), Digits)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// Step returns the RFC 6238 time step number for t.
+func Step(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// URI builds the otpauth:// provisioning URI understood by authenticator apps.
+func URI(issuer, account, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", strconv.Itoa(Digits))
+	params.Set("period", strconv.Itoa(Period))
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	normalized := strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	normalized = strings.TrimRight(normalized, "=")
+	key, err := encoding.DecodeString(normalized)
+	if err != nil {
+		return nil, fmt.Errorf("invalid totp secret: %v", err)
+	}
+	return key, nil
+}
+
+func hotp(key []byte, counter uint64, digits int) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
@@ -0,0 +1,69 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// RFC 6238 appendix B vectors for the SHA1 variant.
+func TestHOTPRFCVectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+	vectors := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+	for unix, expected := range vectors {
+		step := Step(time.Unix(unix, 0))
+		assert.Equal(t, expected, hotp(key, uint64(step), 8), "unexpected code for time %d", unix)
+	}
+}
+
+func TestGenerateAndValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	assert.NoError(t, err)
+	assert.Len(t, secret, 32, "160-bit secret should encode to 32 base32 characters")
+
+	now := time.Unix(1700000000, 0)
+	code, err := GenerateCode(secret, now)
+	assert.NoError(t, err)
+	assert.Len(t, code, Digits)
+
+	step, ok := Validate(secret, code, now, 1)
+	assert.True(t, ok, "code should be valid for the current step")
+	assert.Equal(t, Step(now), step)
+
+	_, ok = Validate(secret, code, now.Add(Period*time.Second), 1)
+	assert.True(t, ok, "code should be accepted within the allowed skew")
+
+	_, ok = Validate(secret, code, now.Add(3*Period*time.Second), 1)
+	assert.False(t, ok, "code should be rejected outside the allowed skew")
+}
+
+func TestValidateRejectsMalformedCodes(t *testing.T) {
+	secret, err := GenerateSecret()
+	assert.NoError(t, err)
+	now := time.Now()
+
+	for _, code := range []string{"", "12345", "1234567", "abcdef"} {
+		_, ok := Validate(secret, code, now, 1)
+		assert.False(t, ok, "code %q should be rejected", code)
+	}
+	_, ok := Validate("not base32!", "123456", now, 1)
+	assert.False(t, ok, "invalid secret should be rejected")
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("GBS", "adm", "JBSWY3DPEHPK3PXP")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/GBS:adm?"), "unexpected uri prefix: %s", uri)
+	assert.Contains(t, uri, "secret=JBSWY3DPEHPK3PXP")
+	assert.Contains(t, uri, "issuer=GBS")
+	assert.Contains(t, uri, "digits=6")
+	assert.Contains(t, uri, "period=30")
+}